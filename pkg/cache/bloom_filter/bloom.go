@@ -2,15 +2,49 @@ package bloomfilter
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/8xmx8/easier/pkg/logger"
 	"github.com/bits-and-blooms/bloom/v3"
 )
 
+/*
+本地布隆过滤器:
+1. 并发安全: Add/Test 使用原子位操作, 可以并发执行; ClearAll/加载/保存/合并时独占;
+2. 保存的文件格式: 魔数"EBF" + 版本(1字节) + 数据长度(uint64) + 数据 + crc32校验和(uint32), 加载时校验;
+   也可以加载旧版本直接保存的数据(无文件头与校验和);
+3. 保存时先写入临时文件再原子地重命名, 避免进程崩溃时文件被截断; 可通过 SnapshotWithOption 定期保存;
+4. Union/Intersect 合并参数(m、k)相同的过滤器, 用于合并并行构建的过滤器;
+*/
+
+const bloomFileVersion = 1
+
+var (
+	bloomFileMagic = []byte("EBF")
+
+	ErrIncompatibleFilter = errors.New("bloom filters have different m or k")
+	ErrChecksumMismatch   = errors.New("bloom filter file checksum mismatch")
+)
+
 // BloomFilter 定义布隆过滤器
 type BloomFilter struct {
+	mu     sync.RWMutex // Add/Test持有读锁, 修改整个位数组时持有写锁
 	filter *bloom.BloomFilter
+	logg   logger.Logger
+
+	snapshotPath     string
+	snapshotInterval time.Duration
 }
 
 type BloomOption func(*BloomFilter) error
@@ -18,14 +52,26 @@ type BloomOption func(*BloomFilter) error
 // LoadFileWithOption 通过文件加载过滤器样本
 func LoadFileWithOption(filepath string) func(*BloomFilter) error {
 	return func(bf *BloomFilter) error {
-		f, err := os.Open(filepath)
-		if err != nil {
-			return err
+		return bf.LoadByFile(context.Background(), filepath)
+	}
+}
+
+// SnapshotWithOption 每隔interval将过滤器保存到文件, ctx结束时停止并保存最后一次
+func SnapshotWithOption(filepath string, interval time.Duration) BloomOption {
+	return func(bf *BloomFilter) error {
+		if interval <= 0 {
+			return fmt.Errorf("snapshot interval must be > 0, currently as %s", interval)
 		}
-		defer f.Close()
-		r := bufio.NewReader(f)
-		_, err = bf.filter.ReadFrom(r)
-		return err
+		bf.snapshotPath, bf.snapshotInterval = filepath, interval
+		return nil
+	}
+}
+
+// LoggerWithOption 自定义logger
+func LoggerWithOption(logg logger.Logger) BloomOption {
+	return func(bf *BloomFilter) error {
+		bf.logg = logg
+		return nil
 	}
 }
 
@@ -34,6 +80,7 @@ func NewBloom(ctx context.Context, n uint, fp float64, ops ...BloomOption) (*Blo
 	filter := bloom.NewWithEstimates(n, fp)
 	bf := &BloomFilter{
 		filter: filter,
+		logg:   logger.DefaultLogger(),
 	}
 	for _, op := range ops {
 		err := op(bf)
@@ -41,47 +88,229 @@ func NewBloom(ctx context.Context, n uint, fp float64, ops ...BloomOption) (*Blo
 			return nil, err
 		}
 	}
+	if bf.snapshotPath != "" {
+		go bf.snapshotLoop(ctx)
+	}
 	return bf, nil
 }
 
 // Add 向过滤器中增加样本
 func (bf *BloomFilter) Add(data []byte) {
-	bf.filter.Add(data)
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	words, m := bf.filter.BitSet().Bytes(), uint64(bf.filter.Cap())
+	for _, loc := range bloom.Locations(data, bf.filter.K()) {
+		i := loc % m
+		addr, mask := &words[i/64], uint64(1)<<(i%64)
+		for {
+			old := atomic.LoadUint64(addr)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+				break
+			}
+		}
+	}
 }
 
 // ClearAll 清空过滤器中的所有样本
 func (bf *BloomFilter) ClearAll() {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
 	bf.filter.ClearAll()
 }
 
 // Test 如果数据位于 BloomFilter 中，则 Test 返回 true，否则返回 false。如果为 true，则结果可能是误报。如果为 false，则数据肯定不在集合中
 func (bf *BloomFilter) Test(data []byte) bool {
-	return bf.filter.Test(data)
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	words, m := bf.filter.BitSet().Bytes(), uint64(bf.filter.Cap())
+	for _, loc := range bloom.Locations(data, bf.filter.K()) {
+		i := loc % m
+		if atomic.LoadUint64(&words[i/64])&(uint64(1)<<(i%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshot 复制当前的位数组
+func (bf *BloomFilter) snapshot() *bloom.BloomFilter {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	words := bf.filter.BitSet().Bytes()
+	cp := make([]uint64, len(words))
+	for i := range words {
+		cp[i] = atomic.LoadUint64(&words[i])
+	}
+	return bloom.FromWithM(cp, bf.filter.Cap(), bf.filter.K())
+}
+
+// Union 将others中的样本合并到过滤器中(并集)
+func (bf *BloomFilter) Union(others ...*BloomFilter) error {
+	return bf.merge(others, func(a, b uint64) uint64 { return a | b })
+}
+
+// Intersect 只保留同时位于others中的样本(交集), 结果的误判率高于直接用交集构建的过滤器
+func (bf *BloomFilter) Intersect(others ...*BloomFilter) error {
+	return bf.merge(others, func(a, b uint64) uint64 { return a & b })
+}
+
+func (bf *BloomFilter) merge(others []*BloomFilter, op func(a, b uint64) uint64) error {
+	snaps := make([]*bloom.BloomFilter, 0, len(others))
+	for _, other := range others {
+		if other == bf {
+			continue
+		}
+		snaps = append(snaps, other.snapshot())
+	}
+	// ReadFrom 可能替换bf.filter, 持有锁后再校验参数
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	for _, snap := range snaps {
+		if snap.Cap() != bf.filter.Cap() || snap.K() != bf.filter.K() {
+			return ErrIncompatibleFilter
+		}
+	}
+	words := bf.filter.BitSet().Bytes()
+	for _, snap := range snaps {
+		for i, w := range snap.BitSet().Bytes() {
+			words[i] = op(words[i], w)
+		}
+	}
+	return nil
 }
 
-// DownloadToFile 将过滤器的样本保存到本地文件
+// WriteTo 序列化过滤器, 包含文件头与校验和
+func (bf *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var payload bytes.Buffer
+	if _, err := bf.snapshot().WriteTo(&payload); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	buf.Write(bloomFileMagic)
+	buf.WriteByte(bloomFileVersion)
+	_ = binary.Write(&buf, binary.BigEndian, uint64(payload.Len()))
+	buf.Write(payload.Bytes())
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ReadFrom 反序列化过滤器, 覆盖过滤器中的所有样本
+func (bf *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	filter := &bloom.BloomFilter{}
+	var n int64
+	head, err := br.Peek(len(bloomFileMagic) + 1)
+	if err == nil && bytes.Equal(head[:len(bloomFileMagic)], bloomFileMagic) {
+		if n, err = readBloomFile(br, filter); err != nil {
+			return n, err
+		}
+	} else if err = checkBloomHeader(br); err != nil { // 旧版本: 无文件头与校验和
+		return 0, err
+	} else if n, err = filter.ReadFrom(br); err != nil {
+		return n, err
+	}
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	bf.filter = filter
+	return n, nil
+}
+
+func readBloomFile(r io.Reader, filter *bloom.BloomFilter) (int64, error) {
+	head := make([]byte, len(bloomFileMagic)+1+8)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+	if version := head[len(bloomFileMagic)]; version != bloomFileVersion {
+		return int64(len(head)), fmt.Errorf("unsupported bloom filter file version %d", version)
+	}
+	// 长度字段不在校验和范围内, 超出上限或大于剩余的数据时视为校验失败
+	size := binary.BigEndian.Uint64(head[len(bloomFileMagic)+1:])
+	if size > maxFilterDataSize {
+		return int64(len(head)), ErrChecksumMismatch
+	}
+	payload, err := readFilterData(r, size+4)
+	if errors.Is(err, errFilterDataLength) {
+		return int64(len(head)), ErrChecksumMismatch
+	} else if err != nil {
+		return int64(len(head)), fmt.Errorf("read bloom filter file: %w", err)
+	}
+	n := int64(len(head) + len(payload))
+	if crc32.ChecksumIEEE(payload[:size]) != binary.BigEndian.Uint32(payload[size:]) {
+		return n, ErrChecksumMismatch
+	}
+	if _, err := filter.ReadFrom(bytes.NewReader(payload[:size])); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// DownloadToFile 将过滤器的样本保存到本地文件, 先写入临时文件再重命名
 func (bf *BloomFilter) DownloadToFile(ctx context.Context, filepath string) error {
-	f, err := os.Create(filepath)
-	if err != nil {
+	return writeFileAtomic(filepath, func(w io.Writer) error {
+		_, err := bf.WriteTo(w)
 		return err
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	_, err = bf.filter.WriteTo(w)
-	return err
+	})
 }
 
 // LoadByFile 从文件中加载
 // 先清空过滤后所有样本,重新加载
 func (bf *BloomFilter) LoadByFile(ctx context.Context, filepath string) error {
-	bf.ClearAll()
 	// 加载样本文件
 	f, err := os.Open(filepath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	r := bufio.NewReader(f)
-	_, err = bf.filter.ReadFrom(r)
+	_, err = bf.ReadFrom(f)
 	return err
 }
+
+// snapshotLoop 定期保存过滤器
+func (bf *BloomFilter) snapshotLoop(ctx context.Context) {
+	ticker := time.NewTicker(bf.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			bf.saveSnapshot()
+			return
+		case <-ticker.C:
+			bf.saveSnapshot()
+		}
+	}
+}
+
+func (bf *BloomFilter) saveSnapshot() {
+	if err := bf.DownloadToFile(context.Background(), bf.snapshotPath); err != nil {
+		bf.logg.Error(logger.ErrorCache, "bloom filter snapshot", logger.MakeField("path", bf.snapshotPath), logger.ErrorField(err))
+	}
+}
+
+// writeFileAtomic 将数据写入同目录下的临时文件, 同步到磁盘后重命名为path
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,111 @@
+package bloomfilter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomConcurrent(t *testing.T) {
+	bf, _ := NewBloom(context.Background(), 10000, 0.001)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", g, i))
+				bf.Add(key)
+				assert.True(t, bf.Test(key))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.True(t, bf.filter.Test([]byte("7-499"))) // 与 bloom.BloomFilter 的位置计算一致
+}
+
+func TestBloomFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bf")
+	bf, _ := NewBloom(ctx, 1000, 0.01)
+	bf.Add([]byte("a"))
+	assert.NoError(t, bf.DownloadToFile(ctx, file))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1) // 临时文件已被重命名
+
+	loaded, err := NewBloom(ctx, 10, 0.1, LoadFileWithOption(file))
+	assert.NoError(t, err)
+	assert.True(t, loaded.Test([]byte("a")))
+	assert.Equal(t, bf.filter.Cap(), loaded.filter.Cap())
+
+	// 校验和错误
+	b, _ := os.ReadFile(file)
+	b[len(b)-5] ^= 0xff
+	assert.NoError(t, os.WriteFile(file, b, 0o644))
+	assert.ErrorIs(t, loaded.LoadByFile(ctx, file), ErrChecksumMismatch)
+	// 截断
+	assert.NoError(t, os.WriteFile(file, b[:len(b)/2], 0o644))
+	assert.Error(t, loaded.LoadByFile(ctx, file))
+	// 长度字段损坏: 超出上限或大于剩余的数据
+	b[len(b)-5] ^= 0xff
+	for _, i := range []int{4, 11} {
+		corrupted := append([]byte(nil), b...)
+		corrupted[i] ^= 0x7f
+		assert.NoError(t, os.WriteFile(file, corrupted, 0o644))
+		assert.ErrorIs(t, loaded.LoadByFile(ctx, file), ErrChecksumMismatch)
+	}
+
+	// 旧版本的文件
+	f, _ := os.Create(file)
+	_, _ = bf.filter.WriteTo(f)
+	_ = f.Close()
+	assert.NoError(t, loaded.LoadByFile(ctx, file))
+	assert.True(t, loaded.Test([]byte("a")))
+	sbf, err := NewScalableBloom(ctx, 1000, 0.01, ScalableLoadFileWithOption(file))
+	assert.NoError(t, err)
+	assert.True(t, sbf.Test([]byte("a")))
+}
+
+func TestBloomSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	file := filepath.Join(t.TempDir(), "bf")
+	bf, err := NewBloom(ctx, 1000, 0.01, SnapshotWithOption(file, 10*time.Millisecond))
+	assert.NoError(t, err)
+	bf.Add([]byte("a"))
+	time.Sleep(30 * time.Millisecond)
+	bf.Add([]byte("b"))
+	cancel()
+	assert.Eventually(t, func() bool {
+		loaded, err := NewBloom(context.Background(), 1000, 0.01, LoadFileWithOption(file))
+		return err == nil && loaded.Test([]byte("b"))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBloomMerge(t *testing.T) {
+	ctx := context.Background()
+	a, _ := NewBloom(ctx, 1000, 0.01)
+	b, _ := NewBloom(ctx, 1000, 0.01)
+	a.Add([]byte("x"))
+	a.Add([]byte("both"))
+	b.Add([]byte("y"))
+	b.Add([]byte("both"))
+
+	u, _ := NewBloom(ctx, 1000, 0.01)
+	assert.NoError(t, u.Union(a, b))
+	for _, k := range []string{"x", "y", "both"} {
+		assert.True(t, u.Test([]byte(k)))
+	}
+	assert.NoError(t, a.Intersect(b))
+	assert.True(t, a.Test([]byte("both")))
+	assert.False(t, a.Test([]byte("x")))
+
+	c, _ := NewBloom(ctx, 10, 0.01)
+	assert.ErrorIs(t, a.Union(c), ErrIncompatibleFilter)
+}
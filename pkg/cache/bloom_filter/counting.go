@@ -0,0 +1,142 @@
+package bloomfilter
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+/*
+计数布隆过滤器(Counting Bloom Filter):
+1. 每个位置使用4位计数器代替1位, 添加时计数器加1, 删除时减1, 从而支持删除样本;
+2. 计数器达到最大值(15)后不再变化, 以免删除时产生漏判; 内存占用为同参数 BloomFilter 的4倍;
+3. 位置的计算方式与 BloomFilter 相同;
+*/
+
+const counterMax = 0x0f
+
+var countingMagic = []byte("CBF\x01")
+
+// CountingBloomFilter 计数布隆过滤器, 非并发安全
+type CountingBloomFilter struct {
+	m        uint
+	k        uint
+	counters []byte // 每个字节保存两个4位计数器
+	count    uint64
+}
+
+// NewCountingBloom 实例化计数布隆过滤器, n为容量, fp为误判率
+func NewCountingBloom(ctx context.Context, n uint, fp float64) *CountingBloomFilter {
+	m, k := bloom.EstimateParameters(n, fp)
+	return &CountingBloomFilter{
+		m:        m,
+		k:        k,
+		counters: make([]byte, (m+1)/2),
+	}
+}
+
+func (cbf *CountingBloomFilter) get(i uint) byte {
+	return cbf.counters[i/2] >> ((i % 2) * 4) & counterMax
+}
+
+func (cbf *CountingBloomFilter) set(i uint, v byte) {
+	shift := (i % 2) * 4
+	cbf.counters[i/2] = cbf.counters[i/2]&^(counterMax<<shift) | v<<shift
+}
+
+func (cbf *CountingBloomFilter) locations(data []byte) []uint {
+	locs := bloom.Locations(data, cbf.k)
+	idx := make([]uint, len(locs))
+	for i, loc := range locs {
+		idx[i] = uint(loc % uint64(cbf.m))
+	}
+	return idx
+}
+
+// Add 向过滤器中增加样本
+func (cbf *CountingBloomFilter) Add(data []byte) error {
+	for _, i := range cbf.locations(data) {
+		if v := cbf.get(i); v < counterMax {
+			cbf.set(i, v+1)
+		}
+	}
+	cbf.count++
+	return nil
+}
+
+// Test 如果数据位于过滤器中返回true(可能误判), 否则返回false
+func (cbf *CountingBloomFilter) Test(data []byte) bool {
+	for _, i := range cbf.locations(data) {
+		if cbf.get(i) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove 删除样本, 样本不在过滤器中时返回false
+func (cbf *CountingBloomFilter) Remove(data []byte) bool {
+	locs := cbf.locations(data)
+	for _, i := range locs {
+		if cbf.get(i) == 0 {
+			return false
+		}
+	}
+	for _, i := range locs {
+		if v := cbf.get(i); v < counterMax {
+			cbf.set(i, v-1)
+		}
+	}
+	if cbf.count > 0 {
+		cbf.count--
+	}
+	return true
+}
+
+// Count 过滤器中的样本数
+func (cbf *CountingBloomFilter) Count() uint64 {
+	return cbf.count
+}
+
+// Cap 计数器的个数
+func (cbf *CountingBloomFilter) Cap() uint {
+	return cbf.m
+}
+
+// K 哈希函数的个数
+func (cbf *CountingBloomFilter) K() uint {
+	return cbf.k
+}
+
+// ClearAll 清空过滤器中的所有样本
+func (cbf *CountingBloomFilter) ClearAll() {
+	for i := range cbf.counters {
+		cbf.counters[i] = 0
+	}
+	cbf.count = 0
+}
+
+// WriteTo 序列化过滤器
+func (cbf *CountingBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return writeFilter(w, countingMagic, []uint64{uint64(cbf.m), uint64(cbf.k), cbf.count}, cbf.counters)
+}
+
+// ReadFrom 反序列化过滤器
+func (cbf *CountingBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header [3]uint64
+	n, err := readFilterHeader(r, countingMagic, header[:])
+	if err != nil {
+		return n, err
+	}
+	if header[0] == 0 || header[1] == 0 || header[0] > maxFilterDataSize*2 {
+		return n, fmt.Errorf("invalid counting bloom filter: m=%d k=%d", header[0], header[1])
+	}
+	counters, err := readFilterData(r, (header[0]+1)/2)
+	if err != nil {
+		return n, fmt.Errorf("invalid counting bloom filter: %w", err)
+	}
+	cbf.m, cbf.k, cbf.count, cbf.counters = uint(header[0]), uint(header[1]), header[2], counters
+	return n + int64(len(counters)), nil
+}
@@ -0,0 +1,186 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/bits"
+	"math/rand"
+)
+
+/*
+布谷鸟过滤器(Cuckoo Filter):
+1. 每个样本保存16位指纹, 可以位于两个候选桶之一(i2 = i1 ^ hash(指纹)), 每个桶4个槽位;
+2. 两个候选桶都满时随机踢出一个指纹到它的另一个候选桶, 超过最大踢出次数时返回 ErrFilterFull;
+3. 误判率约为 8/2^16 ≈ 0.012%, 装载率可达95%, 支持删除; 同一个样本最多添加 2*4 次;
+*/
+
+const (
+	cuckooBucketSize = 4
+	cuckooMaxKicks   = 500
+)
+
+var cuckooMagic = []byte("CKF\x01")
+
+// CuckooFilter 布谷鸟过滤器, 非并发安全
+type CuckooFilter struct {
+	buckets [][cuckooBucketSize]uint16 // 指纹为0表示空槽位
+	mask    uint64
+	count   uint64
+}
+
+// NewCuckoo 实例化布谷鸟过滤器, n为容量
+func NewCuckoo(ctx context.Context, n uint) *CuckooFilter {
+	num := uint64(n)/cuckooBucketSize*100/95 + 1
+	num = 1 << bits.Len64(num-1) // 桶数为2的幂
+	return &CuckooFilter{
+		buckets: make([][cuckooBucketSize]uint16, num),
+		mask:    num - 1,
+	}
+}
+
+// fingerprint 计算样本的指纹与第一个候选桶
+func (cf *CuckooFilter) fingerprint(data []byte) (uint16, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	v := h.Sum64()
+	fp := uint16(v >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, v & cf.mask
+}
+
+// altIndex 指纹的另一个候选桶
+func (cf *CuckooFilter) altIndex(i uint64, fp uint16) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & cf.mask
+}
+
+func (cf *CuckooFilter) insert(i uint64, fp uint16) bool {
+	b := &cf.buckets[i]
+	for j := range b {
+		if b[j] == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *CuckooFilter) contains(i uint64, fp uint16) bool {
+	for _, v := range cf.buckets[i] {
+		if v == fp {
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *CuckooFilter) delete(i uint64, fp uint16) bool {
+	b := &cf.buckets[i]
+	for j := range b {
+		if b[j] == fp {
+			b[j] = 0
+			return true
+		}
+	}
+	return false
+}
+
+// Add 向过滤器中增加样本, 过滤器已满时返回 ErrFilterFull
+func (cf *CuckooFilter) Add(data []byte) error {
+	fp, i1 := cf.fingerprint(data)
+	i2 := cf.altIndex(i1, fp)
+	if cf.insert(i1, fp) || cf.insert(i2, fp) {
+		cf.count++
+		return nil
+	}
+	// 随机踢出指纹, 失败时回滚, 保证已有样本不丢失
+	type kick struct {
+		i    uint64
+		slot int
+		fp   uint16
+	}
+	path := make([]kick, 0, cuckooMaxKicks)
+	i := i1
+	if rand.Intn(2) == 0 { // nolint
+		i = i2
+	}
+	for n := 0; n < cuckooMaxKicks; n++ {
+		slot := rand.Intn(cuckooBucketSize) // nolint
+		old := cf.buckets[i][slot]
+		cf.buckets[i][slot] = fp
+		path = append(path, kick{i: i, slot: slot, fp: old})
+		fp, i = old, cf.altIndex(i, old)
+		if cf.insert(i, fp) {
+			cf.count++
+			return nil
+		}
+	}
+	for n := len(path) - 1; n >= 0; n-- {
+		cf.buckets[path[n].i][path[n].slot] = path[n].fp
+	}
+	return ErrFilterFull
+}
+
+// Test 如果数据位于过滤器中返回true(可能误判), 否则返回false
+func (cf *CuckooFilter) Test(data []byte) bool {
+	fp, i1 := cf.fingerprint(data)
+	return cf.contains(i1, fp) || cf.contains(cf.altIndex(i1, fp), fp)
+}
+
+// Remove 删除样本, 样本不在过滤器中时返回false
+func (cf *CuckooFilter) Remove(data []byte) bool {
+	fp, i1 := cf.fingerprint(data)
+	if cf.delete(i1, fp) || cf.delete(cf.altIndex(i1, fp), fp) {
+		cf.count--
+		return true
+	}
+	return false
+}
+
+// Count 过滤器中的样本数
+func (cf *CuckooFilter) Count() uint64 {
+	return cf.count
+}
+
+// LoadFactor 装载率
+func (cf *CuckooFilter) LoadFactor() float64 {
+	return float64(cf.count) / float64(len(cf.buckets)*cuckooBucketSize)
+}
+
+// ClearAll 清空过滤器中的所有样本
+func (cf *CuckooFilter) ClearAll() {
+	for i := range cf.buckets {
+		cf.buckets[i] = [cuckooBucketSize]uint16{}
+	}
+	cf.count = 0
+}
+
+// WriteTo 序列化过滤器
+func (cf *CuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	return writeFilter(w, cuckooMagic, []uint64{uint64(len(cf.buckets)), cf.count}, cf.buckets)
+}
+
+// ReadFrom 反序列化过滤器
+func (cf *CuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header [2]uint64
+	n, err := readFilterHeader(r, cuckooMagic, header[:])
+	if err != nil {
+		return n, err
+	}
+	if header[0] == 0 || header[0]&(header[0]-1) != 0 || header[0] > maxFilterDataSize/(cuckooBucketSize*2) {
+		return n, fmt.Errorf("invalid cuckoo filter: %d buckets", header[0])
+	}
+	data, err := readFilterData(r, header[0]*cuckooBucketSize*2)
+	if err != nil {
+		return n, fmt.Errorf("invalid cuckoo filter: %w", err)
+	}
+	buckets := make([][cuckooBucketSize]uint16, header[0])
+	_ = binary.Read(bytes.NewReader(data), binary.BigEndian, buckets)
+	cf.buckets, cf.mask, cf.count = buckets, header[0]-1, header[1]
+	return n + int64(header[0])*cuckooBucketSize*2, nil
+}
@@ -0,0 +1,100 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrFilterFull 过滤器已满, 无法写入新的样本
+var ErrFilterFull = errors.New("filter is full")
+
+// errFilterDataLength 数据长度字段超出上限或大于剩余的数据, 通常是文件损坏或被截断
+var errFilterDataLength = errors.New("invalid filter data length")
+
+// maxFilterDataSize 反序列化时允许的最大数据长度(4GiB), 避免损坏的长度字段导致超大的内存分配
+const maxFilterDataSize = 1 << 32
+
+// Filter 支持删除样本的过滤器
+type Filter interface {
+	// Add 向过滤器中增加样本
+	Add(data []byte) error
+	// Test 如果数据位于过滤器中返回true(可能误判), 否则返回false(数据肯定不在集合中)
+	Test(data []byte) bool
+	// Remove 删除样本, 样本不在过滤器中时返回false; 只能删除已添加的样本, 否则会导致其它样本误删
+	Remove(data []byte) bool
+	// Count 过滤器中的样本数
+	Count() uint64
+	// WriteTo 序列化过滤器
+	WriteTo(w io.Writer) (int64, error)
+	// ReadFrom 反序列化过滤器, 覆盖过滤器中的所有样本
+	ReadFrom(r io.Reader) (int64, error)
+}
+
+var (
+	_ Filter = (*CountingBloomFilter)(nil)
+	_ Filter = (*CuckooFilter)(nil)
+)
+
+// writeFilter 写入魔数、头部字段与数据
+func writeFilter(w io.Writer, magic []byte, header []uint64, data interface{}) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var buf bytes.Buffer
+	buf.Write(magic)
+	_ = binary.Write(&buf, binary.BigEndian, header)
+	_ = binary.Write(&buf, binary.BigEndian, data)
+	n, err := bw.Write(buf.Bytes())
+	if err != nil {
+		return int64(n), err
+	}
+	return int64(n), bw.Flush()
+}
+
+// readFilterHeader 校验魔数并读取头部字段
+func readFilterHeader(r io.Reader, magic []byte, header []uint64) (int64, error) {
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(head, magic) {
+		return int64(len(head)), fmt.Errorf("invalid filter magic %q, expect %q", head, magic)
+	}
+	if err := binary.Read(r, binary.BigEndian, header); err != nil {
+		return int64(len(head)), err
+	}
+	return int64(len(head) + 8*len(header)), nil
+}
+
+// readFilterData 读取size字节的数据, 缓冲区按实际读到的数据增长
+func readFilterData(r io.Reader, size uint64) ([]byte, error) {
+	if size > maxFilterDataSize {
+		return nil, fmt.Errorf("%w: %d exceeds %d", errFilterDataLength, size, uint64(maxFilterDataSize))
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: %d exceeds remaining %d", errFilterDataLength, size, buf.Len())
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkBloomHeader 校验 bloom.BloomFilter 序列化数据的头部(m、k、位数组长度), 读取前调用
+func checkBloomHeader(br *bufio.Reader) error {
+	head, err := br.Peek(24)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	m, k, length := binary.BigEndian.Uint64(head), binary.BigEndian.Uint64(head[8:]), binary.BigEndian.Uint64(head[16:])
+	if m > maxFilterDataSize*8 || k == 0 || length > m {
+		return fmt.Errorf("%w: m=%d k=%d bits=%d", errFilterDataLength, m, k, length)
+	}
+	return nil
+}
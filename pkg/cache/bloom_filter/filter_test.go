@@ -0,0 +1,112 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFilter(t *testing.T, f, loaded Filter) {
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, f.Add([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	assert.Equal(t, uint64(1000), f.Count())
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.Test([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	for i := 0; i < 500; i++ {
+		assert.True(t, f.Remove([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	assert.Equal(t, uint64(500), f.Count())
+	fp := 0
+	for i := 0; i < 500; i++ {
+		if f.Test([]byte(fmt.Sprintf("key-%d", i))) {
+			fp++
+		}
+	}
+	assert.Less(t, fp, 10)
+	assert.False(t, f.Remove([]byte("never-added")))
+
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	m, err := loaded.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, n, m)
+	assert.Equal(t, uint64(500), loaded.Count())
+	assert.True(t, loaded.Test([]byte("key-999")))
+}
+
+func TestCountingBloom(t *testing.T) {
+	ctx := context.Background()
+	testFilter(t, NewCountingBloom(ctx, 1000, 0.001), NewCountingBloom(ctx, 1, 0.5))
+	_, err := NewCountingBloom(ctx, 1, 0.5).ReadFrom(bytes.NewReader([]byte("CKF\x01")))
+	assert.Error(t, err)
+	// 头部字段损坏时不按头部分配内存
+	for _, m := range []uint64{1 << 62, 1 << 30} {
+		var buf bytes.Buffer
+		_, _ = writeFilter(&buf, countingMagic, []uint64{m, 3, 0}, []byte{0})
+		_, err = NewCountingBloom(ctx, 1, 0.5).ReadFrom(&buf)
+		assert.Error(t, err)
+	}
+}
+
+func TestCuckoo(t *testing.T) {
+	ctx := context.Background()
+	testFilter(t, NewCuckoo(ctx, 1000), NewCuckoo(ctx, 1))
+
+	cf := NewCuckoo(ctx, 100)
+	var err error
+	n := 0
+	for ; err == nil; n++ {
+		err = cf.Add([]byte(fmt.Sprintf("key-%d", n)))
+	}
+	assert.ErrorIs(t, err, ErrFilterFull)
+	assert.Greater(t, cf.LoadFactor(), 0.9)
+	for i := 0; i < n-1; i++ { // 已满时写入失败不影响已有样本
+		assert.True(t, cf.Test([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	_, err = NewCuckoo(ctx, 1).ReadFrom(bytes.NewReader(append(append([]byte(nil), cuckooMagic...), 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)))
+	assert.Error(t, err)
+	var buf bytes.Buffer
+	_, _ = writeFilter(&buf, cuckooMagic, []uint64{1 << 20, 0}, []byte{0})
+	_, err = NewCuckoo(ctx, 1).ReadFrom(&buf)
+	assert.ErrorIs(t, err, errFilterDataLength)
+}
+
+const benchN = 100000
+
+func benchFilter(b *testing.B, add func([]byte), test func([]byte) bool, bytes int) {
+	for i := 0; i < benchN; i++ {
+		add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	fp := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if test([]byte(fmt.Sprintf("other-%d", i))) {
+			fp++
+		}
+	}
+	b.ReportMetric(float64(fp)/float64(b.N), "fp")
+	b.ReportMetric(float64(bytes), "bytes")
+}
+
+func BenchmarkBloom(b *testing.B) {
+	bf, _ := NewBloom(context.Background(), benchN, 0.001)
+	benchFilter(b, bf.Add, bf.Test, int(bf.filter.Cap()/8))
+}
+
+func BenchmarkCountingBloom(b *testing.B) {
+	cbf := NewCountingBloom(context.Background(), benchN, 0.001)
+	benchFilter(b, func(data []byte) { _ = cbf.Add(data) }, cbf.Test, len(cbf.counters))
+}
+
+func BenchmarkCuckoo(b *testing.B) {
+	cf := NewCuckoo(context.Background(), benchN)
+	benchFilter(b, func(data []byte) { _ = cf.Add(data) }, cf.Test, len(cf.buckets)*cuckooBucketSize*2)
+}
@@ -0,0 +1,213 @@
+package bloomfilter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cache "github.com/8xmx8/easier/pkg/cache/redis"
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+/*
+基于Redis的共享布隆过滤器:
+1. 位数组保存在Redis的bitmap中, 多个实例共享同一个过滤器;
+2. 位数组大小m与哈希函数个数k与 NewBloom 的估算方式一致, 位置的计算方式与本地过滤器相同;
+3. 一个样本的k个位通过一次lua脚本调用完成设置/查询, 批量操作时所有样本也只需一次调用;
+4. 可选使用 RedisBloom 模块的 BF.* 命令(需服务端加载该模块), 批量操作在脚本内分批执行;
+*/
+
+// maxRedisBits redis单个字符串最大512MB
+const maxRedisBits = 1 << 32
+
+// redisBloomScripts 布隆过滤器使用的lua脚本, 通过EVALSHA执行
+var redisBloomScripts = cache.NewScriptRegistry()
+
+var (
+	// ARGV[1]为k, 之后每k个offset为一个样本
+	redisBloomAddScript = redisBloomScripts.MustRegister("bloom_add", `
+local k = tonumber(ARGV[1])
+local added = 0
+for i = 2, #ARGV, k do
+	local exists = 1
+	for j = i, i + k - 1 do
+		if redis.call('SETBIT', KEYS[1], ARGV[j], 1) == 0 then exists = 0 end
+	end
+	if exists == 0 then added = added + 1 end
+end
+return added`)
+
+	redisBloomTestScript = redisBloomScripts.MustRegister("bloom_test", `
+local k = tonumber(ARGV[1])
+local res = {}
+for i = 2, #ARGV, k do
+	local exists = 1
+	for j = i, i + k - 1 do
+		if redis.call('GETBIT', KEYS[1], ARGV[j]) == 0 then exists = 0 break end
+	end
+	res[#res + 1] = exists
+end
+return res`)
+
+	redisBloomReserveScript = redisBloomScripts.MustRegister("bloom_reserve", `
+local ok, err = pcall(redis.call, 'BF.RESERVE', KEYS[1], ARGV[1], ARGV[2])
+if not ok then
+	local msg = type(err) == 'table' and err.err or tostring(err)
+	if not string.find(msg, 'exists') then return redis.error_reply(msg) end
+end
+return 1`)
+
+	// ARGV[1]为 BF.MADD 或 BF.MEXISTS, 之后为样本; 样本分批传入, 避免unpack超过lua栈的限制
+	redisBloomNativeScript = redisBloomScripts.MustRegister("bloom_native", `
+local res = {}
+for i = 2, #ARGV, 1000 do
+	local part = redis.call(ARGV[1], KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+	for _, v in ipairs(part) do res[#res + 1] = v end
+end
+return res`)
+)
+
+// RedisBloomFilter 基于Redis的布隆过滤器
+type RedisBloomFilter struct {
+	rds    cache.Redis
+	key    string
+	n      uint
+	fp     float64
+	m      uint
+	k      uint
+	native bool // 使用 RedisBloom 的 BF.* 命令
+}
+
+type RedisBloomOption func(*RedisBloomFilter)
+
+// RedisBloomWithNative 使用 RedisBloom 模块的 BF.* 命令, 过滤器由服务端按n/fp创建
+func RedisBloomWithNative() RedisBloomOption {
+	return func(bf *RedisBloomFilter) {
+		bf.native = true
+	}
+}
+
+// NewRedisBloom 实例化基于Redis的布隆过滤器, key为保存位数组的redis key
+// n为预估的样本数, fp为误报率, 与 NewBloom 一致
+func NewRedisBloom(ctx context.Context, rds cache.Redis, key string, n uint, fp float64, ops ...RedisBloomOption) (*RedisBloomFilter, error) {
+	m, k := bloom.EstimateParameters(n, fp)
+	bf := &RedisBloomFilter{
+		rds: rds,
+		key: key,
+		n:   n,
+		fp:  fp,
+		m:   m,
+		k:   k,
+	}
+	for _, op := range ops {
+		op(bf)
+	}
+	if bf.native {
+		return bf, bf.reserve(ctx)
+	}
+	if m > maxRedisBits {
+		return nil, fmt.Errorf("bloom filter size %d exceeds redis bitmap limit", m)
+	}
+	return bf, nil
+}
+
+// Cap 位数组大小
+func (bf *RedisBloomFilter) Cap() uint {
+	return bf.m
+}
+
+// K 哈希函数个数
+func (bf *RedisBloomFilter) K() uint {
+	return bf.k
+}
+
+// Add 向过滤器中增加样本
+func (bf *RedisBloomFilter) Add(ctx context.Context, data []byte) error {
+	return bf.AddMulti(ctx, data)
+}
+
+// AddMulti 向过滤器中批量增加样本, 只需一次网络请求
+func (bf *RedisBloomFilter) AddMulti(ctx context.Context, data ...[]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	script, args := bf.args(redisBloomAddScript, "BF.MADD", data)
+	return script.Run(ctx, bf.rds, []string{bf.key}, args...).Err()
+}
+
+// Test 如果数据位于过滤器中返回 true, 结果可能是误报; 返回 false 时数据肯定不在集合中
+func (bf *RedisBloomFilter) Test(ctx context.Context, data []byte) (bool, error) {
+	res, err := bf.TestMulti(ctx, data)
+	if err != nil {
+		return false, err
+	}
+	return res[0], nil
+}
+
+// TestMulti 批量查询样本, 返回值与data一一对应
+func (bf *RedisBloomFilter) TestMulti(ctx context.Context, data ...[]byte) ([]bool, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	script, args := bf.args(redisBloomTestScript, "BF.MEXISTS", data)
+	res, err := script.Run(ctx, bf.rds, []string{bf.key}, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != len(data) {
+		return nil, fmt.Errorf("unexpected bloom reply: %v", res)
+	}
+	exists := make([]bool, len(res))
+	for i, n := range res {
+		exists[i] = n == 1
+	}
+	return exists, nil
+}
+
+// ClearAll 清空过滤器中的所有样本
+func (bf *RedisBloomFilter) ClearAll(ctx context.Context) error {
+	if err := bf.rds.Del(ctx, bf.key); err != nil {
+		return err
+	}
+	if bf.native {
+		return bf.reserve(ctx)
+	}
+	return nil
+}
+
+func (bf *RedisBloomFilter) reserve(ctx context.Context) error {
+	err := redisBloomReserveScript.Run(ctx, bf.rds, []string{bf.key}, bf.fp, bf.n).Err()
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+		return fmt.Errorf("redisbloom module not loaded: %w", err)
+	}
+	return err
+}
+
+// args 构造lua脚本参数: BF.* 命令传入命令及样本, 否则传入k及每个样本的k个offset
+func (bf *RedisBloomFilter) args(script *cache.Script, nativeCmd string, data [][]byte) (*cache.Script, []interface{}) {
+	if bf.native {
+		args := make([]interface{}, 0, 1+len(data))
+		args = append(args, nativeCmd)
+		for _, d := range data {
+			args = append(args, string(d))
+		}
+		return redisBloomNativeScript, args
+	}
+	args := make([]interface{}, 0, 1+len(data)*int(bf.k))
+	args = append(args, bf.k)
+	for _, d := range data {
+		for _, off := range bf.offsets(d) {
+			args = append(args, off)
+		}
+	}
+	return script, args
+}
+
+// offsets 样本在位数组中的k个位置, 与本地过滤器的计算方式一致
+func (bf *RedisBloomFilter) offsets(data []byte) []uint64 {
+	locs := bloom.Locations(data, bf.k)
+	for i := range locs {
+		locs[i] %= uint64(bf.m)
+	}
+	return locs
+}
@@ -0,0 +1,92 @@
+package bloomfilter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	cache "github.com/8xmx8/easier/pkg/cache/redis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// 与本地过滤器使用相同的位置, 便于两者之间迁移数据
+func TestRedisBloomOffsets(t *testing.T) {
+	m, k := bloom.EstimateParameters(10000, 0.001)
+	bf := &RedisBloomFilter{m: m, k: k}
+	local := bloom.New(m, k)
+	for _, key := range []string{"a", "hello", "布隆过滤器"} {
+		local.ClearAll()
+		local.Add([]byte(key))
+		offsets := bf.offsets([]byte(key))
+		assert.Len(t, offsets, int(k))
+		for _, off := range offsets {
+			assert.True(t, local.BitSet().Test(uint(off)))
+		}
+	}
+
+	script, args := bf.args(redisBloomAddScript, "BF.MADD", [][]byte{[]byte("a"), []byte("b")})
+	assert.Equal(t, redisBloomAddScript, script)
+	assert.Len(t, args, 1+2*int(k))
+	bf.native = true
+	script, args = bf.args(redisBloomAddScript, "BF.MADD", [][]byte{[]byte("a"), []byte("b")})
+	assert.Equal(t, redisBloomNativeScript, script)
+	assert.Equal(t, []interface{}{"BF.MADD", "a", "b"}, args)
+}
+
+// registerFakeBF 在miniredis中注册简化的 BF.* 命令, 用于测试分批调用
+func registerFakeBF(t *testing.T, mr *miniredis.Miniredis) {
+	set := map[string]bool{}
+	multi := func(add bool) server.Cmd {
+		return func(c *server.Peer, cmd string, args []string) {
+			c.WriteLen(len(args) - 1)
+			for _, item := range args[1:] {
+				n := 0
+				if add && !set[item] || !add && set[item] {
+					n = 1
+				}
+				if add {
+					set[item] = true
+				}
+				c.WriteInt(n)
+			}
+		}
+	}
+	assert.NoError(t, mr.Server().Register("BF.RESERVE", func(c *server.Peer, cmd string, args []string) { c.WriteOK() }))
+	assert.NoError(t, mr.Server().Register("BF.MADD", multi(true)))
+	assert.NoError(t, mr.Server().Register("BF.MEXISTS", multi(false)))
+}
+
+func TestRedisBloom(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	registerFakeBF(t, mr)
+	rds, err := cache.NewClient(ctx, mr.Addr(), 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := make([][]byte, 0, 2500)
+	for i := 0; i < cap(data); i++ {
+		data = append(data, []byte(strconv.Itoa(i)))
+	}
+	for _, native := range []bool{false, true} {
+		var ops []RedisBloomOption
+		if native {
+			ops = append(ops, RedisBloomWithNative())
+		}
+		bf, err := NewRedisBloom(ctx, rds, "bloom:"+strconv.FormatBool(native), 10000, 0.001, ops...)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, bf.AddMulti(ctx, data...))
+		res, err := bf.TestMulti(ctx, append(data, []byte("none"))...)
+		assert.NoError(t, err)
+		assert.Len(t, res, len(data)+1)
+		for i := range data {
+			assert.True(t, res[i])
+		}
+		assert.False(t, res[len(data)])
+	}
+}
@@ -0,0 +1,278 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+/*
+可扩容的布隆过滤器(Scalable Bloom Filter):
+1. 由一组布隆过滤器组成, 当前过滤器的样本数达到容量时, 追加一个容量为上一个growth倍的过滤器;
+2. 第i个过滤器的误判率为 fp*(1-ratio)*ratio^i, 整体误判率不超过fp;
+3. 新样本只写入最后一个过滤器, 已存在(可能误判)的样本不重复写入, 查询时任意一个过滤器命中即返回true;
+4. DownloadToFile/LoadByFile 的用法与 BloomFilter 一致, LoadByFile 也可以加载 BloomFilter 保存的文件;
+*/
+
+// scalableMagic 可扩容布隆过滤器文件的魔数; BloomFilter 的文件以位数组大小m(大端uint64)开头, 首字节为0
+var scalableMagic = []byte("SBF\x01")
+
+// ScalableStats 可扩容布隆过滤器的统计数据
+type ScalableStats struct {
+	Stages   int     `json:"stages"`   // 过滤器的个数
+	Count    uint64  `json:"count"`    // 写入的样本数(不含被判定为已存在的样本)
+	Capacity uint64  `json:"capacity"` // 当前所有过滤器的容量之和
+	Bits     uint64  `json:"bits"`     // 位数组的总大小
+	FPRate   float64 `json:"fpRate"`   // 根据样本数估算的当前误判率
+}
+
+type scalableStage struct {
+	filter   *bloom.BloomFilter
+	capacity uint64
+	fp       float64
+	count    uint64
+}
+
+// ScalableBloomFilter 可扩容的布隆过滤器
+type ScalableBloomFilter struct {
+	n      uint    // 第一个过滤器的容量
+	fp     float64 // 整体的目标误判率
+	growth uint    // 容量的增长倍数
+	ratio  float64 // 误判率的收紧比例
+	stages []*scalableStage
+}
+
+type ScalableBloomOption func(*ScalableBloomFilter) error
+
+// ScalableWithGrowth 配置容量的增长倍数, 默认为2
+func ScalableWithGrowth(growth uint) ScalableBloomOption {
+	return func(sbf *ScalableBloomFilter) error {
+		if growth < 1 {
+			return fmt.Errorf("growth must be >= 1, currently as %d", growth)
+		}
+		sbf.growth = growth
+		return nil
+	}
+}
+
+// ScalableWithRatio 配置误判率的收紧比例, 取值(0, 1), 默认为0.8; 越小整体误判率越稳定, 但后续过滤器占用的空间越大
+func ScalableWithRatio(ratio float64) ScalableBloomOption {
+	return func(sbf *ScalableBloomFilter) error {
+		if ratio <= 0 || ratio >= 1 {
+			return fmt.Errorf("ratio must be in (0, 1), currently as %v", ratio)
+		}
+		sbf.ratio = ratio
+		return nil
+	}
+}
+
+// ScalableLoadFileWithOption 通过文件加载过滤器样本
+func ScalableLoadFileWithOption(filepath string) ScalableBloomOption {
+	return func(sbf *ScalableBloomFilter) error {
+		return sbf.LoadByFile(context.Background(), filepath)
+	}
+}
+
+// NewScalableBloom 实例化可扩容的布隆过滤器, n为初始容量, fp为整体的目标误判率
+func NewScalableBloom(ctx context.Context, n uint, fp float64, ops ...ScalableBloomOption) (*ScalableBloomFilter, error) {
+	if n == 0 {
+		return nil, errors.New("n must be > 0")
+	}
+	if fp <= 0 || fp >= 1 {
+		return nil, fmt.Errorf("fp must be in (0, 1), currently as %v", fp)
+	}
+	sbf := &ScalableBloomFilter{
+		n:      n,
+		fp:     fp,
+		growth: 2,
+		ratio:  0.8,
+	}
+	for _, op := range ops {
+		if err := op(sbf); err != nil {
+			return nil, err
+		}
+	}
+	if len(sbf.stages) == 0 {
+		sbf.grow()
+	}
+	return sbf, nil
+}
+
+// grow 追加一个过滤器
+func (sbf *ScalableBloomFilter) grow() *scalableStage {
+	i := len(sbf.stages)
+	capacity := uint64(sbf.n) * uint64(math.Pow(float64(sbf.growth), float64(i)))
+	fp := sbf.fp * (1 - sbf.ratio) * math.Pow(sbf.ratio, float64(i))
+	st := &scalableStage{
+		filter:   bloom.NewWithEstimates(uint(capacity), fp),
+		capacity: capacity,
+		fp:       fp,
+	}
+	sbf.stages = append(sbf.stages, st)
+	return st
+}
+
+// Add 向过滤器中增加样本
+func (sbf *ScalableBloomFilter) Add(data []byte) {
+	if sbf.Test(data) {
+		return
+	}
+	st := sbf.stages[len(sbf.stages)-1]
+	if st.count >= st.capacity {
+		st = sbf.grow()
+	}
+	st.filter.Add(data)
+	st.count++
+}
+
+// Test 如果数据位于过滤器中返回true(可能误判), 否则返回false(数据肯定不在集合中)
+func (sbf *ScalableBloomFilter) Test(data []byte) bool {
+	for i := len(sbf.stages) - 1; i >= 0; i-- {
+		if sbf.stages[i].filter.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearAll 清空过滤器中的所有样本, 只保留第一个过滤器
+func (sbf *ScalableBloomFilter) ClearAll() {
+	sbf.stages = sbf.stages[:1]
+	sbf.stages[0].filter.ClearAll()
+	sbf.stages[0].count = 0
+}
+
+// Stats 获取过滤器的统计数据
+func (sbf *ScalableBloomFilter) Stats() ScalableStats {
+	st := ScalableStats{Stages: len(sbf.stages)}
+	notFP := 1.0
+	for _, s := range sbf.stages {
+		st.Count += s.count
+		st.Capacity += s.capacity
+		st.Bits += uint64(s.filter.Cap())
+		notFP *= 1 - estimateFPRate(s.filter.Cap(), s.filter.K(), s.count)
+	}
+	st.FPRate = 1 - notFP
+	return st
+}
+
+// estimateFPRate 根据位数组大小m、哈希函数个数k与样本数n估算误判率: (1 - e^(-kn/m))^k
+func estimateFPRate(m, k uint, n uint64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return math.Pow(1-math.Exp(-float64(k)*float64(n)/float64(m)), float64(k))
+}
+
+// WriteTo 序列化过滤器
+func (sbf *ScalableBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.Write(scalableMagic)
+	for _, v := range []uint64{uint64(sbf.n), math.Float64bits(sbf.fp), uint64(sbf.growth), math.Float64bits(sbf.ratio), uint64(len(sbf.stages))} {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	n, err := w.Write(buf.Bytes())
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	for _, st := range sbf.stages {
+		if err := binary.Write(w, binary.BigEndian, []uint64{st.capacity, math.Float64bits(st.fp), st.count}); err != nil {
+			return total, err
+		}
+		total += 24
+		n, err := st.filter.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFrom 反序列化过滤器, 也可以读取 BloomFilter 保存的数据, 此时作为第一个过滤器, 样本数按位数组估算
+func (sbf *ScalableBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(scalableMagic))
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(head, scalableMagic) {
+		filter := &bloom.BloomFilter{}
+		var n int64
+		if bytes.HasPrefix(head, bloomFileMagic) {
+			n, err = readBloomFile(br, filter)
+		} else if err = checkBloomHeader(br); err == nil {
+			n, err = filter.ReadFrom(br)
+		}
+		if err != nil {
+			return n, err
+		}
+		sbf.stages = []*scalableStage{{
+			filter:   filter,
+			capacity: uint64(sbf.n),
+			fp:       sbf.fp * (1 - sbf.ratio),
+			count:    uint64(filter.ApproximatedSize()),
+		}}
+		return n, nil
+	}
+	_, _ = br.Discard(len(scalableMagic))
+	total := int64(len(scalableMagic))
+	var params [5]uint64
+	if err := binary.Read(br, binary.BigEndian, &params); err != nil {
+		return total, err
+	}
+	total += 40
+	// 过滤器数量不预分配, 数量字段损坏时读取到文件末尾返回错误
+	var stages []*scalableStage
+	for i := uint64(0); i < params[4]; i++ {
+		var meta [3]uint64
+		if err := binary.Read(br, binary.BigEndian, &meta); err != nil {
+			return total, err
+		}
+		total += 24
+		if err := checkBloomHeader(br); err != nil {
+			return total, err
+		}
+		filter := &bloom.BloomFilter{}
+		n, err := filter.ReadFrom(br)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		stages = append(stages, &scalableStage{filter: filter, capacity: meta[0], fp: math.Float64frombits(meta[1]), count: meta[2]})
+	}
+	if len(stages) == 0 {
+		return total, errors.New("invalid scalable bloom filter: no stage")
+	}
+	sbf.n, sbf.fp, sbf.growth, sbf.ratio = uint(params[0]), math.Float64frombits(params[1]), uint(params[2]), math.Float64frombits(params[3])
+	sbf.stages = stages
+	return total, nil
+}
+
+// DownloadToFile 将过滤器的样本保存到本地文件, 先写入临时文件再重命名
+func (sbf *ScalableBloomFilter) DownloadToFile(ctx context.Context, filepath string) error {
+	return writeFileAtomic(filepath, func(w io.Writer) error {
+		_, err := sbf.WriteTo(w)
+		return err
+	})
+}
+
+// LoadByFile 从文件中加载, 覆盖过滤器中的所有样本
+func (sbf *ScalableBloomFilter) LoadByFile(ctx context.Context, filepath string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = sbf.ReadFrom(f)
+	return err
+}
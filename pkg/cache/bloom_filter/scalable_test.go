@@ -0,0 +1,72 @@
+package bloomfilter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalableBloom(t *testing.T) {
+	ctx := context.Background()
+	sbf, err := NewScalableBloom(ctx, 1000, 0.01)
+	assert.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		sbf.Add([]byte(fmt.Sprintf("url-%d", i)))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, sbf.Test([]byte(fmt.Sprintf("url-%d", i))))
+	}
+	st := sbf.Stats()
+	assert.Equal(t, 4, st.Stages) // 1000 + 2000 + 4000 + 8000
+	assert.GreaterOrEqual(t, st.Capacity, uint64(10000))
+	assert.Less(t, st.FPRate, 0.01)
+
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if sbf.Test([]byte(fmt.Sprintf("other-%d", i))) {
+			fp++
+		}
+	}
+	assert.Less(t, float64(fp)/10000, 0.02)
+
+	file := filepath.Join(t.TempDir(), "sbf")
+	assert.NoError(t, sbf.DownloadToFile(ctx, file))
+	loaded, err := NewScalableBloom(ctx, 10, 0.1, ScalableLoadFileWithOption(file))
+	assert.NoError(t, err)
+	assert.Equal(t, st, loaded.Stats())
+	assert.True(t, loaded.Test([]byte("url-9999")))
+
+	// 过滤器数量或位数组长度损坏
+	b, _ := os.ReadFile(file)
+	for _, i := range []int{len(scalableMagic) + 32, len(scalableMagic) + 40 + 24} {
+		corrupted := append([]byte(nil), b...)
+		corrupted[i] = 0x7f
+		assert.NoError(t, os.WriteFile(file, corrupted, 0o644))
+		assert.Error(t, loaded.LoadByFile(ctx, file))
+	}
+
+	sbf.ClearAll()
+	assert.Equal(t, 1, sbf.Stats().Stages)
+	assert.False(t, sbf.Test([]byte("url-1")))
+}
+
+func TestScalableBloomLoadPlainFile(t *testing.T) {
+	ctx := context.Background()
+	bf, _ := NewBloom(ctx, 1000, 0.01)
+	bf.Add([]byte("a"))
+	file := filepath.Join(t.TempDir(), "bf")
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	_, err = bf.filter.WriteTo(f)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	sbf, err := NewScalableBloom(ctx, 1000, 0.01, ScalableLoadFileWithOption(file))
+	assert.NoError(t, err)
+	assert.True(t, sbf.Test([]byte("a")))
+	assert.Equal(t, uint64(1), sbf.Stats().Count)
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+基于bitmap的活跃用户统计:
+1. 每天一个bitmap, 用户ID作为offset, 用户活跃时置为1;
+2. 周活跃为一周(周一至周日)内每日bitmap的并集(BITOP OR), 结果保存为周bitmap;
+3. 所有key都使用 {prefix} 作为hashtag, 以保证集群模式下BITOP的key位于同一个slot
+*/
+
+const activeDayLayout = "20060102"
+
+// ActiveUsers 日活/周活统计
+type ActiveUsers struct {
+	rds    Redis
+	prefix string
+	ttl    time.Duration // 日/周bitmap的过期时间, <=0 时不过期
+}
+
+type ActiveUsersOptionFunc func(*ActiveUsers)
+
+// ActiveUsersWithTTL 配置日/周bitmap的过期时间
+func ActiveUsersWithTTL(ttl time.Duration) ActiveUsersOptionFunc {
+	return func(a *ActiveUsers) {
+		a.ttl = ttl
+	}
+}
+
+// NewActiveUsers 实例化活跃用户统计, prefix为key的前缀
+func NewActiveUsers(rds Redis, prefix string, ops ...ActiveUsersOptionFunc) *ActiveUsers {
+	a := &ActiveUsers{rds: rds, prefix: prefix}
+	for _, op := range ops {
+		op(a)
+	}
+	return a
+}
+
+// DailyKey day所在日期的bitmap key
+func (a *ActiveUsers) DailyKey(day time.Time) string {
+	return fmt.Sprintf("{%s}:dau:%s", a.prefix, day.Format(activeDayLayout))
+}
+
+// WeeklyKey day所在ISO周的bitmap key
+func (a *ActiveUsers) WeeklyKey(day time.Time) string {
+	year, week := day.ISOWeek()
+	return fmt.Sprintf("{%s}:wau:%d-W%02d", a.prefix, year, week)
+}
+
+// Mark 标记用户在t所在的日期活跃
+func (a *ActiveUsers) Mark(ctx context.Context, uid int64, t time.Time) error {
+	key := a.DailyKey(t)
+	if _, err := a.rds.SetBit(ctx, key, uid, 1); err != nil {
+		return err
+	}
+	if a.ttl > 0 {
+		return a.rds.SetExpire(ctx, key, a.ttl)
+	}
+	return nil
+}
+
+// IsActive 用户在day是否活跃
+func (a *ActiveUsers) IsActive(ctx context.Context, uid int64, day time.Time) (bool, error) {
+	v, err := a.rds.GetBit(ctx, a.DailyKey(day), uid)
+	return v == 1, err
+}
+
+// CountDaily day的活跃用户数
+func (a *ActiveUsers) CountDaily(ctx context.Context, day time.Time) (int64, error) {
+	return a.rds.CountBit(ctx, a.DailyKey(day), 0, -1)
+}
+
+// RollupWeekly 汇总day所在ISO周(周一至周日)的日活bitmap到周bitmap, 返回周活跃用户数
+// 当周未结束时可重复调用以刷新结果
+func (a *ActiveUsers) RollupWeekly(ctx context.Context, day time.Time) (int64, error) {
+	dest := a.WeeklyKey(day)
+	if _, err := a.rds.OpBit(ctx, BitOr, dest, a.dailyKeys(weekDays(day)...)...); err != nil {
+		return 0, err
+	}
+	if a.ttl > 0 {
+		if err := a.rds.SetExpire(ctx, dest, a.ttl); err != nil {
+			return 0, err
+		}
+	}
+	return a.rds.CountBit(ctx, dest, 0, -1)
+}
+
+// CountWeekly day所在ISO周已汇总的周活跃用户数, 未汇总时为0
+func (a *ActiveUsers) CountWeekly(ctx context.Context, day time.Time) (int64, error) {
+	return a.rds.CountBit(ctx, a.WeeklyKey(day), 0, -1)
+}
+
+// IsActiveWeekly 用户在day所在ISO周是否活跃, 需先调用 RollupWeekly
+func (a *ActiveUsers) IsActiveWeekly(ctx context.Context, uid int64, day time.Time) (bool, error) {
+	v, err := a.rds.GetBit(ctx, a.WeeklyKey(day), uid)
+	return v == 1, err
+}
+
+func (a *ActiveUsers) dailyKeys(days ...time.Time) []string {
+	keys := make([]string, 0, len(days))
+	for _, d := range days {
+		keys = append(keys, a.DailyKey(d))
+	}
+	return keys
+}
+
+// weekDays day所在ISO周的周一至周日
+func weekDays(day time.Time) []time.Time {
+	offset := (int(day.Weekday()) + 6) % 7 // 周一为0
+	monday := day.AddDate(0, 0, -offset)
+	days := make([]time.Time, 0, 7)
+	for i := 0; i < 7; i++ {
+		days = append(days, monday.AddDate(0, 0, i))
+	}
+	return days
+}
@@ -3,11 +3,13 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/go-redis/redis/v7"
+	"strings"
 	"time"
 
 	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
 	"github.com/jinzhu/copier"
 )
 
@@ -15,40 +17,47 @@ import (
 type Client struct {
 	client *redis.Client
 	logg   logger.Logger
+	prefix string // key前缀
 }
 
-type OptionFunc func(*redis.Options)
+type OptionFunc func(*clientOptions)
 
 // WithPasswd 配置密码
 func WithPasswd(passwd string) OptionFunc {
-	return func(o *redis.Options) {
+	return func(o *clientOptions) {
 		o.Password = passwd
 	}
 }
 
 // WithAuth 配置鉴权
 func WithAuth(user, passwd string) OptionFunc {
-	return func(o *redis.Options) {
+	return func(o *clientOptions) {
 		o.Username = user
 		o.Password = passwd
 	}
 }
 
+// NewClient 实例化单机模式, db的数量由服务端的databases配置决定
 func NewClient(ctx context.Context, addr string, db int, ops ...OptionFunc) (Redis, error) {
-	if db > 15 {
-		return nil, fmt.Errorf("client mode Only libraries 0 to 15 are supported, currently as %d", db)
+	if db < 0 {
+		return nil, fmt.Errorf("client mode db must be >= 0, currently as %d", db)
 	}
-	opt := &redis.Options{
+	opt := &clientOptions{Options: &redis.Options{
 		Addr: addr,
 		DB:   db,
-	}
+	}}
 	for _, op := range ops {
 		op(opt)
 	}
 
+	client := redis.NewClient(opt.Options)
+	for _, hook := range opt.redisHooks() {
+		client.AddHook(hook)
+	}
 	return &Client{
-		client: redis.NewClient(opt).WithContext(ctx),
+		client: client.WithContext(ctx),
 		logg:   logger.DefaultLogger(),
+		prefix: opt.keyPrefix,
 	}, nil
 }
 
@@ -105,11 +114,12 @@ func (c *Client) GetMixed(ctx context.Context, key string, value interface{}) er
 		return c.client.ZRevRange(key, 0, -1).ScanSlice(value)
 	case "hash": //nolint
 		// object
-		h := c.client.HGetAll(key)
-		if h.Err() != nil {
-			return h.Err()
+		h, err := c.client.HGetAll(key).Result()
+		if err != nil {
+			return err
 		}
-		hb, err := json.Marshal(h.Val())
+		filterExpiredHash(h, time.Now())
+		hb, err := json.Marshal(h)
 		if err != nil {
 			return err
 		}
@@ -120,37 +130,37 @@ func (c *Client) GetMixed(ctx context.Context, key string, value interface{}) er
 	}
 }
 
-// ScanKey 扫描字符串值
+// ScanKey 扫描当前节点中与match匹配的key, ctx结束时停止扫描
 func (c *Client) ScanKey(ctx context.Context, match string) chan string {
-	batchSize := 10
-	out := make(chan string, batchSize)
-	go func() {
-		defer close(out)
-		var (
-			cursor uint64
-			err    error
-		)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				var keys []string
-				keys, cursor, err = c.client.Scan(cursor, match, int64(batchSize)).Result()
-				if err != nil {
-					c.logg.Error(logger.ErrorCache, "redis scan error", logger.MakeField("match", match), logger.ErrorField(err))
-					return
-				}
-				for _, item := range keys {
-					out <- item
-				}
-				if cursor == 0 {
-					return
-				}
-			}
-		}
-	}()
-	return out
+	return scanKeys(ctx, c.nodes, c.logg, c.prefix, match, "")
+}
+
+// ScanKeyByType 扫描与match匹配且类型为typ(string/list/set/zset/hash/stream)的key(redis >= 6.0)
+func (c *Client) ScanKeyByType(ctx context.Context, match, typ string) chan string {
+	return scanKeys(ctx, c.nodes, c.logg, c.prefix, match, typ)
+}
+
+// DelByPattern 使用UNLINK按批删除与match匹配的key, 返回删除的key数
+// 通过 BulkWithRate 限速, BulkWithDryRun 只统计匹配的key数
+func (c *Client) DelByPattern(ctx context.Context, match string, ops ...BulkOptionFunc) (int64, error) {
+	return bulkByPattern(ctx, c.nodes, c.prefix, match, ops, unlinkKeys)
+}
+
+// ExpireByPattern 按批为与match匹配的key设置过期时间, 返回设置成功的key数
+func (c *Client) ExpireByPattern(ctx context.Context, match string, ttl time.Duration, ops ...BulkOptionFunc) (int64, error) {
+	return bulkByPattern(ctx, c.nodes, c.prefix, match, ops, func(ctx context.Context, node *redis.Client, keys []string) (int64, error) {
+		return expireKeys(ctx, node, keys, ttl)
+	})
+}
+
+// nodes 需要扫描的节点
+func (c *Client) nodes(fn func(node *redis.Client) error) error {
+	return fn(c.client)
+}
+
+// GetType 获取key对应数据的数据类型: string/list/set/zset/hash/stream, key不存在时返回none
+func (c *Client) GetType(ctx context.Context, key string) (string, error) {
+	return c.getType(ctx, key)
 }
 
 // getType 获取key对应数据的数据类型
@@ -158,6 +168,16 @@ func (c *Client) getType(ctx context.Context, key string) (string, error) {
 	return c.client.Type(key).Result()
 }
 
+// keyPrefix key前缀, 用于脚本执行前校验slot
+func (c *Client) keyPrefix() string {
+	return c.prefix
+}
+
+// keyMeta 在一次往返中读取key的类型、剩余过期时间及hash的field数, 用于 NearCache
+func (c *Client) keyMeta(ctx context.Context, key string) (*keyMeta, error) {
+	return readKeyMeta(c.client.WithContext(ctx), key)
+}
+
 /********************************* string接口 **************************************/
 // Set 设置key数据(不含TTL)
 func (c *Client) SetStr(ctx context.Context, key, value string) error {
@@ -176,24 +196,84 @@ func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration
 
 /*********************************** hash接口 ****************************************/
 
-// SetHash设置hash数据
+// SetHash设置hash数据, 覆盖写入的field取消其TTL
 //
 //	HMSet("myhash", map[string]interface{}{"key1": "value1", "key2": "value2"})
 //
-// TODO: @zcf redis 的Hash不支持 层级结构
+// 层级结构使用 SetHashStruct
 func (c *Client) SetHash(ctx context.Context, key string, value map[string]interface{}) error {
-	return c.client.HMSet(key, value).Err()
+	return setHash(ctx, c.client, key, value)
 }
 
-// GetHashField 获取执行hash的指定field数据
-// TODO: @zcf 当field对应的数据为非string数据类型时, 需要对该方法进行改造(例如: 返回[]byte); 如果要改造,需要对redis interface同步修改
+// GetHashField 获取执行hash的指定field数据, field不存在或已过期时返回 redis.Nil
+// 需要按类型读取时使用 GetHashStruct
 func (c *Client) GetHashField(ctx context.Context, key, field string) (string, error) {
-	return c.client.HGet(key, field).Result()
+	res, err := readHash(ctx, c.client, c.logg, key, field)
+	if err != nil {
+		return "", err
+	}
+	return res[field], nil
+}
+
+// SetHashStruct 将struct按 `redis:"field"` 标签写入hash, 层级结构序列化为JSON
+func (c *Client) SetHashStruct(ctx context.Context, key string, value interface{}) error {
+	fields, err := structToHash(value)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return setHash(ctx, c.client, key, fields)
+}
+
+// GetHashStruct 读取hash并按 `redis:"field"` 标签写入struct, value需为struct指针
+// fields 为空时读取全部field, 否则只读取指定的field; key或field都不存在时返回 redis.Nil
+func (c *Client) GetHashStruct(ctx context.Context, key string, value interface{}, fields ...string) error {
+	res, err := readHash(ctx, c.client, c.logg, key, fields...)
+	if err != nil {
+		return err
+	}
+	return hashToStruct(res, value)
+}
+
+// SetHashFieldTTL 写入单个field并设置该field的过期时间, ttl <= 0 时取消过期
+func (c *Client) SetHashFieldTTL(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	return setHashFieldTTL(ctx, c.client, key, field, value, ttl)
+}
+
+// IncrByHash 为field的整数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (c *Client) IncrByHash(ctx context.Context, key, field string, incr int64) (int64, error) {
+	return incrHash(ctx, c, key, field, incr, false).Int64()
+}
+
+// IncrByFloatHash 为field的浮点数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (c *Client) IncrByFloatHash(ctx context.Context, key, field string, incr float64) (float64, error) {
+	return incrHash(ctx, c, key, field, incr, true).Float64()
+}
+
+// DelHashField 删除hash中的field
+func (c *Client) DelHashField(ctx context.Context, key string, fields ...string) error {
+	all := make([]string, 0, 2*len(fields))
+	for _, f := range fields {
+		all = append(all, f, hashTTLField(f))
+	}
+	return c.client.HDel(key, all...).Err()
+}
+
+// ScanHash 流式扫描hash中与match匹配的field
+func (c *Client) ScanHash(ctx context.Context, key, match string) chan *HashField {
+	return scanHash(ctx, c.client, c.logg, key, match)
+}
+
+// PurgeHash 清理hash中已过期的field, 返回清理的field数
+func (c *Client) PurgeHash(ctx context.Context, key string) (int64, error) {
+	return purgeHash(ctx, c.client, key)
 }
 
 /*********************************** list接口 ****************************************/
-// TODO: @zcf 针对list类型的头部（左边）和尾部（右边）的操作暂时不做多余实现.
-// 当前默认, list添加数据从尾部（右边）添加, 从头部（左边）开始获取
+// PushList/PopList 默认从尾部（右边）添加, 从头部（左边）开始获取;
+// 需要指定操作点时使用 PushListBy/PopListBy
 
 // PushList 向key对应的list中从尾部(右边)追加数据
 func (c *Client) PushList(ctx context.Context, key string, values ...interface{}) error {
@@ -210,8 +290,58 @@ func (c *Client) PopList(ctx context.Context, key string, value interface{}) err
 	return c.client.LPop(key).Scan(value)
 }
 
+// PushListBy 按flag从头部(StartPoint)或尾部(EndPoint)向list中添加数据
+func (c *Client) PushListBy(ctx context.Context, key string, flag ListFlag, values ...interface{}) error {
+	switch flag {
+	case StartPoint:
+		return c.client.LPush(key, values...).Err()
+	case EndPoint:
+		return c.client.RPush(key, values...).Err()
+	}
+	return errListFlag(flag)
+}
+
+// PopListBy 按flag从头部(StartPoint)或尾部(EndPoint)获取并删除
+func (c *Client) PopListBy(ctx context.Context, key string, flag ListFlag, value interface{}) error {
+	switch flag {
+	case StartPoint:
+		return c.client.LPop(key).Scan(value)
+	case EndPoint:
+		return c.client.RPop(key).Scan(value)
+	}
+	return errListFlag(flag)
+}
+
+// BlockPopList 阻塞式地按flag从多个list中获取并删除第一个可用的元素(BLPOP/BRPOP)
+// timeout 为0时一直阻塞; 超时返回 redis.Nil
+func (c *Client) BlockPopList(ctx context.Context, flag ListFlag, timeout time.Duration, keys ...string) (string, string, error) {
+	var cmd *redis.StringSliceCmd
+	switch flag {
+	case StartPoint:
+		cmd = c.client.WithContext(ctx).BLPop(timeout, keys...)
+	case EndPoint:
+		cmd = c.client.WithContext(ctx).BRPop(timeout, keys...)
+	default:
+		return "", "", errListFlag(flag)
+	}
+	res, err := cmd.Result()
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(res[0], c.prefix), res[1], nil
+}
+
+// RangeList 获取list中[start, stop]区间内的元素, 支持负数下标
+func (c *Client) RangeList(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.client.LRange(key, start, stop).Result()
+}
+
+// TrimList 只保留list中[start, stop]区间内的元素
+func (c *Client) TrimList(ctx context.Context, key string, start, stop int64) error {
+	return c.client.LTrim(key, start, stop).Err()
+}
+
 /*********************************** set接口 ****************************************/
-// TODO: @zcf 当前进提供最基本的操作, 暂时不支持集合运算
 
 // AddSet 向key集合中添加成员
 func (c *Client) AddSet(ctx context.Context, key string, values ...interface{}) error {
@@ -228,6 +358,36 @@ func (c *Client) RemSetEle(ctx context.Context, key string, values ...interface{
 	return c.client.SRem(key, values...).Err()
 }
 
+// UnionSet 获取多个集合的并集
+func (c *Client) UnionSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.client.SUnion(keys...).Result()
+}
+
+// InterSet 获取多个集合的交集
+func (c *Client) InterSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.client.SInter(keys...).Result()
+}
+
+// DiffSet 获取第一个集合与其它集合的差集
+func (c *Client) DiffSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.client.SDiff(keys...).Result()
+}
+
+// UnionStoreSet 将多个集合的并集保存到dest, 返回dest的成员数
+func (c *Client) UnionStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.client.SUnionStore(dest, keys...).Result()
+}
+
+// InterStoreSet 将多个集合的交集保存到dest, 返回dest的成员数
+func (c *Client) InterStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.client.SInterStore(dest, keys...).Result()
+}
+
+// DiffStoreSet 将第一个集合与其它集合的差集保存到dest, 返回dest的成员数
+func (c *Client) DiffStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.client.SDiffStore(dest, keys...).Result()
+}
+
 /*********************************** 有序集合接口 ****************************************/
 
 // AddZSet 向key有序集合添加成员
@@ -261,26 +421,296 @@ func (c *Client) RemMembersZSet(ctx context.Context, key string, members ...stri
 	return c.client.ZRem(key, members).Err()
 }
 
+// RangeByScoreZSet 分页获取分数在[min, max]区间内的成员及分数
+// min/max 支持 "-inf"、"+inf" 以及 "(1" 形式的开区间; reverse 为true时按分数从高到低排序
+// count <= 0 时不分页
+func (c *Client) RangeByScoreZSet(ctx context.Context, key, min, max string, offset, count int64, reverse bool) ([]*ZSetMember, error) {
+	opt := &redis.ZRangeBy{Min: min, Max: max, Offset: offset, Count: count}
+	if count <= 0 {
+		opt.Offset, opt.Count = 0, 0
+	}
+	var cmd *redis.ZSliceCmd
+	if reverse {
+		cmd = c.client.ZRevRangeByScoreWithScores(key, opt)
+	} else {
+		cmd = c.client.ZRangeByScoreWithScores(key, opt)
+	}
+	zres, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*ZSetMember, 0, len(zres))
+	err = copier.Copy(&values, &zres)
+	return values, err
+}
+
+// RangeWithScoreZSet 按排名获取[start, stop]区间内的成员及分数
+// reverse 为true时按分数从高到低排名
+func (c *Client) RangeWithScoreZSet(ctx context.Context, key string, start, stop int64, reverse bool) ([]*ZSetMember, error) {
+	var cmd *redis.ZSliceCmd
+	if reverse {
+		cmd = c.client.ZRevRangeWithScores(key, start, stop)
+	} else {
+		cmd = c.client.ZRangeWithScores(key, start, stop)
+	}
+	zres, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*ZSetMember, 0, len(zres))
+	err = copier.Copy(&values, &zres)
+	return values, err
+}
+
+// RankZSet 获取成员的排名(从0开始), 成员不存在时返回 redis.Nil
+// reverse 为true时按分数从高到低排名
+func (c *Client) RankZSet(ctx context.Context, key, member string, reverse bool) (int64, error) {
+	if reverse {
+		return c.client.ZRevRank(key, member).Result()
+	}
+	return c.client.ZRank(key, member).Result()
+}
+
+// ScoreZSet 获取成员的分数, 成员不存在时返回 redis.Nil
+func (c *Client) ScoreZSet(ctx context.Context, key, member string) (float64, error) {
+	return c.client.ZScore(key, member).Result()
+}
+
+// IncrByZSet 为成员的分数加上increment, 返回新的分数
+func (c *Client) IncrByZSet(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	return c.client.ZIncrBy(key, increment, member).Result()
+}
+
 /*********************************** pubsub接口 ****************************************/
+
+// Publish 向channel发布消息
 func (c *Client) Publish(ctx context.Context, key string, value interface{}) error {
 	return c.client.Publish(key, value).Err()
 }
 
+// Subscribe 订阅channel, ctx结束时退订并关闭消息通道
 func (c *Client) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
-	pubsub := c.client.Subscribe(channels...)
-	_, err := pubsub.Receive()
+	ps := c.NewPubSub(ctx)
+	if err := ps.Subscribe(ctx, channels...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// PSubscribe 按pattern订阅, ctx结束时退订并关闭消息通道
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error) {
+	ps := c.NewPubSub(ctx)
+	if err := ps.PSubscribe(ctx, patterns...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// NewPubSub 创建一个可动态订阅/退订的发布订阅连接, ctx结束时关闭
+func (c *Client) NewPubSub(ctx context.Context) *PubSub {
+	return newPubSub(ctx, c.client.Subscribe(), c.logg)
+}
+
+// SPublish 向分片channel发布消息(SPUBLISH, redis >= 7.0)
+func (c *Client) SPublish(ctx context.Context, channel string, message interface{}) error {
+	return c.client.Do("spublish", channel, message).Err()
+}
+
+// SSubscribe 订阅分片channel(SSUBSCRIBE, redis >= 7.0), ctx结束时退订并关闭消息通道
+// 每个channel使用独立的连接, 首次订阅失败时返回错误
+func (c *Client) SSubscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("at least one channel is required")
+	}
+	// 哨兵模式下Addr不是真实的节点地址, 由Dialer定位master
+	opt := c.client.Options()
+	dialer := shardedDialer{
+		dial:        opt.Dialer,
+		username:    opt.Username,
+		password:    opt.Password,
+		tlsConfig:   opt.TLSConfig,
+		dialTimeout: opt.DialTimeout,
+	}
+	ps, err := newShardedPubSub(ctx, c.logg, dialer, func(string) (string, error) {
+		return opt.Addr, nil
+	}, channels...)
 	if err != nil {
 		return nil, err
 	}
-	ch := make(chan *Message, 1)
-	go func() {
-		defer close(ch)
-		for msg := range pubsub.Channel() {
-			ch <- &Message{
-				Channel: msg.Channel,
-				Payload: msg.Payload,
-			}
-		}
-	}()
-	return ch, nil
+	return ps.out, nil
+}
+
+/*********************************** stream接口 ****************************************/
+
+// AddStream 向stream追加消息(XADD)
+// maxLen > 0 时按近似长度(MAXLEN ~)裁剪stream, 返回消息ID
+func (c *Client) AddStream(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return c.client.WithContext(ctx).XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       values,
+	}).Result()
+}
+
+// CreateStreamGroup 创建消费者组, stream不存在时自动创建
+// start 为组的起始消费位置, "0"从头消费, "$"只消费新消息; 组已存在时不返回错误
+func (c *Client) CreateStreamGroup(ctx context.Context, stream, group, start string) error {
+	err := c.client.WithContext(ctx).XGroupCreateMkStream(stream, group, start).Err()
+	if isBusyGroup(err) {
+		return nil
+	}
+	return err
+}
+
+// ReadGroupStream 以消费者组的方式读取新消息(XREADGROUP)
+// block < 0 时不阻塞; 超时无消息时返回空列表
+func (c *Client) ReadGroupStream(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error) {
+	res, err := c.client.WithContext(ctx).XReadGroup(&redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*StreamMessage
+	for _, s := range res {
+		msgs = append(msgs, toStreamMessages(s.Messages)...)
+	}
+	return msgs, nil
+}
+
+// AckStream 确认消息已处理(XACK)
+func (c *Client) AckStream(ctx context.Context, stream, group string, ids ...string) error {
+	return c.client.WithContext(ctx).XAck(stream, group, ids...).Err()
+}
+
+// PendingStream 查看消费者组中[start, end]区间内未确认的消息(XPENDING)
+func (c *Client) PendingStream(ctx context.Context, stream, group, start, end string, count int64) ([]*StreamPending, error) {
+	res, err := c.client.WithContext(ctx).XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  start,
+		End:    end,
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*StreamPending, 0, len(res))
+	for _, p := range res {
+		pending = append(pending, &StreamPending{ID: p.ID, Consumer: p.Consumer, Idle: p.Idle, RetryCount: p.RetryCount})
+	}
+	return pending, nil
+}
+
+// AutoClaimStream 将空闲超过minIdle的pending消息转移给consumer(XAUTOCLAIM, redis >= 6.2)
+// 返回下一次扫描的起始ID, 为"0-0"时表示已扫描完毕
+func (c *Client) AutoClaimStream(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) (string, []*StreamMessage, error) {
+	res, err := c.client.WithContext(ctx).Do("xautoclaim", stream, group, consumer,
+		int64(minIdle/time.Millisecond), start, "count", count).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	return parseAutoClaim(res)
+}
+
+/*********************************** script接口 ****************************************/
+
+// Eval 执行lua脚本, 脚本返回nil时返回 redis.Nil
+// 集群模式下keys需位于同一个slot(可使用 {hashtag})
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.client.WithContext(ctx).Eval(script, keys, args...).Result()
+}
+
+// EvalSha 执行已缓存的lua脚本, 脚本不存在时返回NOSCRIPT错误
+func (c *Client) EvalSha(ctx context.Context, sha string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.client.WithContext(ctx).EvalSha(sha, keys, args...).Result()
+}
+
+// ScriptLoad 缓存lua脚本, 返回脚本的sha1
+func (c *Client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return c.client.WithContext(ctx).ScriptLoad(script).Result()
+}
+
+// ScriptExists 检查脚本是否已缓存
+func (c *Client) ScriptExists(ctx context.Context, shas ...string) ([]bool, error) {
+	return c.client.WithContext(ctx).ScriptExists(shas...).Result()
+}
+
+/*********************************** HyperLogLog接口 ****************************************/
+
+// AddHLL 向HyperLogLog添加元素, 基数估计值发生变化时返回true
+func (c *Client) AddHLL(ctx context.Context, key string, elements ...interface{}) (bool, error) {
+	n, err := c.client.PFAdd(key, elements...).Result()
+	return n == 1, err
+}
+
+// CountHLL 获取HyperLogLog的基数估计值, 多个key时返回并集的基数
+func (c *Client) CountHLL(ctx context.Context, keys ...string) (int64, error) {
+	return c.client.PFCount(keys...).Result()
+}
+
+// MergeHLL 将多个HyperLogLog合并到dest
+func (c *Client) MergeHLL(ctx context.Context, dest string, keys ...string) error {
+	return c.client.PFMerge(dest, keys...).Err()
+}
+
+/*********************************** bitmap接口 ****************************************/
+
+// SetBit 设置offset位的值(0或1), 返回原来的值
+func (c *Client) SetBit(ctx context.Context, key string, offset int64, value int) (int64, error) {
+	return c.client.SetBit(key, offset, value).Result()
+}
+
+// GetBit 获取offset位的值
+func (c *Client) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
+	return c.client.GetBit(key, offset).Result()
+}
+
+// CountBit 统计字节区间[start, end]内值为1的位数, start=0,end=-1 时统计整个bitmap
+func (c *Client) CountBit(ctx context.Context, key string, start, end int64) (int64, error) {
+	return c.client.BitCount(key, &redis.BitCount{Start: start, End: end}).Result()
+}
+
+// OpBit 对多个bitmap进行位运算并保存到dest, 返回dest的长度(字节)
+func (c *Client) OpBit(ctx context.Context, op BitOp, dest string, keys ...string) (int64, error) {
+	return bitOp(c.client, op, dest, keys...)
+}
+
+// BitField 执行BITFIELD子命令, 例如: "incrby", "u8", 0, 1, "get", "u4", 8
+func (c *Client) BitField(ctx context.Context, key string, args ...interface{}) ([]int64, error) {
+	return c.client.BitField(key, args...).Result()
+}
+
+/*********************************** geo接口 ****************************************/
+
+// AddGeo 添加地理位置, 返回新增的成员数
+func (c *Client) AddGeo(ctx context.Context, key string, locations ...*GeoLocation) (int64, error) {
+	return c.client.GeoAdd(key, toRedisGeo(locations)...).Result()
+}
+
+// SearchGeo 按半径或矩形查询范围内的成员(GEOSEARCH, redis >= 6.2), 返回成员的坐标及与中心的距离
+func (c *Client) SearchGeo(ctx context.Context, key string, query *GeoSearchQuery) ([]*GeoLocation, error) {
+	args, err := geoSearchArgs(key, query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.WithContext(ctx).Do(args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoSearch(res)
+}
+
+// DistGeo 获取两个成员之间的距离, unit为m/km/ft/mi; 成员不存在时返回 redis.Nil
+func (c *Client) DistGeo(ctx context.Context, key, member1, member2, unit string) (float64, error) {
+	return c.client.GeoDist(key, member1, member2, unit).Result()
 }
@@ -4,18 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
-const (
-	addr = "172.16.20.30:6379"
-	db   = 15
-)
+const db = 15
+
+// 默认使用 Memory 运行, 设置 REDIS_TEST_ADDR(如 127.0.0.1:6379) 时连接真实的redis
+var addr = os.Getenv("REDIS_TEST_ADDR")
 
 type MainClientSuite struct {
 	suite.Suite
@@ -27,14 +29,20 @@ func Test_MainSuite(t *testing.T) {
 	s := &MainClientSuite{
 		ctx: context.Background(),
 	}
-	redis, err := NewClient(s.ctx, addr, db)
-	assert.NoError(t, err)
-	s.redis = redis
+	if addr == "" {
+		s.redis = NewMemory(s.ctx)
+	} else {
+		redis, err := NewClient(s.ctx, addr, db)
+		if !assert.NoError(t, err) {
+			return
+		}
+		s.redis = redis
+	}
 	suite.Run(t, s)
 }
 
 func (s *MainClientSuite) BeforeTest(suiteName, testName string) {
-	assert.NoError(s.T(), s.redis.(*Client).client.FlushDB().Err())
+	assert.NoError(s.T(), s.redis.FlushDB(s.ctx, false))
 }
 
 func (s *MainClientSuite) Test_BaseOpreation() {
@@ -186,6 +194,58 @@ func (s *MainClientSuite) Test_Hash() {
 			<-time.NewTimer(time.Second).C
 			convey.So(s.redis.IsExist(s.ctx, "hello_p1"), convey.ShouldBeFalse)
 		})
+		convey.Convey("struct_Hash", func() {
+			type Person struct {
+				Name  string   `redis:"name"`
+				Age   int      `redis:"age"`
+				Score float64  `redis:"score"`
+				Hobby []string `redis:"hobby"`
+			}
+			p := &Person{Name: "tom", Age: 18, Score: 60.5, Hobby: []string{"计算机", "音乐"}}
+			convey.So(s.redis.SetHashStruct(s.ctx, "struct_hash", p), convey.ShouldBeEmpty)
+			var all Person
+			convey.So(s.redis.GetHashStruct(s.ctx, "struct_hash", &all), convey.ShouldBeEmpty)
+			convey.So(all, convey.ShouldResemble, *p)
+			var part Person
+			convey.So(s.redis.GetHashStruct(s.ctx, "struct_hash", &part, "age"), convey.ShouldBeEmpty)
+			convey.So(part, convey.ShouldResemble, Person{Age: 18})
+
+			age, err := s.redis.IncrByHash(s.ctx, "struct_hash", "age", 2)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(age, convey.ShouldEqual, 20)
+			score, err := s.redis.IncrByFloatHash(s.ctx, "struct_hash", "score", 0.5)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(score, convey.ShouldEqual, 61)
+			convey.So(s.redis.DelHashField(s.ctx, "struct_hash", "hobby"), convey.ShouldBeEmpty)
+			_, err = s.redis.GetHashField(s.ctx, "struct_hash", "hobby")
+			convey.So(err, convey.ShouldEqual, redis.Nil)
+
+			var fields []string
+			for f := range s.redis.ScanHash(s.ctx, "struct_hash", "*") {
+				fields = append(fields, f.Field)
+			}
+			convey.So(fields, convey.ShouldHaveLength, 3)
+		})
+		convey.Convey("fieldTTL_Hash", func() {
+			convey.So(s.redis.SetHashFieldTTL(s.ctx, "ttl_hash", "token", "abc", 500*time.Millisecond), convey.ShouldBeEmpty)
+			convey.So(s.redis.SetHashFieldTTL(s.ctx, "ttl_hash", "profile", map[string]int{"level": 3}, 0), convey.ShouldBeEmpty)
+			v, err := s.redis.GetHashField(s.ctx, "ttl_hash", "token")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(v, convey.ShouldEqual, "abc")
+
+			<-time.NewTimer(time.Second).C
+			_, err = s.redis.GetHashField(s.ctx, "ttl_hash", "token")
+			convey.So(err, convey.ShouldEqual, redis.Nil)
+			v, err = s.redis.GetHashField(s.ctx, "ttl_hash", "profile")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(v, convey.ShouldEqual, `{"level":3}`)
+
+			convey.So(s.redis.SetHashFieldTTL(s.ctx, "ttl_hash", "token", "abc", time.Millisecond), convey.ShouldBeEmpty)
+			<-time.NewTimer(10 * time.Millisecond).C
+			n, err := s.redis.PurgeHash(s.ctx, "ttl_hash")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(n, convey.ShouldEqual, 1)
+		})
 	})
 }
 
@@ -239,11 +299,34 @@ func (s *MainClientSuite) Test_List() {
 
 			var item3 float32
 			convey.So(s.redis.PopList(s.ctx, "pop_list", &item3), convey.ShouldBeEmpty)
-			convey.So(item3, convey.ShouldEqual, 3.14159)
+			convey.So(item3, convey.ShouldEqual, float32(3.14159))
 			len3, err := s.redis.LenList(s.ctx, "pop_list")
 			convey.So(err, convey.ShouldBeEmpty)
 			convey.So(len3, convey.ShouldEqual, len(ll)-3)
 		})
+		convey.Convey("flag_List", func() {
+			convey.So(s.redis.PushListBy(s.ctx, "flag_list", EndPoint, "b", "c"), convey.ShouldBeEmpty)
+			convey.So(s.redis.PushListBy(s.ctx, "flag_list", StartPoint, "a"), convey.ShouldBeEmpty)
+			convey.So(s.redis.PushListBy(s.ctx, "flag_list", ListFlag(0), "x"), convey.ShouldNotBeNil)
+			all, err := s.redis.RangeList(s.ctx, "flag_list", 0, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(all, convey.ShouldResemble, []string{"a", "b", "c"})
+
+			var tail string
+			convey.So(s.redis.PopListBy(s.ctx, "flag_list", EndPoint, &tail), convey.ShouldBeEmpty)
+			convey.So(tail, convey.ShouldEqual, "c")
+			key, head, err := s.redis.BlockPopList(s.ctx, StartPoint, time.Second, "empty_list", "flag_list")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(key, convey.ShouldEqual, "flag_list")
+			convey.So(head, convey.ShouldEqual, "a")
+		})
+		convey.Convey("trim_List", func() {
+			convey.So(s.redis.PushList(s.ctx, "trim_list", 1, 2, 3, 4, 5), convey.ShouldBeEmpty)
+			convey.So(s.redis.TrimList(s.ctx, "trim_list", 1, 3), convey.ShouldBeEmpty)
+			all, err := s.redis.RangeList(s.ctx, "trim_list", 0, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(all, convey.ShouldResemble, []string{"2", "3", "4"})
+		})
 	})
 }
 
@@ -274,6 +357,29 @@ func (s *MainClientSuite) Test_Set() {
 			convey.So(s.redis.GetMixed(s.ctx, "RemEleSet", &Vset), convey.ShouldBeEmpty)
 			convey.So(len(Vset), convey.ShouldEqual, 2)
 		})
+		convey.Convey("algebra_set", func() {
+			convey.So(s.redis.AddSet(s.ctx, "setA", "a", "b", "c"), convey.ShouldBeEmpty)
+			convey.So(s.redis.AddSet(s.ctx, "setB", "b", "c", "d"), convey.ShouldBeEmpty)
+			union, err := s.redis.UnionSet(s.ctx, "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(union, convey.ShouldHaveLength, 4)
+			inter, err := s.redis.InterSet(s.ctx, "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(inter, convey.ShouldHaveLength, 2)
+			diff, err := s.redis.DiffSet(s.ctx, "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(diff, convey.ShouldResemble, []string{"a"})
+
+			n, err := s.redis.InterStoreSet(s.ctx, "setAB", "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(n, convey.ShouldEqual, 2)
+			n, err = s.redis.UnionStoreSet(s.ctx, "setAorB", "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(n, convey.ShouldEqual, 4)
+			n, err = s.redis.DiffStoreSet(s.ctx, "setAnotB", "setA", "setB")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(n, convey.ShouldEqual, 1)
+		})
 	})
 }
 
@@ -330,6 +436,224 @@ func (s *MainClientSuite) Test_ZSet() {
 				{Score: -0.5, Member: "sin"},
 			})
 		})
+		convey.Convey("ByScore_zset", func() {
+			zmap := []*ZSetMember{
+				{Score: 1, Member: "a"},
+				{Score: 2, Member: "b"},
+				{Score: 3, Member: "c"},
+				{Score: 4, Member: "d"},
+			}
+			convey.So(s.redis.AddZSet(s.ctx, "bs_ZSet", zmap...), convey.ShouldBeEmpty)
+			res, err := s.redis.RangeByScoreZSet(s.ctx, "bs_ZSet", "(1", "+inf", 1, 2, false)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(res, convey.ShouldResemble, []*ZSetMember{{Score: 3, Member: "c"}, {Score: 4, Member: "d"}})
+			res, err = s.redis.RangeByScoreZSet(s.ctx, "bs_ZSet", "-inf", "+inf", 0, 0, true)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(res, convey.ShouldHaveLength, 4)
+			convey.So(res[0].Member, convey.ShouldEqual, "d")
+
+			rank, err := s.redis.RankZSet(s.ctx, "bs_ZSet", "b", false)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(rank, convey.ShouldEqual, 1)
+			score, err := s.redis.IncrByZSet(s.ctx, "bs_ZSet", 10, "b")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(score, convey.ShouldEqual, 12)
+			rank, err = s.redis.RankZSet(s.ctx, "bs_ZSet", "b", true)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(rank, convey.ShouldEqual, 0)
+		})
+		convey.Convey("leaderboard_zset", func() {
+			lb := NewLeaderboard(s.redis, "lb_ZSet")
+			for i, m := range []string{"a", "b", "c", "d", "e"} {
+				convey.So(lb.SetScore(s.ctx, m, float64(i)), convey.ShouldBeEmpty)
+			}
+			_, err := lb.Incr(s.ctx, "a", 100)
+			convey.So(err, convey.ShouldBeEmpty)
+			top, err := lb.Top(s.ctx, 2)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(top, convey.ShouldResemble, []*LeaderboardEntry{
+				{Rank: 1, Member: "a", Score: 100},
+				{Rank: 2, Member: "e", Score: 4},
+			})
+			page, err := lb.Page(s.ctx, 2, 2)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(page[0], convey.ShouldResemble, &LeaderboardEntry{Rank: 3, Member: "d", Score: 3})
+			entry, err := lb.Rank(s.ctx, "c")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(entry, convey.ShouldResemble, &LeaderboardEntry{Rank: 4, Member: "c", Score: 2})
+			around, err := lb.Around(s.ctx, "c", 1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(around, convey.ShouldHaveLength, 3)
+			missing, err := lb.Rank(s.ctx, "nobody")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(missing, convey.ShouldBeNil)
+		})
 
 	})
 }
+
+func (s *MainClientSuite) Test_Stream() {
+	convey.Convey("Test_Stream", s.T(), func() {
+		convey.Reset(func() {
+			s.BeforeTest("MainClientSuite", "Test_Stream")
+		})
+		convey.Convey("ack_stream", func() {
+			convey.So(s.redis.CreateStreamGroup(s.ctx, "hello_stream", "g1", "0"), convey.ShouldBeEmpty)
+			convey.So(s.redis.CreateStreamGroup(s.ctx, "hello_stream", "g1", "0"), convey.ShouldBeEmpty)
+			id, err := s.redis.AddStream(s.ctx, "hello_stream", 100, map[string]interface{}{"name": "tom"})
+			convey.So(err, convey.ShouldBeEmpty)
+
+			msgs, err := s.redis.ReadGroupStream(s.ctx, "hello_stream", "g1", "c1", 10, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(len(msgs), convey.ShouldEqual, 1)
+			convey.So(msgs[0].ID, convey.ShouldEqual, id)
+			convey.So(msgs[0].Values["name"], convey.ShouldEqual, "tom")
+
+			pending, err := s.redis.PendingStream(s.ctx, "hello_stream", "g1", "-", "+", 10)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(len(pending), convey.ShouldEqual, 1)
+			convey.So(pending[0].Consumer, convey.ShouldEqual, "c1")
+			convey.So(pending[0].RetryCount, convey.ShouldEqual, 1)
+
+			convey.So(s.redis.AckStream(s.ctx, "hello_stream", "g1", id), convey.ShouldBeEmpty)
+			pending, err = s.redis.PendingStream(s.ctx, "hello_stream", "g1", "-", "+", 10)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(len(pending), convey.ShouldEqual, 0)
+		})
+		convey.Convey("autoclaim_stream", func() {
+			convey.So(s.redis.CreateStreamGroup(s.ctx, "claim_stream", "g1", "0"), convey.ShouldBeEmpty)
+			id, err := s.redis.AddStream(s.ctx, "claim_stream", 0, map[string]interface{}{"k": "v"})
+			convey.So(err, convey.ShouldBeEmpty)
+			_, err = s.redis.ReadGroupStream(s.ctx, "claim_stream", "g1", "c1", 10, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+
+			<-time.NewTimer(100 * time.Millisecond).C
+			next, msgs, err := s.redis.AutoClaimStream(s.ctx, "claim_stream", "g1", "c2", 50*time.Millisecond, "0-0", 10)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(next, convey.ShouldEqual, "0-0")
+			convey.So(len(msgs), convey.ShouldEqual, 1)
+			convey.So(msgs[0].ID, convey.ShouldEqual, id)
+			pending, err := s.redis.PendingStream(s.ctx, "claim_stream", "g1", id, id, 1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(pending[0].Consumer, convey.ShouldEqual, "c2")
+			convey.So(pending[0].RetryCount, convey.ShouldEqual, 2)
+		})
+		convey.Convey("dead_letter_stream", func() {
+			ctx, cancel := context.WithTimeout(s.ctx, 3*time.Second)
+			defer cancel()
+			_, err := s.redis.AddStream(s.ctx, "dl_stream", 0, map[string]interface{}{"k": "v"})
+			convey.So(err, convey.ShouldBeEmpty)
+			sc, err := NewStreamConsumer(s.redis, "dl_stream", "g1", "c1",
+				func(ctx context.Context, msg *StreamMessage) error {
+					return fmt.Errorf("always failed")
+				},
+				StreamWithBatch(10, 100*time.Millisecond),
+				StreamWithClaim(50*time.Millisecond, 100*time.Millisecond),
+				StreamWithDeadLetter("dl_stream:dead", 2),
+			)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(sc.Run(ctx), convey.ShouldBeEmpty)
+
+			convey.So(s.redis.CreateStreamGroup(s.ctx, "dl_stream:dead", "g1", "0"), convey.ShouldBeEmpty)
+			dead, err := s.redis.ReadGroupStream(s.ctx, "dl_stream:dead", "g1", "c1", 10, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(len(dead), convey.ShouldEqual, 1)
+			convey.So(dead[0].Values[DeadLetterOriginStream], convey.ShouldEqual, "dl_stream")
+			pending, err := s.redis.PendingStream(s.ctx, "dl_stream", "g1", "-", "+", 10)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(len(pending), convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func (s *MainClientSuite) Test_PubSub() {
+	convey.Convey("Test_PubSub", s.T(), func() {
+		convey.Convey("pattern_PubSub", func() {
+			ctx, cancel := context.WithCancel(s.ctx)
+			ch, err := s.redis.PSubscribe(ctx, "news.*")
+			convey.So(err, convey.ShouldBeEmpty)
+			<-time.NewTimer(100 * time.Millisecond).C
+			convey.So(s.redis.Publish(s.ctx, "news.sport", "goal"), convey.ShouldBeEmpty)
+			msg := <-ch
+			convey.So(msg.Pattern, convey.ShouldEqual, "news.*")
+			convey.So(msg.Channel, convey.ShouldEqual, "news.sport")
+			convey.So(msg.Payload, convey.ShouldEqual, "goal")
+			cancel()
+			_, ok := <-ch
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+		convey.Convey("dynamic_PubSub", func() {
+			ps := s.redis.NewPubSub(s.ctx)
+			defer ps.Close()
+			convey.So(ps.Subscribe(s.ctx, "ch1", "ch2"), convey.ShouldBeEmpty)
+			convey.So(ps.Unsubscribe(s.ctx, "ch1"), convey.ShouldBeEmpty)
+			<-time.NewTimer(100 * time.Millisecond).C
+			convey.So(s.redis.Publish(s.ctx, "ch1", "lost"), convey.ShouldBeEmpty)
+			convey.So(s.redis.Publish(s.ctx, "ch2", "hello"), convey.ShouldBeEmpty)
+			msg := <-ps.Channel()
+			convey.So(msg.Channel, convey.ShouldEqual, "ch2")
+			convey.So(msg.Payload, convey.ShouldEqual, "hello")
+		})
+	})
+}
+
+func (s *MainClientSuite) Test_NearCache() {
+	convey.Convey("Test_NearCache", s.T(), func() {
+		convey.Reset(func() {
+			s.BeforeTest("MainClientSuite", "Test_NearCache")
+		})
+		convey.Convey("invalidate_NearCache", func() {
+			ctx, cancel := context.WithCancel(s.ctx)
+			defer cancel()
+			nc1, err := NewNearCache(ctx, s.redis, NearCacheWithChannel("near_test"))
+			convey.So(err, convey.ShouldBeEmpty)
+			nc2, err := NewNearCache(ctx, s.redis, NearCacheWithChannel("near_test"))
+			convey.So(err, convey.ShouldBeEmpty)
+			<-time.NewTimer(100 * time.Millisecond).C
+
+			convey.So(nc1.SetStr(s.ctx, "near_key", "v1"), convey.ShouldBeEmpty)
+			<-time.NewTimer(100 * time.Millisecond).C
+			var v string
+			convey.So(nc2.GetMixed(s.ctx, "near_key", &v), convey.ShouldBeEmpty)
+			convey.So(v, convey.ShouldEqual, "v1")
+			convey.So(nc2.GetMixed(s.ctx, "near_key", &v), convey.ShouldBeEmpty)
+			st := nc2.Stats()
+			convey.So(st.Hits, convey.ShouldEqual, 1)
+			convey.So(st.Misses, convey.ShouldEqual, 1)
+			convey.So(st.HitRatio, convey.ShouldEqual, 0.5)
+
+			convey.So(nc1.SetStr(s.ctx, "near_key", "v2"), convey.ShouldBeEmpty)
+			<-time.NewTimer(100 * time.Millisecond).C
+			convey.So(nc2.GetMixed(s.ctx, "near_key", &v), convey.ShouldBeEmpty)
+			convey.So(v, convey.ShouldEqual, "v2")
+			convey.So(nc2.Stats().Invalidations, convey.ShouldEqual, 2)
+		})
+		convey.Convey("types_NearCache", func() {
+			ctx, cancel := context.WithCancel(s.ctx)
+			defer cancel()
+			nc, err := NewNearCache(ctx, s.redis, NearCacheWithMaxEntries(10))
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(nc.PushList(s.ctx, "near_list", 1, 2, 3), convey.ShouldBeEmpty)
+			l, err := nc.RangeList(s.ctx, "near_list", 1, -1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(l, convey.ShouldResemble, []string{"2", "3"})
+			n, err := nc.LenList(s.ctx, "near_list")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(n, convey.ShouldEqual, 3)
+
+			convey.So(nc.AddSet(s.ctx, "near_set", 1, "a"), convey.ShouldBeEmpty)
+			ok, err := nc.CheckSetMember(s.ctx, "near_set", 1)
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(ok, convey.ShouldBeTrue)
+
+			convey.So(nc.SetHash(s.ctx, "near_hash", map[string]interface{}{"age": 18}), convey.ShouldBeEmpty)
+			age, err := nc.GetHashField(s.ctx, "near_hash", "age")
+			convey.So(err, convey.ShouldBeEmpty)
+			convey.So(age, convey.ShouldEqual, "18")
+			_, err = nc.GetHashField(s.ctx, "near_hash", "none")
+			convey.So(err, convey.ShouldEqual, redis.Nil)
+			_, err = nc.GetHashField(s.ctx, "near_missing", "none")
+			convey.So(err, convey.ShouldEqual, redis.Nil)
+		})
+	})
+}
@@ -3,6 +3,10 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/8xmx8/easier/pkg/logger"
@@ -14,36 +18,42 @@ import (
 type Cluster struct {
 	cluster *redis.ClusterClient
 	logg    logger.Logger
+	prefix  string // key前缀
 }
 
-type OptionFuncForCluster func(*redis.ClusterOptions)
+type OptionFuncForCluster func(*clusterOptions)
 
 // WithPasswd 配置密码
 func ClusterWithPasswd(passwd string) OptionFuncForCluster {
-	return func(o *redis.ClusterOptions) {
+	return func(o *clusterOptions) {
 		o.Password = passwd
 	}
 }
 
 // WithAuth 配置鉴权
 func ClusterWithAuth(user, passwd string) OptionFuncForCluster {
-	return func(o *redis.ClusterOptions) {
+	return func(o *clusterOptions) {
 		o.Username = user
 		o.Password = passwd
 	}
 }
 
 func NewCluster(ctx context.Context, addr []string, ops ...OptionFuncForCluster) (Redis, error) {
-	opt := &redis.ClusterOptions{
+	opt := &clusterOptions{ClusterOptions: &redis.ClusterOptions{
 		Addrs:         addr,
 		RouteRandomly: true,
-	}
+	}}
 	for _, op := range ops {
 		op(opt)
 	}
+	cluster := redis.NewClusterClient(opt.ClusterOptions)
+	for _, hook := range opt.redisHooks() {
+		cluster.AddHook(hook)
+	}
 	return &Cluster{
-		cluster: redis.NewClusterClient(opt).WithContext(ctx),
+		cluster: cluster.WithContext(ctx),
 		logg:    logger.DefaultLogger(),
+		prefix:  opt.keyPrefix,
 	}, nil
 }
 
@@ -100,11 +110,12 @@ func (c *Cluster) GetMixed(ctx context.Context, key string, value interface{}) e
 		return c.cluster.ZRevRange(key, 0, -1).ScanSlice(value)
 	case "hash": // nolint
 		// object
-		h := c.cluster.HGetAll(key)
-		if h.Err() != nil {
-			return h.Err()
+		h, err := c.cluster.HGetAll(key).Result()
+		if err != nil {
+			return err
 		}
-		hb, err := json.Marshal(h.Val())
+		filterExpiredHash(h, time.Now())
+		hb, err := json.Marshal(h)
 		if err != nil {
 			return err
 		}
@@ -115,42 +126,52 @@ func (c *Cluster) GetMixed(ctx context.Context, key string, value interface{}) e
 	}
 }
 
+// GetType 获取key对应数据的数据类型: string/list/set/zset/hash/stream, key不存在时返回none
+func (c *Cluster) GetType(ctx context.Context, key string) (string, error) {
+	return c.getType(ctx, key)
+}
+
 // getType 获取key对应数据的数据类型
 func (c *Cluster) getType(ctx context.Context, key string) (string, error) {
 	return c.cluster.Type(key).Result()
 }
 
-// ScanKey 扫描字符串值
+// keyPrefix key前缀, 用于脚本执行前校验slot
+func (c *Cluster) keyPrefix() string {
+	return c.prefix
+}
+
+// keyMeta 在一次往返中读取key的类型、剩余过期时间及hash的field数, 用于 NearCache
+func (c *Cluster) keyMeta(ctx context.Context, key string) (*keyMeta, error) {
+	return readKeyMeta(c.cluster.WithContext(ctx), key)
+}
+
+// ScanKey 扫描所有master节点中与match匹配的key, 每个节点使用独立的游标, ctx结束时停止扫描
 func (c *Cluster) ScanKey(ctx context.Context, match string) chan string {
-	batchSize := 10
-	out := make(chan string, batchSize)
-	go func() {
-		defer close(out)
-		var (
-			cursor uint64
-			err    error
-		)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				var keys []string
-				keys, cursor, err = c.cluster.Scan(cursor, match, int64(batchSize)).Result()
-				if err != nil {
-					c.logg.Error(logger.ErrorCache, "redis scan error", logger.MakeField("match", match), logger.ErrorField(err))
-					return
-				}
-				for _, item := range keys {
-					out <- item
-				}
-				if cursor == 0 {
-					return
-				}
-			}
-		}
-	}()
-	return out
+	return scanKeys(ctx, c.nodes, c.logg, c.prefix, match, "")
+}
+
+// ScanKeyByType 扫描与match匹配且类型为typ(string/list/set/zset/hash/stream)的key(redis >= 6.0)
+func (c *Cluster) ScanKeyByType(ctx context.Context, match, typ string) chan string {
+	return scanKeys(ctx, c.nodes, c.logg, c.prefix, match, typ)
+}
+
+// DelByPattern 使用UNLINK按批删除与match匹配的key, 返回删除的key数
+// 通过 BulkWithRate 限速, BulkWithDryRun 只统计匹配的key数
+func (c *Cluster) DelByPattern(ctx context.Context, match string, ops ...BulkOptionFunc) (int64, error) {
+	return bulkByPattern(ctx, c.nodes, c.prefix, match, ops, unlinkKeys)
+}
+
+// ExpireByPattern 按批为与match匹配的key设置过期时间, 返回设置成功的key数
+func (c *Cluster) ExpireByPattern(ctx context.Context, match string, ttl time.Duration, ops ...BulkOptionFunc) (int64, error) {
+	return bulkByPattern(ctx, c.nodes, c.prefix, match, ops, func(ctx context.Context, node *redis.Client, keys []string) (int64, error) {
+		return expireKeys(ctx, node, keys, ttl)
+	})
+}
+
+// nodes 需要扫描的节点
+func (c *Cluster) nodes(fn func(node *redis.Client) error) error {
+	return c.cluster.ForEachMaster(fn)
 }
 
 /********************************* string接口 **************************************/
@@ -171,24 +192,84 @@ func (c *Cluster) SetNX(ctx context.Context, key, value string, ttl time.Duratio
 
 /*********************************** hash接口 ****************************************/
 
-// SetHash设置hash数据
+// SetHash设置hash数据, 覆盖写入的field取消其TTL
 //
 //	HMSet("myhash", map[string]interface{}{"key1": "value1", "key2": "value2"})
 //
-// TODO: @zcf redis 的Hash不支持 层级结构
+// 层级结构使用 SetHashStruct
 func (c *Cluster) SetHash(ctx context.Context, key string, value map[string]interface{}) error {
-	return c.cluster.HMSet(key, value).Err()
+	return setHash(ctx, c.cluster, key, value)
 }
 
-// GetHashField 获取执行hash的指定field数据
-// TODO: @zcf 当field对应的数据为非string数据类型时, 需要对该方法进行改造(例如: 返回[]byte); 如果要改造,需要对redis interface同步修改
+// GetHashField 获取执行hash的指定field数据, field不存在或已过期时返回 redis.Nil
+// 需要按类型读取时使用 GetHashStruct
 func (c *Cluster) GetHashField(ctx context.Context, key, field string) (string, error) {
-	return c.cluster.HGet(key, field).Result()
+	res, err := readHash(ctx, c.cluster, c.logg, key, field)
+	if err != nil {
+		return "", err
+	}
+	return res[field], nil
+}
+
+// SetHashStruct 将struct按 `redis:"field"` 标签写入hash, 层级结构序列化为JSON
+func (c *Cluster) SetHashStruct(ctx context.Context, key string, value interface{}) error {
+	fields, err := structToHash(value)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return setHash(ctx, c.cluster, key, fields)
+}
+
+// GetHashStruct 读取hash并按 `redis:"field"` 标签写入struct, value需为struct指针
+// fields 为空时读取全部field, 否则只读取指定的field; key或field都不存在时返回 redis.Nil
+func (c *Cluster) GetHashStruct(ctx context.Context, key string, value interface{}, fields ...string) error {
+	res, err := readHash(ctx, c.cluster, c.logg, key, fields...)
+	if err != nil {
+		return err
+	}
+	return hashToStruct(res, value)
+}
+
+// SetHashFieldTTL 写入单个field并设置该field的过期时间, ttl <= 0 时取消过期
+func (c *Cluster) SetHashFieldTTL(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	return setHashFieldTTL(ctx, c.cluster, key, field, value, ttl)
+}
+
+// IncrByHash 为field的整数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (c *Cluster) IncrByHash(ctx context.Context, key, field string, incr int64) (int64, error) {
+	return incrHash(ctx, c, key, field, incr, false).Int64()
+}
+
+// IncrByFloatHash 为field的浮点数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (c *Cluster) IncrByFloatHash(ctx context.Context, key, field string, incr float64) (float64, error) {
+	return incrHash(ctx, c, key, field, incr, true).Float64()
+}
+
+// DelHashField 删除hash中的field
+func (c *Cluster) DelHashField(ctx context.Context, key string, fields ...string) error {
+	all := make([]string, 0, 2*len(fields))
+	for _, f := range fields {
+		all = append(all, f, hashTTLField(f))
+	}
+	return c.cluster.HDel(key, all...).Err()
+}
+
+// ScanHash 流式扫描hash中与match匹配的field
+func (c *Cluster) ScanHash(ctx context.Context, key, match string) chan *HashField {
+	return scanHash(ctx, c.cluster, c.logg, key, match)
+}
+
+// PurgeHash 清理hash中已过期的field, 返回清理的field数
+func (c *Cluster) PurgeHash(ctx context.Context, key string) (int64, error) {
+	return purgeHash(ctx, c.cluster, key)
 }
 
 /*********************************** list接口 ****************************************/
-// TODO: @zcf 针对list类型的头部（左边）和尾部（右边）的操作暂时不做多余实现.
-// 当前默认, list添加数据从尾部（右边）添加, 从头部（左边）开始获取
+// PushList/PopList 默认从尾部（右边）添加, 从头部（左边）开始获取;
+// 需要指定操作点时使用 PushListBy/PopListBy
 
 // PushList 向key对应的list中从尾部(右边)追加数据
 func (c *Cluster) PushList(ctx context.Context, key string, values ...interface{}) error {
@@ -205,8 +286,58 @@ func (c *Cluster) PopList(ctx context.Context, key string, value interface{}) er
 	return c.cluster.LPop(key).Scan(value)
 }
 
+// PushListBy 按flag从头部(StartPoint)或尾部(EndPoint)向list中添加数据
+func (c *Cluster) PushListBy(ctx context.Context, key string, flag ListFlag, values ...interface{}) error {
+	switch flag {
+	case StartPoint:
+		return c.cluster.LPush(key, values...).Err()
+	case EndPoint:
+		return c.cluster.RPush(key, values...).Err()
+	}
+	return errListFlag(flag)
+}
+
+// PopListBy 按flag从头部(StartPoint)或尾部(EndPoint)获取并删除
+func (c *Cluster) PopListBy(ctx context.Context, key string, flag ListFlag, value interface{}) error {
+	switch flag {
+	case StartPoint:
+		return c.cluster.LPop(key).Scan(value)
+	case EndPoint:
+		return c.cluster.RPop(key).Scan(value)
+	}
+	return errListFlag(flag)
+}
+
+// BlockPopList 阻塞式地按flag从多个list中获取并删除第一个可用的元素(BLPOP/BRPOP)
+// timeout 为0时一直阻塞; 超时返回 redis.Nil
+func (c *Cluster) BlockPopList(ctx context.Context, flag ListFlag, timeout time.Duration, keys ...string) (string, string, error) {
+	var cmd *redis.StringSliceCmd
+	switch flag {
+	case StartPoint:
+		cmd = c.cluster.WithContext(ctx).BLPop(timeout, keys...)
+	case EndPoint:
+		cmd = c.cluster.WithContext(ctx).BRPop(timeout, keys...)
+	default:
+		return "", "", errListFlag(flag)
+	}
+	res, err := cmd.Result()
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(res[0], c.prefix), res[1], nil
+}
+
+// RangeList 获取list中[start, stop]区间内的元素, 支持负数下标
+func (c *Cluster) RangeList(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.cluster.LRange(key, start, stop).Result()
+}
+
+// TrimList 只保留list中[start, stop]区间内的元素
+func (c *Cluster) TrimList(ctx context.Context, key string, start, stop int64) error {
+	return c.cluster.LTrim(key, start, stop).Err()
+}
+
 /*********************************** set接口 ****************************************/
-// TODO: @zcf 当前进提供最基本的操作, 暂时不支持集合运算
 
 // AddSet 向key集合中添加成员
 func (c *Cluster) AddSet(ctx context.Context, key string, values ...interface{}) error {
@@ -223,6 +354,36 @@ func (c *Cluster) RemSetEle(ctx context.Context, key string, values ...interface
 	return c.cluster.SRem(key, values...).Err()
 }
 
+// UnionSet 获取多个集合的并集
+func (c *Cluster) UnionSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.cluster.SUnion(keys...).Result()
+}
+
+// InterSet 获取多个集合的交集
+func (c *Cluster) InterSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.cluster.SInter(keys...).Result()
+}
+
+// DiffSet 获取第一个集合与其它集合的差集
+func (c *Cluster) DiffSet(ctx context.Context, keys ...string) ([]string, error) {
+	return c.cluster.SDiff(keys...).Result()
+}
+
+// UnionStoreSet 将多个集合的并集保存到dest, 返回dest的成员数
+func (c *Cluster) UnionStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.cluster.SUnionStore(dest, keys...).Result()
+}
+
+// InterStoreSet 将多个集合的交集保存到dest, 返回dest的成员数
+func (c *Cluster) InterStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.cluster.SInterStore(dest, keys...).Result()
+}
+
+// DiffStoreSet 将第一个集合与其它集合的差集保存到dest, 返回dest的成员数
+func (c *Cluster) DiffStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return c.cluster.SDiffStore(dest, keys...).Result()
+}
+
 /*********************************** 有序集合接口 ****************************************/
 
 // AddZSet 向key有序集合添加成员
@@ -256,26 +417,336 @@ func (c *Cluster) RemMembersZSet(ctx context.Context, key string, members ...str
 	return c.cluster.ZRem(key, members).Err()
 }
 
+// RangeByScoreZSet 分页获取分数在[min, max]区间内的成员及分数
+// min/max 支持 "-inf"、"+inf" 以及 "(1" 形式的开区间; reverse 为true时按分数从高到低排序
+// count <= 0 时不分页
+func (c *Cluster) RangeByScoreZSet(ctx context.Context, key, min, max string, offset, count int64, reverse bool) ([]*ZSetMember, error) {
+	opt := &redis.ZRangeBy{Min: min, Max: max, Offset: offset, Count: count}
+	if count <= 0 {
+		opt.Offset, opt.Count = 0, 0
+	}
+	var cmd *redis.ZSliceCmd
+	if reverse {
+		cmd = c.cluster.ZRevRangeByScoreWithScores(key, opt)
+	} else {
+		cmd = c.cluster.ZRangeByScoreWithScores(key, opt)
+	}
+	zres, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*ZSetMember, 0, len(zres))
+	err = copier.Copy(&values, &zres)
+	return values, err
+}
+
+// RangeWithScoreZSet 按排名获取[start, stop]区间内的成员及分数
+// reverse 为true时按分数从高到低排名
+func (c *Cluster) RangeWithScoreZSet(ctx context.Context, key string, start, stop int64, reverse bool) ([]*ZSetMember, error) {
+	var cmd *redis.ZSliceCmd
+	if reverse {
+		cmd = c.cluster.ZRevRangeWithScores(key, start, stop)
+	} else {
+		cmd = c.cluster.ZRangeWithScores(key, start, stop)
+	}
+	zres, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*ZSetMember, 0, len(zres))
+	err = copier.Copy(&values, &zres)
+	return values, err
+}
+
+// RankZSet 获取成员的排名(从0开始), 成员不存在时返回 redis.Nil
+// reverse 为true时按分数从高到低排名
+func (c *Cluster) RankZSet(ctx context.Context, key, member string, reverse bool) (int64, error) {
+	if reverse {
+		return c.cluster.ZRevRank(key, member).Result()
+	}
+	return c.cluster.ZRank(key, member).Result()
+}
+
+// ScoreZSet 获取成员的分数, 成员不存在时返回 redis.Nil
+func (c *Cluster) ScoreZSet(ctx context.Context, key, member string) (float64, error) {
+	return c.cluster.ZScore(key, member).Result()
+}
+
+// IncrByZSet 为成员的分数加上increment, 返回新的分数
+func (c *Cluster) IncrByZSet(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	return c.cluster.ZIncrBy(key, increment, member).Result()
+}
+
 /*********************************** pubsub接口 ****************************************/
+
+// Publish 向channel发布消息
 func (c *Cluster) Publish(ctx context.Context, key string, value interface{}) error {
 	return c.cluster.Publish(key, value).Err()
 }
 
+// Subscribe 订阅channel, ctx结束时退订并关闭消息通道
 func (c *Cluster) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
-	pubsub := c.cluster.Subscribe(channels...)
-	_, err := pubsub.Receive()
+	ps := c.NewPubSub(ctx)
+	if err := ps.Subscribe(ctx, channels...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// PSubscribe 按pattern订阅, ctx结束时退订并关闭消息通道
+func (c *Cluster) PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error) {
+	ps := c.NewPubSub(ctx)
+	if err := ps.PSubscribe(ctx, patterns...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// NewPubSub 创建一个可动态订阅/退订的发布订阅连接, ctx结束时关闭
+func (c *Cluster) NewPubSub(ctx context.Context) *PubSub {
+	return newPubSub(ctx, c.cluster.Subscribe(), c.logg)
+}
+
+// SPublish 向分片channel发布消息(SPUBLISH, redis >= 7.0)
+func (c *Cluster) SPublish(ctx context.Context, channel string, message interface{}) error {
+	return c.cluster.Do("spublish", channel, message).Err()
+}
+
+// SSubscribe 订阅分片channel(SSUBSCRIBE, redis >= 7.0), ctx结束时退订并关闭消息通道
+// 每个channel使用独立的连接, 首次订阅失败时返回错误
+func (c *Cluster) SSubscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("at least one channel is required")
+	}
+	opt := c.cluster.Options()
+	dialer := shardedDialer{
+		dial:        opt.Dialer,
+		username:    opt.Username,
+		password:    opt.Password,
+		tlsConfig:   opt.TLSConfig,
+		dialTimeout: opt.DialTimeout,
+	}
+	ps, err := newShardedPubSub(ctx, c.logg, dialer, c.locateSlot, channels...)
+	if err != nil {
+		return nil, err
+	}
+	return ps.out, nil
+}
+
+// locateSlot 定位key所在slot的master节点
+func (c *Cluster) locateSlot(key string) (string, error) {
+	slots, err := c.cluster.ClusterSlots().Result()
+	if err != nil {
+		return "", err
+	}
+	slot := keyHashSlot(key)
+	for _, s := range slots {
+		if slot >= s.Start && slot <= s.End && len(s.Nodes) > 0 {
+			return s.Nodes[0].Addr, nil
+		}
+	}
+	return "", fmt.Errorf("slot %d of %q is not served by any node", slot, key)
+}
+
+/*********************************** stream接口 ****************************************/
+
+// AddStream 向stream追加消息(XADD)
+// maxLen > 0 时按近似长度(MAXLEN ~)裁剪stream, 返回消息ID
+func (c *Cluster) AddStream(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return c.cluster.WithContext(ctx).XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       values,
+	}).Result()
+}
+
+// CreateStreamGroup 创建消费者组, stream不存在时自动创建
+// start 为组的起始消费位置, "0"从头消费, "$"只消费新消息; 组已存在时不返回错误
+func (c *Cluster) CreateStreamGroup(ctx context.Context, stream, group, start string) error {
+	err := c.cluster.WithContext(ctx).XGroupCreateMkStream(stream, group, start).Err()
+	if isBusyGroup(err) {
+		return nil
+	}
+	return err
+}
+
+// ReadGroupStream 以消费者组的方式读取新消息(XREADGROUP)
+// block < 0 时不阻塞; 超时无消息时返回空列表
+func (c *Cluster) ReadGroupStream(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error) {
+	res, err := c.cluster.WithContext(ctx).XReadGroup(&redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*StreamMessage
+	for _, s := range res {
+		msgs = append(msgs, toStreamMessages(s.Messages)...)
+	}
+	return msgs, nil
+}
+
+// AckStream 确认消息已处理(XACK)
+func (c *Cluster) AckStream(ctx context.Context, stream, group string, ids ...string) error {
+	return c.cluster.WithContext(ctx).XAck(stream, group, ids...).Err()
+}
+
+// PendingStream 查看消费者组中[start, end]区间内未确认的消息(XPENDING)
+func (c *Cluster) PendingStream(ctx context.Context, stream, group, start, end string, count int64) ([]*StreamPending, error) {
+	res, err := c.cluster.WithContext(ctx).XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  start,
+		End:    end,
+		Count:  count,
+	}).Result()
 	if err != nil {
 		return nil, err
 	}
-	ch := make(chan *Message, 1)
-	go func() {
-		defer close(ch)
-		for msg := range pubsub.Channel() {
-			ch <- &Message{
-				Channel: msg.Channel,
-				Payload: msg.Payload,
-			}
+	pending := make([]*StreamPending, 0, len(res))
+	for _, p := range res {
+		pending = append(pending, &StreamPending{ID: p.ID, Consumer: p.Consumer, Idle: p.Idle, RetryCount: p.RetryCount})
+	}
+	return pending, nil
+}
+
+// AutoClaimStream 将空闲超过minIdle的pending消息转移给consumer(XAUTOCLAIM, redis >= 6.2)
+// 返回下一次扫描的起始ID, 为"0-0"时表示已扫描完毕
+func (c *Cluster) AutoClaimStream(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) (string, []*StreamMessage, error) {
+	res, err := c.cluster.WithContext(ctx).Do("xautoclaim", stream, group, consumer,
+		int64(minIdle/time.Millisecond), start, "count", count).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	return parseAutoClaim(res)
+}
+
+/*********************************** script接口 ****************************************/
+
+// Eval 执行lua脚本, 脚本返回nil时返回 redis.Nil
+// 集群模式下keys需位于同一个slot(可使用 {hashtag})
+func (c *Cluster) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.cluster.WithContext(ctx).Eval(script, keys, args...).Result()
+}
+
+// EvalSha 执行已缓存的lua脚本, 脚本不存在时返回NOSCRIPT错误
+func (c *Cluster) EvalSha(ctx context.Context, sha string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.cluster.WithContext(ctx).EvalSha(sha, keys, args...).Result()
+}
+
+// ScriptLoad 在所有master节点上缓存lua脚本, 返回脚本的sha1
+func (c *Cluster) ScriptLoad(ctx context.Context, script string) (string, error) {
+	var (
+		mu  sync.Mutex
+		sha string
+	)
+	err := c.cluster.ForEachMaster(func(node *redis.Client) error {
+		s, err := node.WithContext(ctx).ScriptLoad(script).Result()
+		mu.Lock()
+		sha = s
+		mu.Unlock()
+		return err
+	})
+	return sha, err
+}
+
+// ScriptExists 检查脚本是否已在所有master节点上缓存
+func (c *Cluster) ScriptExists(ctx context.Context, shas ...string) ([]bool, error) {
+	var mu sync.Mutex
+	exists := make([]bool, len(shas))
+	for i := range exists {
+		exists[i] = true
+	}
+	err := c.cluster.ForEachMaster(func(node *redis.Client) error {
+		res, err := node.WithContext(ctx).ScriptExists(shas...).Result()
+		if err != nil {
+			return err
 		}
-	}()
-	return ch, nil
+		mu.Lock()
+		defer mu.Unlock()
+		for i, ok := range res {
+			exists[i] = exists[i] && ok
+		}
+		return nil
+	})
+	return exists, err
+}
+
+/*********************************** HyperLogLog接口 ****************************************/
+
+// AddHLL 向HyperLogLog添加元素, 基数估计值发生变化时返回true
+func (c *Cluster) AddHLL(ctx context.Context, key string, elements ...interface{}) (bool, error) {
+	n, err := c.cluster.PFAdd(key, elements...).Result()
+	return n == 1, err
+}
+
+// CountHLL 获取HyperLogLog的基数估计值, 多个key时返回并集的基数
+func (c *Cluster) CountHLL(ctx context.Context, keys ...string) (int64, error) {
+	return c.cluster.PFCount(keys...).Result()
+}
+
+// MergeHLL 将多个HyperLogLog合并到dest
+func (c *Cluster) MergeHLL(ctx context.Context, dest string, keys ...string) error {
+	return c.cluster.PFMerge(dest, keys...).Err()
+}
+
+/*********************************** bitmap接口 ****************************************/
+
+// SetBit 设置offset位的值(0或1), 返回原来的值
+func (c *Cluster) SetBit(ctx context.Context, key string, offset int64, value int) (int64, error) {
+	return c.cluster.SetBit(key, offset, value).Result()
+}
+
+// GetBit 获取offset位的值
+func (c *Cluster) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
+	return c.cluster.GetBit(key, offset).Result()
+}
+
+// CountBit 统计字节区间[start, end]内值为1的位数, start=0,end=-1 时统计整个bitmap
+func (c *Cluster) CountBit(ctx context.Context, key string, start, end int64) (int64, error) {
+	return c.cluster.BitCount(key, &redis.BitCount{Start: start, End: end}).Result()
+}
+
+// OpBit 对多个bitmap进行位运算并保存到dest, 返回dest的长度(字节)
+func (c *Cluster) OpBit(ctx context.Context, op BitOp, dest string, keys ...string) (int64, error) {
+	return bitOp(c.cluster, op, dest, keys...)
+}
+
+// BitField 执行BITFIELD子命令, 例如: "incrby", "u8", 0, 1, "get", "u4", 8
+func (c *Cluster) BitField(ctx context.Context, key string, args ...interface{}) ([]int64, error) {
+	return c.cluster.BitField(key, args...).Result()
+}
+
+/*********************************** geo接口 ****************************************/
+
+// AddGeo 添加地理位置, 返回新增的成员数
+func (c *Cluster) AddGeo(ctx context.Context, key string, locations ...*GeoLocation) (int64, error) {
+	return c.cluster.GeoAdd(key, toRedisGeo(locations)...).Result()
+}
+
+// SearchGeo 按半径或矩形查询范围内的成员(GEOSEARCH, redis >= 6.2), 返回成员的坐标及与中心的距离
+func (c *Cluster) SearchGeo(ctx context.Context, key string, query *GeoSearchQuery) ([]*GeoLocation, error) {
+	args, err := geoSearchArgs(key, query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.cluster.WithContext(ctx).Do(args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoSearch(res)
+}
+
+// DistGeo 获取两个成员之间的距离, unit为m/km/ft/mi; 成员不存在时返回 redis.Nil
+func (c *Cluster) DistGeo(ctx context.Context, key, member1, member2, unit string) (float64, error) {
+	return c.cluster.GeoDist(key, member1, member2, unit).Result()
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,9 +14,8 @@ import (
 	"github.com/stretchr/testify/suite"
 )
 
-var (
-	clusterAddrs = []string{"10.10.11.218:7001"}
-)
+// 默认使用 Memory 运行, 设置 REDIS_TEST_CLUSTER(逗号分隔的节点地址) 时连接真实的redis集群
+var clusterAddrs = os.Getenv("REDIS_TEST_CLUSTER")
 
 type MainClusterSuite struct {
 	suite.Suite
@@ -26,14 +27,20 @@ func Test_MainClusterSuite(t *testing.T) {
 	s := &MainClusterSuite{
 		ctx: context.Background(),
 	}
-	redis, err := NewCluster(s.ctx, clusterAddrs)
-	assert.NoError(t, err)
-	s.redis = redis
+	if clusterAddrs == "" {
+		s.redis = NewMemory(s.ctx)
+	} else {
+		redis, err := NewCluster(s.ctx, strings.Split(clusterAddrs, ","))
+		if !assert.NoError(t, err) {
+			return
+		}
+		s.redis = redis
+	}
 	suite.Run(t, s)
 }
 
 func (s *MainClusterSuite) BeforeTest(suiteName, testName string) {
-	assert.NoError(s.T(), s.redis.(*Cluster).cluster.FlushDB().Err())
+	assert.NoError(s.T(), s.redis.FlushDB(s.ctx, false))
 }
 
 func (s *MainClusterSuite) Test_BaseOpreation() {
@@ -238,7 +245,7 @@ func (s *MainClusterSuite) Test_List() {
 
 			var item3 float32
 			convey.So(s.redis.PopList(s.ctx, "pop_list", &item3), convey.ShouldBeEmpty)
-			convey.So(item3, convey.ShouldEqual, 3.14159)
+			convey.So(item3, convey.ShouldEqual, float32(3.14159))
 			len3, err := s.redis.LenList(s.ctx, "pop_list")
 			convey.So(err, convey.ShouldBeEmpty)
 			convey.So(len3, convey.ShouldEqual, len(ll)-3)
@@ -0,0 +1,451 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/8xmx8/easier/pkg/utils"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+基于有序集合的延时任务队列:
+1. 任务按到期时间作为分数写入 delayed(ZSET), 任务内容保存在 jobs(HASH) 中, 任务ID唯一, 重复的ID不会入队;
+2. 到期的任务通过lua脚本原子地从 delayed 移入 ready(LIST);
+3. Worker从 ready 中领取任务, 领取的任务以可见性超时时间为分数写入 inflight(ZSET);
+   Worker崩溃或处理超时, 超过可见性超时的任务会被重新放回 ready;
+4. 处理失败的任务按退避策略重新写入 delayed, 超过最大重试次数后移入 dead(LIST);
+   超时未确认的任务同样计入重试次数, 超过后不再放回 ready 而是移入 dead, 避免导致Worker崩溃的任务无限循环;
+5. 任务可以在执行前按ID取消;
+
+所有key都使用 {name} 作为hashtag, 以保证集群模式下lua脚本操作的key位于同一个slot
+*/
+
+var (
+	ErrJobExists   = errors.New("delay job already exists")
+	ErrJobNotFound = errors.New("delay job not found")
+)
+
+// Job 延时任务
+type Job struct {
+	ID       string `json:"id"`
+	Payload  string `json:"payload"`
+	Attempts int64  `json:"attempts"` // 已领取的次数(含本次)
+	Error    string `json:"error,omitempty"`
+
+	deadline int64 // 领取时的可见性超时时间(unix毫秒), 与Attempts一起标识本次领取
+}
+
+// DelayQueueStats 队列中各状态的任务数
+type DelayQueueStats struct {
+	Delayed  int64 `json:"delayed"`
+	Ready    int64 `json:"ready"`
+	Inflight int64 `json:"inflight"`
+	Dead     int64 `json:"dead"`
+}
+
+// JobHandleFunc 任务处理函数, 返回nil时任务完成, 否则按退避策略重试
+type JobHandleFunc func(context.Context, *Job) error
+
+// DelayQueue 延时任务队列
+type DelayQueue struct {
+	store      *redisDelayStore
+	logg       logger.Logger
+	visibility time.Duration                      // 可见性超时
+	maxRetries int64                              // 最大重试次数, 超过后移入dead
+	backoff    func(attempts int64) time.Duration // 重试的退避时间
+	poll       time.Duration                      // 没有任务时的轮询间隔
+	batch      int64                              // 单次移动到期任务的最大数量
+}
+
+type DelayQueueOptionFunc func(*DelayQueue)
+
+// DelayQueueWithLogger 自定义logger
+func DelayQueueWithLogger(logg logger.Logger) DelayQueueOptionFunc {
+	return func(q *DelayQueue) {
+		q.logg = logg
+	}
+}
+
+// DelayQueueWithVisibility 配置可见性超时, 任务处理超过该时间未确认时会被重新投递
+func DelayQueueWithVisibility(d time.Duration) DelayQueueOptionFunc {
+	return func(q *DelayQueue) {
+		q.visibility = d
+	}
+}
+
+// DelayQueueWithRetry 配置最大重试次数及退避策略
+func DelayQueueWithRetry(maxRetries int64, backoff func(attempts int64) time.Duration) DelayQueueOptionFunc {
+	return func(q *DelayQueue) {
+		q.maxRetries = maxRetries
+		if backoff != nil {
+			q.backoff = backoff
+		}
+	}
+}
+
+// DelayQueueWithPoll 配置没有任务时的轮询间隔
+func DelayQueueWithPoll(d time.Duration) DelayQueueOptionFunc {
+	return func(q *DelayQueue) {
+		q.poll = d
+	}
+}
+
+// ExponentialBackoff 指数退避: base * 2^(attempts-1), 最大为max
+func ExponentialBackoff(base, max time.Duration) func(attempts int64) time.Duration {
+	return func(attempts int64) time.Duration {
+		d := base
+		for i := int64(1); i < attempts && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// NewDelayQueue 实例化基于Redis的延时任务队列, name为队列名
+// 默认可见性超时30s, 最多重试3次, 退避时间从1s开始指数增长, 最长10分钟
+func NewDelayQueue(rds Redis, name string, ops ...DelayQueueOptionFunc) *DelayQueue {
+	q := &DelayQueue{
+		store:      newRedisDelayStore(rds, name),
+		logg:       logger.DefaultLogger(),
+		visibility: 30 * time.Second,
+		maxRetries: 3,
+		backoff:    ExponentialBackoff(time.Second, 10*time.Minute),
+		poll:       time.Second,
+		batch:      100,
+	}
+	for _, op := range ops {
+		op(q)
+	}
+	return q
+}
+
+type JobOptionFunc func(*Job)
+
+// JobWithID 指定任务ID, 相同ID的任务在完成或取消前不会重复入队
+func JobWithID(id string) JobOptionFunc {
+	return func(j *Job) {
+		j.ID = id
+	}
+}
+
+// Enqueue 写入一个delay后执行的任务, 返回任务ID; 任务ID已存在时返回 ErrJobExists
+func (q *DelayQueue) Enqueue(ctx context.Context, payload string, delay time.Duration, ops ...JobOptionFunc) (string, error) {
+	return q.EnqueueAt(ctx, payload, time.Now().Add(delay), ops...)
+}
+
+// EnqueueAt 写入一个在at时刻执行的任务, 返回任务ID; 任务ID已存在时返回 ErrJobExists
+func (q *DelayQueue) EnqueueAt(ctx context.Context, payload string, at time.Time, ops ...JobOptionFunc) (string, error) {
+	job := &Job{Payload: payload}
+	for _, op := range ops {
+		op(job)
+	}
+	if job.ID == "" {
+		job.ID = utils.GenerateRandomKey(16)
+	}
+	ok, err := q.store.Enqueue(ctx, job.ID, payload, at)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return job.ID, ErrJobExists
+	}
+	return job.ID, nil
+}
+
+// Cancel 取消任务, 任务不存在(已完成或已取消)时返回 ErrJobNotFound
+func (q *DelayQueue) Cancel(ctx context.Context, id string) error {
+	ok, err := q.store.Cancel(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Stats 队列中各状态的任务数
+func (q *DelayQueue) Stats(ctx context.Context) (*DelayQueueStats, error) {
+	return q.store.Stats(ctx)
+}
+
+// Run 执行任务, 阻塞直至ctx结束; 多个Worker可以并发执行Run
+func (q *DelayQueue) Run(ctx context.Context, handle JobHandleFunc) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		job, err := q.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			q.logg.Error(logger.ErrorRedis, "delay queue claim", logger.ErrorField(err))
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(q.poll):
+			}
+			continue
+		}
+		q.process(ctx, job, handle)
+	}
+}
+
+// next 移动到期及超时的任务, 并领取一个任务
+func (q *DelayQueue) next(ctx context.Context) (*Job, error) {
+	now := time.Now()
+	if _, err := q.store.Promote(ctx, now, q.batch); err != nil {
+		return nil, err
+	}
+	requeued, buried, err := q.store.Requeue(ctx, now, q.batch, q.maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if requeued > 0 {
+		q.logg.Warn(logger.ErrorRedis, "delay queue requeue timeout jobs", logger.MakeField("count", requeued))
+	}
+	if buried > 0 {
+		q.logg.Error(logger.ErrorRedis, "delay queue timeout jobs moved to dead", logger.MakeField("count", buried))
+	}
+	return q.store.Claim(ctx, now.Add(q.visibility))
+}
+
+func (q *DelayQueue) process(ctx context.Context, job *Job, handle JobHandleFunc) {
+	hctx, cancel := context.WithTimeout(ctx, q.visibility)
+	err := handle(hctx, job)
+	cancel()
+	if err == nil {
+		if ok, err := q.store.Ack(ctx, job); err != nil {
+			q.logg.Error(logger.ErrorRedis, "delay queue ack", logger.MakeField("id", job.ID), logger.ErrorField(err))
+		} else if !ok {
+			q.logg.Warn(logger.ErrorRedis, "delay job lease lost before ack", logger.MakeField("id", job.ID))
+		}
+		return
+	}
+	job.Error = err.Error()
+	if job.Attempts > q.maxRetries {
+		q.logg.Error(logger.ErrorRedis, "delay job moved to dead", logger.MakeField("id", job.ID),
+			logger.MakeField("attempts", job.Attempts), logger.ErrorField(err))
+		if ok, err := q.store.Bury(ctx, job); err != nil {
+			q.logg.Error(logger.ErrorRedis, "delay queue bury", logger.MakeField("id", job.ID), logger.ErrorField(err))
+		} else if !ok {
+			q.logg.Warn(logger.ErrorRedis, "delay job lease lost before bury", logger.MakeField("id", job.ID))
+		}
+		return
+	}
+	delay := q.backoff(job.Attempts)
+	q.logg.Warn(logger.ErrorRedis, "delay job failed, retry later", logger.MakeField("id", job.ID),
+		logger.MakeField("attempts", job.Attempts), logger.MakeField("delay", delay.String()), logger.ErrorField(err))
+	if ok, err := q.store.Retry(ctx, job, time.Now().Add(delay)); err != nil {
+		q.logg.Error(logger.ErrorRedis, "delay queue retry", logger.MakeField("id", job.ID), logger.ErrorField(err))
+	} else if !ok {
+		q.logg.Warn(logger.ErrorRedis, "delay job lease lost before retry", logger.MakeField("id", job.ID))
+	}
+}
+
+/*********************************** redis存储 ****************************************/
+
+var (
+	delayEnqueueScript = NewScript("delay_enqueue", `
+if redis.call('HSETNX', KEYS[4], ARGV[1], ARGV[2]) == 0 then return 0 end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1`)
+
+	// 将 KEYS[1](ZSET) 中分数不大于ARGV[1]的成员移入 KEYS[2](LIST)
+	delayMoveScript = NewScript("delay_move", `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('RPUSH', KEYS[2], id)
+end
+return #ids`)
+
+	// 将 KEYS[3](inflight) 中超时的任务移回 KEYS[2](ready), 领取次数超过ARGV[3]的任务移入 KEYS[6](dead)
+	delayRequeueScript = NewScript("delay_requeue", `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local buried = 0
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[3], id)
+	local attempts = tonumber(redis.call('HGET', KEYS[5], id) or '0')
+	if attempts > tonumber(ARGV[3]) then
+		local payload = redis.call('HGET', KEYS[4], id)
+		redis.call('HDEL', KEYS[4], id)
+		redis.call('HDEL', KEYS[5], id)
+		if payload then
+			redis.call('RPUSH', KEYS[6], cjson.encode({id = id, payload = payload, attempts = attempts, error = ARGV[4]}))
+			buried = buried + 1
+		end
+	else
+		redis.call('RPUSH', KEYS[2], id)
+	end
+end
+return {#ids - buried, buried}`)
+
+	delayClaimScript = NewScript("delay_claim", `
+while true do
+	local id = redis.call('LPOP', KEYS[2])
+	if not id then return false end
+	local payload = redis.call('HGET', KEYS[4], id)
+	if payload then
+		redis.call('ZADD', KEYS[3], ARGV[1], id)
+		local attempts = redis.call('HINCRBY', KEYS[5], id, 1)
+		return {id, payload, attempts}
+	end
+end`)
+
+	// 以下脚本只处理本次领取的任务: ARGV[1]为任务ID, ARGV[2]为领取时的可见性超时时间(inflight中的分数), ARGV[3]为领取次数;
+	// 任务超时后被重新领取时二者会改变, 过期的确认不会影响其他Worker的领取
+	delayAckScript = NewScript("delay_ack", delayOwnedCheck+`
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+return 1`)
+
+	delayRetryScript = NewScript("delay_retry", delayOwnedCheck+`
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+return 1`)
+
+	delayBuryScript = NewScript("delay_bury", delayOwnedCheck+`
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('RPUSH', KEYS[6], ARGV[4])
+return 1`)
+
+	delayCancelScript = NewScript("delay_cancel", `
+local n = redis.call('ZREM', KEYS[1], ARGV[1]) + redis.call('LREM', KEYS[2], 0, ARGV[1]) + redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+return redis.call('HDEL', KEYS[4], ARGV[1])`)
+)
+
+// delayOwnedCheck 校验任务仍处于本次领取中
+const delayOwnedCheck = `
+local deadline = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if not deadline or tonumber(deadline) ~= tonumber(ARGV[2]) then return 0 end
+if redis.call('HGET', KEYS[5], ARGV[1]) ~= ARGV[3] then return 0 end`
+
+// errVisibilityTimeout 超时未确认的任务移入dead时记录的错误
+const errVisibilityTimeout = "visibility timeout exceeded"
+
+// redisDelayStore 基于Redis的延时队列存储, 每个方法都通过lua脚本原子地执行
+type redisDelayStore struct {
+	rds Redis
+	// delayed, ready, inflight, jobs, attempts, dead
+	keys []string
+}
+
+func newRedisDelayStore(rds Redis, name string) *redisDelayStore {
+	prefix := "{" + name + "}:delay:"
+	return &redisDelayStore{
+		rds: rds,
+		keys: []string{
+			prefix + "delayed", prefix + "ready", prefix + "inflight",
+			prefix + "jobs", prefix + "attempts", prefix + "dead",
+		},
+	}
+}
+
+func (s *redisDelayStore) Enqueue(ctx context.Context, id, payload string, dueAt time.Time) (bool, error) {
+	return s.evalBool(ctx, delayEnqueueScript, id, payload, dueAt.UnixMilli())
+}
+
+func (s *redisDelayStore) Promote(ctx context.Context, now time.Time, limit int64) (int64, error) {
+	return s.evalInt(ctx, delayMoveScript, []string{s.keys[0], s.keys[1]}, now.UnixMilli(), limit)
+}
+
+// Requeue 将超时未确认的任务移回ready, 领取次数超过maxRetries的任务移入dead; 返回移回与移入dead的任务数
+func (s *redisDelayStore) Requeue(ctx context.Context, now time.Time, limit, maxRetries int64) (int64, int64, error) {
+	res, err := delayRequeueScript.Run(ctx, s.rds, s.keys, now.UnixMilli(), limit, maxRetries, errVisibilityTimeout).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("unexpected requeue reply: %v", res)
+	}
+	return res[0], res[1], nil
+}
+
+// Claim 领取一个任务, 以deadline作为可见性超时时间; 没有任务时返回nil
+func (s *redisDelayStore) Claim(ctx context.Context, deadline time.Time) (*Job, error) {
+	arr, err := delayClaimScript.Run(ctx, s.rds, s.keys, deadline.UnixMilli()).Slice()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(arr) != 3 {
+		return nil, fmt.Errorf("unexpected claim reply: %v", arr)
+	}
+	job := &Job{deadline: deadline.UnixMilli()}
+	job.ID, _ = arr[0].(string)
+	job.Payload, _ = arr[1].(string)
+	job.Attempts, _ = arr[2].(int64)
+	return job, nil
+}
+
+// Ack 确认任务完成; 任务已不属于本次领取(超时后被重新投递)时返回false
+func (s *redisDelayStore) Ack(ctx context.Context, job *Job) (bool, error) {
+	return s.evalBool(ctx, delayAckScript, job.ID, job.deadline, job.Attempts)
+}
+
+// Retry 任务在dueAt时重试; 任务已不属于本次领取时返回false
+func (s *redisDelayStore) Retry(ctx context.Context, job *Job, dueAt time.Time) (bool, error) {
+	return s.evalBool(ctx, delayRetryScript, job.ID, job.deadline, job.Attempts, dueAt.UnixMilli())
+}
+
+// Bury 任务移入dead; 任务已不属于本次领取时返回false
+func (s *redisDelayStore) Bury(ctx context.Context, job *Job) (bool, error) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	return s.evalBool(ctx, delayBuryScript, job.ID, job.deadline, job.Attempts, string(b))
+}
+
+func (s *redisDelayStore) Cancel(ctx context.Context, id string) (bool, error) {
+	return s.evalBool(ctx, delayCancelScript, id)
+}
+
+func (s *redisDelayStore) Stats(ctx context.Context) (*DelayQueueStats, error) {
+	var (
+		st  = &DelayQueueStats{}
+		err error
+	)
+	if st.Delayed, err = s.rds.CardZSet(ctx, s.keys[0]); err != nil {
+		return nil, err
+	}
+	if st.Ready, err = s.rds.LenList(ctx, s.keys[1]); err != nil {
+		return nil, err
+	}
+	if st.Inflight, err = s.rds.CardZSet(ctx, s.keys[2]); err != nil {
+		return nil, err
+	}
+	if st.Dead, err = s.rds.LenList(ctx, s.keys[5]); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *redisDelayStore) evalBool(ctx context.Context, script *Script, args ...interface{}) (bool, error) {
+	n, err := s.evalInt(ctx, script, s.keys, args...)
+	return n > 0, err
+}
+
+func (s *redisDelayStore) evalInt(ctx context.Context, script *Script, keys []string, args ...interface{}) (int64, error) {
+	return script.Run(ctx, s.rds, keys, args...).Int64()
+}
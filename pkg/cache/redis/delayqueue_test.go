@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, b(1))
+	assert.Equal(t, 2*time.Second, b(2))
+	assert.Equal(t, 4*time.Second, b(3))
+	assert.Equal(t, 5*time.Second, b(4))
+	assert.Equal(t, 5*time.Second, b(100))
+}
+
+// newMiniRedis 基于miniredis的Client, 用于测试lua脚本
+func newMiniRedis(t *testing.T) (Redis, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rds, err := NewClient(context.Background(), mr.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rds, mr
+}
+
+func TestDelayStore(t *testing.T) {
+	ctx := context.Background()
+	rds, _ := newMiniRedis(t)
+	s := newRedisDelayStore(rds, "q")
+	now := time.Now()
+
+	ok, _ := s.Enqueue(ctx, "a", "pa", now.Add(-time.Second))
+	assert.True(t, ok)
+	ok, _ = s.Enqueue(ctx, "a", "pa", now)
+	assert.False(t, ok) // 重复ID
+	_, _ = s.Enqueue(ctx, "b", "pb", now.Add(time.Hour))
+
+	n, _ := s.Promote(ctx, now, 10)
+	assert.Equal(t, int64(1), n)
+	job, err := s.Claim(ctx, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, &Job{ID: "a", Payload: "pa", Attempts: 1, deadline: now.Add(time.Second).UnixMilli()}, job)
+	job, _ = s.Claim(ctx, now)
+	assert.Nil(t, job)
+
+	// 可见性超时后重新投递
+	requeued, buried, err := s.Requeue(ctx, now.Add(2*time.Second), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+	assert.Equal(t, int64(0), buried)
+	job, _ = s.Claim(ctx, now.Add(time.Second))
+	assert.Equal(t, int64(2), job.Attempts)
+	ok, _ = s.Ack(ctx, job)
+	assert.True(t, ok)
+	ok, _ = s.Ack(ctx, job)
+	assert.False(t, ok)
+
+	ok, _ = s.Cancel(ctx, "b")
+	assert.True(t, ok)
+	ok, _ = s.Cancel(ctx, "b")
+	assert.False(t, ok)
+	st, _ := s.Stats(ctx)
+	assert.Equal(t, &DelayQueueStats{}, st)
+}
+
+func TestDelayStoreStaleClaim(t *testing.T) {
+	ctx := context.Background()
+	rds, _ := newMiniRedis(t)
+	s := newRedisDelayStore(rds, "q")
+	now := time.Now()
+	_, _ = s.Enqueue(ctx, "a", "pa", now)
+	_, _ = s.Promote(ctx, now, 10)
+
+	// 第一个Worker处理超时, 任务被重新投递给第二个Worker
+	stale, _ := s.Claim(ctx, now)
+	_, _, _ = s.Requeue(ctx, now, 10, 3)
+	job, _ := s.Claim(ctx, now)
+	if !assert.NotNil(t, job) {
+		return
+	}
+	assert.Equal(t, int64(2), job.Attempts)
+
+	// 过期的确认不影响第二个Worker的领取
+	ok, err := s.Ack(ctx, stale)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, _ = s.Retry(ctx, stale, now)
+	assert.False(t, ok)
+	ok, _ = s.Bury(ctx, stale)
+	assert.False(t, ok)
+	st, _ := s.Stats(ctx)
+	assert.Equal(t, &DelayQueueStats{Inflight: 1}, st)
+
+	ok, _ = s.Retry(ctx, job, now)
+	assert.True(t, ok)
+	st, _ = s.Stats(ctx)
+	assert.Equal(t, &DelayQueueStats{Delayed: 1}, st)
+}
+
+func TestDelayStoreCrashRequeue(t *testing.T) {
+	ctx := context.Background()
+	rds, _ := newMiniRedis(t)
+	s := newRedisDelayStore(rds, "q")
+	now := time.Now()
+	_, _ = s.Enqueue(ctx, "poison", "p", now)
+	_, _ = s.Promote(ctx, now, 10)
+
+	// Worker每次领取后都崩溃, 超过最大重试次数后移入dead
+	for i := int64(1); i <= 3; i++ {
+		job, err := s.Claim(ctx, now)
+		if !assert.NoError(t, err) || !assert.NotNil(t, job) {
+			return
+		}
+		assert.Equal(t, i, job.Attempts)
+		requeued, buried, err := s.Requeue(ctx, now, 10, 2)
+		assert.NoError(t, err)
+		if i <= 2 {
+			assert.Equal(t, [2]int64{1, 0}, [2]int64{requeued, buried})
+		} else {
+			assert.Equal(t, [2]int64{0, 1}, [2]int64{requeued, buried})
+		}
+	}
+	st, _ := s.Stats(ctx)
+	assert.Equal(t, &DelayQueueStats{Dead: 1}, st)
+	dead, _ := rds.RangeList(ctx, s.keys[5], 0, -1)
+	var job Job
+	assert.NoError(t, json.Unmarshal([]byte(dead[0]), &job))
+	assert.Equal(t, Job{ID: "poison", Payload: "p", Attempts: 3, Error: errVisibilityTimeout}, job)
+}
+
+func TestDelayQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rds, _ := newMiniRedis(t)
+	q := NewDelayQueue(rds, "q",
+		DelayQueueWithPoll(10*time.Millisecond),
+		DelayQueueWithRetry(2, func(int64) time.Duration { return 10 * time.Millisecond }))
+
+	// 到期时间精确到毫秒
+	start := time.Now().Truncate(time.Millisecond)
+	id, err := q.Enqueue(ctx, "ok", 50*time.Millisecond, JobWithID("job-ok"))
+	assert.NoError(t, err)
+	assert.Equal(t, "job-ok", id)
+	_, err = q.Enqueue(ctx, "ok", 0, JobWithID("job-ok"))
+	assert.Equal(t, ErrJobExists, err)
+	_, err = q.Enqueue(ctx, "fail", 0, JobWithID("job-fail"))
+	assert.NoError(t, err)
+	cid, _ := q.Enqueue(ctx, "cancel", time.Hour)
+	assert.NoError(t, q.Cancel(ctx, cid))
+	assert.Equal(t, ErrJobNotFound, q.Cancel(ctx, cid))
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int64)
+		done     = make(chan struct{})
+		okAt     time.Duration
+	)
+	go func() {
+		_ = q.Run(ctx, func(_ context.Context, job *Job) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[job.ID] = job.Attempts
+			if job.Payload == "ok" {
+				okAt = time.Since(start)
+				return nil
+			}
+			if job.Attempts == 3 {
+				close(done)
+			}
+			return errors.New("failed")
+		})
+	}()
+	<-done
+	assert.Eventually(t, func() bool {
+		st, _ := q.Stats(ctx)
+		return st.Dead == 1 && st.Delayed == 0 && st.Inflight == 0
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(1), attempts["job-ok"])
+	assert.Equal(t, int64(3), attempts["job-fail"]) // 首次执行 + 2次重试
+	assert.GreaterOrEqual(t, okAt, 50*time.Millisecond)
+}
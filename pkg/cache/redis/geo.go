@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// geoSearchArgs 构造 GEOSEARCH 命令(redis >= 6.2), 总是返回距离和坐标
+func geoSearchArgs(key string, q *GeoSearchQuery) ([]interface{}, error) {
+	unit := q.Unit
+	if unit == "" {
+		unit = "m"
+	}
+	args := []interface{}{"geosearch", key}
+	if q.Member != "" {
+		args = append(args, "frommember", q.Member)
+	} else {
+		args = append(args, "fromlonlat", q.Longitude, q.Latitude)
+	}
+	switch {
+	case q.Radius > 0:
+		args = append(args, "byradius", q.Radius, unit)
+	case q.Width > 0 && q.Height > 0:
+		args = append(args, "bybox", q.Width, q.Height, unit)
+	default:
+		return nil, fmt.Errorf("geo search requires radius or width/height")
+	}
+	switch strings.ToUpper(q.Sort) {
+	case "":
+	case "ASC", "DESC":
+		args = append(args, strings.ToUpper(q.Sort))
+	default:
+		return nil, fmt.Errorf("unsupported geo search sort %q", q.Sort)
+	}
+	if q.Count > 0 {
+		args = append(args, "count", q.Count)
+	}
+	return append(args, "withcoord", "withdist"), nil
+}
+
+// parseGeoSearch 解析 GEOSEARCH ... WITHCOORD WITHDIST 的返回: [[name, dist, [lon, lat]], ...]
+func parseGeoSearch(res interface{}) ([]*GeoLocation, error) {
+	arr, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected geosearch reply: %v", res)
+	}
+	locs := make([]*GeoLocation, 0, len(arr))
+	for _, item := range arr {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected geosearch item: %v", item)
+		}
+		loc := &GeoLocation{}
+		loc.Name, _ = fields[0].(string)
+		dist, _ := fields[1].(string)
+		loc.Dist, _ = strconv.ParseFloat(dist, 64)
+		if coord, ok := fields[2].([]interface{}); ok && len(coord) == 2 {
+			lon, _ := coord[0].(string)
+			lat, _ := coord[1].(string)
+			loc.Longitude, _ = strconv.ParseFloat(lon, 64)
+			loc.Latitude, _ = strconv.ParseFloat(lat, 64)
+		}
+		locs = append(locs, loc)
+	}
+	return locs, nil
+}
+
+func toRedisGeo(locations []*GeoLocation) []*redis.GeoLocation {
+	geos := make([]*redis.GeoLocation, 0, len(locations))
+	for _, l := range locations {
+		geos = append(geos, &redis.GeoLocation{Name: l.Name, Longitude: l.Longitude, Latitude: l.Latitude})
+	}
+	return geos
+}
+
+// bitOp 执行 BITOP, 返回dest的长度(字节)
+func bitOp(cmd redis.Cmdable, op BitOp, dest string, keys ...string) (int64, error) {
+	switch op {
+	case BitAnd:
+		return cmd.BitOpAnd(dest, keys...).Result()
+	case BitOr:
+		return cmd.BitOpOr(dest, keys...).Result()
+	case BitXor:
+		return cmd.BitOpXor(dest, keys...).Result()
+	case BitNot:
+		if len(keys) != 1 {
+			return 0, fmt.Errorf("bitop NOT requires exactly one source key")
+		}
+		return cmd.BitOpNot(dest, keys[0]).Result()
+	}
+	return 0, fmt.Errorf("unsupported bitop %q", op)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoSearchArgs(t *testing.T) {
+	args, err := geoSearchArgs("stores", &GeoSearchQuery{Member: "a", Radius: 5, Unit: "km", Count: 10, Sort: "asc"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"geosearch", "stores", "frommember", "a", "byradius", 5.0, "km",
+		"ASC", "count", int64(10), "withcoord", "withdist"}, args)
+
+	args, err = geoSearchArgs("stores", &GeoSearchQuery{Longitude: 116.4, Latitude: 39.9, Width: 2, Height: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"geosearch", "stores", "fromlonlat", 116.4, 39.9, "bybox", 2.0, 1.0, "m",
+		"withcoord", "withdist"}, args)
+
+	_, err = geoSearchArgs("stores", &GeoSearchQuery{Member: "a"})
+	assert.Error(t, err)
+}
+
+func TestParseGeoSearch(t *testing.T) {
+	locs, err := parseGeoSearch([]interface{}{
+		[]interface{}{"a", "0.1234", []interface{}{"116.40000039339065552", "39.90000009167092543"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, locs, 1)
+	assert.Equal(t, "a", locs[0].Name)
+	assert.Equal(t, 0.1234, locs[0].Dist)
+	assert.InDelta(t, 116.4, locs[0].Longitude, 1e-6)
+	assert.InDelta(t, 39.9, locs[0].Latitude, 1e-6)
+}
+
+func TestActiveUsersKeys(t *testing.T) {
+	a := NewActiveUsers(nil, "app")
+	day := time.Date(2024, 1, 3, 10, 0, 0, 0, time.Local) // 周三
+	assert.Equal(t, "{app}:dau:20240103", a.DailyKey(day))
+	assert.Equal(t, "{app}:wau:2024-W01", a.WeeklyKey(day))
+	days := weekDays(day)
+	assert.Len(t, days, 7)
+	assert.Equal(t, "{app}:dau:20240101", a.DailyKey(days[0]))
+	assert.Equal(t, "{app}:dau:20240107", a.DailyKey(days[6]))
+	assert.Equal(t, "{app}:dau:20240101", a.DailyKey(weekDays(days[0])[0]))
+	assert.Equal(t, "{app}:dau:20240101", a.DailyKey(weekDays(days[6])[0]))
+}
@@ -0,0 +1,404 @@
+package cache
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+struct与hash的映射规则:
+1. 通过 `redis:"field"` 标签指定hash的field, `redis:"-"` 忽略该字段, `redis:"field,omitempty"` 零值时不写入;
+   未设置标签的导出字段使用字段名;
+2. string、数值、bool 按字面值存储; time.Time 按 RFC3339Nano 存储; 实现了 encoding.TextMarshaler 的类型按文本存储;
+3. 嵌套的struct、map、slice 等层级结构序列化为JSON后存储在单个field中;
+
+field级别的TTL模拟:
+redis(< 7.4)的hash只支持key级别的过期, 通过在同一个hash中写入 "__ttl:<field>" 记录field的过期时间(unix毫秒),
+读取时过滤并惰性删除已过期的field; 元数据与数据位于同一个key, 在集群模式下不会产生跨slot问题;
+也可以通过 PurgeHash 主动清理;
+*/
+
+const (
+	hashTagName     = "redis"
+	hashTTLPrefix   = "__ttl:"
+	hashScanBatchSz = 100
+)
+
+// HashField hash中的一个field
+type HashField struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// hashTTLField field对应的过期时间元数据
+func hashTTLField(field string) string {
+	return hashTTLPrefix + field
+}
+
+// structToHash 将struct转换为hash的 field-value
+func structToHash(value interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("hash struct is nil")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("hash value should be a struct, not %s", rv.Kind())
+	}
+	rt := rv.Type()
+	fields := make(map[string]interface{}, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		name, omitempty, ok := hashFieldName(sf)
+		if !ok {
+			continue
+		}
+		fv := rv.Field(i)
+		if omitempty && fv.IsZero() {
+			continue
+		}
+		v, err := encodeHashValue(fv)
+		if err != nil {
+			return nil, fmt.Errorf("encode hash field %s: %w", name, err)
+		}
+		fields[name] = v
+	}
+	return fields, nil
+}
+
+// hashToStruct 将hash的 field-value 写入struct, value需为struct指针
+func hashToStruct(fields map[string]string, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("hash value should be a non-nil pointer to struct, not %T", value)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name, _, ok := hashFieldName(rt.Field(i))
+		if !ok {
+			continue
+		}
+		s, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := decodeHashValue(s, rv.Field(i)); err != nil {
+			return fmt.Errorf("decode hash field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// hashFieldName 解析struct字段对应的hash field
+func hashFieldName(sf reflect.StructField) (name string, omitempty, ok bool) {
+	if sf.PkgPath != "" { // 非导出字段
+		return "", false, false
+	}
+	tag := sf.Tag.Get(hashTagName)
+	if tag == "-" {
+		return "", false, false
+	}
+	opts := strings.Split(tag, ",")
+	name = opts[0]
+	if name == "" {
+		name = sf.Name
+	}
+	for _, opt := range opts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty, true
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func encodeHashValue(fv reflect.Value) (interface{}, error) {
+	if fv.Type() == timeType {
+		return fv.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	if m, ok := fv.Interface().(encoding.TextMarshaler); ok && (fv.Kind() != reflect.Ptr || !fv.IsNil()) {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 { // []byte
+			return string(fv.Bytes()), nil
+		}
+	}
+	// 层级结构
+	b, err := json.Marshal(fv.Interface())
+	return string(b), err
+}
+
+func decodeHashValue(s string, fv reflect.Value) error {
+	if fv.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if fv.CanAddr() {
+		if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(s))
+			return nil
+		}
+	}
+	return json.Unmarshal([]byte(s), fv.Addr().Interface())
+}
+
+// filterExpiredHash 移除fields中的TTL元数据及已过期的field, 返回需要删除的field(含元数据)
+func filterExpiredHash(fields map[string]string, now time.Time) []string {
+	var expired []string
+	for f, v := range fields {
+		if !strings.HasPrefix(f, hashTTLPrefix) {
+			continue
+		}
+		delete(fields, f)
+		at, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || now.UnixMilli() < at {
+			continue
+		}
+		field := strings.TrimPrefix(f, hashTTLPrefix)
+		delete(fields, field)
+		expired = append(expired, field, f)
+	}
+	return expired
+}
+
+// readHash 读取hash的全部或部分field, 过滤并惰性删除已过期的field
+// key不存在或所有field都不存在时返回 redis.Nil
+func readHash(ctx context.Context, cmd redis.Cmdable, logg logger.Logger, key string, fields ...string) (map[string]string, error) {
+	var res map[string]string
+	if len(fields) == 0 {
+		all, err := cmd.HGetAll(key).Result()
+		if err != nil {
+			return nil, err
+		}
+		res = all
+	} else {
+		args := make([]string, 0, 2*len(fields))
+		args = append(args, fields...)
+		for _, f := range fields {
+			args = append(args, hashTTLField(f))
+		}
+		vals, err := cmd.HMGet(key, args...).Result()
+		if err != nil {
+			return nil, err
+		}
+		res = make(map[string]string, len(vals))
+		for i, v := range vals {
+			if s, ok := v.(string); ok {
+				res[args[i]] = s
+			}
+		}
+	}
+	if expired := filterExpiredHash(res, time.Now()); len(expired) > 0 {
+		if err := cmd.HDel(key, expired...).Err(); err != nil {
+			logg.Warn(logger.ErrorRedis, "delete expired hash fields", logger.MakeField("key", key), logger.ErrorField(err))
+		}
+	}
+	if len(res) == 0 {
+		return nil, redis.Nil
+	}
+	return res, nil
+}
+
+// setHash 写入field, 并在同一个事务中删除这些field的TTL元数据, 覆盖写入的field不再过期
+func setHash(ctx context.Context, cmd redis.Cmdable, key string, fields map[string]interface{}) error {
+	metas := make([]string, 0, len(fields))
+	for f := range fields {
+		metas = append(metas, hashTTLField(f))
+	}
+	_, err := cmd.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(key, fields)
+		pipe.HDel(key, metas...)
+		return nil
+	})
+	return err
+}
+
+// hashIncrScript 对field做增量; field已过期时先删除field及其元数据, 从0开始计数; 未过期时保留其TTL
+// KEYS[1]: hash, ARGV[1]: field, ARGV[2]: field的TTL元数据, ARGV[3]: 增量, ARGV[4]: 当前unix毫秒, ARGV[5]: 1为浮点数
+var hashIncrScript = NewScript("hash_incr", `
+local at = redis.call('HGET', KEYS[1], ARGV[2])
+if at and tonumber(at) <= tonumber(ARGV[4]) then
+	redis.call('HDEL', KEYS[1], ARGV[1], ARGV[2])
+end
+if ARGV[5] == '1' then
+	return redis.call('HINCRBYFLOAT', KEYS[1], ARGV[1], ARGV[3])
+end
+return redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[3])
+`)
+
+// incrHash 为field的值加上incr, 考虑field的TTL
+func incrHash(ctx context.Context, rds Redis, key, field string, incr interface{}, float bool) *ScriptResult {
+	isFloat := 0
+	if float {
+		isFloat = 1
+	}
+	return hashIncrScript.Run(ctx, rds, []string{key}, field, hashTTLField(field), incr, time.Now().UnixMilli(), isFloat)
+}
+
+// setHashFieldTTL 写入field并记录其过期时间
+func setHashFieldTTL(ctx context.Context, cmd redis.Cmdable, key, field string, value interface{}, ttl time.Duration) error {
+	if value == nil {
+		return fmt.Errorf("hash field %s value is nil", field)
+	}
+	v, err := encodeHashValue(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		_, err = cmd.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.HSet(key, field, v)
+			pipe.HDel(key, hashTTLField(field))
+			return nil
+		})
+		return err
+	}
+	return cmd.HSet(key, field, v, hashTTLField(field), time.Now().Add(ttl).UnixMilli()).Err()
+}
+
+// purgeHash 删除hash中已过期的field, 返回删除的field数
+func purgeHash(ctx context.Context, cmd redis.Cmdable, key string) (int64, error) {
+	var (
+		expired []string
+		cursor  uint64
+		now     = time.Now()
+	)
+	for {
+		kvs, next, err := cmd.HScan(key, cursor, hashTTLPrefix+"*", hashScanBatchSz).Result()
+		if err != nil {
+			return 0, err
+		}
+		metas := make(map[string]string, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			metas[kvs[i]] = kvs[i+1]
+		}
+		expired = append(expired, filterExpiredHash(metas, now)...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := cmd.HDel(key, expired...).Err(); err != nil {
+		return 0, err
+	}
+	return int64(len(expired) / 2), nil
+}
+
+// scanHash 流式扫描hash中的field, 跳过TTL元数据及已过期的field
+func scanHash(ctx context.Context, cmd redis.Cmdable, logg logger.Logger, key, match string) chan *HashField {
+	out := make(chan *HashField, hashScanBatchSz)
+	go func() {
+		defer close(out)
+		var cursor uint64
+		for {
+			kvs, next, err := cmd.HScan(key, cursor, match, hashScanBatchSz).Result()
+			if err != nil {
+				logg.Error(logger.ErrorCache, "redis hscan error", logger.MakeField("key", key), logger.MakeField("match", match), logger.ErrorField(err))
+				return
+			}
+			batch := make(map[string]string, len(kvs)/2)
+			var fields []string
+			for i := 0; i+1 < len(kvs); i += 2 {
+				if strings.HasPrefix(kvs[i], hashTTLPrefix) {
+					continue
+				}
+				batch[kvs[i]] = kvs[i+1]
+				fields = append(fields, kvs[i])
+			}
+			if len(fields) > 0 {
+				// 过滤已过期的field
+				valid, err := readHash(ctx, cmd, logg, key, fields...)
+				if err != nil && err != redis.Nil {
+					logg.Error(logger.ErrorCache, "redis hscan error", logger.MakeField("key", key), logger.ErrorField(err))
+					return
+				}
+				for _, f := range fields {
+					if _, ok := valid[f]; !ok {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case out <- &HashField{Field: f, Value: batch[f]}:
+					}
+				}
+			}
+			if cursor = next; cursor == 0 {
+				return
+			}
+		}
+	}()
+	return out
+}
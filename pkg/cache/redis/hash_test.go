@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hashAddr struct {
+	City string `json:"city"`
+	Zip  int    `json:"zip"`
+}
+
+type hashPerson struct {
+	Name     string    `redis:"name"`
+	Age      int       `redis:"age"`
+	Score    float64   `redis:"score"`
+	Vip      bool      `redis:"vip"`
+	Birthday time.Time `redis:"birthday"`
+	Addr     hashAddr  `redis:"addr"`
+	Hobby    []string  `redis:"hobby"`
+	Nick     string    `redis:"nick,omitempty"`
+	Ignore   string    `redis:"-"`
+	NoTag    uint8
+	private  string // nolint
+}
+
+func TestStructToHash(t *testing.T) {
+	birthday := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &hashPerson{
+		Name:     "tom",
+		Age:      18,
+		Score:    99.5,
+		Vip:      true,
+		Birthday: birthday,
+		Addr:     hashAddr{City: "北京", Zip: 100000},
+		Hobby:    []string{"计算机", "音乐"},
+		Ignore:   "ignore",
+		NoTag:    7,
+	}
+	fields, err := structToHash(p)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":     "tom",
+		"age":      "18",
+		"score":    "99.5",
+		"vip":      "true",
+		"birthday": "2000-01-02T03:04:05Z",
+		"addr":     `{"city":"北京","zip":100000}`,
+		"hobby":    `["计算机","音乐"]`,
+		"NoTag":    "7",
+	}, fields)
+
+	raw := make(map[string]string, len(fields))
+	for k, v := range fields {
+		raw[k] = v.(string)
+	}
+	var got hashPerson
+	assert.NoError(t, hashToStruct(raw, &got))
+	p.Ignore = ""
+	assert.Equal(t, *p, got)
+
+	_, err = structToHash(map[string]string{})
+	assert.Error(t, err)
+	assert.Error(t, hashToStruct(raw, got))
+	assert.Error(t, hashToStruct(map[string]string{"age": "x"}, &got))
+}
+
+func TestFilterExpiredHash(t *testing.T) {
+	now := time.Now()
+	fields := map[string]string{
+		"a":               "1",
+		"b":               "2",
+		"c":               "3",
+		hashTTLField("a"): "0",
+		hashTTLField("b"): "99999999999999",
+	}
+	expired := filterExpiredHash(fields, now)
+	assert.ElementsMatch(t, []string{"a", hashTTLField("a")}, expired)
+	assert.Equal(t, map[string]string{"b": "2", "c": "3"}, fields)
+}
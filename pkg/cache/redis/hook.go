@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+命令钩子:
+1. 通过 WithHooks/ClusterWithHooks 在 NewClient/NewCluster 时注册 Hook, 每条命令执行前后回调;
+2. pipeline中的每条命令都会回调, Duration为整个pipeline的耗时, Pipeline为true;
+3. 内置 Metrics(按命令统计耗时分布与错误数) 与 SlowLogHook(慢命令日志);
+
+PS: 集群模式下 ScanKey/DelByPattern 等直接访问master节点的命令不经过Hook
+*/
+
+// CommandInfo 命令的执行信息
+type CommandInfo struct {
+	Name     string        // 命令名(小写), 如 get、hset
+	Key      string        // 命令操作的第一个key, 无key时为空
+	Args     []interface{} // 完整的命令参数
+	Duration time.Duration // 执行耗时, 只在 AfterCommand 中有效
+	Err      error         // 执行错误, 只在 AfterCommand 中有效; 包含 redis.Nil
+	Pipeline bool          // 是否在pipeline中执行
+}
+
+// Hook 命令钩子, 需并发安全
+type Hook interface {
+	// BeforeCommand 命令执行前调用, 返回的ctx会传递给 AfterCommand
+	BeforeCommand(ctx context.Context, cmd *CommandInfo) context.Context
+	// AfterCommand 命令执行后调用
+	AfterCommand(ctx context.Context, cmd *CommandInfo)
+}
+
+// WithHooks 注册命令钩子
+func WithHooks(hooks ...Hook) OptionFunc {
+	return func(o *clientOptions) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+// ClusterWithHooks 注册命令钩子
+func ClusterWithHooks(hooks ...Hook) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+type hookStartKey struct{}
+
+type hookState struct {
+	start time.Time
+	infos []*CommandInfo
+	ctxs  []context.Context
+}
+
+// hookAdapter 将 Hook 适配为go-redis的Hook
+type hookAdapter struct {
+	hooks []Hook
+}
+
+func (h *hookAdapter) before(ctx context.Context, cmds []redis.Cmder, pipeline bool) context.Context {
+	st := &hookState{start: time.Now()}
+	for _, cmd := range cmds {
+		info := newCommandInfo(cmd, pipeline)
+		for _, hook := range h.hooks {
+			ctx = hook.BeforeCommand(ctx, info)
+		}
+		st.infos = append(st.infos, info)
+		st.ctxs = append(st.ctxs, ctx)
+	}
+	return context.WithValue(ctx, hookStartKey{}, st)
+}
+
+func (h *hookAdapter) after(ctx context.Context, cmds []redis.Cmder) {
+	st, ok := ctx.Value(hookStartKey{}).(*hookState)
+	if !ok {
+		return
+	}
+	d := time.Since(st.start)
+	for i, info := range st.infos {
+		info.Duration, info.Err = d, cmds[i].Err()
+		for _, hook := range h.hooks {
+			hook.AfterCommand(st.ctxs[i], info)
+		}
+	}
+}
+
+func (h *hookAdapter) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx, []redis.Cmder{cmd}, false), nil
+}
+
+func (h *hookAdapter) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(ctx, []redis.Cmder{cmd})
+	return nil
+}
+
+func (h *hookAdapter) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.before(ctx, cmds, true), nil
+}
+
+func (h *hookAdapter) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	h.after(ctx, cmds)
+	return nil
+}
+
+func newCommandInfo(cmd redis.Cmder, pipeline bool) *CommandInfo {
+	args := cmd.Args()
+	return &CommandInfo{
+		Name:     strings.ToLower(cmd.Name()),
+		Key:      commandKey(cmd.Name(), args),
+		Args:     args,
+		Pipeline: pipeline,
+	}
+}
+
+// commandKey 命令操作的第一个key
+func commandKey(name string, args []interface{}) string {
+	idx := keyIndexes(name, args)
+	if len(idx) == 0 {
+		return ""
+	}
+	s, _ := args[idx[0]].(string)
+	return s
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordHook struct {
+	before, after []*CommandInfo
+}
+
+func (h *recordHook) BeforeCommand(ctx context.Context, cmd *CommandInfo) context.Context {
+	h.before = append(h.before, cmd)
+	return ctx
+}
+
+func (h *recordHook) AfterCommand(ctx context.Context, cmd *CommandInfo) {
+	h.after = append(h.after, cmd)
+}
+
+func TestCommandKey(t *testing.T) {
+	assert.Equal(t, "k", commandKey("get", []interface{}{"get", "k"}))
+	assert.Equal(t, "", commandKey("ping", []interface{}{"ping"}))
+	assert.Equal(t, "k1", commandKey("evalsha", []interface{}{"evalsha", "sha", 1, "k1", "a"}))
+	assert.Equal(t, "", commandKey("eval", []interface{}{"eval", "return 1", 0}))
+	assert.Equal(t, "s", commandKey("xreadgroup", []interface{}{"xreadgroup", "group", "g", "c", "streams", "s", ">"}))
+	assert.Equal(t, "dest", commandKey("bitop", []interface{}{"bitop", "and", "dest", "a"}))
+}
+
+func TestHookAdapter(t *testing.T) {
+	rec := &recordHook{}
+	opt := &clientOptions{Options: &redis.Options{}}
+	assert.Empty(t, opt.redisHooks())
+	WithHooks(rec)(opt)
+	hook := opt.redisHooks()[0]
+	assert.NotNil(t, hook)
+
+	cmd := redis.NewStringCmd("get", "k")
+	ctx, _ := hook.BeforeProcess(context.Background(), cmd)
+	time.Sleep(2 * time.Millisecond)
+	cmd.SetErr(redis.Nil)
+	_ = hook.AfterProcess(ctx, cmd)
+	assert.Len(t, rec.after, 1)
+	assert.Equal(t, "get", rec.after[0].Name)
+	assert.Equal(t, "k", rec.after[0].Key)
+	assert.Equal(t, redis.Nil, rec.after[0].Err)
+	assert.GreaterOrEqual(t, rec.after[0].Duration, 2*time.Millisecond)
+
+	cmds := []redis.Cmder{redis.NewIntCmd("incr", "a"), redis.NewIntCmd("incr", "b")}
+	ctx, _ = hook.BeforeProcessPipeline(context.Background(), cmds)
+	_ = hook.AfterProcessPipeline(ctx, cmds)
+	assert.Len(t, rec.after, 3)
+	assert.True(t, rec.after[2].Pipeline)
+	assert.Equal(t, "b", rec.after[2].Key)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics(time.Millisecond, 10*time.Millisecond)
+	ctx := context.Background()
+	m.AfterCommand(ctx, &CommandInfo{Name: "get", Duration: 500 * time.Microsecond, Err: redis.Nil})
+	m.AfterCommand(ctx, &CommandInfo{Name: "get", Duration: 5 * time.Millisecond})
+	m.AfterCommand(ctx, &CommandInfo{Name: "set", Duration: 20 * time.Millisecond, Err: errors.New("timeout")})
+
+	snap := m.Reset()
+	assert.Equal(t, int64(3), snap.Count)
+	assert.Equal(t, int64(1), snap.Errors)
+	assert.Equal(t, "get", snap.Commands[0].Name)
+	assert.Equal(t, []int64{1, 1, 0}, snap.Commands[0].Counts)
+	assert.Equal(t, time.Millisecond, snap.Commands[0].Quantile(0.5))
+	assert.Equal(t, 10*time.Millisecond, snap.Commands[0].Quantile(0.99))
+	assert.Equal(t, 20*time.Millisecond, snap.Commands[1].Quantile(0.99))
+	assert.Empty(t, m.Snapshot().Commands)
+}
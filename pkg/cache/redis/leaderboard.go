@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Leaderboard 基于有序集合的排行榜, 分数越高排名越靠前
+type Leaderboard struct {
+	rds Redis
+	key string
+}
+
+// LeaderboardEntry 排行榜中的一项
+type LeaderboardEntry struct {
+	Rank   int64   `json:"rank"` // 排名, 从1开始
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// NewLeaderboard 实例化排行榜, key为有序集合的key
+func NewLeaderboard(rds Redis, key string) *Leaderboard {
+	return &Leaderboard{rds: rds, key: key}
+}
+
+// SetScore 设置成员的分数
+func (lb *Leaderboard) SetScore(ctx context.Context, member string, score float64) error {
+	return lb.rds.AddZSet(ctx, lb.key, &ZSetMember{Score: score, Member: member})
+}
+
+// Incr 为成员加分, 返回新的分数
+func (lb *Leaderboard) Incr(ctx context.Context, member string, increment float64) (float64, error) {
+	return lb.rds.IncrByZSet(ctx, lb.key, increment, member)
+}
+
+// Remove 将成员移出排行榜
+func (lb *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	return lb.rds.RemMembersZSet(ctx, lb.key, members...)
+}
+
+// Total 排行榜的成员数
+func (lb *Leaderboard) Total(ctx context.Context) (int64, error) {
+	return lb.rds.CardZSet(ctx, lb.key)
+}
+
+// Rank 获取成员的排名及分数, 成员不存在时返回 nil
+func (lb *Leaderboard) Rank(ctx context.Context, member string) (*LeaderboardEntry, error) {
+	rank, err := lb.rds.RankZSet(ctx, lb.key, member, true)
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	score, err := lb.rds.ScoreZSet(ctx, lb.key, member)
+	if err != nil {
+		return nil, err
+	}
+	return &LeaderboardEntry{Rank: rank + 1, Member: member, Score: score}, nil
+}
+
+// Top 获取前n名
+func (lb *Leaderboard) Top(ctx context.Context, n int64) ([]*LeaderboardEntry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return lb.rangeByRank(ctx, 0, n-1)
+}
+
+// Page 分页获取排行榜, page从1开始
+func (lb *Leaderboard) Page(ctx context.Context, page, size int64) ([]*LeaderboardEntry, error) {
+	if page < 1 || size < 1 {
+		return nil, fmt.Errorf("page(%d) and size(%d) should be greater than 0", page, size)
+	}
+	start := (page - 1) * size
+	return lb.rangeByRank(ctx, start, start+size-1)
+}
+
+// Around 获取成员及其前后各n名, 成员不存在时返回 nil
+func (lb *Leaderboard) Around(ctx context.Context, member string, n int64) ([]*LeaderboardEntry, error) {
+	rank, err := lb.rds.RankZSet(ctx, lb.key, member, true)
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+	return lb.rangeByRank(ctx, start, rank+n)
+}
+
+// rangeByRank 按排名获取[start, stop]区间内的成员, 下标从0开始
+func (lb *Leaderboard) rangeByRank(ctx context.Context, start, stop int64) ([]*LeaderboardEntry, error) {
+	members, err := lb.rds.RangeWithScoreZSet(ctx, lb.key, start, stop, true)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*LeaderboardEntry, 0, len(members))
+	for i, m := range members {
+		entries = append(entries, &LeaderboardEntry{
+			Rank:   start + int64(i) + 1,
+			Member: fmt.Sprint(m.Member),
+			Score:  m.Score,
+		})
+	}
+	return entries, nil
+}
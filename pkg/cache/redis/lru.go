@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache 带TTL的LRU缓存, 按条目数和估算的内存占用限制容量, 并发安全
+type lruCache struct {
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[string]*list.Element
+	maxEntries int   // <=0 表示不限制
+	maxBytes   int64 // <=0 表示不限制
+	bytes      int64
+	evictions  int64
+}
+
+type lruEntry struct {
+	key      string
+	value    interface{}
+	size     int64
+	expireAt time.Time
+}
+
+func newLRUCache(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// Get 获取未过期的缓存, 并将其移到队首
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ele, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	ent := ele.Value.(*lruEntry)
+	if !ent.expireAt.IsZero() && time.Now().After(ent.expireAt) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.ll.MoveToFront(ele)
+	return ent.value, true
+}
+
+// Set 写入缓存, ttl <= 0 时不过期; 单条数据超过maxBytes时不缓存
+func (c *lruCache) Set(key string, value interface{}, size int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ele, ok := c.items[key]; ok {
+		c.removeElement(ele)
+	}
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	ent := &lruEntry{key: key, value: value, size: size}
+	if ttl > 0 {
+		ent.expireAt = time.Now().Add(ttl)
+	}
+	c.items[key] = c.ll.PushFront(ent)
+	c.bytes += size
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// Del 删除缓存
+func (c *lruCache) Del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if ele, ok := c.items[key]; ok {
+			c.removeElement(ele)
+		}
+	}
+}
+
+// Clear 清空缓存
+func (c *lruCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// Stats 返回当前的条目数、估算内存占用和累计淘汰数
+func (c *lruCache) Stats() (entries int, bytes, evictions int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.bytes, c.evictions
+}
+
+func (c *lruCache) removeElement(ele *list.Element) {
+	ent := c.ll.Remove(ele).(*lruEntry)
+	delete(c.items, ent.key)
+	c.bytes -= ent.size
+}
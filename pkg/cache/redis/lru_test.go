@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2, 100)
+	c.Set("a", 1, 10, 0)
+	c.Set("b", 2, 10, 0)
+	_, ok := c.Get("a") // a 成为最近使用
+	assert.True(t, ok)
+	c.Set("c", 3, 10, 0) // 按条目数淘汰 b
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	entries, bytes, evictions := c.Stats()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(20), bytes)
+	assert.Equal(t, int64(1), evictions)
+
+	c.Set("d", 4, 95, 0) // 按内存淘汰 a、c
+	entries, bytes, _ = c.Stats()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, int64(95), bytes)
+	c.Set("e", 5, 101, 0) // 超过单条上限不缓存
+	_, ok = c.Get("e")
+	assert.False(t, ok)
+
+	c.Set("ttl", 6, 1, time.Millisecond)
+	<-time.After(5 * time.Millisecond)
+	_, ok = c.Get("ttl")
+	assert.False(t, ok)
+
+	c.Del("d")
+	c.Clear()
+	entries, bytes, _ = c.Stats()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, int64(0), bytes)
+}
+
+func TestRangeIndex(t *testing.T) {
+	cases := []struct {
+		n, start, stop int64
+		lo, hi         int64
+		ok             bool
+	}{
+		{5, 0, -1, 0, 4, true},
+		{5, 1, 3, 1, 3, true},
+		{5, -2, -1, 3, 4, true},
+		{5, 0, 100, 0, 4, true},
+		{5, -100, 1, 0, 1, true},
+		{5, 3, 1, 0, 0, false},
+		{5, 5, 10, 0, 0, false},
+		{0, 0, -1, 0, 0, false},
+	}
+	for _, c := range cases {
+		lo, hi, ok := rangeIndex(c.n, c.start, c.stop)
+		assert.Equal(t, c.ok, ok, "%+v", c)
+		if ok {
+			assert.Equal(t, []int64{c.lo, c.hi}, []int64{lo, hi}, "%+v", c)
+		}
+	}
+}
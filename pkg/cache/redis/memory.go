@@ -0,0 +1,1306 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+内存模式:
+实现了完整的 Redis 接口, 数据保存在进程内存中, 用于单元测试等无法连接Redis服务的场景;
+1. 语义与Redis保持一致: key过期、类型错误(WRONGTYPE)、不存在时返回 redis.Nil 等;
+2. 阻塞操作(BlockPopList/ReadGroupStream)在数据写入时被唤醒;
+3. 发布订阅只在同一个 Memory 实例内传播;
+4. 不支持lua, 需要通过 RegisterScript 为脚本注册等价的Go实现;
+*/
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// MemoryScriptFunc lua脚本的Go实现, 在 Memory 上调用 Eval 时执行
+type MemoryScriptFunc func(ctx context.Context, rds *Memory, keys []string, args ...interface{}) (interface{}, error)
+
+// Memory 内存模式
+type Memory struct {
+	mu      sync.Mutex
+	items   map[string]*memItem
+	changed chan struct{} // list/stream写入时关闭并重建, 用于唤醒阻塞的读取
+	logg    logger.Logger
+
+	scripts sync.Map // script => MemoryScriptFunc
+	shas    sync.Map // sha1 => script, 通过 ScriptLoad 缓存的脚本
+
+	subMu sync.RWMutex
+	subs  map[*memPubSubConn]struct{}
+}
+
+type memItem struct {
+	typ      string // string/list/set/zset/hash/stream, HyperLogLog为hll(对外表现为string)
+	val      interface{}
+	expireAt time.Time
+}
+
+// NewMemory 实例化内存模式
+func NewMemory(ctx context.Context) *Memory {
+	return &Memory{
+		items:   make(map[string]*memItem),
+		changed: make(chan struct{}),
+		logg:    logger.DefaultLogger(),
+		subs:    make(map[*memPubSubConn]struct{}),
+	}
+}
+
+var _ Redis = (*Memory)(nil)
+
+// RegisterScript 注册lua脚本的Go实现
+func (m *Memory) RegisterScript(script string, fn MemoryScriptFunc) {
+	m.scripts.Store(script, fn)
+}
+
+// get 获取未过期的key, 需持有锁; typ不为空时校验类型
+func (m *Memory) get(key, typ string) (*memItem, error) {
+	it, ok := m.items[key]
+	if !ok {
+		return nil, nil
+	}
+	if !it.expireAt.IsZero() && !time.Now().Before(it.expireAt) {
+		delete(m.items, key)
+		return nil, nil
+	}
+	if typ != "" && it.typ != typ {
+		return nil, errWrongType
+	}
+	return it, nil
+}
+
+// getOrCreate 获取key, 不存在时按newVal创建, 需持有锁
+func (m *Memory) getOrCreate(key, typ string, newVal func() interface{}) (*memItem, error) {
+	it, err := m.get(key, typ)
+	if err != nil || it != nil {
+		return it, err
+	}
+	it = &memItem{typ: typ, val: newVal()}
+	m.items[key] = it
+	return it, nil
+}
+
+// notify 唤醒阻塞的读取, 需持有锁
+func (m *Memory) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// cleanup 删除空的容器类型, 与Redis一致, 需持有锁
+func (m *Memory) cleanup(key string) {
+	it, ok := m.items[key]
+	if !ok {
+		return
+	}
+	empty := false
+	switch v := it.val.(type) {
+	case []string:
+		empty = len(v) == 0
+	case map[string]struct{}:
+		empty = len(v) == 0
+	case map[string]float64:
+		empty = len(v) == 0
+	case map[string]string:
+		empty = len(v) == 0
+	}
+	if empty {
+		delete(m.items, key)
+	}
+}
+
+/********************************* 通用接口 **************************************/
+
+// IsExist 判断key是否存在
+func (m *Memory) IsExist(ctx context.Context, key ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range key {
+		if it, _ := m.get(k, ""); it != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FlushDB 删除数据
+func (m *Memory) FlushDB(ctx context.Context, isAll bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[string]*memItem)
+	return nil
+}
+
+// Del 删除key
+func (m *Memory) Del(ctx context.Context, key ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range key {
+		delete(m.items, k)
+	}
+	return nil
+}
+
+// SetExpire 设置key的ttl, ttl <= 0 时删除key
+func (m *Memory) SetExpire(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, _ := m.get(key, "")
+	if it == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(m.items, key)
+		return nil
+	}
+	it.expireAt = time.Now().Add(ttl)
+	return nil
+}
+
+// GetExpire 获取ttl, key不存在时返回-2, 未设置过期时间时返回-1
+func (m *Memory) GetExpire(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, _ := m.get(key, "")
+	if it == nil {
+		return time.Duration(-2), nil
+	}
+	if it.expireAt.IsZero() {
+		return time.Duration(-1), nil
+	}
+	return time.Until(it.expireAt).Round(time.Second), nil
+}
+
+// keyMeta 读取key的类型、剩余过期时间及hash的field数, 用于 NearCache
+func (m *Memory) keyMeta(ctx context.Context, key string) (*keyMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, _ := m.get(key, "")
+	if it == nil {
+		return &keyMeta{typ: typeNone, pttl: -2, hlen: -1}, nil
+	}
+	meta := &keyMeta{typ: it.typ, pttl: -1, hlen: -1}
+	if it.typ == "hll" {
+		meta.typ = "string"
+	}
+	if !it.expireAt.IsZero() {
+		meta.pttl = time.Until(it.expireAt).Truncate(time.Millisecond)
+	}
+	if it.typ == "hash" {
+		meta.hlen = int64(len(it.val.(map[string]string)))
+	}
+	return meta, nil
+}
+
+// GetMixed 获取到key对应的value, 规则与 Client.GetMixed 一致
+func (m *Memory) GetMixed(ctx context.Context, key string, value interface{}) error {
+	m.mu.Lock()
+	it, _ := m.get(key, "")
+	if it == nil {
+		m.mu.Unlock()
+		return nil
+	}
+	var (
+		str  string
+		strs []string
+		hash map[string]string
+	)
+	switch v := it.val.(type) {
+	case string:
+		str = v
+	case []string:
+		strs = append(strs, v...)
+	case map[string]struct{}:
+		if it.typ == "hll" {
+			str = encodeMemHLL(v)
+		} else {
+			for s := range v {
+				strs = append(strs, s)
+			}
+		}
+	case map[string]float64:
+		for _, z := range sortZSet(v, true) {
+			strs = append(strs, z.Member.(string))
+		}
+	case map[string]string:
+		hash = make(map[string]string, len(v))
+		for f, s := range v {
+			hash[f] = s
+		}
+	}
+	typ := it.typ
+	m.mu.Unlock()
+
+	switch typ {
+	case "string", "hll":
+		return redis.NewStringResult(str, nil).Scan(value)
+	case "list", "set", "zset":
+		return redis.NewStringSliceResult(strs, nil).ScanSlice(value)
+	case "hash":
+		filterExpiredHash(hash, time.Now())
+		hb, err := json.Marshal(hash)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(hb, value)
+	}
+	return nil
+}
+
+// GetType 获取key对应数据的数据类型: string/list/set/zset/hash/stream, key不存在时返回none
+func (m *Memory) GetType(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, _ := m.get(key, "")
+	if it == nil {
+		return typeNone, nil
+	}
+	if it.typ == "hll" {
+		return "string", nil
+	}
+	return it.typ, nil
+}
+
+// ScanKey 扫描与match匹配的key
+func (m *Memory) ScanKey(ctx context.Context, match string) chan string {
+	return m.ScanKeyByType(ctx, match, "")
+}
+
+// ScanKeyByType 扫描与match匹配且类型为typ的key
+func (m *Memory) ScanKeyByType(ctx context.Context, match, typ string) chan string {
+	keys := m.matchKeys(match, typ)
+	out := make(chan string, scanBatchSize)
+	go func() {
+		defer close(out)
+		for _, k := range keys {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- k:
+			}
+		}
+	}()
+	return out
+}
+
+// DelByPattern 按批删除与match匹配的key, 返回删除的key数
+func (m *Memory) DelByPattern(ctx context.Context, match string, ops ...BulkOptionFunc) (int64, error) {
+	return m.bulkByPattern(ctx, match, ops, func(key string) bool {
+		_, ok := m.items[key]
+		delete(m.items, key)
+		return ok
+	})
+}
+
+// ExpireByPattern 按批为与match匹配的key设置过期时间, 返回设置成功的key数
+func (m *Memory) ExpireByPattern(ctx context.Context, match string, ttl time.Duration, ops ...BulkOptionFunc) (int64, error) {
+	return m.bulkByPattern(ctx, match, ops, func(key string) bool {
+		it, _ := m.get(key, "")
+		if it == nil {
+			return false
+		}
+		if ttl <= 0 {
+			delete(m.items, key)
+		} else {
+			it.expireAt = time.Now().Add(ttl)
+		}
+		return true
+	})
+}
+
+// matchKeys 与match匹配且类型为typ(为空时不限)的key
+func (m *Memory) matchKeys(match, typ string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.items {
+		it, _ := m.get(k, "")
+		if it == nil || (match != "" && !matchGlob(match, k)) {
+			continue
+		}
+		if t := it.typ; typ != "" && t != typ && !(t == "hll" && typ == "string") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// bulkByPattern 按批对匹配的key执行action(持有锁), 返回action返回true的key数
+func (m *Memory) bulkByPattern(ctx context.Context, match string, ops []BulkOptionFunc, action func(key string) bool) (int64, error) {
+	opt := newBulkOptions(ops)
+	limiter := &bulkLimiter{rate: opt.rate}
+	keys := m.matchKeys(match, opt.typ)
+	var total int64
+	for len(keys) > 0 {
+		n := int(opt.batch)
+		if n > len(keys) {
+			n = len(keys)
+		}
+		batch := keys[:n]
+		keys = keys[n:]
+		if err := limiter.wait(ctx, len(batch)); err != nil {
+			return total, err
+		}
+		if opt.dryRun {
+			total += int64(len(batch))
+			continue
+		}
+		m.mu.Lock()
+		for _, key := range batch {
+			if action(key) {
+				total++
+			}
+		}
+		m.mu.Unlock()
+	}
+	return total, nil
+}
+
+/********************************* string接口 **************************************/
+
+// SetStr 设置key数据(不含TTL)
+func (m *Memory) SetStr(ctx context.Context, key, value string) error {
+	return m.SetStrTTL(ctx, key, value, 0)
+}
+
+// SetStrTTL 设置key数据(含TTL)
+func (m *Memory) SetStrTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it := &memItem{typ: "string", val: value}
+	if ttl > 0 {
+		it.expireAt = time.Now().Add(ttl)
+	}
+	m.items[key] = it
+	return nil
+}
+
+// SetNX key不存在时设置数据, 与 Client.SetNX 一致, key已存在时不返回错误
+func (m *Memory) SetNX(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if exists, _ := m.get(key, ""); exists != nil {
+		return nil
+	}
+	it := &memItem{typ: "string", val: value}
+	if ttl > 0 {
+		it.expireAt = time.Now().Add(ttl)
+	}
+	m.items[key] = it
+	return nil
+}
+
+/*********************************** hash接口 ****************************************/
+
+func newMemHash() interface{} { return make(map[string]string) }
+
+// SetHash 设置hash数据, 覆盖写入的field取消其TTL
+func (m *Memory) SetHash(ctx context.Context, key string, value map[string]interface{}) error {
+	fields := make(map[string]string, len(value))
+	metas := make([]string, 0, len(value))
+	for f, v := range value {
+		s, err := toRedisString(v)
+		if err != nil {
+			return err
+		}
+		fields[f] = s
+		metas = append(metas, hashTTLField(f))
+	}
+	return m.setHash(key, fields, metas)
+}
+
+// setHash 写入并删除field
+func (m *Memory) setHash(key string, set map[string]string, del []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "hash", newMemHash)
+	if err != nil {
+		return err
+	}
+	h := it.val.(map[string]string)
+	for f, v := range set {
+		h[f] = v
+	}
+	for _, f := range del {
+		delete(h, f)
+	}
+	m.cleanup(key)
+	return nil
+}
+
+// readHash 读取hash并过滤已过期的field, 语义与 readHash 一致
+func (m *Memory) readHash(key string, fields ...string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "hash")
+	if err != nil {
+		return nil, err
+	}
+	if it == nil {
+		return nil, redis.Nil
+	}
+	h := it.val.(map[string]string)
+	res := make(map[string]string)
+	if len(fields) == 0 {
+		for f, v := range h {
+			res[f] = v
+		}
+	} else {
+		for _, f := range fields {
+			for _, name := range []string{f, hashTTLField(f)} {
+				if v, ok := h[name]; ok {
+					res[name] = v
+				}
+			}
+		}
+	}
+	for _, f := range filterExpiredHash(res, time.Now()) {
+		delete(h, f)
+	}
+	m.cleanup(key)
+	if len(res) == 0 {
+		return nil, redis.Nil
+	}
+	return res, nil
+}
+
+// GetHashField 获取执行hash的指定field数据, field不存在或已过期时返回 redis.Nil
+func (m *Memory) GetHashField(ctx context.Context, key, field string) (string, error) {
+	res, err := m.readHash(key, field)
+	if err != nil {
+		return "", err
+	}
+	return res[field], nil
+}
+
+// SetHashStruct 将struct按 `redis:"field"` 标签写入hash
+func (m *Memory) SetHashStruct(ctx context.Context, key string, value interface{}) error {
+	fields, err := structToHash(value)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return m.SetHash(ctx, key, fields)
+}
+
+// GetHashStruct 读取hash并按 `redis:"field"` 标签写入struct
+func (m *Memory) GetHashStruct(ctx context.Context, key string, value interface{}, fields ...string) error {
+	res, err := m.readHash(key, fields...)
+	if err != nil {
+		return err
+	}
+	return hashToStruct(res, value)
+}
+
+// SetHashFieldTTL 写入单个field并设置该field的过期时间, ttl <= 0 时取消过期
+func (m *Memory) SetHashFieldTTL(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	if value == nil {
+		return fmt.Errorf("hash field %s value is nil", field)
+	}
+	v, err := encodeHashValue(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+	s, err := toRedisString(v)
+	if err != nil {
+		return err
+	}
+	set := map[string]string{field: s}
+	if ttl <= 0 {
+		return m.setHash(key, set, []string{hashTTLField(field)})
+	}
+	set[hashTTLField(field)] = strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	return m.setHash(key, set, nil)
+}
+
+// expireHashField field已过期时删除field及其TTL元数据, 与 hashIncrScript 一致
+func expireHashField(h map[string]string, field string, now time.Time) {
+	if v, ok := h[hashTTLField(field)]; ok {
+		if at, err := strconv.ParseInt(v, 10, 64); err == nil && at <= now.UnixMilli() {
+			delete(h, field)
+			delete(h, hashTTLField(field))
+		}
+	}
+}
+
+// IncrByHash 为field的整数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (m *Memory) IncrByHash(ctx context.Context, key, field string, incr int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "hash", newMemHash)
+	if err != nil {
+		return 0, err
+	}
+	h := it.val.(map[string]string)
+	expireHashField(h, field, time.Now())
+	var n int64
+	if v, ok := h[field]; ok {
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			m.cleanup(key)
+			return 0, errors.New("ERR hash value is not an integer")
+		}
+	}
+	n += incr
+	h[field] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+// IncrByFloatHash 为field的浮点数值加上incr, 返回新值; field已过期时从0开始, 未过期时保留其TTL
+func (m *Memory) IncrByFloatHash(ctx context.Context, key, field string, incr float64) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "hash", newMemHash)
+	if err != nil {
+		return 0, err
+	}
+	h := it.val.(map[string]string)
+	expireHashField(h, field, time.Now())
+	var n float64
+	if v, ok := h[field]; ok {
+		if n, err = strconv.ParseFloat(v, 64); err != nil {
+			m.cleanup(key)
+			return 0, errors.New("ERR hash value is not a float")
+		}
+	}
+	n += incr
+	h[field] = strconv.FormatFloat(n, 'f', -1, 64)
+	return n, nil
+}
+
+// DelHashField 删除hash中的field
+func (m *Memory) DelHashField(ctx context.Context, key string, fields ...string) error {
+	del := make([]string, 0, 2*len(fields))
+	for _, f := range fields {
+		del = append(del, f, hashTTLField(f))
+	}
+	m.mu.Lock()
+	it, err := m.get(key, "hash")
+	m.mu.Unlock()
+	if err != nil || it == nil {
+		return err
+	}
+	return m.setHash(key, nil, del)
+}
+
+// ScanHash 流式扫描hash中与match匹配的field
+func (m *Memory) ScanHash(ctx context.Context, key, match string) chan *HashField {
+	out := make(chan *HashField, hashScanBatchSz)
+	res, err := m.readHash(key)
+	if err != nil && err != redis.Nil {
+		m.logg.Error(logger.ErrorCache, "redis hscan error", logger.MakeField("key", key), logger.ErrorField(err))
+	}
+	fields := make([]string, 0, len(res))
+	for f := range res {
+		if match == "" || matchGlob(match, f) {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	go func() {
+		defer close(out)
+		for _, f := range fields {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- &HashField{Field: f, Value: res[f]}:
+			}
+		}
+	}()
+	return out
+}
+
+// PurgeHash 清理hash中已过期的field, 返回清理的field数
+func (m *Memory) PurgeHash(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "hash")
+	if err != nil || it == nil {
+		return 0, err
+	}
+	h := it.val.(map[string]string)
+	metas := make(map[string]string)
+	for f, v := range h {
+		if strings.HasPrefix(f, hashTTLPrefix) {
+			metas[f] = v
+		}
+	}
+	expired := filterExpiredHash(metas, time.Now())
+	for _, f := range expired {
+		delete(h, f)
+	}
+	m.cleanup(key)
+	return int64(len(expired) / 2), nil
+}
+
+/*********************************** list接口 ****************************************/
+
+func newMemList() interface{} { return []string(nil) }
+
+// PushList 向key对应的list中从尾部(右边)追加数据
+func (m *Memory) PushList(ctx context.Context, key string, values ...interface{}) error {
+	return m.PushListBy(ctx, key, EndPoint, values...)
+}
+
+// LenList 获取指定列表长度
+func (m *Memory) LenList(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "list")
+	if err != nil || it == nil {
+		return 0, err
+	}
+	return int64(len(it.val.([]string))), nil
+}
+
+// PopList 从头部（左边）开始获取并删除
+func (m *Memory) PopList(ctx context.Context, key string, value interface{}) error {
+	return m.PopListBy(ctx, key, StartPoint, value)
+}
+
+// PushListBy 按flag从头部(StartPoint)或尾部(EndPoint)向list中添加数据
+func (m *Memory) PushListBy(ctx context.Context, key string, flag ListFlag, values ...interface{}) error {
+	if flag != StartPoint && flag != EndPoint {
+		return errListFlag(flag)
+	}
+	strs, err := toRedisStrings(values)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "list", newMemList)
+	if err != nil {
+		return err
+	}
+	list := it.val.([]string)
+	if flag == EndPoint {
+		list = append(list, strs...)
+	} else {
+		head := make([]string, 0, len(strs)+len(list))
+		for i := len(strs) - 1; i >= 0; i-- {
+			head = append(head, strs[i])
+		}
+		list = append(head, list...)
+	}
+	it.val = list
+	m.cleanup(key)
+	m.notify()
+	return nil
+}
+
+// PopListBy 按flag从头部(StartPoint)或尾部(EndPoint)获取并删除
+func (m *Memory) PopListBy(ctx context.Context, key string, flag ListFlag, value interface{}) error {
+	if flag != StartPoint && flag != EndPoint {
+		return errListFlag(flag)
+	}
+	m.mu.Lock()
+	v, ok, err := m.popList(key, flag)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return redis.NewStringResult("", redis.Nil).Scan(value)
+	}
+	return redis.NewStringResult(v, nil).Scan(value)
+}
+
+// popList 需持有锁
+func (m *Memory) popList(key string, flag ListFlag) (string, bool, error) {
+	it, err := m.get(key, "list")
+	if err != nil || it == nil {
+		return "", false, err
+	}
+	list := it.val.([]string)
+	var v string
+	if flag == StartPoint {
+		v, it.val = list[0], list[1:]
+	} else {
+		v, it.val = list[len(list)-1], list[:len(list)-1]
+	}
+	m.cleanup(key)
+	return v, true, nil
+}
+
+// BlockPopList 阻塞式地按flag从多个list中获取并删除第一个可用的元素
+// timeout 为0时一直阻塞; 超时返回 redis.Nil
+func (m *Memory) BlockPopList(ctx context.Context, flag ListFlag, timeout time.Duration, keys ...string) (string, string, error) {
+	if flag != StartPoint && flag != EndPoint {
+		return "", "", errListFlag(flag)
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		m.mu.Lock()
+		for _, key := range keys {
+			v, ok, err := m.popList(key, flag)
+			if err != nil || ok {
+				m.mu.Unlock()
+				return key, v, err
+			}
+		}
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		case <-deadline:
+			return "", "", redis.Nil
+		case <-changed:
+		}
+	}
+}
+
+// RangeList 获取list中[start, stop]区间内的元素, 支持负数下标
+func (m *Memory) RangeList(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "list")
+	if err != nil || it == nil {
+		return []string{}, err
+	}
+	list := it.val.([]string)
+	s, e, ok := rangeIndex(int64(len(list)), start, stop)
+	if !ok {
+		return []string{}, nil
+	}
+	return append([]string{}, list[s:e+1]...), nil
+}
+
+// TrimList 只保留list中[start, stop]区间内的元素
+func (m *Memory) TrimList(ctx context.Context, key string, start, stop int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "list")
+	if err != nil || it == nil {
+		return err
+	}
+	list := it.val.([]string)
+	s, e, ok := rangeIndex(int64(len(list)), start, stop)
+	if !ok {
+		it.val = []string(nil)
+	} else {
+		it.val = append([]string(nil), list[s:e+1]...)
+	}
+	m.cleanup(key)
+	return nil
+}
+
+/*********************************** set接口 ****************************************/
+
+func newMemSet() interface{} { return make(map[string]struct{}) }
+
+// AddSet 向key集合中添加成员
+func (m *Memory) AddSet(ctx context.Context, key string, values ...interface{}) error {
+	strs, err := toRedisStrings(values)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "set", newMemSet)
+	if err != nil {
+		return err
+	}
+	set := it.val.(map[string]struct{})
+	for _, s := range strs {
+		set[s] = struct{}{}
+	}
+	m.cleanup(key)
+	return nil
+}
+
+// CheckSetMember 检查成员是否在集合内
+func (m *Memory) CheckSetMember(ctx context.Context, key string, value interface{}) (bool, error) {
+	s, err := toRedisString(value)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "set")
+	if err != nil || it == nil {
+		return false, err
+	}
+	_, ok := it.val.(map[string]struct{})[s]
+	return ok, nil
+}
+
+// RemSetEle 移除key集合中的元素
+func (m *Memory) RemSetEle(ctx context.Context, key string, values ...interface{}) error {
+	strs, err := toRedisStrings(values)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.get(key, "set")
+	if err != nil || it == nil {
+		return err
+	}
+	set := it.val.(map[string]struct{})
+	for _, s := range strs {
+		delete(set, s)
+	}
+	m.cleanup(key)
+	return nil
+}
+
+// setAlgebra 计算集合的并集/交集/差集, 需持有锁
+func (m *Memory) setAlgebra(op string, keys ...string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, 0, len(keys))
+	for _, key := range keys {
+		it, err := m.get(key, "set")
+		if err != nil {
+			return nil, err
+		}
+		if it == nil {
+			sets = append(sets, nil)
+			continue
+		}
+		sets = append(sets, it.val.(map[string]struct{}))
+	}
+	res := make(map[string]struct{})
+	if len(sets) == 0 {
+		return res, nil
+	}
+	switch op {
+	case "union":
+		for _, set := range sets {
+			for s := range set {
+				res[s] = struct{}{}
+			}
+		}
+	case "inter":
+		for s := range sets[0] {
+			in := true
+			for _, set := range sets[1:] {
+				if _, ok := set[s]; !ok {
+					in = false
+					break
+				}
+			}
+			if in {
+				res[s] = struct{}{}
+			}
+		}
+	case "diff":
+		for s := range sets[0] {
+			in := false
+			for _, set := range sets[1:] {
+				if _, ok := set[s]; ok {
+					in = true
+					break
+				}
+			}
+			if !in {
+				res[s] = struct{}{}
+			}
+		}
+	}
+	return res, nil
+}
+
+func (m *Memory) setAlgebraSlice(op string, keys ...string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.setAlgebra(op, keys...)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(res))
+	for s := range res {
+		members = append(members, s)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (m *Memory) setAlgebraStore(op, dest string, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.setAlgebra(op, keys...)
+	if err != nil {
+		return 0, err
+	}
+	m.items[dest] = &memItem{typ: "set", val: res}
+	m.cleanup(dest)
+	return int64(len(res)), nil
+}
+
+// UnionSet 获取多个集合的并集
+func (m *Memory) UnionSet(ctx context.Context, keys ...string) ([]string, error) {
+	return m.setAlgebraSlice("union", keys...)
+}
+
+// InterSet 获取多个集合的交集
+func (m *Memory) InterSet(ctx context.Context, keys ...string) ([]string, error) {
+	return m.setAlgebraSlice("inter", keys...)
+}
+
+// DiffSet 获取第一个集合与其它集合的差集
+func (m *Memory) DiffSet(ctx context.Context, keys ...string) ([]string, error) {
+	return m.setAlgebraSlice("diff", keys...)
+}
+
+// UnionStoreSet 将多个集合的并集保存到dest, 返回dest的成员数
+func (m *Memory) UnionStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return m.setAlgebraStore("union", dest, keys...)
+}
+
+// InterStoreSet 将多个集合的交集保存到dest, 返回dest的成员数
+func (m *Memory) InterStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return m.setAlgebraStore("inter", dest, keys...)
+}
+
+// DiffStoreSet 将第一个集合与其它集合的差集保存到dest, 返回dest的成员数
+func (m *Memory) DiffStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	return m.setAlgebraStore("diff", dest, keys...)
+}
+
+/*********************************** 有序集合接口 ****************************************/
+
+func newMemZSet() interface{} { return make(map[string]float64) }
+
+// sortZSet 按分数(相同时按成员)排序
+func sortZSet(z map[string]float64, reverse bool) []*ZSetMember {
+	members := make([]*ZSetMember, 0, len(z))
+	for s, score := range z {
+		members = append(members, &ZSetMember{Score: score, Member: s})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if reverse {
+			a, b = b, a
+		}
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		return a.Member.(string) < b.Member.(string)
+	})
+	return members
+}
+
+// zset 获取有序集合, 需持有锁
+func (m *Memory) zset(key string) (map[string]float64, error) {
+	it, err := m.get(key, "zset")
+	if err != nil || it == nil {
+		return nil, err
+	}
+	return it.val.(map[string]float64), nil
+}
+
+// AddZSet 向key有序集合添加成员
+func (m *Memory) AddZSet(ctx context.Context, key string, members ...*ZSetMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "zset", newMemZSet)
+	if err != nil {
+		return err
+	}
+	z := it.val.(map[string]float64)
+	for _, mb := range members {
+		s, err := toRedisString(mb.Member)
+		if err != nil {
+			m.cleanup(key)
+			return err
+		}
+		z[s] = mb.Score
+	}
+	m.cleanup(key)
+	return nil
+}
+
+// CardZSet 获取有序集合的成员数
+func (m *Memory) CardZSet(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	return int64(len(z)), err
+}
+
+// MembersWithScoreZSet 从高到低获取有序集合的成员及分数
+func (m *Memory) MembersWithScoreZSet(ctx context.Context, key string) ([]*ZSetMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return nil, err
+	}
+	return sortZSet(z, true), nil
+}
+
+// RemMembersZSet 移除key有序集合中的members
+func (m *Memory) RemMembersZSet(ctx context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil || z == nil {
+		return err
+	}
+	for _, s := range members {
+		delete(z, s)
+	}
+	m.cleanup(key)
+	return nil
+}
+
+// RangeByScoreZSet 分页获取分数在[min, max]区间内的成员及分数
+func (m *Memory) RangeByScoreZSet(ctx context.Context, key, min, max string, offset, count int64, reverse bool) ([]*ZSetMember, error) {
+	lo, loOpen, err := parseScoreBound(min)
+	if err != nil {
+		return nil, err
+	}
+	hi, hiOpen, err := parseScoreBound(max)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*ZSetMember, 0)
+	for _, mb := range sortZSet(z, reverse) {
+		if mb.Score < lo || (loOpen && mb.Score == lo) || mb.Score > hi || (hiOpen && mb.Score == hi) {
+			continue
+		}
+		res = append(res, mb)
+	}
+	if count > 0 {
+		if offset >= int64(len(res)) {
+			return []*ZSetMember{}, nil
+		}
+		res = res[offset:]
+		if count < int64(len(res)) {
+			res = res[:count]
+		}
+	}
+	return res, nil
+}
+
+// RangeWithScoreZSet 按排名获取[start, stop]区间内的成员及分数
+func (m *Memory) RangeWithScoreZSet(ctx context.Context, key string, start, stop int64, reverse bool) ([]*ZSetMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return nil, err
+	}
+	sorted := sortZSet(z, reverse)
+	s, e, ok := rangeIndex(int64(len(sorted)), start, stop)
+	if !ok {
+		return []*ZSetMember{}, nil
+	}
+	return sorted[s : e+1], nil
+}
+
+// RankZSet 获取成员的排名(从0开始), 成员不存在时返回 redis.Nil
+func (m *Memory) RankZSet(ctx context.Context, key, member string, reverse bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := z[member]; !ok {
+		return 0, redis.Nil
+	}
+	for i, mb := range sortZSet(z, reverse) {
+		if mb.Member.(string) == member {
+			return int64(i), nil
+		}
+	}
+	return 0, redis.Nil
+}
+
+// ScoreZSet 获取成员的分数, 成员不存在时返回 redis.Nil
+func (m *Memory) ScoreZSet(ctx context.Context, key, member string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := z[member]
+	if !ok {
+		return 0, redis.Nil
+	}
+	return score, nil
+}
+
+// IncrByZSet 为成员的分数加上increment, 返回新的分数
+func (m *Memory) IncrByZSet(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "zset", newMemZSet)
+	if err != nil {
+		return 0, err
+	}
+	z := it.val.(map[string]float64)
+	z[member] += increment
+	return z[member], nil
+}
+
+/*********************************** script接口 ****************************************/
+
+// Eval 执行通过 RegisterScript 注册的脚本; 注意脚本的Go实现不是原子的
+func (m *Memory) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	fn, ok := m.scripts.Load(script)
+	if !ok {
+		return nil, errors.New("NOSCRIPT script is not registered in memory redis")
+	}
+	return fn.(MemoryScriptFunc)(ctx, m, keys, args...)
+}
+
+// EvalSha 执行通过 ScriptLoad 缓存的脚本
+func (m *Memory) EvalSha(ctx context.Context, sha string, keys []string, args ...interface{}) (interface{}, error) {
+	script, ok := m.shas.Load(strings.ToLower(sha))
+	if !ok {
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return m.Eval(ctx, script.(string), keys, args...)
+}
+
+// ScriptLoad 缓存脚本, 返回脚本的sha1
+func (m *Memory) ScriptLoad(ctx context.Context, script string) (string, error) {
+	sha := scriptSha(script)
+	m.shas.Store(sha, script)
+	return sha, nil
+}
+
+// ScriptExists 检查脚本是否已缓存
+func (m *Memory) ScriptExists(ctx context.Context, shas ...string) ([]bool, error) {
+	exists := make([]bool, len(shas))
+	for i, sha := range shas {
+		_, exists[i] = m.shas.Load(strings.ToLower(sha))
+	}
+	return exists, nil
+}
+
+/********************************* 工具 **************************************/
+
+func toRedisStrings(values []interface{}) ([]string, error) {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		s, err := toRedisString(v)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// parseScoreBound 解析有序集合的分数区间: "-inf"、"+inf"、"1.5"、"(1.5"(开区间)
+func parseScoreBound(s string) (float64, bool, error) {
+	open := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), open, nil
+	case "+inf", "inf":
+		return math.Inf(1), open, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, errors.New("ERR min or max is not a float")
+	}
+	return f, open, nil
+}
+
+// matchGlob redis风格的glob匹配: * ? [abc] [^a] [a-z] 以及 \ 转义
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+2:]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if class[i] == '\\' && i+1 < len(class) {
+					i++
+					matched = matched || class[i] == s[0]
+				} else if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (s[0] >= lo && s[0] <= hi)
+					i += 2
+				} else {
+					matched = matched || class[i] == s[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
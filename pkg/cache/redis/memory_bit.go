@@ -0,0 +1,555 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+/*********************************** HyperLogLog接口 ****************************************/
+
+// 内存模式下HyperLogLog使用集合精确计数
+
+func encodeMemHLL(set map[string]struct{}) string {
+	elements := make([]string, 0, len(set))
+	for e := range set {
+		elements = append(elements, e)
+	}
+	sort.Strings(elements)
+	return "HYLL" + strings.Join(elements, "\n")
+}
+
+// hll 获取HyperLogLog, 需持有锁
+func (m *Memory) hll(key string) (map[string]struct{}, error) {
+	it, err := m.get(key, "")
+	if err != nil || it == nil {
+		return nil, err
+	}
+	if it.typ != "hll" {
+		return nil, errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+	return it.val.(map[string]struct{}), nil
+}
+
+// AddHLL 向HyperLogLog添加元素, 基数估计值发生变化时返回true
+func (m *Memory) AddHLL(ctx context.Context, key string, elements ...interface{}) (bool, error) {
+	strs, err := toRedisStrings(elements)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, err := m.hll(key)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	if set == nil {
+		set, changed = make(map[string]struct{}), true
+		m.items[key] = &memItem{typ: "hll", val: set}
+	}
+	for _, s := range strs {
+		if _, ok := set[s]; !ok {
+			set[s], changed = struct{}{}, true
+		}
+	}
+	return changed, nil
+}
+
+// CountHLL 获取HyperLogLog的基数, 多个key时返回并集的基数
+func (m *Memory) CountHLL(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	union, err := m.unionHLL(keys...)
+	return int64(len(union)), err
+}
+
+// MergeHLL 将多个HyperLogLog合并到dest
+func (m *Memory) MergeHLL(ctx context.Context, dest string, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	union, err := m.unionHLL(append([]string{dest}, keys...)...)
+	if err != nil {
+		return err
+	}
+	m.items[dest] = &memItem{typ: "hll", val: union}
+	return nil
+}
+
+func (m *Memory) unionHLL(keys ...string) (map[string]struct{}, error) {
+	union := make(map[string]struct{})
+	for _, key := range keys {
+		set, err := m.hll(key)
+		if err != nil {
+			return nil, err
+		}
+		for e := range set {
+			union[e] = struct{}{}
+		}
+	}
+	return union, nil
+}
+
+/*********************************** bitmap接口 ****************************************/
+
+// bytes 获取string的内容, 需持有锁
+func (m *Memory) bytes(key string) ([]byte, error) {
+	it, err := m.get(key, "string")
+	if err != nil || it == nil {
+		return nil, err
+	}
+	return []byte(it.val.(string)), nil
+}
+
+// setBytes 写入string并保留过期时间, 需持有锁
+func (m *Memory) setBytes(key string, b []byte) {
+	if it, _ := m.get(key, "string"); it != nil {
+		it.val = string(b)
+		return
+	}
+	m.items[key] = &memItem{typ: "string", val: string(b)}
+}
+
+func getBitAt(b []byte, offset int64) int64 {
+	if offset/8 >= int64(len(b)) {
+		return 0
+	}
+	return int64(b[offset/8]>>(7-uint(offset%8))) & 1
+}
+
+func setBitAt(b []byte, offset int64, value int) []byte {
+	if need := offset/8 + 1; need > int64(len(b)) {
+		b = append(b, make([]byte, need-int64(len(b)))...)
+	}
+	mask := byte(1) << (7 - uint(offset%8))
+	if value == 1 {
+		b[offset/8] |= mask
+	} else {
+		b[offset/8] &^= mask
+	}
+	return b
+}
+
+// SetBit 设置offset位的值(0或1), 返回原来的值
+func (m *Memory) SetBit(ctx context.Context, key string, offset int64, value int) (int64, error) {
+	if value != 0 && value != 1 {
+		return 0, errors.New("ERR bit is not an integer or out of range")
+	}
+	if offset < 0 || offset >= 1<<32 {
+		return 0, errors.New("ERR bit offset is not an integer or out of range")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bytes(key)
+	if err != nil {
+		return 0, err
+	}
+	old := getBitAt(b, offset)
+	m.setBytes(key, setBitAt(b, offset, value))
+	return old, nil
+}
+
+// GetBit 获取offset位的值
+func (m *Memory) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bytes(key)
+	if err != nil {
+		return 0, err
+	}
+	return getBitAt(b, offset), nil
+}
+
+// CountBit 统计字节区间[start, end]内值为1的位数
+func (m *Memory) CountBit(ctx context.Context, key string, start, end int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bytes(key)
+	if err != nil {
+		return 0, err
+	}
+	s, e, ok := rangeIndex(int64(len(b)), start, end)
+	if !ok {
+		return 0, nil
+	}
+	var n int64
+	for _, c := range b[s : e+1] {
+		for ; c > 0; c &= c - 1 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// OpBit 对多个bitmap进行位运算并保存到dest, 返回dest的长度(字节)
+func (m *Memory) OpBit(ctx context.Context, op BitOp, dest string, keys ...string) (int64, error) {
+	switch op {
+	case BitAnd, BitOr, BitXor:
+	case BitNot:
+		if len(keys) != 1 {
+			return 0, fmt.Errorf("bitop NOT requires exactly one source key")
+		}
+	default:
+		return 0, fmt.Errorf("unsupported bitop %q", op)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	srcs := make([][]byte, 0, len(keys))
+	size := 0
+	for _, key := range keys {
+		b, err := m.bytes(key)
+		if err != nil {
+			return 0, err
+		}
+		srcs = append(srcs, b)
+		if len(b) > size {
+			size = len(b)
+		}
+	}
+	res := make([]byte, size)
+	for i := 0; i < size; i++ {
+		at := func(b []byte) byte {
+			if i < len(b) {
+				return b[i]
+			}
+			return 0
+		}
+		v := at(srcs[0])
+		for _, b := range srcs[1:] {
+			switch op {
+			case BitAnd:
+				v &= at(b)
+			case BitOr:
+				v |= at(b)
+			case BitXor:
+				v ^= at(b)
+			}
+		}
+		if op == BitNot {
+			v = ^v
+		}
+		res[i] = v
+	}
+	if size == 0 {
+		delete(m.items, dest)
+		return 0, nil
+	}
+	m.items[dest] = &memItem{typ: "string", val: string(res)}
+	return int64(size), nil
+}
+
+// BitField 执行BITFIELD子命令, 支持 GET/SET/INCRBY/OVERFLOW; OVERFLOW FAIL 溢出时返回0
+func (m *Memory) BitField(ctx context.Context, key string, args ...interface{}) ([]int64, error) {
+	strs, err := toRedisStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bytes(key)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		res      []int64
+		overflow = "WRAP"
+		written  bool
+	)
+	for i := 0; i < len(strs); {
+		sub := strings.ToUpper(strs[i])
+		if sub == "OVERFLOW" {
+			if i+1 >= len(strs) {
+				return nil, errors.New("ERR syntax error")
+			}
+			overflow = strings.ToUpper(strs[i+1])
+			i += 2
+			continue
+		}
+		argc := map[string]int{"GET": 2, "SET": 3, "INCRBY": 3}[sub]
+		if argc == 0 || i+argc >= len(strs) {
+			return nil, errors.New("ERR syntax error")
+		}
+		signed, bits, err := parseBitFieldType(strs[i+1])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := parseBitFieldOffset(strs[i+2], bits)
+		if err != nil {
+			return nil, err
+		}
+		old := readBitField(b, offset, bits, signed)
+		switch sub {
+		case "GET":
+			res = append(res, old)
+		case "SET", "INCRBY":
+			v, err := strconv.ParseInt(strs[i+3], 10, 64)
+			if err != nil {
+				return nil, errors.New("ERR value is not an integer or out of range")
+			}
+			if sub == "INCRBY" {
+				v += old
+			}
+			v, ok := fitBitField(v, bits, signed, overflow)
+			if !ok {
+				res = append(res, 0)
+				break
+			}
+			b = writeBitField(b, offset, bits, v)
+			written = true
+			if sub == "SET" {
+				res = append(res, old)
+			} else {
+				res = append(res, v)
+			}
+		}
+		i += argc + 1
+	}
+	if written {
+		m.setBytes(key, b)
+	}
+	return res, nil
+}
+
+// parseBitFieldType 解析 i8/u16 形式的类型
+func parseBitFieldType(s string) (bool, uint, error) {
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return false, 0, errors.New("ERR Invalid bitfield type")
+	}
+	bits, err := strconv.ParseUint(s[1:], 10, 8)
+	signed := s[0] == 'i'
+	if err != nil || bits == 0 || (signed && bits > 64) || (!signed && bits > 63) {
+		return false, 0, errors.New("ERR Invalid bitfield type")
+	}
+	return signed, uint(bits), nil
+}
+
+// parseBitFieldOffset 解析offset, "#N" 表示第N个该类型的位置
+func parseBitFieldOffset(s string, bits uint) (int64, error) {
+	mul := int64(1)
+	if strings.HasPrefix(s, "#") {
+		s, mul = s[1:], int64(bits)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("ERR bit offset is not an integer or out of range")
+	}
+	return n * mul, nil
+}
+
+func readBitField(b []byte, offset int64, bits uint, signed bool) int64 {
+	var v uint64
+	for i := uint(0); i < bits; i++ {
+		v = v<<1 | uint64(getBitAt(b, offset+int64(i)))
+	}
+	if signed && bits < 64 && v&(1<<(bits-1)) != 0 {
+		v |= ^uint64(0) << bits
+	}
+	return int64(v)
+}
+
+func writeBitField(b []byte, offset int64, bits uint, v int64) []byte {
+	for i := uint(0); i < bits; i++ {
+		b = setBitAt(b, offset+int64(i), int((uint64(v)>>(bits-1-i))&1))
+	}
+	return b
+}
+
+// fitBitField 按overflow策略处理溢出, FAIL时溢出返回false
+func fitBitField(v int64, bits uint, signed bool, overflow string) (int64, bool) {
+	var lo, hi int64
+	if signed {
+		if bits == 64 {
+			return v, true
+		}
+		lo, hi = -(1 << (bits - 1)), 1<<(bits-1)-1
+	} else {
+		lo, hi = 0, 1<<bits-1
+	}
+	if v >= lo && v <= hi {
+		return v, true
+	}
+	switch overflow {
+	case "SAT":
+		if v < lo {
+			return lo, true
+		}
+		return hi, true
+	case "FAIL":
+		return 0, false
+	}
+	// WRAP
+	u := uint64(v) & (1<<bits - 1)
+	if signed && u&(1<<(bits-1)) != 0 {
+		u |= ^uint64(0) << bits
+	}
+	return int64(u), true
+}
+
+/*********************************** geo接口 ****************************************/
+
+// 与redis一致, 使用52位geohash作为有序集合的分数
+
+const (
+	geoStep      = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	earthRadiusM = 6372797.560856
+)
+
+func geoEncode(lon, lat float64) (uint64, error) {
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	latOffset := (lat - geoLatMin) / (geoLatMax - geoLatMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	latBits := uint64(latOffset * (1 << geoStep))
+	lonBits := uint64(lonOffset * (1 << geoStep))
+	var hash uint64
+	for i := geoStep - 1; i >= 0; i-- {
+		hash = hash<<2 | (lonBits>>uint(i)&1)<<1 | latBits>>uint(i)&1
+	}
+	return hash, nil
+}
+
+func geoDecode(hash uint64) (lon, lat float64) {
+	var latBits, lonBits uint64
+	for i := geoStep - 1; i >= 0; i-- {
+		lonBits = lonBits<<1 | hash>>(uint(i)*2+1)&1
+		latBits = latBits<<1 | hash>>(uint(i)*2)&1
+	}
+	latScale := (geoLatMax - geoLatMin) / (1 << geoStep)
+	lonScale := (geoLonMax - geoLonMin) / (1 << geoStep)
+	lat = geoLatMin + (float64(latBits)+0.5)*latScale
+	lon = geoLonMin + (float64(lonBits)+0.5)*lonScale
+	return lon, lat
+}
+
+// geoDistance 两点间的球面距离(米)
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	lat1r, lat2r := lat1*rad, lat2*rad
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func geoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "", "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// AddGeo 添加地理位置, 返回新增的成员数
+func (m *Memory) AddGeo(ctx context.Context, key string, locations ...*GeoLocation) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(key, "zset", newMemZSet)
+	if err != nil {
+		return 0, err
+	}
+	z := it.val.(map[string]float64)
+	var added int64
+	for _, l := range locations {
+		hash, err := geoEncode(l.Longitude, l.Latitude)
+		if err != nil {
+			m.cleanup(key)
+			return added, err
+		}
+		if _, ok := z[l.Name]; !ok {
+			added++
+		}
+		z[l.Name] = float64(hash)
+	}
+	return added, nil
+}
+
+// SearchGeo 按半径或矩形查询范围内的成员, 返回成员的坐标及与中心的距离
+func (m *Memory) SearchGeo(ctx context.Context, key string, query *GeoSearchQuery) ([]*GeoLocation, error) {
+	if _, err := geoSearchArgs(key, query); err != nil {
+		return nil, err
+	}
+	unit, err := geoUnit(query.Unit)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return nil, err
+	}
+	lon, lat := query.Longitude, query.Latitude
+	if query.Member != "" {
+		score, ok := z[query.Member]
+		if !ok {
+			return nil, errors.New("ERR could not decode requested zset member")
+		}
+		lon, lat = geoDecode(uint64(score))
+	}
+	res := make([]*GeoLocation, 0)
+	for name, score := range z {
+		mlon, mlat := geoDecode(uint64(score))
+		dist := geoDistance(lon, lat, mlon, mlat)
+		if query.Radius > 0 {
+			if dist > query.Radius*unit {
+				continue
+			}
+		} else {
+			// 按经纬方向分别计算与中心的距离
+			dy := geoDistance(lon, lat, lon, mlat)
+			dx := geoDistance(lon, mlat, mlon, mlat)
+			if dy > query.Height*unit/2 || dx > query.Width*unit/2 {
+				continue
+			}
+		}
+		res = append(res, &GeoLocation{Name: name, Longitude: mlon, Latitude: mlat, Dist: dist / unit})
+	}
+	switch strings.ToUpper(query.Sort) {
+	case "ASC":
+		sort.Slice(res, func(i, j int) bool { return res[i].Dist < res[j].Dist })
+	case "DESC":
+		sort.Slice(res, func(i, j int) bool { return res[i].Dist > res[j].Dist })
+	}
+	if query.Count > 0 && int64(len(res)) > query.Count {
+		res = res[:query.Count]
+	}
+	return res, nil
+}
+
+// DistGeo 获取两个成员之间的距离, unit为m/km/ft/mi; 成员不存在时返回 redis.Nil
+func (m *Memory) DistGeo(ctx context.Context, key, member1, member2, unit string) (float64, error) {
+	u, err := geoUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, err := m.zset(key)
+	if err != nil {
+		return 0, err
+	}
+	s1, ok1 := z[member1]
+	s2, ok2 := z[member2]
+	if !ok1 || !ok2 {
+		return 0, redis.Nil
+	}
+	lon1, lat1 := geoDecode(uint64(s1))
+	lon2, lat2 := geoDecode(uint64(s2))
+	return geoDistance(lon1, lat1, lon2, lat2) / u, nil
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+)
+
+/*********************************** pubsub接口 ****************************************/
+
+// Publish 向channel发布消息
+func (m *Memory) Publish(ctx context.Context, channel string, message interface{}) error {
+	payload, err := toRedisString(message)
+	if err != nil {
+		return err
+	}
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+	for conn := range m.subs {
+		conn.deliver(channel, payload)
+	}
+	return nil
+}
+
+// Subscribe 订阅channel, ctx结束时退订并关闭消息通道
+func (m *Memory) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	ps := m.NewPubSub(ctx)
+	if err := ps.Subscribe(ctx, channels...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// PSubscribe 按pattern订阅, ctx结束时退订并关闭消息通道
+func (m *Memory) PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error) {
+	ps := m.NewPubSub(ctx)
+	if err := ps.PSubscribe(ctx, patterns...); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	return ps.Channel(), nil
+}
+
+// NewPubSub 创建一个可动态订阅/退订的发布订阅连接, ctx结束时关闭
+func (m *Memory) NewPubSub(ctx context.Context) *PubSub {
+	conn := &memPubSubConn{
+		m:        m,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	m.subMu.Lock()
+	m.subs[conn] = struct{}{}
+	m.subMu.Unlock()
+	return newPubSub(ctx, conn, m.logg)
+}
+
+// SPublish 向分片channel发布消息, 内存模式下与 Publish 相同
+func (m *Memory) SPublish(ctx context.Context, channel string, message interface{}) error {
+	return m.Publish(ctx, channel, message)
+}
+
+// SSubscribe 订阅分片channel, 内存模式下与 Subscribe 相同
+func (m *Memory) SSubscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("at least one channel is required")
+	}
+	return m.Subscribe(ctx, channels...)
+}
+
+// memPubSubConn 内存模式的订阅连接, 行为与 *redis.PubSub 一致
+type memPubSubConn struct {
+	m *Memory
+
+	mu       sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	ch       chan interface{}
+	queue    []interface{} // 待投递的订阅确认及消息, 保证投递顺序
+	wake     chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+func (c *memPubSubConn) Subscribe(channels ...string) error {
+	return c.update("subscribe", c.channels, channels, true)
+}
+
+func (c *memPubSubConn) PSubscribe(patterns ...string) error {
+	return c.update("psubscribe", c.patterns, patterns, true)
+}
+
+func (c *memPubSubConn) Unsubscribe(channels ...string) error {
+	return c.update("unsubscribe", c.channels, channels, false)
+}
+
+func (c *memPubSubConn) PUnsubscribe(patterns ...string) error {
+	return c.update("punsubscribe", c.patterns, patterns, false)
+}
+
+// update 更新订阅并发送订阅确认, 退订时names为空表示退订全部
+func (c *memPubSubConn) update(kind string, set map[string]struct{}, names []string, add bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("redis: client is closed")
+	}
+	if !add && len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if add {
+			set[name] = struct{}{}
+		} else {
+			delete(set, name)
+		}
+		c.emit(&redis.Subscription{Kind: kind, Channel: name, Count: len(c.channels) + len(c.patterns)})
+	}
+	return nil
+}
+
+func (c *memPubSubConn) Close() error {
+	c.m.subMu.Lock()
+	delete(c.m.subs, c)
+	c.m.subMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("redis: client is closed")
+	}
+	c.closed = true
+	close(c.done)
+	return nil
+}
+
+func (c *memPubSubConn) ChannelWithSubscriptions(size int) <-chan interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch == nil {
+		c.ch = make(chan interface{}, size)
+		go c.pump()
+	}
+	return c.ch
+}
+
+// pump 按顺序投递队列中的消息
+func (c *memPubSubConn) pump() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+		c.mu.Lock()
+		queue := c.queue
+		c.queue = nil
+		c.mu.Unlock()
+		for _, msg := range queue {
+			select {
+			case c.ch <- msg:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// deliver 向匹配的订阅投递消息
+func (c *memPubSubConn) deliver(channel, payload string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if _, ok := c.channels[channel]; ok {
+		c.emit(&redis.Message{Channel: channel, Payload: payload})
+	}
+	for pattern := range c.patterns {
+		if matchGlob(pattern, channel) {
+			c.emit(&redis.Message{Channel: channel, Pattern: pattern, Payload: payload})
+		}
+	}
+}
+
+// emit 需持有锁
+func (c *memPubSubConn) emit(msg interface{}) {
+	c.queue = append(c.queue, msg)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memStream 内存模式的stream
+type memStream struct {
+	entries []*memStreamEntry // 按ID递增
+	lastID  streamID
+	groups  map[string]*memStreamGroup
+}
+
+type memStreamEntry struct {
+	id     streamID
+	values map[string]interface{}
+}
+
+type memStreamGroup struct {
+	lastDelivered streamID
+	pending       map[streamID]*memStreamPending
+}
+
+type memStreamPending struct {
+	consumer    string
+	deliveredAt time.Time
+	count       int64
+}
+
+// streamID stream消息ID: <毫秒时间戳>-<序号>
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+// parseStreamID 解析消息ID, 支持 "-"、"+" 以及省略序号的ID; max为true时省略的序号取最大值
+func parseStreamID(s string, max bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamID{ms: ^uint64(0), seq: ^uint64(0)}, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	var (
+		id  streamID
+		err error
+	)
+	parts := strings.SplitN(s, "-", 2)
+	if id.ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return id, errors.New("ERR Invalid stream ID specified as stream command argument")
+	}
+	if len(parts) == 2 {
+		if id.seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+			return id, errors.New("ERR Invalid stream ID specified as stream command argument")
+		}
+	} else if max {
+		id.seq = ^uint64(0)
+	}
+	if exclusive {
+		if max {
+			if id.seq == 0 {
+				id.ms, id.seq = id.ms-1, ^uint64(0)
+			} else {
+				id.seq--
+			}
+		} else {
+			id.seq++
+		}
+	}
+	return id, nil
+}
+
+func newMemStream() interface{} {
+	return &memStream{groups: make(map[string]*memStreamGroup)}
+}
+
+// stream 获取stream, 需持有锁
+func (m *Memory) stream(key string) (*memStream, error) {
+	it, err := m.get(key, "stream")
+	if err != nil || it == nil {
+		return nil, err
+	}
+	return it.val.(*memStream), nil
+}
+
+func (s *memStream) find(id streamID) *memStreamEntry {
+	i := sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(id) })
+	if i < len(s.entries) && s.entries[i].id == id {
+		return s.entries[i]
+	}
+	return nil
+}
+
+func toMemStreamMessage(e *memStreamEntry) *StreamMessage {
+	values := make(map[string]interface{}, len(e.values))
+	for k, v := range e.values {
+		values[k] = v
+	}
+	return &StreamMessage{ID: e.id.String(), Values: values}
+}
+
+/*********************************** stream接口 ****************************************/
+
+// AddStream 向stream追加消息, maxLen > 0 时裁剪stream, 返回消息ID
+func (m *Memory) AddStream(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	fields := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		s, err := toRedisString(v)
+		if err != nil {
+			return "", err
+		}
+		fields[k] = s
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(stream, "stream", newMemStream)
+	if err != nil {
+		return "", err
+	}
+	s := it.val.(*memStream)
+	id := streamID{ms: uint64(time.Now().UnixMilli())}
+	if !s.lastID.less(id) {
+		id = streamID{ms: s.lastID.ms, seq: s.lastID.seq + 1}
+	}
+	s.lastID = id
+	s.entries = append(s.entries, &memStreamEntry{id: id, values: fields})
+	if maxLen > 0 && int64(len(s.entries)) > maxLen {
+		s.entries = s.entries[int64(len(s.entries))-maxLen:]
+	}
+	m.notify()
+	return id.String(), nil
+}
+
+// CreateStreamGroup 创建消费者组, stream不存在时自动创建; 组已存在时不返回错误
+func (m *Memory) CreateStreamGroup(ctx context.Context, stream, group, start string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.getOrCreate(stream, "stream", newMemStream)
+	if err != nil {
+		return err
+	}
+	s := it.val.(*memStream)
+	if _, ok := s.groups[group]; ok {
+		return nil
+	}
+	last := s.lastID
+	if start != "$" {
+		if last, err = parseStreamID(start, false); err != nil {
+			return err
+		}
+	}
+	s.groups[group] = &memStreamGroup{lastDelivered: last, pending: make(map[streamID]*memStreamPending)}
+	return nil
+}
+
+// ReadGroupStream 以消费者组的方式读取新消息, block < 0 时不阻塞; 超时无消息时返回空列表
+func (m *Memory) ReadGroupStream(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error) {
+	var deadline <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		m.mu.Lock()
+		s, err := m.stream(stream)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		if s == nil || s.groups[group] == nil {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", stream, group)
+		}
+		g := s.groups[group]
+		var msgs []*StreamMessage
+		for _, e := range s.entries {
+			if !g.lastDelivered.less(e.id) {
+				continue
+			}
+			g.lastDelivered = e.id
+			g.pending[e.id] = &memStreamPending{consumer: consumer, deliveredAt: time.Now(), count: 1}
+			msgs = append(msgs, toMemStreamMessage(e))
+			if count > 0 && int64(len(msgs)) >= count {
+				break
+			}
+		}
+		changed := m.changed
+		m.mu.Unlock()
+		if len(msgs) > 0 || block < 0 {
+			return msgs, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+// AckStream 确认消息已处理
+func (m *Memory) AckStream(ctx context.Context, stream, group string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := m.stream(stream)
+	if err != nil || s == nil || s.groups[group] == nil {
+		return err
+	}
+	for _, raw := range ids {
+		id, err := parseStreamID(raw, false)
+		if err != nil {
+			return err
+		}
+		delete(s.groups[group].pending, id)
+	}
+	return nil
+}
+
+// sortedPending 按ID排序的pending消息
+func (g *memStreamGroup) sortedPending(start, end streamID) []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		if !id.less(start) && !end.less(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// PendingStream 查看消费者组中[start, end]区间内未确认的消息
+func (m *Memory) PendingStream(ctx context.Context, stream, group, start, end string, count int64) ([]*StreamPending, error) {
+	lo, err := parseStreamID(start, false)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := parseStreamID(end, true)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := m.stream(stream)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.groups[group] == nil {
+		return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", stream, group)
+	}
+	g := s.groups[group]
+	pending := make([]*StreamPending, 0)
+	for _, id := range g.sortedPending(lo, hi) {
+		if count > 0 && int64(len(pending)) >= count {
+			break
+		}
+		p := g.pending[id]
+		pending = append(pending, &StreamPending{ID: id.String(), Consumer: p.consumer, Idle: time.Since(p.deliveredAt), RetryCount: p.count})
+	}
+	return pending, nil
+}
+
+// AutoClaimStream 将空闲超过minIdle的pending消息转移给consumer, 返回下一次扫描的起始ID, 为"0-0"时表示已扫描完毕
+func (m *Memory) AutoClaimStream(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) (string, []*StreamMessage, error) {
+	lo, err := parseStreamID(start, false)
+	if err != nil {
+		return "", nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := m.stream(stream)
+	if err != nil {
+		return "", nil, err
+	}
+	if s == nil || s.groups[group] == nil {
+		return "", nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", stream, group)
+	}
+	if count <= 0 {
+		count = 100
+	}
+	g := s.groups[group]
+	ids := g.sortedPending(lo, streamID{ms: ^uint64(0), seq: ^uint64(0)})
+	var (
+		msgs []*StreamMessage
+		next = "0-0"
+		now  = time.Now()
+	)
+	for i, id := range ids {
+		if int64(len(msgs)) >= count {
+			next = ids[i].String()
+			break
+		}
+		p := g.pending[id]
+		if now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+		e := s.find(id)
+		if e == nil { // 消息已被裁剪
+			delete(g.pending, id)
+			continue
+		}
+		p.consumer, p.deliveredAt = consumer, now
+		p.count++
+		msgs = append(msgs, toMemStreamMessage(e))
+	}
+	return next, msgs, nil
+}
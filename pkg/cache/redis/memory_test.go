@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:*:end", "a:b:end", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchGlob(c.pattern, c.s), "%s %s", c.pattern, c.s)
+	}
+}
+
+func TestMemoryGeneric(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	assert.NoError(t, m.SetStr(ctx, "user:1", "a"))
+	assert.NoError(t, m.SetStrTTL(ctx, "user:2", "b", 50*time.Millisecond))
+	assert.NoError(t, m.SetNX(ctx, "user:1", "c", 0))
+	var s string
+	assert.NoError(t, m.GetMixed(ctx, "user:1", &s))
+	assert.Equal(t, "a", s)
+	ttl, _ := m.GetExpire(ctx, "user:1")
+	assert.Equal(t, time.Duration(-1), ttl)
+	ttl, _ = m.GetExpire(ctx, "none")
+	assert.Equal(t, time.Duration(-2), ttl)
+
+	var keys []string
+	for k := range m.ScanKey(ctx, "user:*") {
+		keys = append(keys, k)
+	}
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, m.IsExist(ctx, "user:2"))
+
+	_ = m.PushList(ctx, "list", 1, 2)
+	err := m.PushList(ctx, "user:1", "x")
+	assert.ErrorIs(t, err, errWrongType)
+	typ, _ := m.GetType(ctx, "list")
+	assert.Equal(t, "list", typ)
+	typ, _ = m.GetType(ctx, "none")
+	assert.Equal(t, "none", typ)
+}
+
+func TestMemoryHash(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	assert.NoError(t, m.SetHash(ctx, "h", map[string]interface{}{"a": 1, "b": "x"}))
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "c", 3, 30*time.Millisecond))
+	v, err := m.GetHashField(ctx, "h", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", v)
+	n, _ := m.IncrByHash(ctx, "h", "a", 2)
+	assert.Equal(t, int64(3), n)
+
+	all := map[string]string{}
+	assert.NoError(t, m.GetMixed(ctx, "h", &all))
+	assert.Equal(t, map[string]string{"a": "3", "b": "x", "c": "3"}, all)
+	time.Sleep(40 * time.Millisecond)
+	_, err = m.GetHashField(ctx, "h", "c")
+	assert.Equal(t, redis.Nil, err)
+
+	var fields []string
+	for f := range m.ScanHash(ctx, "h", "*") {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"a", "b"}, fields)
+	assert.NoError(t, m.DelHashField(ctx, "h", "a", "b"))
+	assert.False(t, m.IsExist(ctx, "h"))
+}
+
+func TestMemoryHashOverwriteTTL(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	// 覆盖写入后不再过期
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "a", 1, 30*time.Millisecond))
+	assert.NoError(t, m.SetHash(ctx, "h", map[string]interface{}{"a": 2}))
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "b", 1, 30*time.Millisecond))
+	assert.NoError(t, m.SetHashStruct(ctx, "h", struct {
+		B int `redis:"b"`
+	}{B: 2}))
+	// 未过期的field增量后保留TTL, 过期后从0开始
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "c", 1, 30*time.Millisecond))
+	n, _ := m.IncrByHash(ctx, "h", "c", 1)
+	assert.Equal(t, int64(2), n)
+	time.Sleep(40 * time.Millisecond)
+
+	all := map[string]string{}
+	assert.NoError(t, m.GetMixed(ctx, "h", &all))
+	assert.Equal(t, map[string]string{"a": "2", "b": "2"}, all)
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "c", 5, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	f, _ := m.IncrByFloatHash(ctx, "h", "c", 1.5)
+	assert.Equal(t, 1.5, f)
+	v, err := m.GetHashField(ctx, "h", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5", v)
+}
+
+func TestMemoryListSetZSet(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	_ = m.PushListBy(ctx, "l", StartPoint, "b", "a")
+	_ = m.PushList(ctx, "l", "c")
+	vals, _ := m.RangeList(ctx, "l", 0, -1)
+	assert.Equal(t, []string{"a", "b", "c"}, vals)
+	var s string
+	assert.NoError(t, m.PopListBy(ctx, "l", EndPoint, &s))
+	assert.Equal(t, "c", s)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = m.PushList(ctx, "q", "job")
+	}()
+	key, val, err := m.BlockPopList(ctx, StartPoint, time.Second, "empty", "q")
+	assert.NoError(t, err)
+	assert.Equal(t, "q", key)
+	assert.Equal(t, "job", val)
+	_, _, err = m.BlockPopList(ctx, StartPoint, 10*time.Millisecond, "q")
+	assert.Equal(t, redis.Nil, err)
+
+	_ = m.AddSet(ctx, "s1", "a", "b", "c")
+	_ = m.AddSet(ctx, "s2", "b", "c", "d")
+	inter, _ := m.InterSet(ctx, "s1", "s2")
+	assert.Equal(t, []string{"b", "c"}, inter)
+	n, _ := m.DiffStoreSet(ctx, "s3", "s1", "s2")
+	assert.Equal(t, int64(1), n)
+	ok, _ := m.CheckSetMember(ctx, "s3", "a")
+	assert.True(t, ok)
+
+	_ = m.AddZSet(ctx, "z", &ZSetMember{Score: 1, Member: "a"}, &ZSetMember{Score: 3, Member: "c"}, &ZSetMember{Score: 2, Member: "b"})
+	zs, _ := m.RangeByScoreZSet(ctx, "z", "(1", "+inf", 0, 0, true)
+	assert.Equal(t, []*ZSetMember{{Score: 3, Member: "c"}, {Score: 2, Member: "b"}}, zs)
+	rank, _ := m.RankZSet(ctx, "z", "a", true)
+	assert.Equal(t, int64(2), rank)
+	var members []string
+	assert.NoError(t, m.GetMixed(ctx, "z", &members))
+	assert.Equal(t, []string{"c", "b", "a"}, members)
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m := NewMemory(ctx)
+	ch, err := m.PSubscribe(ctx, "news.*")
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, m.Publish(ctx, "news.a", "1"))
+	assert.NoError(t, m.Publish(ctx, "other", "2"))
+	assert.NoError(t, m.Publish(ctx, "news.b", "3"))
+	msg := <-ch
+	assert.Equal(t, &Message{Channel: "news.a", Pattern: "news.*", Payload: "1"}, msg)
+	msg = <-ch
+	assert.Equal(t, "3", msg.Payload)
+}
+
+func TestMemoryStream(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	assert.NoError(t, m.CreateStreamGroup(ctx, "s", "g", "$"))
+	id, err := m.AddStream(ctx, "s", 0, map[string]interface{}{"k": 1})
+	assert.NoError(t, err)
+	msgs, err := m.ReadGroupStream(ctx, "s", "g", "c1", 10, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*StreamMessage{{ID: id, Values: map[string]interface{}{"k": "1"}}}, msgs)
+	msgs, _ = m.ReadGroupStream(ctx, "s", "g", "c1", 10, 10*time.Millisecond)
+	assert.Empty(t, msgs)
+
+	next, claimed, err := m.AutoClaimStream(ctx, "s", "g", "c2", 0, "0", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0-0", next)
+	assert.Len(t, claimed, 1)
+	pending, _ := m.PendingStream(ctx, "s", "g", "-", "+", 10)
+	assert.Equal(t, "c2", pending[0].Consumer)
+	assert.Equal(t, int64(2), pending[0].RetryCount)
+	assert.NoError(t, m.AckStream(ctx, "s", "g", id))
+	pending, _ = m.PendingStream(ctx, "s", "g", "-", "+", 10)
+	assert.Empty(t, pending)
+}
+
+func TestMemoryBitHLLGeo(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	a := NewActiveUsers(m, "app")
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(t, a.Mark(ctx, 1, monday))
+	assert.NoError(t, a.Mark(ctx, 7, monday))
+	assert.NoError(t, a.Mark(ctx, 7, monday.AddDate(0, 0, 2)))
+	assert.NoError(t, a.Mark(ctx, 9, monday.AddDate(0, 0, 6)))
+	n, _ := a.CountDaily(ctx, monday)
+	assert.Equal(t, int64(2), n)
+	n, err := a.RollupWeekly(ctx, monday.AddDate(0, 0, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	ok, _ := a.IsActiveWeekly(ctx, 9, monday)
+	assert.True(t, ok)
+
+	res, err := m.BitField(ctx, "bf", "set", "u8", 0, 255, "incrby", "u8", 0, 10, "overflow", "sat", "incrby", "i4", "#2", 100, "get", "u8", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 9, 7, 9}, res)
+
+	_, _ = m.AddHLL(ctx, "uv1", "a", "b")
+	_, _ = m.AddHLL(ctx, "uv2", "b", "c")
+	n, _ = m.CountHLL(ctx, "uv1", "uv2")
+	assert.Equal(t, int64(3), n)
+
+	_, _ = m.AddGeo(ctx, "stores",
+		&GeoLocation{Name: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		&GeoLocation{Name: "Catania", Longitude: 15.087269, Latitude: 37.502669})
+	dist, err := m.DistGeo(ctx, "stores", "Palermo", "Catania", "km")
+	assert.NoError(t, err)
+	assert.InDelta(t, 166.2742, dist, 0.01)
+	locs, err := m.SearchGeo(ctx, "stores", &GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Sort: "ASC"})
+	assert.NoError(t, err)
+	assert.Len(t, locs, 2)
+	assert.Equal(t, "Catania", locs[0].Name)
+	assert.InDelta(t, 56.4413, locs[0].Dist, 0.01)
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+// DefaultLatencyBuckets 默认的耗时分布区间上限
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second,
+}
+
+// CommandStats 单个命令的统计数据
+type CommandStats struct {
+	Name    string          `json:"name"`
+	Count   int64           `json:"count"`
+	Errors  int64           `json:"errors"` // 不包含 redis.Nil
+	Total   time.Duration   `json:"total"`
+	Max     time.Duration   `json:"max"`
+	Buckets []time.Duration `json:"buckets"` // 区间上限, 与Counts一一对应
+	Counts  []int64         `json:"counts"`  // 各区间的命令数(非累计), 最后一个为超过所有区间上限的命令数
+}
+
+// Avg 平均耗时
+func (s *CommandStats) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// Quantile 根据耗时分布估算分位数(取区间上限), q取值(0, 1]
+func (s *CommandStats) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	target := int64(q*float64(s.Count) + 0.5)
+	var n int64
+	for i, c := range s.Counts {
+		if n += c; n >= target && i < len(s.Buckets) {
+			return s.Buckets[i]
+		}
+	}
+	return s.Max
+}
+
+// MetricsSnapshot 统计数据快照
+type MetricsSnapshot struct {
+	Commands []*CommandStats `json:"commands"` // 按命令名排序
+	Count    int64           `json:"count"`
+	Errors   int64           `json:"errors"`
+	Since    time.Time       `json:"since"` // 统计开始的时间
+}
+
+// Metrics 按命令统计耗时分布与错误数, 实现了 Hook
+type Metrics struct {
+	mu       sync.Mutex
+	buckets  []time.Duration
+	commands map[string]*CommandStats
+	since    time.Time
+}
+
+var _ Hook = (*Metrics)(nil)
+
+// NewMetrics 实例化命令统计, buckets为空时使用 DefaultLatencyBuckets
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return &Metrics{
+		buckets:  buckets,
+		commands: make(map[string]*CommandStats),
+		since:    time.Now(),
+	}
+}
+
+func (m *Metrics) BeforeCommand(ctx context.Context, cmd *CommandInfo) context.Context {
+	return ctx
+}
+
+func (m *Metrics) AfterCommand(ctx context.Context, cmd *CommandInfo) {
+	i := sort.Search(len(m.buckets), func(i int) bool { return cmd.Duration <= m.buckets[i] })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.commands[cmd.Name]
+	if !ok {
+		st = &CommandStats{Name: cmd.Name, Buckets: m.buckets, Counts: make([]int64, len(m.buckets)+1)}
+		m.commands[cmd.Name] = st
+	}
+	st.Count++
+	st.Total += cmd.Duration
+	st.Counts[i]++
+	if cmd.Duration > st.Max {
+		st.Max = cmd.Duration
+	}
+	if cmd.Err != nil && cmd.Err != redis.Nil {
+		st.Errors++
+	}
+}
+
+// Snapshot 获取统计数据的快照
+func (m *Metrics) Snapshot() *MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := &MetricsSnapshot{Commands: make([]*CommandStats, 0, len(m.commands)), Since: m.since}
+	for _, st := range m.commands {
+		cp := *st
+		cp.Counts = append([]int64(nil), st.Counts...)
+		snap.Commands = append(snap.Commands, &cp)
+		snap.Count += st.Count
+		snap.Errors += st.Errors
+	}
+	sort.Slice(snap.Commands, func(i, j int) bool { return snap.Commands[i].Name < snap.Commands[j].Name })
+	return snap
+}
+
+// Reset 清空统计数据, 返回清空前的快照; 用于按周期上报
+func (m *Metrics) Reset() *MetricsSnapshot {
+	snap := m.Snapshot()
+	m.mu.Lock()
+	m.commands = make(map[string]*CommandStats)
+	m.since = time.Now()
+	m.mu.Unlock()
+	return snap
+}
+
+// SlowLogHook 记录耗时超过阈值的命令
+type SlowLogHook struct {
+	logg      logger.Logger
+	threshold time.Duration
+}
+
+var _ Hook = (*SlowLogHook)(nil)
+
+// NewSlowLogHook 实例化慢命令日志, logg为nil时使用默认logger
+func NewSlowLogHook(logg logger.Logger, threshold time.Duration) *SlowLogHook {
+	if logg == nil {
+		logg = logger.DefaultLogger()
+	}
+	return &SlowLogHook{logg: logg, threshold: threshold}
+}
+
+func (h *SlowLogHook) BeforeCommand(ctx context.Context, cmd *CommandInfo) context.Context {
+	return ctx
+}
+
+func (h *SlowLogHook) AfterCommand(ctx context.Context, cmd *CommandInfo) {
+	if cmd.Duration < h.threshold {
+		return
+	}
+	fields := []logger.Field{
+		logger.MakeField("cmd", cmd.Name),
+		logger.MakeField("key", cmd.Key),
+		logger.MakeField("duration", cmd.Duration.String()),
+		logger.MakeField("pipeline", cmd.Pipeline),
+	}
+	if cmd.Err != nil && cmd.Err != redis.Nil {
+		fields = append(fields, logger.ErrorField(cmd.Err))
+	}
+	h.logg.Warn(logger.ErrorRedis, "redis slow command", fields...)
+}
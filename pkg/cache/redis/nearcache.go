@@ -0,0 +1,779 @@
+package cache
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/8xmx8/easier/pkg/utils"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+二级缓存(near cache): 在Redis前增加一层进程内的LRU/TTL缓存
+1. 读路径(GetMixed、GetHashField、GetHashStruct、RangeList、LenList、CheckSetMember)优先读取本地缓存,
+   未命中时按key的类型从Redis读取完整的值并缓存, 不存在的key同样会被缓存, 避免热点空key穿透;
+2. 写路径在写入Redis后删除本地缓存, 并通过 Publish 广播失效消息, 所有实例收到后删除对应的本地缓存;
+3. 订阅连接断开重连(Gap)期间可能丢失失效消息, 此时清空整个本地缓存;
+4. 本地缓存按TTL过期, 作为失效消息丢失时的兜底; TTL不超过key在Redis中的剩余过期时间; 按条目数和估算的内存占用淘汰;
+5. 即将过期的key与包含field级别TTL(SetHashFieldTTL)的hash不缓存;
+
+PS: go-redis v7 不支持RESP3, 无法使用 CLIENT TRACKING 的服务端辅助失效, 因此基于pub/sub实现;
+    只有通过NearCache执行的写操作才会广播失效消息, 直接写Redis的数据只能依赖本地TTL过期;
+*/
+
+const (
+	defaultNearCacheChannel = "__easier:nearcache:invalidate"
+	typeNone                = "none"
+)
+
+// NearCacheStats 本地缓存的统计数据
+type NearCacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	Entries       int     `json:"entries"`
+	Bytes         int64   `json:"bytes"` // 估算的内存占用
+	Evictions     int64   `json:"evictions"`
+	Invalidations int64   `json:"invalidations"` // 收到的失效消息数
+}
+
+// nearEntry 本地缓存的值
+// string: string; list/set/zset: []string; hash: map[string]string; none: nil
+type nearEntry struct {
+	typ string
+	val interface{}
+}
+
+// keyMeta 加载时在一次往返中读取的key元数据
+type keyMeta struct {
+	typ  string
+	pttl time.Duration // 剩余过期时间, -1为未设置过期时间, -2为key不存在
+	hlen int64         // hash的field数(含TTL元数据), 用于识别field级别的TTL; 未知时为-1
+}
+
+// keyMetaReader 由 Client、Cluster、Memory 实现, 其他Redis实现分多次读取
+type keyMetaReader interface {
+	keyMeta(ctx context.Context, key string) (*keyMeta, error)
+}
+
+// readKeyMeta 通过pipeline读取key的类型、PTTL及HLEN
+func readKeyMeta(cmd redis.Cmdable, key string) (*keyMeta, error) {
+	var (
+		typ  *redis.StatusCmd
+		pttl *redis.DurationCmd
+		hlen *redis.IntCmd
+	)
+	// 非hash时HLEN返回WRONGTYPE, 忽略pipeline的整体错误, 逐个检查
+	_, _ = cmd.Pipelined(func(pipe redis.Pipeliner) error {
+		typ = pipe.Type(key)
+		pttl = pipe.PTTL(key)
+		hlen = pipe.HLen(key)
+		return nil
+	})
+	meta := &keyMeta{hlen: -1}
+	var err error
+	if meta.typ, err = typ.Result(); err != nil {
+		return nil, err
+	}
+	if meta.pttl, err = pttl.Result(); err != nil {
+		return nil, err
+	}
+	if meta.typ == "hash" {
+		if meta.hlen, err = hlen.Result(); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// invalidation 失效消息
+type invalidation struct {
+	Source string   `json:"src"`
+	Keys   []string `json:"keys,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+// NearCache 带进程内缓存的Redis, 未覆盖的方法直接透传给底层Redis
+type NearCache struct {
+	Redis
+	logg    logger.Logger
+	local   *lruCache
+	id      string // 实例ID, 用于忽略自己发出的失效消息
+	channel string
+	ttl     time.Duration
+
+	hits          int64
+	misses        int64
+	invalidations int64
+	generation    int64 // 每次失效自增, 防止未命中时读取的旧值覆盖并发的失效
+}
+
+var _ Redis = (*NearCache)(nil)
+
+type NearCacheOptionFunc func(*NearCache)
+
+// NearCacheWithTTL 本地缓存的过期时间, 默认1分钟
+func NearCacheWithTTL(ttl time.Duration) NearCacheOptionFunc {
+	return func(nc *NearCache) {
+		nc.ttl = ttl
+	}
+}
+
+// NearCacheWithMaxEntries 本地缓存的最大条目数, 默认10000
+func NearCacheWithMaxEntries(n int) NearCacheOptionFunc {
+	return func(nc *NearCache) {
+		nc.local.maxEntries = n
+	}
+}
+
+// NearCacheWithMaxBytes 本地缓存估算的最大内存占用, 默认64MB
+func NearCacheWithMaxBytes(n int64) NearCacheOptionFunc {
+	return func(nc *NearCache) {
+		nc.local.maxBytes = n
+	}
+}
+
+// NearCacheWithChannel 广播失效消息的channel, 共享缓存的实例需使用相同的channel
+func NearCacheWithChannel(channel string) NearCacheOptionFunc {
+	return func(nc *NearCache) {
+		nc.channel = channel
+	}
+}
+
+// NearCacheWithLogger 自定义logger
+func NearCacheWithLogger(logg logger.Logger) NearCacheOptionFunc {
+	return func(nc *NearCache) {
+		nc.logg = logg
+	}
+}
+
+// NewNearCache 在rds前增加一层本地缓存, ctx结束时停止接收失效消息
+func NewNearCache(ctx context.Context, rds Redis, ops ...NearCacheOptionFunc) (*NearCache, error) {
+	nc := &NearCache{
+		Redis:   rds,
+		logg:    logger.DefaultLogger(),
+		local:   newLRUCache(10000, 64<<20),
+		id:      utils.GenerateRandomKey(8),
+		channel: defaultNearCacheChannel,
+		ttl:     time.Minute,
+	}
+	for _, op := range ops {
+		op(nc)
+	}
+	ch, err := rds.Subscribe(ctx, nc.channel)
+	if err != nil {
+		return nil, err
+	}
+	go nc.listen(ch)
+	return nc, nil
+}
+
+// Stats 获取本地缓存的统计数据
+func (nc *NearCache) Stats() NearCacheStats {
+	st := NearCacheStats{
+		Hits:          atomic.LoadInt64(&nc.hits),
+		Misses:        atomic.LoadInt64(&nc.misses),
+		Invalidations: atomic.LoadInt64(&nc.invalidations),
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	st.Entries, st.Bytes, st.Evictions = nc.local.Stats()
+	return st
+}
+
+// listen 处理其它实例广播的失效消息
+func (nc *NearCache) listen(ch <-chan *Message) {
+	for msg := range ch {
+		if msg.Gap {
+			nc.logg.Warn(logger.ErrorCache, "near cache invalidation may be lost, clear local cache", logger.MakeField("channel", nc.channel))
+			nc.drop(true)
+			continue
+		}
+		payload, _ := msg.Payload.(string)
+		var inv invalidation
+		if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+			nc.logg.Error(logger.ErrorCache, "invalid near cache message", logger.MakeField("payload", msg.Payload), logger.ErrorField(err))
+			continue
+		}
+		if inv.Source == nc.id {
+			continue
+		}
+		atomic.AddInt64(&nc.invalidations, 1)
+		nc.drop(inv.All, inv.Keys...)
+	}
+}
+
+func (nc *NearCache) drop(all bool, keys ...string) {
+	atomic.AddInt64(&nc.generation, 1)
+	if all {
+		nc.local.Clear()
+		return
+	}
+	nc.local.Del(keys...)
+}
+
+// invalidate 删除本地缓存并广播失效消息
+func (nc *NearCache) invalidate(ctx context.Context, all bool, keys ...string) {
+	nc.drop(all, keys...)
+	b, _ := json.Marshal(&invalidation{Source: nc.id, Keys: keys, All: all})
+	if err := nc.Redis.Publish(ctx, nc.channel, string(b)); err != nil {
+		nc.logg.Error(logger.ErrorCache, "publish near cache invalidation", logger.MakeField("keys", keys), logger.ErrorField(err))
+	}
+}
+
+// load 读取本地缓存, 未命中时从Redis加载; 返回nil表示该类型不缓存
+func (nc *NearCache) load(ctx context.Context, key string) (*nearEntry, error) {
+	if v, ok := nc.local.Get(key); ok {
+		atomic.AddInt64(&nc.hits, 1)
+		return v.(*nearEntry), nil
+	}
+	atomic.AddInt64(&nc.misses, 1)
+	gen := atomic.LoadInt64(&nc.generation)
+	meta, err := nc.keyMeta(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	ent := &nearEntry{typ: meta.typ}
+	switch meta.typ {
+	case typeNone:
+	case "string":
+		var s string
+		err = nc.Redis.GetMixed(ctx, key, &s)
+		ent.val = s
+	case "list":
+		ent.val, err = nc.Redis.RangeList(ctx, key, 0, -1)
+	case "set", "zset":
+		var ss []string
+		err = nc.Redis.GetMixed(ctx, key, &ss)
+		ent.val = ss
+	case "hash":
+		m := map[string]string{}
+		err = nc.Redis.GetMixed(ctx, key, &m)
+		ent.val = m
+	default:
+		return nil, nil
+	}
+	if err == redis.Nil { // 读取期间被删除
+		ent.typ, ent.val, err = typeNone, nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ttl, ok := nc.entryTTL(meta, ent); ok && atomic.LoadInt64(&nc.generation) == gen {
+		nc.local.Set(key, ent, entrySize(key, ent), ttl)
+	}
+	return ent, nil
+}
+
+// keyPrefix 底层Redis的key前缀
+func (nc *NearCache) keyPrefix() string {
+	return keyPrefixOf(nc.Redis)
+}
+
+// keyMeta 读取key的元数据, 底层Redis未实现 keyMetaReader 时分多次读取且无法识别hash的field级别TTL
+func (nc *NearCache) keyMeta(ctx context.Context, key string) (*keyMeta, error) {
+	if r, ok := nc.Redis.(keyMetaReader); ok {
+		return r.keyMeta(ctx, key)
+	}
+	typ, err := nc.Redis.GetType(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	pttl, err := nc.Redis.GetExpire(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &keyMeta{typ: typ, pttl: pttl, hlen: -1}, nil
+}
+
+// entryTTL 本地缓存的过期时间, 不超过key的剩余过期时间; 返回false时不缓存
+func (nc *NearCache) entryTTL(meta *keyMeta, ent *nearEntry) (time.Duration, bool) {
+	if ent.typ == typeNone {
+		return nc.ttl, true
+	}
+	// hash中包含TTL元数据(或已过期的field)时field数不一致
+	if m := ent.hash(); m != nil && int64(len(m)) != meta.hlen {
+		return 0, false
+	}
+	switch {
+	case meta.pttl == -1:
+		return nc.ttl, true
+	case meta.pttl < time.Millisecond: // 已过期或即将过期
+		return 0, false
+	case nc.ttl <= 0 || meta.pttl < nc.ttl:
+		return meta.pttl, true
+	}
+	return nc.ttl, true
+}
+
+/********************************* 读接口 **************************************/
+
+// GetMixed 获取到key对应的value, 优先读取本地缓存
+func (nc *NearCache) GetMixed(ctx context.Context, key string, value interface{}) error {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ent == nil {
+		return nc.Redis.GetMixed(ctx, key, value)
+	}
+	switch v := ent.val.(type) {
+	case string:
+		return redis.NewStringResult(v, nil).Scan(value)
+	case []string:
+		return redis.NewStringSliceResult(v, nil).ScanSlice(value)
+	case map[string]string:
+		hb, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(hb, value)
+	}
+	return nil
+}
+
+// GetHashField 获取执行hash的指定field数据, 优先读取本地缓存
+func (nc *NearCache) GetHashField(ctx context.Context, key, field string) (string, error) {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	switch m := ent.hash(); {
+	case m != nil:
+		v, ok := m[field]
+		if !ok {
+			return "", redis.Nil
+		}
+		return v, nil
+	case ent != nil && ent.typ == typeNone:
+		return "", redis.Nil
+	}
+	return nc.Redis.GetHashField(ctx, key, field)
+}
+
+// GetHashStruct 读取hash并写入struct, 优先读取本地缓存
+func (nc *NearCache) GetHashStruct(ctx context.Context, key string, value interface{}, fields ...string) error {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return err
+	}
+	switch m := ent.hash(); {
+	case m != nil:
+		if len(fields) > 0 {
+			part := make(map[string]string, len(fields))
+			for _, f := range fields {
+				if v, ok := m[f]; ok {
+					part[f] = v
+				}
+			}
+			m = part
+		}
+		if len(m) == 0 {
+			return redis.Nil
+		}
+		return hashToStruct(m, value)
+	case ent != nil && ent.typ == typeNone:
+		return redis.Nil
+	}
+	return nc.Redis.GetHashStruct(ctx, key, value, fields...)
+}
+
+// RangeList 获取list中[start, stop]区间内的元素, 优先读取本地缓存
+func (nc *NearCache) RangeList(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ent != nil && ent.typ == typeNone {
+		return []string{}, nil
+	}
+	if ent == nil || ent.typ != "list" {
+		return nc.Redis.RangeList(ctx, key, start, stop)
+	}
+	l := ent.val.([]string)
+	lo, hi, ok := rangeIndex(int64(len(l)), start, stop)
+	if !ok {
+		return []string{}, nil
+	}
+	return append([]string(nil), l[lo:hi+1]...), nil
+}
+
+// LenList 获取指定列表长度, 优先读取本地缓存
+func (nc *NearCache) LenList(ctx context.Context, key string) (int64, error) {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if ent != nil && ent.typ == typeNone {
+		return 0, nil
+	}
+	if ent == nil || ent.typ != "list" {
+		return nc.Redis.LenList(ctx, key)
+	}
+	return int64(len(ent.val.([]string))), nil
+}
+
+// CheckSetMember 检查成员是否在集合内, 优先读取本地缓存
+func (nc *NearCache) CheckSetMember(ctx context.Context, key string, value interface{}) (bool, error) {
+	ent, err := nc.load(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if ent != nil && ent.typ == typeNone {
+		return false, nil
+	}
+	if ent == nil || ent.typ != "set" {
+		return nc.Redis.CheckSetMember(ctx, key, value)
+	}
+	member, err := toRedisString(value)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range ent.val.([]string) {
+		if m == member {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/********************************* 写接口 **************************************/
+
+// FlushDB 删除数据, 并清空所有实例的本地缓存
+func (nc *NearCache) FlushDB(ctx context.Context, isAll bool) error {
+	err := nc.Redis.FlushDB(ctx, isAll)
+	nc.invalidate(ctx, true)
+	return err
+}
+
+func (nc *NearCache) Del(ctx context.Context, key ...string) error {
+	err := nc.Redis.Del(ctx, key...)
+	nc.invalidate(ctx, false, key...)
+	return err
+}
+
+func (nc *NearCache) SetExpire(ctx context.Context, key string, ttl time.Duration) error {
+	err := nc.Redis.SetExpire(ctx, key, ttl)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+// DelByPattern 批量删除key, 并使所有本地缓存失效
+func (nc *NearCache) DelByPattern(ctx context.Context, match string, ops ...BulkOptionFunc) (int64, error) {
+	n, err := nc.Redis.DelByPattern(ctx, match, ops...)
+	if n > 0 {
+		nc.invalidate(ctx, true)
+	}
+	return n, err
+}
+
+// ExpireByPattern 批量设置过期时间, 并使所有本地缓存失效
+func (nc *NearCache) ExpireByPattern(ctx context.Context, match string, ttl time.Duration, ops ...BulkOptionFunc) (int64, error) {
+	n, err := nc.Redis.ExpireByPattern(ctx, match, ttl, ops...)
+	if n > 0 {
+		nc.invalidate(ctx, true)
+	}
+	return n, err
+}
+
+func (nc *NearCache) SetStr(ctx context.Context, key, value string) error {
+	err := nc.Redis.SetStr(ctx, key, value)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) SetStrTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	err := nc.Redis.SetStrTTL(ctx, key, value, ttl)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) SetNX(ctx context.Context, key, value string, ttl time.Duration) error {
+	err := nc.Redis.SetNX(ctx, key, value, ttl)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) SetHash(ctx context.Context, key string, value map[string]interface{}) error {
+	err := nc.Redis.SetHash(ctx, key, value)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) SetHashStruct(ctx context.Context, key string, value interface{}) error {
+	err := nc.Redis.SetHashStruct(ctx, key, value)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) SetHashFieldTTL(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	err := nc.Redis.SetHashFieldTTL(ctx, key, field, value, ttl)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) IncrByHash(ctx context.Context, key, field string, incr int64) (int64, error) {
+	v, err := nc.Redis.IncrByHash(ctx, key, field, incr)
+	nc.invalidate(ctx, false, key)
+	return v, err
+}
+
+func (nc *NearCache) IncrByFloatHash(ctx context.Context, key, field string, incr float64) (float64, error) {
+	v, err := nc.Redis.IncrByFloatHash(ctx, key, field, incr)
+	nc.invalidate(ctx, false, key)
+	return v, err
+}
+
+func (nc *NearCache) DelHashField(ctx context.Context, key string, fields ...string) error {
+	err := nc.Redis.DelHashField(ctx, key, fields...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) PurgeHash(ctx context.Context, key string) (int64, error) {
+	n, err := nc.Redis.PurgeHash(ctx, key)
+	if n > 0 {
+		nc.invalidate(ctx, false, key)
+	}
+	return n, err
+}
+
+func (nc *NearCache) PushList(ctx context.Context, key string, values ...interface{}) error {
+	err := nc.Redis.PushList(ctx, key, values...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) PopList(ctx context.Context, key string, value interface{}) error {
+	err := nc.Redis.PopList(ctx, key, value)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) PushListBy(ctx context.Context, key string, flag ListFlag, values ...interface{}) error {
+	err := nc.Redis.PushListBy(ctx, key, flag, values...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) PopListBy(ctx context.Context, key string, flag ListFlag, value interface{}) error {
+	err := nc.Redis.PopListBy(ctx, key, flag, value)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) BlockPopList(ctx context.Context, flag ListFlag, timeout time.Duration, keys ...string) (string, string, error) {
+	key, value, err := nc.Redis.BlockPopList(ctx, flag, timeout, keys...)
+	if err == nil {
+		nc.invalidate(ctx, false, key)
+	}
+	return key, value, err
+}
+
+func (nc *NearCache) TrimList(ctx context.Context, key string, start, stop int64) error {
+	err := nc.Redis.TrimList(ctx, key, start, stop)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) AddSet(ctx context.Context, key string, values ...interface{}) error {
+	err := nc.Redis.AddSet(ctx, key, values...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) RemSetEle(ctx context.Context, key string, values ...interface{}) error {
+	err := nc.Redis.RemSetEle(ctx, key, values...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) UnionStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	n, err := nc.Redis.UnionStoreSet(ctx, dest, keys...)
+	nc.invalidate(ctx, false, dest)
+	return n, err
+}
+
+func (nc *NearCache) InterStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	n, err := nc.Redis.InterStoreSet(ctx, dest, keys...)
+	nc.invalidate(ctx, false, dest)
+	return n, err
+}
+
+func (nc *NearCache) DiffStoreSet(ctx context.Context, dest string, keys ...string) (int64, error) {
+	n, err := nc.Redis.DiffStoreSet(ctx, dest, keys...)
+	nc.invalidate(ctx, false, dest)
+	return n, err
+}
+
+func (nc *NearCache) AddZSet(ctx context.Context, key string, members ...*ZSetMember) error {
+	err := nc.Redis.AddZSet(ctx, key, members...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) RemMembersZSet(ctx context.Context, key string, members ...string) error {
+	err := nc.Redis.RemMembersZSet(ctx, key, members...)
+	nc.invalidate(ctx, false, key)
+	return err
+}
+
+func (nc *NearCache) IncrByZSet(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	v, err := nc.Redis.IncrByZSet(ctx, key, increment, member)
+	nc.invalidate(ctx, false, key)
+	return v, err
+}
+
+// Eval 执行lua脚本, 并使keys的本地缓存失效
+func (nc *NearCache) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	v, err := nc.Redis.Eval(ctx, script, keys, args...)
+	if len(keys) > 0 {
+		nc.invalidate(ctx, false, keys...)
+	}
+	return v, err
+}
+
+// EvalSha 执行已缓存的lua脚本, 并使keys的本地缓存失效
+func (nc *NearCache) EvalSha(ctx context.Context, sha string, keys []string, args ...interface{}) (interface{}, error) {
+	v, err := nc.Redis.EvalSha(ctx, sha, keys, args...)
+	if len(keys) > 0 {
+		nc.invalidate(ctx, false, keys...)
+	}
+	return v, err
+}
+
+func (nc *NearCache) AddHLL(ctx context.Context, key string, elements ...interface{}) (bool, error) {
+	ok, err := nc.Redis.AddHLL(ctx, key, elements...)
+	nc.invalidate(ctx, false, key)
+	return ok, err
+}
+
+func (nc *NearCache) MergeHLL(ctx context.Context, dest string, keys ...string) error {
+	err := nc.Redis.MergeHLL(ctx, dest, keys...)
+	nc.invalidate(ctx, false, dest)
+	return err
+}
+
+func (nc *NearCache) SetBit(ctx context.Context, key string, offset int64, value int) (int64, error) {
+	v, err := nc.Redis.SetBit(ctx, key, offset, value)
+	nc.invalidate(ctx, false, key)
+	return v, err
+}
+
+func (nc *NearCache) OpBit(ctx context.Context, op BitOp, dest string, keys ...string) (int64, error) {
+	n, err := nc.Redis.OpBit(ctx, op, dest, keys...)
+	nc.invalidate(ctx, false, dest)
+	return n, err
+}
+
+func (nc *NearCache) BitField(ctx context.Context, key string, args ...interface{}) ([]int64, error) {
+	v, err := nc.Redis.BitField(ctx, key, args...)
+	nc.invalidate(ctx, false, key)
+	return v, err
+}
+
+func (nc *NearCache) AddGeo(ctx context.Context, key string, locations ...*GeoLocation) (int64, error) {
+	n, err := nc.Redis.AddGeo(ctx, key, locations...)
+	nc.invalidate(ctx, false, key)
+	return n, err
+}
+
+/********************************* 工具 **************************************/
+
+func (ent *nearEntry) hash() map[string]string {
+	if ent == nil {
+		return nil
+	}
+	m, _ := ent.val.(map[string]string)
+	return m
+}
+
+// entrySize 估算缓存条目的内存占用
+func entrySize(key string, ent *nearEntry) int64 {
+	size := int64(len(key) + 64)
+	switch v := ent.val.(type) {
+	case string:
+		size += int64(len(v))
+	case []string:
+		for _, s := range v {
+			size += int64(len(s) + 16)
+		}
+	case map[string]string:
+		for k, s := range v {
+			size += int64(len(k) + len(s) + 32)
+		}
+	}
+	return size
+}
+
+// rangeIndex 按redis的规则将[start, stop](支持负数下标)转换为有效的下标区间
+func rangeIndex(n, start, stop int64) (int64, int64, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// toRedisString 按go-redis写入参数的规则将value转换为redis中存储的字符串
+func toRedisString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		return string(b), err
+	}
+	return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", value)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearCacheTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMemory(ctx)
+	nc, err := NewNearCache(ctx, m, NearCacheWithTTL(time.Minute))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// 本地缓存不超过key的剩余过期时间
+	assert.NoError(t, nc.SetStrTTL(ctx, "s", "v", 50*time.Millisecond))
+	var s string
+	assert.NoError(t, nc.GetMixed(ctx, "s", &s))
+	assert.Equal(t, "v", s)
+	assert.Equal(t, 1, nc.Stats().Entries)
+	time.Sleep(60 * time.Millisecond)
+	s = ""
+	assert.NoError(t, nc.GetMixed(ctx, "s", &s))
+	assert.Equal(t, "", s)
+
+	// 包含field级别TTL的hash不缓存
+	assert.NoError(t, nc.SetHash(ctx, "h", map[string]interface{}{"a": 1}))
+	assert.NoError(t, m.SetHashFieldTTL(ctx, "h", "b", 2, 30*time.Millisecond))
+	v, err := nc.GetHashField(ctx, "h", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", v)
+	before := nc.Stats().Entries
+	time.Sleep(40 * time.Millisecond)
+	_, err = nc.GetHashField(ctx, "h", "b")
+	assert.Error(t, err)
+	assert.Equal(t, before, nc.Stats().Entries)
+
+	// 未设置过期时间的hash正常缓存
+	assert.NoError(t, nc.SetHash(ctx, "h2", map[string]interface{}{"a": 1}))
+	v, _ = nc.GetHashField(ctx, "h2", "a")
+	assert.Equal(t, "1", v)
+	assert.Equal(t, before+1, nc.Stats().Entries)
+}
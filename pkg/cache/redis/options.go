@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+连接配置:
+1. 单机(With*)、集群(ClusterWith*)、哨兵(SentinelWith*)模式分别提供连接池、超时、重试、TLS以及key前缀的OptionFunc;
+2. key前缀: 所有命令操作的key自动加上前缀, ScanKey/BlockPopList 等返回的key会去掉前缀;
+   channel(发布订阅)不加前缀; 集群模式下前缀会参与slot计算, 可使用 {hashtag} 形式的前缀将所有key放在同一个slot;
+*/
+
+// optionExtras go-redis的Options之外的配置
+type optionExtras struct {
+	hooks     []Hook
+	keyPrefix string
+}
+
+// clientOptions 单机模式的配置, 在 NewClient 中实例化并交给 OptionFunc 修改
+type clientOptions struct {
+	*redis.Options
+	optionExtras
+}
+
+// clusterOptions 集群模式的配置, 在 NewCluster 中实例化并交给 OptionFuncForCluster 修改
+type clusterOptions struct {
+	*redis.ClusterOptions
+	optionExtras
+}
+
+// sentinelOptions 哨兵模式的配置, 在 NewSentinel 中实例化并交给 OptionFuncForSentinel 修改
+type sentinelOptions struct {
+	*redis.FailoverOptions
+	optionExtras
+}
+
+// redisHooks 需注册到go-redis客户端的hook, key前缀先于命令钩子执行, 命令钩子看到的是实际的key
+func (e *optionExtras) redisHooks() []redis.Hook {
+	var hooks []redis.Hook
+	if e.keyPrefix != "" {
+		hooks = append(hooks, &keyPrefixHook{prefix: e.keyPrefix})
+	}
+	if len(e.hooks) > 0 {
+		hooks = append(hooks, &hookAdapter{hooks: e.hooks})
+	}
+	return hooks
+}
+
+/********************************* 单机模式 **************************************/
+
+// WithPool 配置连接池大小与最小空闲连接数
+func WithPool(size, minIdle int) OptionFunc {
+	return func(o *clientOptions) {
+		o.PoolSize = size
+		o.MinIdleConns = minIdle
+	}
+}
+
+// WithTimeout 配置建立连接、读、写的超时时间, 为0时使用默认值
+func WithTimeout(dial, read, write time.Duration) OptionFunc {
+	return func(o *clientOptions) {
+		o.DialTimeout = dial
+		o.ReadTimeout = read
+		o.WriteTimeout = write
+	}
+}
+
+// WithRetry 配置最大重试次数与重试的退避时间区间, maxRetries为-1时不重试
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) OptionFunc {
+	return func(o *clientOptions) {
+		o.MaxRetries = maxRetries
+		o.MinRetryBackoff = minBackoff
+		o.MaxRetryBackoff = maxBackoff
+	}
+}
+
+// WithTLS 配置TLS
+func WithTLS(conf *tls.Config) OptionFunc {
+	return func(o *clientOptions) {
+		o.TLSConfig = conf
+	}
+}
+
+// WithKeyPrefix 配置key前缀
+func WithKeyPrefix(prefix string) OptionFunc {
+	return func(o *clientOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+/********************************* 集群模式 **************************************/
+
+// ClusterWithPool 配置每个节点的连接池大小与最小空闲连接数
+func ClusterWithPool(size, minIdle int) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.PoolSize = size
+		o.MinIdleConns = minIdle
+	}
+}
+
+// ClusterWithTimeout 配置建立连接、读、写的超时时间, 为0时使用默认值
+func ClusterWithTimeout(dial, read, write time.Duration) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.DialTimeout = dial
+		o.ReadTimeout = read
+		o.WriteTimeout = write
+	}
+}
+
+// ClusterWithRetry 配置最大重试次数与重试的退避时间区间, maxRetries为-1时不重试
+func ClusterWithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.MaxRetries = maxRetries
+		o.MinRetryBackoff = minBackoff
+		o.MaxRetryBackoff = maxBackoff
+	}
+}
+
+// ClusterWithTLS 配置TLS
+func ClusterWithTLS(conf *tls.Config) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.TLSConfig = conf
+	}
+}
+
+// ClusterWithKeyPrefix 配置key前缀
+func ClusterWithKeyPrefix(prefix string) OptionFuncForCluster {
+	return func(o *clusterOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+/********************************* 哨兵模式 **************************************/
+
+// SentinelWithPool 配置连接池大小与最小空闲连接数
+func SentinelWithPool(size, minIdle int) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.PoolSize = size
+		o.MinIdleConns = minIdle
+	}
+}
+
+// SentinelWithTimeout 配置建立连接、读、写的超时时间, 为0时使用默认值
+func SentinelWithTimeout(dial, read, write time.Duration) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.DialTimeout = dial
+		o.ReadTimeout = read
+		o.WriteTimeout = write
+	}
+}
+
+// SentinelWithRetry 配置最大重试次数与重试的退避时间区间, maxRetries为-1时不重试
+func SentinelWithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.MaxRetries = maxRetries
+		o.MinRetryBackoff = minBackoff
+		o.MaxRetryBackoff = maxBackoff
+	}
+}
+
+// SentinelWithTLS 配置TLS
+func SentinelWithTLS(conf *tls.Config) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.TLSConfig = conf
+	}
+}
+
+// SentinelWithKeyPrefix 配置key前缀
+func SentinelWithKeyPrefix(prefix string) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// SentinelWithHooks 注册命令钩子
+func SentinelWithHooks(hooks ...Hook) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+/********************************* key前缀 **************************************/
+
+type rawKeysKey struct{}
+
+// withRawKeys 标记ctx中执行的命令使用的已是加上前缀的key(如SCAN返回的key), 不再添加前缀
+func withRawKeys(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawKeysKey{}, true)
+}
+
+// keyPrefixer 由 Client、Cluster 实现, 返回 keyPrefixHook 添加的key前缀
+type keyPrefixer interface {
+	keyPrefix() string
+}
+
+// keyPrefixOf rds配置的key前缀, 未配置时为空
+func keyPrefixOf(rds Redis) string {
+	if p, ok := rds.(keyPrefixer); ok {
+		return p.keyPrefix()
+	}
+	return ""
+}
+
+// keyPrefixHook 为命令操作的key加上前缀
+type keyPrefixHook struct {
+	prefix string
+}
+
+func (h *keyPrefixHook) rewrite(ctx context.Context, cmd redis.Cmder) {
+	if raw, _ := ctx.Value(rawKeysKey{}).(bool); raw {
+		return
+	}
+	args := cmd.Args()
+	for _, i := range keyIndexes(cmd.Name(), args) {
+		if s, ok := args[i].(string); ok {
+			args[i] = h.prefix + s
+		}
+	}
+}
+
+func (h *keyPrefixHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.rewrite(ctx, cmd)
+	return ctx, nil
+}
+
+func (h *keyPrefixHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *keyPrefixHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		h.rewrite(ctx, cmd)
+	}
+	return ctx, nil
+}
+
+func (h *keyPrefixHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// keyIndexes 命令参数中key的下标
+func keyIndexes(name string, args []interface{}) []int {
+	name = strings.ToLower(name)
+	switch name {
+	case "ping", "info", "flushdb", "flushall", "dbsize", "scan", "script", "publish", "spublish", "subscribe",
+		"psubscribe", "unsubscribe", "punsubscribe", "cluster", "client", "multi", "exec", "discard", "time",
+		"select", "auth", "hello", "echo", "quit", "command", "config", "slowlog", "readonly", "wait":
+		return nil
+	case "del", "unlink", "exists", "touch", "mget", "watch", "sunion", "sinter", "sdiff", "sunionstore",
+		"sinterstore", "sdiffstore", "pfcount", "pfmerge", "rename", "renamenx", "rpoplpush":
+		return keyRange(1, len(args), 1)
+	case "smove", "brpoplpush", "lmove", "blmove", "copy":
+		return keyRange(1, min(3, len(args)), 1)
+	case "blpop", "brpop", "bzpopmin", "bzpopmax": // 最后一个参数为timeout
+		return keyRange(1, len(args)-1, 1)
+	case "mset", "msetnx":
+		return keyRange(1, len(args), 2)
+	case "bitop": // bitop op dest key...
+		return keyRange(2, len(args), 1)
+	case "eval", "evalsha": // eval script numkeys key...
+		return keyRange(3, min(3+argInt(args, 2), len(args)), 1)
+	case "zunionstore", "zinterstore": // dest numkeys key...
+		return append([]int{1}, keyRange(3, min(3+argInt(args, 2), len(args)), 1)...)
+	case "xread", "xreadgroup": // ... streams key... id...
+		for i, arg := range args {
+			if s, ok := arg.(string); ok && strings.EqualFold(s, "streams") {
+				n := (len(args) - i - 1) / 2
+				return keyRange(i+1, i+1+n, 1)
+			}
+		}
+		return nil
+	case "object", "memory", "xgroup", "xinfo": // object encoding key, xgroup create key group id
+		return keyRange(2, min(3, len(args)), 1)
+	}
+	return keyRange(1, min(2, len(args)), 1)
+}
+
+func keyRange(start, end, step int) []int {
+	var idx []int
+	for i := start; i < end; i += step {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+func argInt(args []interface{}, i int) int {
+	if i >= len(args) {
+		return 0
+	}
+	switch v := args[i].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
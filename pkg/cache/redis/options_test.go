@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyIndexes(t *testing.T) {
+	cases := []struct {
+		args []interface{}
+		idx  []int
+	}{
+		{[]interface{}{"get", "k"}, []int{1}},
+		{[]interface{}{"ping"}, nil},
+		{[]interface{}{"del", "a", "b"}, []int{1, 2}},
+		{[]interface{}{"mset", "a", 1, "b", 2}, []int{1, 3}},
+		{[]interface{}{"blpop", "a", "b", 0}, []int{1, 2}},
+		{[]interface{}{"evalsha", "sha", 2, "a", "b", "arg"}, []int{3, 4}},
+		{[]interface{}{"zunionstore", "d", 2, "a", "b"}, []int{1, 3, 4}},
+		{[]interface{}{"xreadgroup", "group", "g", "c", "streams", "a", "b", ">", ">"}, []int{5, 6}},
+		{[]interface{}{"bitop", "and", "d", "a"}, []int{2, 3}},
+		{[]interface{}{"xgroup", "create", "s", "g", "$", "mkstream"}, []int{2}},
+		{[]interface{}{"xinfo", "consumers", "s", "g"}, []int{2}},
+		{[]interface{}{"xinfo", "help"}, nil},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.idx, keyIndexes(c.args[0].(string), c.args), "%v", c.args)
+	}
+}
+
+func TestKeyPrefixHook(t *testing.T) {
+	opt := &clientOptions{Options: &redis.Options{}}
+	WithKeyPrefix("app:")(opt)
+	hooks := opt.redisHooks()
+	assert.Len(t, hooks, 1)
+
+	cmd := redis.NewIntCmd("del", "a", "b")
+	_, _ = hooks[0].BeforeProcess(context.Background(), cmd)
+	assert.Equal(t, []interface{}{"del", "app:a", "app:b"}, cmd.Args())
+	raw := redis.NewIntCmd("unlink", "app:a")
+	_, _ = hooks[0].BeforeProcess(withRawKeys(context.Background()), raw)
+	assert.Equal(t, []interface{}{"unlink", "app:a"}, raw.Args())
+	xgroup := redis.NewStatusCmd("xgroup", "create", "s", "g", "$", "mkstream")
+	_, _ = hooks[0].BeforeProcess(context.Background(), xgroup)
+	assert.Equal(t, []interface{}{"xgroup", "create", "app:s", "g", "$", "mkstream"}, xgroup.Args())
+
+	assert.Equal(t, "app:user:*", prefixMatch("app:", "user:*"))
+	assert.Equal(t, `\[app\]:*`, prefixMatch("[app]:", ""))
+	assert.Equal(t, "user:*", prefixMatch("", "user:*"))
+}
+
+func TestRedisConfValidate(t *testing.T) {
+	assert.NoError(t, (&RedisConf{Endpoints: []string{"127.0.0.1:6379"}, Db: 32}).Validate())
+	assert.NoError(t, (&RedisConf{DeployMode: MemoryMod}).Validate())
+
+	err := (&RedisConf{
+		DeployMode:      SentinelMod,
+		Db:              -1,
+		PoolSize:        2,
+		MinIdleConns:    3,
+		ReadTimeout:     -time.Second,
+		MinRetryBackoff: time.Second,
+		MaxRetryBackoff: time.Millisecond,
+		TLS:             &RedisTLSConf{CertFile: "client.crt"},
+	}).Validate()
+	assert.Error(t, err)
+	for _, msg := range []string{
+		"endpoints is required",
+		"db must be >= 0",
+		"masterName is required",
+		"minIdleConns(3) must be <= poolSize(2)",
+		"readTimeout must be >= 0 or -1",
+		"minRetryBackoff(1s) must be <= maxRetryBackoff(1ms)",
+		"tls.certFile and tls.keyFile must be set together",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+	err = (&RedisConf{DeployMode: ClusterMod, Endpoints: []string{"a:1"}, Db: 1}).Validate()
+	assert.ErrorContains(t, err, "cluster mode only supports db 0")
+	_, err = InitRedisClient(context.Background(), &RedisConf{DeployMode: "ring", Endpoints: []string{"a:1"}})
+	assert.ErrorContains(t, err, `unsupported deployMode "ring"`)
+}
+
+func TestNewClientOptions(t *testing.T) {
+	rds, err := NewClient(context.Background(), "127.0.0.1:6379", 20,
+		WithPool(20, 5),
+		WithTimeout(time.Second, 2*time.Second, 3*time.Second),
+		WithRetry(5, 10*time.Millisecond, time.Second),
+		WithKeyPrefix("app:"),
+	)
+	assert.NoError(t, err)
+	c := rds.(*Client)
+	opt := c.client.Options()
+	assert.Equal(t, 20, opt.DB)
+	assert.Equal(t, 20, opt.PoolSize)
+	assert.Equal(t, 5, opt.MinIdleConns)
+	assert.Equal(t, 2*time.Second, opt.ReadTimeout)
+	assert.Equal(t, 5, opt.MaxRetries)
+	assert.Equal(t, "app:", c.prefix)
+	_, err = NewClient(context.Background(), "127.0.0.1:6379", -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+发布订阅:
+1. PubSub 支持在一个连接上动态地订阅/退订channel(SUBSCRIBE)与pattern(PSUBSCRIBE);
+2. 连接断开后由go-redis自动重连并重新订阅, 重新订阅成功时会向订阅方发送一条 Gap 为true的消息,
+   表示断开期间的消息可能已丢失, 订阅方可据此进行补偿(例如: 重新加载数据);
+3. Subscribe/PSubscribe 等待服务端确认订阅后返回, 返回后发布的消息不会丢失;
+4. ctx结束或调用Close后, 释放连接并关闭消息通道;
+*/
+
+const (
+	pubSubChanSize       = 100
+	pubSubConfirmTimeout = 5 * time.Second // 等待订阅确认的超时时间
+)
+
+var ErrSubscribeTimeout = errors.New("redis: subscribe confirmation timeout")
+
+// pubSubConn 订阅连接, 由 *redis.PubSub 或内存实现提供
+type pubSubConn interface {
+	Subscribe(channels ...string) error
+	PSubscribe(patterns ...string) error
+	Unsubscribe(channels ...string) error
+	PUnsubscribe(patterns ...string) error
+	Close() error
+	// ChannelWithSubscriptions 返回 *redis.Subscription 与 *redis.Message
+	ChannelWithSubscriptions(size int) <-chan interface{}
+}
+
+// PubSub 一个可动态订阅/退订的发布订阅连接
+type PubSub struct {
+	pubsub pubSubConn
+	logg   logger.Logger
+	out    chan *Message
+
+	mu      sync.Mutex
+	active  map[string]struct{}      // 已确认订阅的channel/pattern, 用于识别重连后的重新订阅
+	waiters map[string]chan struct{} // 等待订阅确认的channel/pattern
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPubSub(ctx context.Context, ps pubSubConn, logg logger.Logger) *PubSub {
+	p := &PubSub{
+		pubsub:  ps,
+		logg:    logg,
+		out:     make(chan *Message, pubSubChanSize),
+		active:  make(map[string]struct{}),
+		waiters: make(map[string]chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// Channel 接收消息的通道, PubSub关闭后通道关闭
+func (p *PubSub) Channel() <-chan *Message {
+	return p.out
+}
+
+// Subscribe 订阅channel, 等待服务端确认后返回
+func (p *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return p.subscribe(ctx, "subscribe", channels, p.pubsub.Subscribe)
+}
+
+// PSubscribe 按pattern订阅, 例如: "news.*", 等待服务端确认后返回
+func (p *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	return p.subscribe(ctx, "psubscribe", patterns, p.pubsub.PSubscribe)
+}
+
+func (p *PubSub) subscribe(ctx context.Context, kind string, names []string, do func(...string) error) error {
+	waits := p.forget(kind, names)
+	if err := do(names...); err != nil {
+		return err
+	}
+	timer := time.NewTimer(pubSubConfirmTimeout)
+	defer timer.Stop()
+	for _, wait := range waits {
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return errors.New("redis: pubsub is closed")
+		case <-timer.C:
+			return ErrSubscribeTimeout
+		}
+	}
+	return nil
+}
+
+// Unsubscribe 退订channel, 不指定channel时退订全部
+func (p *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return p.pubsub.Unsubscribe(channels...)
+}
+
+// PUnsubscribe 退订pattern, 不指定pattern时退订全部
+func (p *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return p.pubsub.PUnsubscribe(patterns...)
+}
+
+// Close 关闭订阅连接
+func (p *PubSub) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.done)
+		err = p.pubsub.Close()
+	})
+	return err
+}
+
+func (p *PubSub) run(ctx context.Context) {
+	defer close(p.out)
+	in := p.pubsub.ChannelWithSubscriptions(pubSubChanSize)
+	for {
+		select {
+		case <-ctx.Done():
+			_ = p.Close()
+			return
+		case <-p.done:
+			return
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+			switch msg := m.(type) {
+			case *redis.Subscription:
+				if gap := p.onSubscription(msg); gap != nil {
+					p.logg.Warn(logger.ErrorRedis, "redis pubsub resubscribed", logger.MakeField("channel", msg.Channel))
+					p.send(ctx, gap)
+				}
+			case *redis.Message:
+				p.send(ctx, &Message{Channel: msg.Channel, Pattern: msg.Pattern, Payload: msg.Payload})
+			}
+		}
+	}
+}
+
+// onSubscription 记录订阅状态, 已确认的订阅再次被确认时说明发生了重连, 返回Gap消息
+func (p *PubSub) onSubscription(sub *redis.Subscription) *Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch sub.Kind {
+	case "subscribe", "psubscribe":
+		key := sub.Kind + ":" + sub.Channel
+		if wait, ok := p.waiters[key]; ok {
+			close(wait)
+			delete(p.waiters, key)
+		}
+		if _, ok := p.active[key]; !ok {
+			p.active[key] = struct{}{}
+			return nil
+		}
+		if sub.Kind == "psubscribe" {
+			return &Message{Pattern: sub.Channel, Gap: true}
+		}
+		return &Message{Channel: sub.Channel, Gap: true}
+	case "unsubscribe":
+		delete(p.active, "subscribe:"+sub.Channel)
+	case "punsubscribe":
+		delete(p.active, "psubscribe:"+sub.Channel)
+	}
+	return nil
+}
+
+// forget 主动(重复)订阅前清除确认状态, 避免将订阅确认误判为重连; 返回等待订阅确认的channel
+func (p *PubSub) forget(kind string, names []string) []chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waits := make([]chan struct{}, 0, len(names))
+	for _, name := range names {
+		key := kind + ":" + name
+		delete(p.active, key)
+		wait, ok := p.waiters[key]
+		if !ok {
+			wait = make(chan struct{})
+			p.waiters[key] = wait
+		}
+		waits = append(waits, wait)
+	}
+	return waits
+}
+
+func (p *PubSub) send(ctx context.Context, msg *Message) {
+	select {
+	case p.out <- msg:
+	case <-ctx.Done():
+	case <-p.done:
+	}
+}
@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+)
+
+/*
+分片发布订阅(SSUBSCRIBE/SPUBLISH, redis >= 7.0):
+集群模式下, 分片channel的消息只在其slot所在的分片内传播, 避免普通PUBLISH在整个集群中广播;
+go-redis v7 的 PubSub 无法解析 smessage, 这里通过go-redis的Dialer建立连接(支持TLS及哨兵模式下定位master),
+基于RESP2协议为每个channel维护独立的订阅连接:
+1. 连接建立前根据channel的slot定位所在的master节点;
+2. 首次订阅同步进行, 连接、鉴权及订阅失败时直接返回错误;
+3. slot迁移时服务端会主动发送 sunsubscribe, 此时重新定位节点并订阅;
+4. 连接断开后按退避策略重连, 重新订阅成功时发送 Gap 为true的消息;
+*/
+
+const (
+	shardedPingInterval = 30 * time.Second
+	shardedMaxBackoff   = 3 * time.Second
+	clusterSlots        = 16384
+)
+
+var errShardMoved = errors.New("sharded channel moved")
+
+// shardedDialer 分片订阅的连接参数
+type shardedDialer struct {
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error) // go-redis的Dialer, 为空时直接建立TCP连接
+	username    string
+	password    string
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+}
+
+type shardedPubSub struct {
+	logg   logger.Logger
+	locate func(channel string) (string, error) // 定位channel所在的节点
+	dialer shardedDialer
+	out    chan *Message
+	wg     sync.WaitGroup
+}
+
+// newShardedPubSub 同步订阅所有channel, 任一channel订阅失败时关闭已建立的连接并返回错误
+func newShardedPubSub(ctx context.Context, logg logger.Logger, dialer shardedDialer,
+	locate func(string) (string, error), channels ...string) (*shardedPubSub, error) {
+	s := &shardedPubSub{
+		logg:   logg,
+		locate: locate,
+		dialer: dialer,
+		out:    make(chan *Message, pubSubChanSize),
+	}
+	conns := make([]*respConn, 0, len(channels))
+	for _, ch := range channels {
+		conn, err := s.subscribe(ctx, ch)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, fmt.Errorf("ssubscribe %s: %w", ch, err)
+		}
+		conns = append(conns, conn)
+	}
+	for i, ch := range channels {
+		s.wg.Add(1)
+		go s.serve(ctx, ch, conns[i])
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.out)
+	}()
+	return s, nil
+}
+
+// serve 维护单个channel的订阅, 直至ctx结束
+func (s *shardedPubSub) serve(ctx context.Context, channel string, conn *respConn) {
+	defer s.wg.Done()
+	for attempt := 0; ; attempt++ {
+		if conn != nil {
+			attempt = 0
+			err := s.receive(ctx, channel, conn)
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, errShardMoved) {
+				s.logg.Warn(logger.ErrorRedis, "redis sharded pubsub disconnected", logger.MakeField("channel", channel), logger.ErrorField(err))
+			}
+		}
+		backoff := time.Duration(attempt) * 100 * time.Millisecond
+		if backoff > shardedMaxBackoff {
+			backoff = shardedMaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		var err error
+		if conn, err = s.subscribe(ctx, channel); err != nil {
+			s.logg.Warn(logger.ErrorRedis, "redis sharded pubsub resubscribe", logger.MakeField("channel", channel), logger.ErrorField(err))
+			continue
+		}
+		s.logg.Warn(logger.ErrorRedis, "redis sharded pubsub resubscribed", logger.MakeField("channel", channel))
+		s.send(ctx, &Message{Channel: channel, Gap: true})
+	}
+}
+
+// subscribe 定位节点、建立连接并订阅, 等待服务端确认
+func (s *shardedPubSub) subscribe(ctx context.Context, channel string) (*respConn, error) {
+	addr, err := s.locate(channel)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.dialer.dialConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.write("ssubscribe", channel); err == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(pubSubConfirmTimeout))
+		var reply interface{}
+		if reply, err = conn.read(); err == nil {
+			if arr, ok := reply.([]interface{}); !ok || len(arr) < 2 || !strings.EqualFold(fmt.Sprint(arr[0]), "ssubscribe") {
+				err = fmt.Errorf("redis: unexpected ssubscribe reply %v", reply)
+			}
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return conn, nil
+}
+
+// receive 接收已订阅连接上的消息, 返回连接中断的原因
+func (s *shardedPubSub) receive(ctx context.Context, channel string, conn *respConn) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() { // ctx结束时关闭连接以中断阻塞的读取; 定期PING检测连接
+		ticker := time.NewTicker(shardedPingInterval)
+		defer ticker.Stop()
+		defer conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = conn.write("ping")
+			}
+		}
+	}()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * shardedPingInterval))
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) < 2 {
+			continue
+		}
+		kind, _ := arr[0].(string)
+		switch strings.ToLower(kind) {
+		case "sunsubscribe": // slot迁移
+			return errShardMoved
+		case "smessage":
+			if len(arr) < 3 {
+				continue
+			}
+			msg := &Message{}
+			msg.Channel, _ = arr[1].(string)
+			switch payload := arr[2].(type) {
+			case []interface{}:
+				for _, p := range payload {
+					if ps, ok := p.(string); ok {
+						msg.PayloadSlice = append(msg.PayloadSlice, ps)
+					}
+				}
+			default:
+				msg.Payload = payload
+			}
+			s.send(ctx, msg)
+		}
+	}
+}
+
+func (s *shardedPubSub) send(ctx context.Context, msg *Message) {
+	select {
+	case s.out <- msg:
+	case <-ctx.Done():
+	}
+}
+
+/*********************************** RESP ****************************************/
+
+// respConn 最小化的RESP2连接, 仅用于分片订阅
+type respConn struct {
+	net.Conn
+	rd *bufio.Reader
+	mu sync.Mutex
+}
+
+func (d shardedDialer) dialConn(ctx context.Context, addr string) (*respConn, error) {
+	dialer := &net.Dialer{Timeout: d.dialTimeout, KeepAlive: 5 * time.Minute}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if d.dial != nil {
+		conn, err = d.dial(ctx, "tcp", addr)
+	} else if d.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: d.tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{Conn: conn, rd: bufio.NewReader(conn)}
+	if d.password == "" {
+		return rc, nil
+	}
+	if d.username != "" {
+		err = rc.write("auth", d.username, d.password)
+	} else {
+		err = rc.write("auth", d.password)
+	}
+	if err == nil {
+		_ = rc.SetReadDeadline(time.Now().Add(pubSubConfirmTimeout))
+		_, err = rc.read()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("redis auth: %w", err)
+	}
+	return rc, nil
+}
+
+func (c *respConn) write(args ...string) error {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := io.WriteString(c.Conn, b.String())
+	return err
+}
+
+// read 读取一个RESP2回复: 简单字符串/批量字符串返回string, 整数返回int64, 数组返回[]interface{}
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: invalid reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply %q", line)
+}
+
+/*********************************** slot ****************************************/
+
+// keyHashSlot 计算key所在的slot, 支持 {hashtag}
+func keyHashSlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 CRC16-CCITT(XMODEM), redis cluster 使用的slot算法
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
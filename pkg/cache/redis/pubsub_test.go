@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), crc16("123456789"))
+	assert.Equal(t, 12182, keyHashSlot("foo"))
+	assert.Equal(t, keyHashSlot("user1000"), keyHashSlot("{user1000}.following"))
+	assert.Equal(t, keyHashSlot("{}.following"), keyHashSlot("{}.following"))
+	assert.NotEqual(t, keyHashSlot("a{}b"), keyHashSlot(""))
+}
+
+func TestRespRead(t *testing.T) {
+	raw := "*3\r\n$8\r\nsmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n" +
+		":42\r\n" +
+		"+OK\r\n" +
+		"-ERR wrong\r\n" +
+		"*2\r\n$3\r\nfoo\r\n*2\r\n$1\r\na\r\n$-1\r\n"
+	c := &respConn{rd: bufio.NewReader(strings.NewReader(raw))}
+
+	v, err := c.read()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"smessage", "ch", "hello"}, v)
+	v, err = c.read()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), v)
+	v, err = c.read()
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", v)
+	_, err = c.read()
+	assert.EqualError(t, err, "ERR wrong")
+	v, err = c.read()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"foo", []interface{}{"a", nil}}, v)
+}
+
+func TestPubSubGap(t *testing.T) {
+	p := &PubSub{active: make(map[string]struct{}), waiters: make(map[string]chan struct{})}
+	assert.Nil(t, p.onSubscription(&redis.Subscription{Kind: "subscribe", Channel: "news"}))
+	assert.Nil(t, p.onSubscription(&redis.Subscription{Kind: "psubscribe", Channel: "news.*"}))
+	// 重连后重新订阅
+	assert.Equal(t, &Message{Channel: "news", Gap: true}, p.onSubscription(&redis.Subscription{Kind: "subscribe", Channel: "news"}))
+	assert.Equal(t, &Message{Pattern: "news.*", Gap: true}, p.onSubscription(&redis.Subscription{Kind: "psubscribe", Channel: "news.*"}))
+	// 主动重复订阅与退订后重新订阅都不是Gap
+	p.forget("subscribe", []string{"news"})
+	assert.Nil(t, p.onSubscription(&redis.Subscription{Kind: "subscribe", Channel: "news"}))
+	assert.Nil(t, p.onSubscription(&redis.Subscription{Kind: "punsubscribe", Channel: "news.*"}))
+	assert.Nil(t, p.onSubscription(&redis.Subscription{Kind: "psubscribe", Channel: "news.*"}))
+}
+
+// fakeShardServer 回复ssubscribe的测试服务端, reply为订阅的回复
+func fakeShardServer(t *testing.T, reply string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rc := &respConn{Conn: conn, rd: bufio.NewReader(conn)}
+				if _, err := rc.read(); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(reply))
+				_, _ = rc.read() // 等待客户端关闭
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestShardedPubSub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logg := logger.DefaultLogger()
+	locate := func(addr string) func(string) (string, error) {
+		return func(string) (string, error) { return addr, nil }
+	}
+	dialer := shardedDialer{dialTimeout: time.Second}
+
+	// 订阅失败时同步返回错误
+	addr := fakeShardServer(t, "-ERR unknown command 'ssubscribe'\r\n")
+	_, err := newShardedPubSub(ctx, logg, dialer, locate(addr), "ch")
+	assert.EqualError(t, err, "ssubscribe ch: ERR unknown command 'ssubscribe'")
+	_, err = newShardedPubSub(ctx, logg, dialer, locate("127.0.0.1:1"), "ch")
+	assert.Error(t, err)
+
+	addr = fakeShardServer(t, "*3\r\n$10\r\nssubscribe\r\n$2\r\nch\r\n:1\r\n"+
+		"*3\r\n$8\r\nsmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n")
+	ps, err := newShardedPubSub(ctx, logg, dialer, locate(addr), "ch")
+	if !assert.NoError(t, err) {
+		return
+	}
+	msg := <-ps.out
+	assert.Equal(t, "ch", msg.Channel)
+	assert.Equal(t, "hello", msg.Payload)
+	cancel()
+	for range ps.out {
+	}
+}
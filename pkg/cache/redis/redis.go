@@ -2,7 +2,11 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"os"
 	"time"
 )
 
@@ -14,6 +18,10 @@ const (
 	EndPoint                       // 列表的尾部（右边）
 )
 
+func errListFlag(flag ListFlag) error {
+	return fmt.Errorf("unsupported list flag %d, should be StartPoint or EndPoint", flag)
+}
+
 // 声明支持的redis部署模式
 type DeployMode string
 
@@ -21,6 +29,7 @@ const (
 	ClientMod   DeployMode = "client"   // 单机模式
 	ClusterMod  DeployMode = "cluster"  // 集群模式
 	SentinelMod DeployMode = "sentinel" // 哨兵模式
+	MemoryMod   DeployMode = "memory"   // 内存模式, 用于单元测试
 )
 
 // ZSetMember 有序集合的数据结构
@@ -29,11 +38,58 @@ type ZSetMember struct { // nolint
 	Member interface{} `json:"member"`
 }
 
+// Message 订阅到的消息
 type Message struct {
 	Channel      string
-	Pattern      string
-	Payload      interface{}
-	PayloadSlice []string
+	Pattern      string      // 通过PSUBSCRIBE订阅时匹配的pattern
+	Payload      interface{} // 消息内容
+	PayloadSlice []string    // 消息内容为数组时的元素(Payload为空)
+	Gap          bool        // 连接断开后重新订阅成功, 断开期间的消息可能已丢失
+}
+
+// StreamMessage stream中的一条消息
+type StreamMessage struct {
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// StreamPending 消费者组中已投递但未确认(pending)的消息
+type StreamPending struct {
+	ID         string        `json:"id"`
+	Consumer   string        `json:"consumer"`
+	Idle       time.Duration `json:"idle"`
+	RetryCount int64         `json:"retryCount"` // 投递次数
+}
+
+// BitOp BITOP支持的位运算
+type BitOp string
+
+const (
+	BitAnd BitOp = "AND"
+	BitOr  BitOp = "OR"
+	BitXor BitOp = "XOR"
+	BitNot BitOp = "NOT" // 只支持一个源key
+)
+
+// GeoLocation 地理位置
+type GeoLocation struct {
+	Name      string  `json:"name"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Dist      float64 `json:"dist"` // 与查询中心的距离, 单位与查询条件一致
+}
+
+// GeoSearchQuery GEOSEARCH的查询条件
+type GeoSearchQuery struct {
+	Member    string  // 以成员的位置为中心, 为空时以 Longitude/Latitude 为中心
+	Longitude float64 // 中心经度
+	Latitude  float64 // 中心纬度
+	Radius    float64 // 按半径查询
+	Width     float64 // Radius为0时按矩形查询
+	Height    float64
+	Unit      string // m/km/ft/mi, 默认为m
+	Count     int64  // <=0 时不限制
+	Sort      string // ASC由近到远, DESC由远到近, 为空时不排序
 }
 
 type Redis interface {
@@ -43,7 +99,11 @@ type Redis interface {
 	SetExpire(ctx context.Context, key string, ttl time.Duration) error
 	GetExpire(ctx context.Context, key string) (time.Duration, error)
 	GetMixed(ctx context.Context, key string, value interface{}) error
+	GetType(ctx context.Context, key string) (string, error)
 	ScanKey(ctx context.Context, match string) chan string
+	ScanKeyByType(ctx context.Context, match, typ string) chan string
+	DelByPattern(ctx context.Context, match string, ops ...BulkOptionFunc) (int64, error)
+	ExpireByPattern(ctx context.Context, match string, ttl time.Duration, ops ...BulkOptionFunc) (int64, error)
 
 	SetStr(ctx context.Context, key, value string) error
 	SetStrTTL(ctx context.Context, key, value string, ttl time.Duration) error
@@ -51,50 +111,250 @@ type Redis interface {
 
 	SetHash(ctx context.Context, key string, value map[string]interface{}) error
 	GetHashField(ctx context.Context, key, field string) (string, error)
+	SetHashStruct(ctx context.Context, key string, value interface{}) error
+	GetHashStruct(ctx context.Context, key string, value interface{}, fields ...string) error
+	SetHashFieldTTL(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error
+	IncrByHash(ctx context.Context, key, field string, incr int64) (int64, error)
+	IncrByFloatHash(ctx context.Context, key, field string, incr float64) (float64, error)
+	DelHashField(ctx context.Context, key string, fields ...string) error
+	ScanHash(ctx context.Context, key, match string) chan *HashField
+	PurgeHash(ctx context.Context, key string) (int64, error)
 
 	PushList(ctx context.Context, key string, values ...interface{}) error
 	LenList(ctx context.Context, key string) (int64, error)
 	PopList(ctx context.Context, key string, value interface{}) error
+	PushListBy(ctx context.Context, key string, flag ListFlag, values ...interface{}) error
+	PopListBy(ctx context.Context, key string, flag ListFlag, value interface{}) error
+	BlockPopList(ctx context.Context, flag ListFlag, timeout time.Duration, keys ...string) (string, string, error)
+	RangeList(ctx context.Context, key string, start, stop int64) ([]string, error)
+	TrimList(ctx context.Context, key string, start, stop int64) error
 
 	AddSet(ctx context.Context, key string, values ...interface{}) error
 	CheckSetMember(ctx context.Context, key string, value interface{}) (bool, error)
 	RemSetEle(ctx context.Context, key string, values ...interface{}) error
+	UnionSet(ctx context.Context, keys ...string) ([]string, error)
+	InterSet(ctx context.Context, keys ...string) ([]string, error)
+	DiffSet(ctx context.Context, keys ...string) ([]string, error)
+	UnionStoreSet(ctx context.Context, dest string, keys ...string) (int64, error)
+	InterStoreSet(ctx context.Context, dest string, keys ...string) (int64, error)
+	DiffStoreSet(ctx context.Context, dest string, keys ...string) (int64, error)
 
 	AddZSet(ctx context.Context, key string, members ...*ZSetMember) error
 	CardZSet(ctx context.Context, key string) (int64, error)
 	MembersWithScoreZSet(ctx context.Context, key string) ([]*ZSetMember, error)
 	RemMembersZSet(ctx context.Context, key string, members ...string) error
+	RangeByScoreZSet(ctx context.Context, key, min, max string, offset, count int64, reverse bool) ([]*ZSetMember, error)
+	RangeWithScoreZSet(ctx context.Context, key string, start, stop int64, reverse bool) ([]*ZSetMember, error)
+	RankZSet(ctx context.Context, key, member string, reverse bool) (int64, error)
+	ScoreZSet(ctx context.Context, key, member string) (float64, error)
+	IncrByZSet(ctx context.Context, key string, increment float64, member string) (float64, error)
+
 	Publish(ctx context.Context, channel string, message interface{}) error
 	Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error)
+	PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error)
+	NewPubSub(ctx context.Context) *PubSub
+	SPublish(ctx context.Context, channel string, message interface{}) error
+	SSubscribe(ctx context.Context, channels ...string) (<-chan *Message, error)
+
+	AddStream(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+	CreateStreamGroup(ctx context.Context, stream, group, start string) error
+	ReadGroupStream(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error)
+	AckStream(ctx context.Context, stream, group string, ids ...string) error
+	PendingStream(ctx context.Context, stream, group, start, end string, count int64) ([]*StreamPending, error)
+	AutoClaimStream(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) (string, []*StreamMessage, error)
+
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+	EvalSha(ctx context.Context, sha string, keys []string, args ...interface{}) (interface{}, error)
+	ScriptLoad(ctx context.Context, script string) (string, error)
+	ScriptExists(ctx context.Context, shas ...string) ([]bool, error)
+
+	AddHLL(ctx context.Context, key string, elements ...interface{}) (bool, error)
+	CountHLL(ctx context.Context, keys ...string) (int64, error)
+	MergeHLL(ctx context.Context, dest string, keys ...string) error
+
+	SetBit(ctx context.Context, key string, offset int64, value int) (int64, error)
+	GetBit(ctx context.Context, key string, offset int64) (int64, error)
+	CountBit(ctx context.Context, key string, start, end int64) (int64, error)
+	OpBit(ctx context.Context, op BitOp, dest string, keys ...string) (int64, error)
+	BitField(ctx context.Context, key string, args ...interface{}) ([]int64, error)
+
+	AddGeo(ctx context.Context, key string, locations ...*GeoLocation) (int64, error)
+	SearchGeo(ctx context.Context, key string, query *GeoSearchQuery) ([]*GeoLocation, error)
+	DistGeo(ctx context.Context, key, member1, member2, unit string) (float64, error)
 }
 
 // RedisConf redis的连接配置
 // nolint
 type RedisConf struct {
 	DeployMode DeployMode `json:"deployMode"`
-	Endpoints  []string   `json:"endpoints"`
+	Endpoints  []string   `json:"endpoints"` // 单机模式只使用第一个地址; 哨兵模式为sentinel节点的地址
 	User       string     `json:"user"`
 	Password   string     `json:"passwd"`
 	Db         int        `json:"db"`
+
+	MasterName       string `json:"masterName"`     // 哨兵模式的master名
+	SentinelPassword string `json:"sentinelPasswd"` // 哨兵模式sentinel节点的密码
+
+	PoolSize        int           `json:"poolSize"`        // 连接池大小(集群模式为每个节点), 为0时使用默认值(每个CPU 10个连接)
+	MinIdleConns    int           `json:"minIdleConns"`    // 最小空闲连接数
+	DialTimeout     time.Duration `json:"dialTimeout"`     // 建立连接的超时时间, 为0时使用默认值(5s)
+	ReadTimeout     time.Duration `json:"readTimeout"`     // 读超时时间, 为0时使用默认值(3s), 为-1时不超时
+	WriteTimeout    time.Duration `json:"writeTimeout"`    // 写超时时间, 为0时与读超时时间相同, 为-1时不超时
+	MaxRetries      int           `json:"maxRetries"`      // 最大重试次数, 为-1时不重试
+	MinRetryBackoff time.Duration `json:"minRetryBackoff"` // 重试的最小退避时间, 为0时使用默认值(8ms), 为-1时不退避
+	MaxRetryBackoff time.Duration `json:"maxRetryBackoff"` // 重试的最大退避时间, 为0时使用默认值(512ms), 为-1时不退避
+
+	TLS       *RedisTLSConf `json:"tls"`       // 为空时不使用TLS
+	KeyPrefix string        `json:"keyPrefix"` // 所有key的前缀
+}
+
+// RedisTLSConf TLS配置
+type RedisTLSConf struct {
+	CAFile             string `json:"caFile"`   // 校验服务端证书的CA, 为空时使用系统CA
+	CertFile           string `json:"certFile"` // 客户端证书, 与KeyFile同时配置
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// Validate 校验配置, 返回所有不合法的配置项
+func (conf *RedisConf) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("redis conf: "+format, args...))
+	}
+	switch conf.DeployMode {
+	case MemoryMod:
+		return nil
+	case "", ClientMod, ClusterMod, SentinelMod:
+	default:
+		invalid("unsupported deployMode %q, should be one of client/cluster/sentinel/memory", conf.DeployMode)
+	}
+	if len(conf.Endpoints) == 0 {
+		invalid("endpoints is required")
+	}
+	for i, ep := range conf.Endpoints {
+		if ep == "" {
+			invalid("endpoints[%d] is empty", i)
+		}
+	}
+	if conf.Db < 0 {
+		invalid("db must be >= 0, currently as %d", conf.Db)
+	}
+	if conf.DeployMode == ClusterMod && conf.Db != 0 {
+		invalid("cluster mode only supports db 0, currently as %d", conf.Db)
+	}
+	if conf.DeployMode == SentinelMod && conf.MasterName == "" {
+		invalid("masterName is required in sentinel mode")
+	}
+	if conf.PoolSize < 0 {
+		invalid("poolSize must be >= 0, currently as %d", conf.PoolSize)
+	}
+	if conf.MinIdleConns < 0 {
+		invalid("minIdleConns must be >= 0, currently as %d", conf.MinIdleConns)
+	}
+	if conf.PoolSize > 0 && conf.MinIdleConns > conf.PoolSize {
+		invalid("minIdleConns(%d) must be <= poolSize(%d)", conf.MinIdleConns, conf.PoolSize)
+	}
+	if conf.DialTimeout < 0 {
+		invalid("dialTimeout must be >= 0, currently as %s", conf.DialTimeout)
+	}
+	for _, d := range []struct {
+		name string
+		val  time.Duration
+	}{
+		{"readTimeout", conf.ReadTimeout},
+		{"writeTimeout", conf.WriteTimeout},
+		{"minRetryBackoff", conf.MinRetryBackoff},
+		{"maxRetryBackoff", conf.MaxRetryBackoff},
+	} {
+		if d.val < -1 {
+			invalid("%s must be >= 0 or -1, currently as %s", d.name, d.val)
+		}
+	}
+	if conf.MaxRetries < -1 {
+		invalid("maxRetries must be >= 0 or -1, currently as %d", conf.MaxRetries)
+	}
+	if conf.MinRetryBackoff > 0 && conf.MaxRetryBackoff > 0 && conf.MinRetryBackoff > conf.MaxRetryBackoff {
+		invalid("minRetryBackoff(%s) must be <= maxRetryBackoff(%s)", conf.MinRetryBackoff, conf.MaxRetryBackoff)
+	}
+	if t := conf.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+		invalid("tls.certFile and tls.keyFile must be set together")
+	}
+	return errors.Join(errs...)
+}
+
+// tlsConfig 根据配置生成 tls.Config, 未配置TLS时返回nil
+func (conf *RedisConf) tlsConfig() (*tls.Config, error) {
+	t := conf.TLS
+	if t == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify, // nolint
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis conf: read tls.caFile: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis conf: no valid certificate in tls.caFile %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis conf: load tls.certFile/tls.keyFile: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
 
 // InitRedisClient 实例化redis连接对象
-func InitRedisClient(ctx context.Context, conf *RedisConf) (client Redis, err error) {
+func InitRedisClient(ctx context.Context, conf *RedisConf) (Redis, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if conf.DeployMode == MemoryMod { // 内存模式
+		return NewMemory(ctx), nil
+	}
+	tlsConf, err := conf.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	switch conf.DeployMode {
 	case SentinelMod: // 哨兵模式
-		return nil, errors.New("不支持'哨兵模式'")
+		return NewSentinel(ctx, conf.MasterName, conf.Endpoints, conf.Db,
+			SentinelWithAuth(conf.User, conf.Password),
+			SentinelWithSentinelPasswd(conf.SentinelPassword),
+			SentinelWithPool(conf.PoolSize, conf.MinIdleConns),
+			SentinelWithTimeout(conf.DialTimeout, conf.ReadTimeout, conf.WriteTimeout),
+			SentinelWithRetry(conf.MaxRetries, conf.MinRetryBackoff, conf.MaxRetryBackoff),
+			SentinelWithTLS(tlsConf),
+			SentinelWithKeyPrefix(conf.KeyPrefix),
+		)
 	case ClusterMod: // 集群模式
-		if client, err = NewCluster(ctx, conf.Endpoints,
+		return NewCluster(ctx, conf.Endpoints,
 			ClusterWithAuth(conf.User, conf.Password),
-		); err != nil {
-			return
-		}
+			ClusterWithPool(conf.PoolSize, conf.MinIdleConns),
+			ClusterWithTimeout(conf.DialTimeout, conf.ReadTimeout, conf.WriteTimeout),
+			ClusterWithRetry(conf.MaxRetries, conf.MinRetryBackoff, conf.MaxRetryBackoff),
+			ClusterWithTLS(tlsConf),
+			ClusterWithKeyPrefix(conf.KeyPrefix),
+		)
 	default: // 默认单机模式
-		if client, err = NewClient(ctx, conf.Endpoints[0], conf.Db,
+		return NewClient(ctx, conf.Endpoints[0], conf.Db,
 			WithAuth(conf.User, conf.Password),
-		); err != nil {
-			return
-		}
+			WithPool(conf.PoolSize, conf.MinIdleConns),
+			WithTimeout(conf.DialTimeout, conf.ReadTimeout, conf.WriteTimeout),
+			WithRetry(conf.MaxRetries, conf.MinRetryBackoff, conf.MaxRetryBackoff),
+			WithTLS(tlsConf),
+			WithKeyPrefix(conf.KeyPrefix),
+		)
 	}
-	return
 }
@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+扫描与批量操作:
+1. 单机模式扫描当前节点, 集群模式并发扫描所有master节点, 每个节点使用独立的游标;
+2. 支持按数据类型过滤(SCAN ... TYPE, redis >= 6.0);
+3. DelByPattern/ExpireByPattern 按批处理扫描到的key, 删除使用UNLINK在后台释放内存;
+   集群模式下按slot分组发送, 避免CROSSSLOT错误;
+4. 通过 BulkWithRate 限制每秒处理的key数, ctx结束时立即停止;
+*/
+
+const (
+	scanBatchSize    = 10
+	bulkDefaultBatch = 500
+	bulkDefaultRate  = 0
+)
+
+// nodeIterator 遍历需要扫描的节点: 单机模式为当前节点, 集群模式为所有master
+type nodeIterator func(fn func(node *redis.Client) error) error
+
+type bulkOptions struct {
+	batch  int64  // 每次SCAN的COUNT及每批处理的key数
+	rate   int    // 每秒最多处理的key数, <=0 时不限制
+	typ    string // 只处理该类型的key
+	dryRun bool   // 只统计匹配的key数, 不做修改
+}
+
+type BulkOptionFunc func(*bulkOptions)
+
+// BulkWithBatch 配置每批处理的key数, 默认500
+func BulkWithBatch(batch int64) BulkOptionFunc {
+	return func(o *bulkOptions) {
+		if batch > 0 {
+			o.batch = batch
+		}
+	}
+}
+
+// BulkWithRate 限制每秒最多处理的key数, 避免批量操作影响线上请求
+func BulkWithRate(keysPerSecond int) BulkOptionFunc {
+	return func(o *bulkOptions) {
+		o.rate = keysPerSecond
+	}
+}
+
+// BulkWithType 只处理该类型(string/list/set/zset/hash/stream)的key
+func BulkWithType(typ string) BulkOptionFunc {
+	return func(o *bulkOptions) {
+		o.typ = typ
+	}
+}
+
+// BulkWithDryRun 只统计匹配的key数, 不做修改
+func BulkWithDryRun() BulkOptionFunc {
+	return func(o *bulkOptions) {
+		o.dryRun = true
+	}
+}
+
+func newBulkOptions(ops []BulkOptionFunc) *bulkOptions {
+	o := &bulkOptions{batch: bulkDefaultBatch, rate: bulkDefaultRate}
+	for _, op := range ops {
+		op(o)
+	}
+	return o
+}
+
+// bulkLimiter 按每秒处理的key数限速, 并发安全
+type bulkLimiter struct {
+	mu   sync.Mutex
+	rate int
+	next time.Time
+}
+
+// wait 等待处理n个key的配额, ctx结束时返回错误
+func (l *bulkLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	l.mu.Unlock()
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// scanOnce 执行一次SCAN, typ不为空时按类型过滤
+func scanOnce(ctx context.Context, node *redis.Client, cursor uint64, match, typ string, count int64) ([]string, uint64, error) {
+	node = node.WithContext(ctx)
+	if typ == "" {
+		return node.Scan(cursor, match, count).Result()
+	}
+	args := []interface{}{"scan", cursor}
+	if match != "" {
+		args = append(args, "match", match)
+	}
+	args = append(args, "count", count, "type", typ)
+	res, err := node.Do(args...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseScan(res)
+}
+
+// parseScan 解析SCAN的返回: [cursor, [key...]]
+func parseScan(res interface{}) ([]string, uint64, error) {
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) != 2 {
+		return nil, 0, fmt.Errorf("unexpected scan reply: %v", res)
+	}
+	cs, _ := arr[0].(string)
+	cursor, err := strconv.ParseUint(cs, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected scan cursor: %v", arr[0])
+	}
+	items, _ := arr[1].([]interface{})
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if k, ok := item.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, cursor, nil
+}
+
+// scanNode 使用独立的游标扫描单个节点, 每批key返回后调用fn
+func scanNode(ctx context.Context, node *redis.Client, match, typ string, count int64, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, next, err := scanOnce(ctx, node, cursor, match, typ, count)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// prefixMatch 为match加上key前缀, 前缀中的通配符会被转义
+func prefixMatch(prefix, match string) string {
+	if prefix == "" {
+		return match
+	}
+	if match == "" {
+		match = "*"
+	}
+	var b strings.Builder
+	for _, c := range prefix {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String() + match
+}
+
+// scanKeys 扫描所有节点中与match匹配的key, 返回的key去掉了前缀; ctx结束或扫描完毕时关闭通道
+func scanKeys(ctx context.Context, nodes nodeIterator, logg logger.Logger, prefix, match, typ string) chan string {
+	out := make(chan string, scanBatchSize)
+	go func() {
+		defer close(out)
+		err := nodes(func(node *redis.Client) error {
+			return scanNode(ctx, node, prefixMatch(prefix, match), typ, scanBatchSize, func(keys []string) error {
+				for _, key := range keys {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case out <- strings.TrimPrefix(key, prefix):
+					}
+				}
+				return nil
+			})
+		})
+		if err != nil && ctx.Err() == nil {
+			logg.Error(logger.ErrorCache, "redis scan error", logger.MakeField("match", match), logger.ErrorField(err))
+		}
+	}()
+	return out
+}
+
+// bulkByPattern 扫描所有节点并按批执行action, 返回处理的key数
+// action收到的是SCAN返回的完整key(包含前缀), 执行时不再添加前缀
+func bulkByPattern(ctx context.Context, nodes nodeIterator, prefix, match string, ops []BulkOptionFunc,
+	action func(ctx context.Context, node *redis.Client, keys []string) (int64, error)) (int64, error) {
+	opt := newBulkOptions(ops)
+	limiter := &bulkLimiter{rate: opt.rate}
+	var total int64
+	err := nodes(func(node *redis.Client) error {
+		return scanNode(ctx, node, prefixMatch(prefix, match), opt.typ, opt.batch, func(keys []string) error {
+			if err := limiter.wait(ctx, len(keys)); err != nil {
+				return err
+			}
+			if opt.dryRun {
+				atomic.AddInt64(&total, int64(len(keys)))
+				return nil
+			}
+			n, err := action(withRawKeys(ctx), node, keys)
+			atomic.AddInt64(&total, n)
+			return err
+		})
+	})
+	return atomic.LoadInt64(&total), err
+}
+
+// unlinkKeys 按slot分组UNLINK, 返回删除的key数
+func unlinkKeys(ctx context.Context, node *redis.Client, keys []string) (int64, error) {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		slot := keyHashSlot(key)
+		groups[slot] = append(groups[slot], key)
+	}
+	var cmds []*redis.IntCmd
+	_, err := node.WithContext(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		for _, group := range groups {
+			cmds = append(cmds, pipe.Unlink(group...))
+		}
+		return nil
+	})
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, err
+}
+
+// expireKeys 为keys设置过期时间, 返回设置成功的key数
+func expireKeys(ctx context.Context, node *redis.Client, keys []string, ttl time.Duration) (int64, error) {
+	cmds := make([]*redis.BoolCmd, 0, len(keys))
+	_, err := node.WithContext(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.Expire(key, ttl))
+		}
+		return nil
+	})
+	var n int64
+	for _, cmd := range cmds {
+		if cmd.Val() {
+			n++
+		}
+	}
+	return n, err
+}
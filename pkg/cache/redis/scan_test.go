@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScan(t *testing.T) {
+	keys, cursor, err := parseScan([]interface{}{"17", []interface{}{"a", "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(17), cursor)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	_, _, err = parseScan([]interface{}{"x"})
+	assert.Error(t, err)
+}
+
+func TestBulkLimiter(t *testing.T) {
+	l := &bulkLimiter{rate: 100}
+	start := time.Now()
+	assert.NoError(t, l.wait(context.Background(), 1))
+	assert.NoError(t, l.wait(context.Background(), 5))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.wait(ctx, 100), context.Canceled)
+}
+
+func TestMemoryBulkByPattern(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	for _, k := range []string{"user:1", "user:2", "user:3"} {
+		_ = m.SetStr(ctx, k, "v")
+	}
+	_ = m.AddSet(ctx, "user:set", "a")
+	_ = m.SetStr(ctx, "order:1", "v")
+
+	var keys []string
+	for k := range m.ScanKeyByType(ctx, "user:*", "set") {
+		keys = append(keys, k)
+	}
+	assert.Equal(t, []string{"user:set"}, keys)
+
+	n, err := m.DelByPattern(ctx, "user:*", BulkWithDryRun())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.True(t, m.IsExist(ctx, "user:1"))
+
+	n, err = m.ExpireByPattern(ctx, "user:*", time.Minute, BulkWithType("string"), BulkWithBatch(2))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	ttl, _ := m.GetExpire(ctx, "user:1")
+	assert.Greater(t, ttl, time.Duration(0))
+
+	n, err = m.DelByPattern(ctx, "user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.False(t, m.IsExist(ctx, "user:set"))
+	assert.True(t, m.IsExist(ctx, "order:1"))
+}
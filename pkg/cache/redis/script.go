@@ -0,0 +1,386 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+lua脚本注册表:
+1. 通过 ScriptRegistry 注册具名脚本, Load 时使用 SCRIPT LOAD 缓存脚本(集群模式缓存到所有master);
+2. 执行时使用EVALSHA, 返回NOSCRIPT(redis重启、SCRIPT FLUSH、新增节点等)时回退到EVAL, EVAL同时会缓存脚本;
+3. 集群模式要求脚本访问的所有key位于同一个slot, 执行前校验keys, 可使用 {hashtag} 保证位于同一个slot;
+4. 可通过 RegisterFS 加载 embed.FS 中的 .lua 文件, 脚本名为去掉扩展名的文件名;
+5. ScriptResult 提供将脚本返回值解码为Go类型的方法;
+
+例:
+	//go:embed lua/*.lua
+	var scripts embed.FS
+
+	registry := NewScriptRegistry()
+	_ = registry.RegisterFS(scripts, "lua/*.lua")
+	_ = registry.Load(ctx, rds)
+	n, err := registry.Run(ctx, rds, "incr_limit", []string{"{user:1}:count"}, 10).Int64()
+*/
+
+var (
+	ErrScriptNotFound = errors.New("script not registered")
+	ErrCrossSlot      = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+)
+
+// scriptSha 计算脚本的sha1
+func scriptSha(src string) string {
+	h := sha1.Sum([]byte(src))
+	return hex.EncodeToString(h[:])
+}
+
+// isNoScript 是否为脚本未缓存的错误
+func isNoScript(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+// checkSlot 校验加上前缀后的keys是否位于同一个slot
+func checkSlot(prefix string, keys []string) error {
+	for i := 1; i < len(keys); i++ {
+		if keyHashSlot(prefix+keys[i]) != keyHashSlot(prefix+keys[0]) {
+			return ErrCrossSlot
+		}
+	}
+	return nil
+}
+
+// Script 具名的lua脚本
+type Script struct {
+	name      string
+	src       string
+	sha       string
+	slotCheck bool
+}
+
+// NewScript 实例化lua脚本, 执行前会校验keys位于同一个slot
+func NewScript(name, src string) *Script {
+	return &Script{name: name, src: src, sha: scriptSha(src), slotCheck: true}
+}
+
+// Name 脚本名
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Sha 脚本的sha1
+func (s *Script) Sha() string {
+	return s.sha
+}
+
+// Source 脚本内容
+func (s *Script) Source() string {
+	return s.src
+}
+
+// Load 使用 SCRIPT LOAD 缓存脚本
+func (s *Script) Load(ctx context.Context, rds Redis) error {
+	sha, err := rds.ScriptLoad(ctx, s.src)
+	if err != nil {
+		return fmt.Errorf("load script %s: %w", s.name, err)
+	}
+	if sha != s.sha {
+		return fmt.Errorf("load script %s: unexpected sha %s", s.name, sha)
+	}
+	return nil
+}
+
+// Run 使用EVALSHA执行脚本, 脚本未缓存时回退到EVAL
+func (s *Script) Run(ctx context.Context, rds Redis, keys []string, args ...interface{}) *ScriptResult {
+	if s.slotCheck {
+		prefix := ""
+		if raw, _ := ctx.Value(rawKeysKey{}).(bool); !raw {
+			prefix = keyPrefixOf(rds)
+		}
+		if err := checkSlot(prefix, keys); err != nil {
+			return &ScriptResult{err: err}
+		}
+	}
+	v, err := rds.EvalSha(ctx, s.sha, keys, args...)
+	if isNoScript(err) {
+		v, err = rds.Eval(ctx, s.src, keys, args...)
+	}
+	return &ScriptResult{val: v, err: err}
+}
+
+// ScriptRegistry 具名lua脚本的注册表, 并发安全
+type ScriptRegistry struct {
+	mu        sync.RWMutex
+	scripts   map[string]*Script
+	slotCheck bool
+}
+
+type ScriptRegistryOptionFunc func(*ScriptRegistry)
+
+// ScriptRegistryWithoutSlotCheck 不校验keys位于同一个slot, 只用于单机/哨兵模式
+func ScriptRegistryWithoutSlotCheck() ScriptRegistryOptionFunc {
+	return func(r *ScriptRegistry) {
+		r.slotCheck = false
+	}
+}
+
+// NewScriptRegistry 实例化脚本注册表
+func NewScriptRegistry(ops ...ScriptRegistryOptionFunc) *ScriptRegistry {
+	r := &ScriptRegistry{
+		scripts:   make(map[string]*Script),
+		slotCheck: true,
+	}
+	for _, op := range ops {
+		op(r)
+	}
+	return r
+}
+
+// Register 注册脚本, 同名脚本内容不同时返回错误
+func (r *ScriptRegistry) Register(name, src string) (*Script, error) {
+	s := NewScript(name, src)
+	s.slotCheck = r.slotCheck
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.scripts[name]; ok {
+		if old.sha != s.sha {
+			return nil, fmt.Errorf("script %s already registered with different source", name)
+		}
+		return old, nil
+	}
+	r.scripts[name] = s
+	return s, nil
+}
+
+// MustRegister 注册脚本, 出错时panic, 用于包级变量初始化
+func (r *ScriptRegistry) MustRegister(name, src string) *Script {
+	s, err := r.Register(name, src)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// RegisterFS 注册fsys中与patterns(默认为 *.lua)匹配的脚本文件, 脚本名为去掉扩展名的文件名
+func (r *ScriptRegistry) RegisterFS(fsys fs.FS, patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"*.lua"}
+	}
+	for _, pattern := range patterns {
+		files, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			b, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				return err
+			}
+			name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+			if _, err := r.Register(name, string(b)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get 获取脚本
+func (r *ScriptRegistry) Get(name string) (*Script, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.scripts[name]
+	return s, ok
+}
+
+// Names 已注册的脚本名
+func (r *ScriptRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.scripts))
+	for name := range r.scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load 缓存所有已注册的脚本
+func (r *ScriptRegistry) Load(ctx context.Context, rds Redis) error {
+	for _, name := range r.Names() {
+		s, _ := r.Get(name)
+		if err := s.Load(ctx, rds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run 执行已注册的脚本
+func (r *ScriptRegistry) Run(ctx context.Context, rds Redis, name string, keys []string, args ...interface{}) *ScriptResult {
+	s, ok := r.Get(name)
+	if !ok {
+		return &ScriptResult{err: fmt.Errorf("%w: %s", ErrScriptNotFound, name)}
+	}
+	return s.Run(ctx, rds, keys, args...)
+}
+
+// ScriptResult 脚本的返回值
+// lua与redis类型的转换: number => integer(小数被截断), string => bulk string, table => array,
+// true => 1, false/nil => nil(redis.Nil)
+type ScriptResult struct {
+	val interface{}
+	err error
+}
+
+// NewScriptResult 使用返回值实例化 ScriptResult, 用于自定义执行方式(如Pipeline)
+func NewScriptResult(val interface{}, err error) *ScriptResult {
+	return &ScriptResult{val: val, err: err}
+}
+
+// Result 原始的返回值
+func (r *ScriptResult) Result() (interface{}, error) {
+	return r.val, r.err
+}
+
+// Err 执行错误, 脚本返回nil时为 redis.Nil
+func (r *ScriptResult) Err() error {
+	return r.err
+}
+
+// Int64 解码为整数
+func (r *ScriptResult) Int64() (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return toScriptInt64(r.val)
+}
+
+// Float64 解码为浮点数, 脚本需返回数字的字符串形式(如 tostring(x))以保留小数
+func (r *ScriptResult) Float64() (float64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	switch v := r.val.(type) {
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unexpected script result type %T", r.val)
+}
+
+// Bool 解码为布尔值: 非0整数、"OK"及非空字符串为true, 脚本返回false/nil时为false
+func (r *ScriptResult) Bool() (bool, error) {
+	if r.err == redis.Nil {
+		return false, nil
+	}
+	if r.err != nil {
+		return false, r.err
+	}
+	switch v := r.val.(type) {
+	case int64:
+		return v != 0, nil
+	case string:
+		return v != "" && v != "0", nil
+	}
+	return false, fmt.Errorf("unexpected script result type %T", r.val)
+}
+
+// Text 解码为字符串
+func (r *ScriptResult) Text() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	switch v := r.val.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	}
+	return "", fmt.Errorf("unexpected script result type %T", r.val)
+}
+
+// Slice 解码为数组
+func (r *ScriptResult) Slice() ([]interface{}, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	arr, ok := r.val.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected script result type %T", r.val)
+	}
+	return arr, nil
+}
+
+// StringSlice 解码为字符串数组, 数组中的nil解码为空字符串
+func (r *ScriptResult) StringSlice() ([]string, error) {
+	arr, err := r.Slice()
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]string, len(arr))
+	for i, item := range arr {
+		switch v := item.(type) {
+		case nil:
+		case string:
+			ss[i] = v
+		case int64:
+			ss[i] = strconv.FormatInt(v, 10)
+		default:
+			return nil, fmt.Errorf("unexpected script result item type %T", item)
+		}
+	}
+	return ss, nil
+}
+
+// Int64Slice 解码为整数数组
+func (r *ScriptResult) Int64Slice() ([]int64, error) {
+	arr, err := r.Slice()
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]int64, len(arr))
+	for i, item := range arr {
+		if ns[i], err = toScriptInt64(item); err != nil {
+			return nil, err
+		}
+	}
+	return ns, nil
+}
+
+// StringMap 将 [k1, v1, k2, v2...] 形式的数组解码为map
+func (r *ScriptResult) StringMap() (map[string]string, error) {
+	ss, err := r.StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(ss)%2 != 0 {
+		return nil, fmt.Errorf("unexpected script result length %d", len(ss))
+	}
+	m := make(map[string]string, len(ss)/2)
+	for i := 0; i < len(ss); i += 2 {
+		m[ss[i]] = ss[i+1]
+	}
+	return m, nil
+}
+
+func toScriptInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected script result type %T", v)
+}
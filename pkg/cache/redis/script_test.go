@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptRegistry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(ctx)
+	registry := NewScriptRegistry()
+	err := registry.RegisterFS(fstest.MapFS{
+		"lua/incr.lua":  {Data: []byte("return redis.call('hincrby', KEYS[1], 'n', ARGV[1])")},
+		"lua/readme.md": {Data: []byte("-")},
+	}, "lua/*.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"incr"}, registry.Names())
+	_, err = registry.Register("incr", "return 1")
+	assert.Error(t, err)
+
+	s, _ := registry.Get("incr")
+	m.RegisterScript(s.Source(), func(ctx context.Context, rds *Memory, keys []string, args ...interface{}) (interface{}, error) {
+		return rds.IncrByHash(ctx, keys[0], "n", int64(args[0].(int)))
+	})
+	// 未缓存时回退到EVAL
+	n, err := registry.Run(ctx, m, "incr", []string{"cnt"}, 2).Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.NoError(t, registry.Load(ctx, m))
+	exists, _ := m.ScriptExists(ctx, s.Sha(), "unknown")
+	assert.Equal(t, []bool{true, false}, exists)
+	n, err = registry.Run(ctx, m, "incr", []string{"cnt"}, 3).Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	err = registry.Run(ctx, m, "incr", []string{"a", "b"}, 1).Err()
+	assert.ErrorIs(t, err, ErrCrossSlot)
+	assert.NoError(t, checkSlot("", []string{"{user}:a", "{user}:b"}))
+	assert.NoError(t, checkSlot("{user}:", []string{"a", "b"}))
+	assert.ErrorIs(t, checkSlot("user:", []string{"{a}", "{b}"}), ErrCrossSlot)
+	err = registry.Run(ctx, m, "none", nil).Err()
+	assert.ErrorIs(t, err, ErrScriptNotFound)
+}
+
+func TestScriptResult(t *testing.T) {
+	ok, err := NewScriptResult(nil, redis.Nil).Bool()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, _ = NewScriptResult(int64(1), nil).Bool()
+	assert.True(t, ok)
+	f, _ := NewScriptResult("1.5", nil).Float64()
+	assert.Equal(t, 1.5, f)
+	ss, _ := NewScriptResult([]interface{}{"a", int64(1), nil}, nil).StringSlice()
+	assert.Equal(t, []string{"a", "1", ""}, ss)
+	ns, _ := NewScriptResult([]interface{}{int64(1), "2"}, nil).Int64Slice()
+	assert.Equal(t, []int64{1, 2}, ns)
+	kv, _ := NewScriptResult([]interface{}{"a", "1", "b", "2"}, nil).StringMap()
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, kv)
+	_, err = NewScriptResult("x", nil).Slice()
+	assert.Error(t, err)
+}
+
+func TestScriptKeyPrefixSlot(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rds, err := NewClient(ctx, mr.Addr(), 0, WithKeyPrefix("{app}:"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	// 加上前缀后位于同一个slot
+	s := NewScript("mset2", "redis.call('set', KEYS[1], ARGV[1]); return redis.call('set', KEYS[2], ARGV[2])")
+	assert.NoError(t, s.Run(ctx, rds, []string{"a", "b"}, 1, 2).Err())
+	v, _ := mr.Get("{app}:b")
+	assert.Equal(t, "2", v)
+	nc, err := NewNearCache(ctx, rds)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, s.Run(ctx, nc, []string{"c", "d"}, 1, 2).Err())
+}
@@ -1,4 +1,58 @@
 package cache
 
-// Sentinel 哨兵模式
-type Sentinel struct{}
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+type OptionFuncForSentinel func(*sentinelOptions)
+
+// SentinelWithAuth 配置master/slave节点的鉴权
+func SentinelWithAuth(user, passwd string) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.Username = user
+		o.Password = passwd
+	}
+}
+
+// SentinelWithSentinelPasswd 配置sentinel节点的密码
+func SentinelWithSentinelPasswd(passwd string) OptionFuncForSentinel {
+	return func(o *sentinelOptions) {
+		o.SentinelPassword = passwd
+	}
+}
+
+// NewSentinel 实例化哨兵模式, 通过sentinel节点发现master并在主从切换后自动重连
+// PS: 哨兵模式与单机模式共用 Client 的实现
+func NewSentinel(ctx context.Context, masterName string, addrs []string, db int, ops ...OptionFuncForSentinel) (Redis, error) {
+	if masterName == "" {
+		return nil, errors.New("sentinel mode master name is required")
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("sentinel mode at least one sentinel address is required")
+	}
+	if db < 0 {
+		return nil, fmt.Errorf("sentinel mode db must be >= 0, currently as %d", db)
+	}
+	opt := &sentinelOptions{FailoverOptions: &redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: addrs,
+		DB:            db,
+	}}
+	for _, op := range ops {
+		op(opt)
+	}
+	client := redis.NewFailoverClient(opt.FailoverOptions)
+	for _, hook := range opt.redisHooks() {
+		client.AddHook(hook)
+	}
+	return &Client{
+		client: client.WithContext(ctx),
+		logg:   logger.DefaultLogger(),
+		prefix: opt.keyPrefix,
+	}, nil
+}
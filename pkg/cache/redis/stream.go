@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/8xmx8/easier/pkg/logger"
+	"github.com/go-redis/redis/v7"
+)
+
+/*
+基于redis stream的轻量级消息队列:
+1. 生产者通过 AddStream(XADD) 写入消息, 可按 MAXLEN 裁剪stream长度;
+2. StreamConsumer 以消费者组(XREADGROUP)的方式拉取消息, Handler执行成功后ACK;
+3. Handler执行失败的消息保留在pending列表中, 空闲超过claimIdle后通过XAUTOCLAIM重新认领并再次投递;
+4. 投递次数超过maxDeliveries的消息会被转入死信stream, 并在原消费者组中ACK;
+*/
+
+const (
+	deadLetterSuffix = ":dead"
+
+	// 死信消息中附加的原始信息
+	DeadLetterOriginID     = "_origin_id"
+	DeadLetterOriginStream = "_origin_stream"
+	DeadLetterDeliveries   = "_deliveries"
+)
+
+// StreamHandleFunc 消息处理函数, 返回nil时消息被ACK
+type StreamHandleFunc func(context.Context, *StreamMessage) error
+
+// StreamConsumer 定义stream的消费者
+type StreamConsumer struct {
+	rds      Redis
+	logg     logger.Logger
+	handle   StreamHandleFunc
+	stream   string
+	group    string
+	consumer string
+
+	start         string        // 消费者组不存在时的起始位置
+	batch         int64         // 单次拉取的消息数
+	block         time.Duration // 单次拉取的阻塞时间
+	claimIdle     time.Duration // pending消息空闲多久后被重新认领
+	claimInterval time.Duration // 重新认领的检查间隔
+	maxDeliveries int64         // 最大投递次数, 超过后转入死信stream; <=0 表示不启用死信
+	deadLetter    string        // 死信stream
+}
+
+type StreamOptionFunc func(*StreamConsumer)
+
+// StreamWithLogger 自定义logger
+func StreamWithLogger(logg logger.Logger) StreamOptionFunc {
+	return func(sc *StreamConsumer) {
+		sc.logg = logg
+	}
+}
+
+// StreamWithStart 消费者组不存在时的起始消费位置, "0"从头消费, "$"只消费新消息
+func StreamWithStart(start string) StreamOptionFunc {
+	return func(sc *StreamConsumer) {
+		sc.start = start
+	}
+}
+
+// StreamWithBatch 配置单次拉取的消息数与阻塞时间
+func StreamWithBatch(batch int64, block time.Duration) StreamOptionFunc {
+	return func(sc *StreamConsumer) {
+		sc.batch = batch
+		sc.block = block
+	}
+}
+
+// StreamWithClaim 配置pending消息的认领策略
+// idle: 消息空闲超过该时长后被重新认领; interval: 检查间隔
+func StreamWithClaim(idle, interval time.Duration) StreamOptionFunc {
+	return func(sc *StreamConsumer) {
+		sc.claimIdle = idle
+		sc.claimInterval = interval
+	}
+}
+
+// StreamWithDeadLetter 配置死信stream, 投递次数超过maxDeliveries的消息将被转入stream
+// maxDeliveries <= 0 时关闭死信
+func StreamWithDeadLetter(stream string, maxDeliveries int64) StreamOptionFunc {
+	return func(sc *StreamConsumer) {
+		sc.deadLetter = stream
+		sc.maxDeliveries = maxDeliveries
+	}
+}
+
+// NewStreamConsumer 创建一个stream消费者实例
+// 默认从头开始消费, 失败消息空闲30s后重新投递, 投递超过5次转入 "<stream>:dead"
+func NewStreamConsumer(rds Redis, stream, group, consumer string, handle StreamHandleFunc, ops ...StreamOptionFunc) (*StreamConsumer, error) {
+	if rds == nil || handle == nil {
+		return nil, errors.New("redis client and handle must not be nil")
+	}
+	if stream == "" || group == "" || consumer == "" {
+		return nil, fmt.Errorf("stream(%q), group(%q) and consumer(%q) must not be empty", stream, group, consumer)
+	}
+	sc := &StreamConsumer{
+		rds:           rds,
+		logg:          logger.DefaultLogger(),
+		handle:        handle,
+		stream:        stream,
+		group:         group,
+		consumer:      consumer,
+		start:         "0",
+		batch:         10,
+		block:         2 * time.Second,
+		claimIdle:     30 * time.Second,
+		claimInterval: 10 * time.Second,
+		maxDeliveries: 5,
+		deadLetter:    stream + deadLetterSuffix,
+	}
+	for _, op := range ops {
+		op(sc)
+	}
+	return sc, nil
+}
+
+// Run 执行消费动作, 阻塞直至ctx结束
+func (sc *StreamConsumer) Run(ctx context.Context) error {
+	if err := sc.rds.CreateStreamGroup(ctx, sc.stream, sc.group, sc.start); err != nil {
+		sc.logg.Error(logger.ErrorRedis, "create stream group", sc.fields(logger.ErrorField(err))...)
+		return err
+	}
+	sc.logg.Info("run redis stream consumer", sc.fields()...)
+	defer sc.logg.Info("redis stream consumer stoped", sc.fields()...)
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		if sc.claimIdle > 0 && time.Since(lastClaim) >= sc.claimInterval {
+			sc.reclaim(ctx)
+			lastClaim = time.Now()
+		}
+		msgs, err := sc.rds.ReadGroupStream(ctx, sc.stream, sc.group, sc.consumer, sc.batch, sc.block)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			sc.logg.Error(logger.ErrorRedis, "read stream group", sc.fields(logger.ErrorField(err))...)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			sc.process(ctx, msg)
+		}
+	}
+}
+
+// process 执行Handler, 成功后ACK; 失败的消息留在pending列表中等待重新认领
+func (sc *StreamConsumer) process(ctx context.Context, msg *StreamMessage) {
+	if err := sc.handle(ctx, msg); err != nil {
+		sc.logg.Error(logger.ErrorRedis, "stream callback handle", sc.fields(logger.MakeField("id", msg.ID), logger.ErrorField(err))...)
+		return
+	}
+	if err := sc.rds.AckStream(ctx, sc.stream, sc.group, msg.ID); err != nil {
+		sc.logg.Error(logger.ErrorRedis, "stream ack", sc.fields(logger.MakeField("id", msg.ID), logger.ErrorField(err))...)
+	}
+}
+
+// reclaim 认领空闲超时的pending消息, 并重新投递或转入死信stream
+func (sc *StreamConsumer) reclaim(ctx context.Context) {
+	start := "0-0"
+	for {
+		next, msgs, err := sc.rds.AutoClaimStream(ctx, sc.stream, sc.group, sc.consumer, sc.claimIdle, start, sc.batch)
+		if err != nil {
+			sc.logg.Error(logger.ErrorRedis, "stream autoclaim", sc.fields(logger.ErrorField(err))...)
+			return
+		}
+		for _, msg := range msgs {
+			if ctx.Err() != nil {
+				return
+			}
+			if msg.Values == nil { // 消息已从stream中删除, 直接确认
+				_ = sc.rds.AckStream(ctx, sc.stream, sc.group, msg.ID)
+				continue
+			}
+			if sc.maxDeliveries > 0 {
+				deliveries, err := sc.deliveries(ctx, msg.ID)
+				if err != nil {
+					sc.logg.Error(logger.ErrorRedis, "stream pending", sc.fields(logger.MakeField("id", msg.ID), logger.ErrorField(err))...)
+					continue
+				}
+				if deliveries > sc.maxDeliveries {
+					sc.toDeadLetter(ctx, msg, deliveries)
+					continue
+				}
+			}
+			sc.process(ctx, msg)
+		}
+		if next == "" || next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+// deliveries 获取消息的投递次数
+func (sc *StreamConsumer) deliveries(ctx context.Context, id string) (int64, error) {
+	pending, err := sc.rds.PendingStream(ctx, sc.stream, sc.group, id, id, 1)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+	return pending[0].RetryCount, nil
+}
+
+// toDeadLetter 将消息转入死信stream并在原消费者组中ACK
+func (sc *StreamConsumer) toDeadLetter(ctx context.Context, msg *StreamMessage, deliveries int64) {
+	values := make(map[string]interface{}, len(msg.Values)+3)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[DeadLetterOriginID] = msg.ID
+	values[DeadLetterOriginStream] = sc.stream
+	values[DeadLetterDeliveries] = deliveries
+	if _, err := sc.rds.AddStream(ctx, sc.deadLetter, 0, values); err != nil {
+		sc.logg.Error(logger.ErrorRedis, "stream dead letter", sc.fields(logger.MakeField("id", msg.ID), logger.ErrorField(err))...)
+		return
+	}
+	sc.logg.Warn(logger.ErrorRedis, "stream message moved to dead letter", sc.fields(
+		logger.MakeField("id", msg.ID), logger.MakeField("deadLetter", sc.deadLetter), logger.MakeField("deliveries", deliveries))...)
+	if err := sc.rds.AckStream(ctx, sc.stream, sc.group, msg.ID); err != nil {
+		sc.logg.Error(logger.ErrorRedis, "stream ack", sc.fields(logger.MakeField("id", msg.ID), logger.ErrorField(err))...)
+	}
+}
+
+func (sc *StreamConsumer) fields(fs ...logger.Field) []logger.Field {
+	return append([]logger.Field{
+		logger.MakeField("stream", sc.stream),
+		logger.MakeField("group", sc.group),
+		logger.MakeField("consumer", sc.consumer),
+	}, fs...)
+}
+
+// isBusyGroup 消费者组已存在
+func isBusyGroup(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
+
+func toStreamMessages(xms []redis.XMessage) []*StreamMessage {
+	msgs := make([]*StreamMessage, 0, len(xms))
+	for _, xm := range xms {
+		msgs = append(msgs, &StreamMessage{ID: xm.ID, Values: xm.Values})
+	}
+	return msgs
+}
+
+// parseAutoClaim 解析XAUTOCLAIM的返回值
+//
+//	[next-id, [[id, [field, value, ...]], ...], (redis >= 7.0: [deleted-id, ...])]
+func parseAutoClaim(res interface{}) (string, []*StreamMessage, error) {
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) < 2 {
+		return "", nil, fmt.Errorf("unexpected xautoclaim reply: %v", res)
+	}
+	next, _ := arr[0].(string)
+	entries, _ := arr[1].([]interface{})
+	msgs := make([]*StreamMessage, 0, len(entries))
+	for _, entry := range entries {
+		kv, ok := entry.([]interface{})
+		if !ok || len(kv) < 1 {
+			continue
+		}
+		msg := &StreamMessage{}
+		msg.ID, _ = kv[0].(string)
+		if len(kv) > 1 {
+			if fvs, ok := kv[1].([]interface{}); ok {
+				msg.Values = make(map[string]interface{}, len(fvs)/2)
+				for i := 0; i+1 < len(fvs); i += 2 {
+					field, _ := fvs[i].(string)
+					msg.Values[field] = fvs[i+1]
+				}
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(arr) > 2 { // redis 7.0 开始单独返回已删除的消息ID
+		deleted, _ := arr[2].([]interface{})
+		for _, id := range deleted {
+			if s, ok := id.(string); ok {
+				msgs = append(msgs, &StreamMessage{ID: s})
+			}
+		}
+	}
+	return next, msgs, nil
+}
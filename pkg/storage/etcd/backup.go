@@ -0,0 +1,398 @@
+package etcd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	etcdcli "go.etcd.io/etcd/client/v3"
+)
+
+/*
+备份与迁移:
+1. Export 将前缀下的数据以JSON lines导出, 每行一个 BackupRecord(key、value、租约剩余TTL与revision), 分页读取同一个revision的快照;
+   租约已过期的key即将被删除, 不导出, 避免导入后成为永久的key;
+2. Import 导入 Export 的文件, 支持覆盖、跳过已存在的key与只比较不写入(dry-run)三种模式, 可以替换key的前缀以在环境之间迁移;
+3. MovePrefix 在一个事务中将前缀下的所有key移动到新前缀, 保留租约;
+4. DiffPrefix 比较两个前缀下的数据, 结果可以直接输出到终端;
+*/
+
+const (
+	exportPageSize = 1000
+	maxMoveKeys    = 64 // 一个事务最多移动的key数, 受etcd的--max-txn-ops(默认128)限制
+)
+
+var (
+	ErrTargetExists = errors.New("target key already exists")
+	ErrTooManyKeys  = fmt.Errorf("too many keys to move in one txn, max %d", maxMoveKeys)
+)
+
+// BackupRecord 导出文件中的一条记录
+type BackupRecord struct {
+	Key            string `json:"key"`
+	Value          string `json:"value,omitempty"`
+	ValueBase64    []byte `json:"value_base64,omitempty"` // value不是合法的UTF-8时使用
+	TTL            int64  `json:"ttl,omitempty"`          // 导出时租约的剩余TTL(秒), 0表示没有租约
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
+}
+
+// value 记录的原始value
+func (r *BackupRecord) value() string {
+	if r.ValueBase64 != nil {
+		return string(r.ValueBase64)
+	}
+	return r.Value
+}
+
+func newBackupRecord(key string, value []byte) BackupRecord {
+	r := BackupRecord{Key: key}
+	if utf8.Valid(value) {
+		r.Value = string(value)
+	} else {
+		r.ValueBase64 = value
+	}
+	return r
+}
+
+// ExportResult 导出结果
+type ExportResult struct {
+	Prefix   string
+	Revision int64 // 导出的快照对应的revision
+	Count    int
+	Expired  []string // 租约已过期而未导出的key
+}
+
+// Export 将前缀下的数据导出到w, 每行一个 BackupRecord
+func (cli *Client) Export(ctx context.Context, prefix string, w io.Writer) (*ExportResult, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	res := &ExportResult{Prefix: prefix}
+	ttls := map[etcdcli.LeaseID]int64{}
+	key, end := prefix, etcdcli.GetPrefixRangeEnd(prefix)
+	for {
+		opts := []etcdcli.OpOption{etcdcli.WithRange(end), etcdcli.WithLimit(exportPageSize)}
+		if res.Revision > 0 {
+			opts = append(opts, etcdcli.WithRev(res.Revision)) // 后续分页读取同一个快照
+		}
+		tctx, cancel := context.WithTimeout(ctx, cli.timeout)
+		resp, err := cli.cli.Get(tctx, key, opts...)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if res.Revision == 0 {
+			res.Revision = resp.Header.Revision
+		}
+		for _, kv := range resp.Kvs {
+			r := newBackupRecord(string(kv.Key), kv.Value)
+			r.CreateRevision, r.ModRevision, r.Version = kv.CreateRevision, kv.ModRevision, kv.Version
+			if lease := etcdcli.LeaseID(kv.Lease); lease != etcdcli.NoLease {
+				ttl, ok := ttls[lease]
+				if !ok {
+					if ttl, err = cli.GetTTLWithLease(ctx, kv.Lease); err != nil {
+						return nil, err
+					}
+					ttls[lease] = ttl
+				}
+				if ttl <= 0 {
+					res.Expired = append(res.Expired, r.Key)
+					continue
+				}
+				r.TTL = ttl
+			}
+			if err := enc.Encode(&r); err != nil {
+				return nil, err
+			}
+			res.Count++
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+	return res, bw.Flush()
+}
+
+// ReadBackup 读取 Export 导出的记录
+func ReadBackup(r io.Reader) ([]BackupRecord, error) {
+	var records []BackupRecord
+	dec := json.NewDecoder(r)
+	for {
+		var rec BackupRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, fmt.Errorf("read backup record %d: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+type ImportMode int
+
+const (
+	ImportOverwrite    ImportMode = iota // 覆盖已存在的key
+	ImportSkipExisting                   // 跳过已存在的key
+	ImportDryRun                         // 只比较, 不写入
+)
+
+type importOptions struct {
+	mode       ImportMode
+	fromPrefix string
+	toPrefix   string
+}
+
+type ImportOptionFunc func(*importOptions)
+
+// ImportWithMode 导入模式, 默认 ImportOverwrite
+func ImportWithMode(mode ImportMode) ImportOptionFunc {
+	return func(o *importOptions) {
+		o.mode = mode
+	}
+}
+
+// ImportWithPrefix 将记录中以from开头的key替换为以to开头, 用于在环境之间迁移
+func ImportWithPrefix(from, to string) ImportOptionFunc {
+	return func(o *importOptions) {
+		o.fromPrefix, o.toPrefix = from, to
+	}
+}
+
+// ImportReport 导入结果, dry-run时为将要执行的变更
+type ImportReport struct {
+	DryRun    bool
+	Created   []string
+	Updated   []string
+	Skipped   []string // 已存在且value不同, 因 ImportSkipExisting 跳过
+	Unchanged []string
+}
+
+// String 以终端友好的格式输出: "+" 新增, "~" 修改, "!" 跳过
+func (r *ImportReport) String() string {
+	var b strings.Builder
+	for _, k := range r.Created {
+		fmt.Fprintf(&b, "+ %s\n", k)
+	}
+	for _, k := range r.Updated {
+		fmt.Fprintf(&b, "~ %s\n", k)
+	}
+	for _, k := range r.Skipped {
+		fmt.Fprintf(&b, "! %s (exists, skipped)\n", k)
+	}
+	fmt.Fprintf(&b, "created: %d, updated: %d, skipped: %d, unchanged: %d", len(r.Created), len(r.Updated), len(r.Skipped), len(r.Unchanged))
+	if r.DryRun {
+		b.WriteString(" (dry-run)")
+	}
+	return b.String()
+}
+
+// Import 导入 Export 导出的数据; 有TTL的记录写入时使用新的租约(相同TTL的记录共享一个租约)
+func (cli *Client) Import(ctx context.Context, r io.Reader, ops ...ImportOptionFunc) (*ImportReport, error) {
+	opts := &importOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+	records, err := ReadBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImportReport{DryRun: opts.mode == ImportDryRun}
+	leases := map[int64]etcdcli.LeaseID{}
+	for _, rec := range records {
+		key := rec.Key
+		if opts.fromPrefix != "" || opts.toPrefix != "" {
+			if !strings.HasPrefix(key, opts.fromPrefix) {
+				continue
+			}
+			key = opts.toPrefix + strings.TrimPrefix(key, opts.fromPrefix)
+		}
+		tctx, cancel := context.WithTimeout(ctx, cli.timeout)
+		resp, err := cli.cli.Get(tctx, key)
+		cancel()
+		if err != nil {
+			return report, err
+		}
+		exists := len(resp.Kvs) > 0
+		switch {
+		case exists && string(resp.Kvs[0].Value) == rec.value():
+			report.Unchanged = append(report.Unchanged, key)
+			continue
+		case exists && opts.mode == ImportSkipExisting:
+			report.Skipped = append(report.Skipped, key)
+			continue
+		case exists:
+			report.Updated = append(report.Updated, key)
+		default:
+			report.Created = append(report.Created, key)
+		}
+		if opts.mode == ImportDryRun {
+			continue
+		}
+		var putOpts []etcdcli.OpOption
+		if rec.TTL > 0 {
+			lease, ok := leases[rec.TTL]
+			if !ok {
+				id, err := cli.CreateLeaseID(ctx, rec.TTL)
+				if err != nil {
+					return report, err
+				}
+				lease = etcdcli.LeaseID(id)
+				leases[rec.TTL] = lease
+			}
+			putOpts = append(putOpts, etcdcli.WithLease(lease))
+		}
+		// 读取之后被修改时放弃, 避免覆盖并发的写入
+		txn := cli.Txn(ctx).Then(etcdcli.OpPut(key, rec.value(), putOpts...))
+		if exists {
+			txn.IfModRevision(key, "=", resp.Kvs[0].ModRevision)
+		} else {
+			txn.IfNotExists(key)
+		}
+		res, err := txn.Commit()
+		if err != nil {
+			return report, err
+		}
+		if !res.Succeeded {
+			return report, fmt.Errorf("import %s: %w", key, ErrTxnConflict)
+		}
+	}
+	return report, nil
+}
+
+// MovePrefix 在一个事务中将from前缀下的所有key移动到to前缀(如 /config/a/x -> /config/b/x), 保留租约, 返回移动的key数;
+// 目标key已存在时返回 ErrTargetExists, 期间数据被修改时返回 ErrTxnConflict
+func (cli *Client) MovePrefix(ctx context.Context, from, to string) (int, error) {
+	if from == "" || strings.HasPrefix(to, from) || strings.HasPrefix(from, to) {
+		return 0, fmt.Errorf("%w: move %q to %q", ErrInvalidParam, from, to)
+	}
+	tctx, cancel := context.WithTimeout(ctx, cli.timeout)
+	resp, err := cli.cli.Get(tctx, from, etcdcli.WithPrefix(), etcdcli.WithLimit(maxMoveKeys+1))
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) > maxMoveKeys {
+		return 0, ErrTooManyKeys
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	txn := cli.Txn(ctx)
+	dsts := make([]etcdcli.Op, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		src := string(kv.Key)
+		dst := to + strings.TrimPrefix(src, from)
+		dsts = append(dsts, etcdcli.OpGet(dst, etcdcli.WithCountOnly()))
+		txn.IfModRevision(src, "=", kv.ModRevision).IfNotExists(dst).
+			Then(etcdcli.OpDelete(src), etcdcli.OpPut(dst, string(kv.Value), etcdcli.WithLease(etcdcli.LeaseID(kv.Lease))))
+	}
+	res, err := txn.Commit()
+	if err != nil {
+		return 0, err
+	}
+	if res.Succeeded {
+		return len(resp.Kvs), nil
+	}
+	// 区分目标已存在与并发修改, 只检查要写入的目标key
+	check, err := cli.Txn(ctx).Then(dsts...).Commit()
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range check.resp.Responses {
+		if r.GetResponseRange().Count > 0 {
+			return 0, ErrTargetExists
+		}
+	}
+	return 0, ErrTxnConflict
+}
+
+// DiffEntry 两个前缀下同一个相对key的差异
+type DiffEntry struct {
+	Key string // 相对于前缀的key
+	A   string
+	B   string
+}
+
+// PrefixDiff 两个前缀的差异, key均相对于各自的前缀
+type PrefixDiff struct {
+	OnlyInA []DiffEntry
+	OnlyInB []DiffEntry
+	Changed []DiffEntry
+}
+
+// Empty 两个前缀的数据是否相同
+func (d *PrefixDiff) Empty() bool {
+	return len(d.OnlyInA)+len(d.OnlyInB)+len(d.Changed) == 0
+}
+
+// String 以终端友好的格式输出: "-" 只在A中, "+" 只在B中, "~" value不同
+func (d *PrefixDiff) String() string {
+	var b strings.Builder
+	for _, e := range d.OnlyInA {
+		fmt.Fprintf(&b, "- %s: %q\n", e.Key, e.A)
+	}
+	for _, e := range d.OnlyInB {
+		fmt.Fprintf(&b, "+ %s: %q\n", e.Key, e.B)
+	}
+	for _, e := range d.Changed {
+		fmt.Fprintf(&b, "~ %s: %q -> %q\n", e.Key, e.A, e.B)
+	}
+	return b.String()
+}
+
+// DiffPrefix 比较a、b两个前缀下的数据(可以在不同的集群中, other为空时使用当前客户端)
+func (cli *Client) DiffPrefix(ctx context.Context, a, b string, other ...*Client) (*PrefixDiff, error) {
+	kvsA, err := cli.GetKvs(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	cb := cli
+	if len(other) > 0 && other[0] != nil {
+		cb = other[0]
+	}
+	kvsB, err := cb.GetKvs(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return diffKvs(trimKeys(kvsA, a), trimKeys(kvsB, b)), nil
+}
+
+func trimKeys(kvs map[string]string, prefix string) map[string]string {
+	out := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		out[strings.TrimPrefix(k, prefix)] = v
+	}
+	return out
+}
+
+// diffKvs 比较两组相对key的数据, 结果按key排序
+func diffKvs(a, b map[string]string) *PrefixDiff {
+	d := &PrefixDiff{}
+	for k, va := range a {
+		vb, ok := b[k]
+		switch {
+		case !ok:
+			d.OnlyInA = append(d.OnlyInA, DiffEntry{Key: k, A: va})
+		case va != vb:
+			d.Changed = append(d.Changed, DiffEntry{Key: k, A: va, B: vb})
+		}
+	}
+	for k, vb := range b {
+		if _, ok := a[k]; !ok {
+			d.OnlyInB = append(d.OnlyInB, DiffEntry{Key: k, B: vb})
+		}
+	}
+	for _, es := range [][]DiffEntry{d.OnlyInA, d.OnlyInB, d.Changed} {
+		sort.Slice(es, func(i, j int) bool { return es[i].Key < es[j].Key })
+	}
+	return d
+}
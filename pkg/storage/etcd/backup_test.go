@@ -0,0 +1,102 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRecord(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	text := newBackupRecord("/a", []byte("hello"))
+	bin := newBackupRecord("/b", []byte{0xff, 0x00, 0xfe})
+	assert.Nil(t, text.ValueBase64)
+	assert.Empty(t, bin.Value)
+	assert.NoError(t, enc.Encode(&text))
+	assert.NoError(t, enc.Encode(&bin))
+
+	records, err := ReadBackup(&buf)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "hello", records[0].value())
+		assert.Equal(t, string([]byte{0xff, 0x00, 0xfe}), records[1].value())
+	}
+
+	_, err = ReadBackup(bytes.NewBufferString("{\"key\":\"/a\"}\nnot json\n"))
+	assert.Error(t, err)
+}
+
+func TestDiffKvs(t *testing.T) {
+	d := diffKvs(
+		map[string]string{"/x": "1", "/y": "2", "/z": "3"},
+		map[string]string{"/x": "1", "/y": "20", "/w": "4"},
+	)
+	assert.False(t, d.Empty())
+	assert.Equal(t, []DiffEntry{{Key: "/z", A: "3"}}, d.OnlyInA)
+	assert.Equal(t, []DiffEntry{{Key: "/w", B: "4"}}, d.OnlyInB)
+	assert.Equal(t, []DiffEntry{{Key: "/y", A: "2", B: "20"}}, d.Changed)
+	assert.Equal(t, "- /z: \"3\"\n+ /w: \"4\"\n~ /y: \"2\" -> \"20\"\n", d.String())
+	assert.True(t, diffKvs(map[string]string{"/x": "1"}, map[string]string{"/x": "1"}).Empty())
+}
+
+func TestImportReport(t *testing.T) {
+	r := &ImportReport{DryRun: true, Created: []string{"/a"}, Updated: []string{"/b"}, Skipped: []string{"/c"}}
+	assert.Equal(t, "+ /a\n~ /b\n! /c (exists, skipped)\ncreated: 1, updated: 1, skipped: 1, unchanged: 0 (dry-run)", r.String())
+}
+
+func TestMovePrefixInvalid(t *testing.T) {
+	cc := &Client{}
+	_, err := cc.MovePrefix(context.Background(), "/a", "/a/b")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+	_, err = cc.MovePrefix(context.Background(), "", "/b")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
+
+func TestETCDBackup(t *testing.T) {
+	ctx := context.Background()
+	cc, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	src, dst := prefix+"/backup/src/", prefix+"/backup/dst/"
+	defer cc.DeleteWithPrefix(ctx, prefix+"/backup")
+	assert.NoError(t, cc.Put(ctx, src+"a", "1"))
+	assert.NoError(t, cc.PutWithTTL(ctx, src+"b", "2", 60))
+
+	var buf bytes.Buffer
+	res, err := cc.Export(ctx, src, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Count)
+
+	data := buf.Bytes()
+	report, err := cc.Import(ctx, bytes.NewReader(data), ImportWithPrefix(src, dst), ImportWithMode(ImportDryRun))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dst + "a", dst + "b"}, report.Created)
+	_, err = cc.Import(ctx, bytes.NewReader(data), ImportWithPrefix(src, dst))
+	assert.NoError(t, err)
+	diff, err := cc.DiffPrefix(ctx, src, dst)
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty(), diff.String())
+
+	assert.NoError(t, cc.Put(ctx, dst+"a", "changed"))
+	report, err = cc.Import(ctx, bytes.NewReader(data), ImportWithPrefix(src, dst), ImportWithMode(ImportSkipExisting))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dst + "a"}, report.Skipped)
+
+	moved := prefix + "/backup/moved/"
+	n, err := cc.MovePrefix(ctx, src, moved)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = cc.MovePrefix(ctx, moved, dst)
+	assert.Equal(t, ErrTargetExists, err)
+	// 目标前缀下无关的key不影响移动
+	other := prefix + "/backup/other/"
+	assert.NoError(t, cc.Put(ctx, other+"unrelated", "x"))
+	n, err = cc.MovePrefix(ctx, moved, other)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
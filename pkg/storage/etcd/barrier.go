@@ -0,0 +1,172 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdcli "go.etcd.io/etcd/client/v3"
+)
+
+/*
+屏障:
+1. Barrier: Hold 设置屏障, Wait 阻塞直到屏障被 Release; 屏障绑定session, 设置者崩溃后自动释放;
+2. DoubleBarrier: N个参与者都 Enter 后才能继续执行, 都 Leave 后才能离开; 参与者绑定session, 崩溃后自动退出;
+   同一个key只能使用一轮, 下一轮使用新的key;
+*/
+
+var ErrBarrierHeld = errors.New("barrier is already held")
+
+// waitEvent 从rev开始监听key, 直到出现match返回true的事件
+func waitEvent(ctx context.Context, cli *etcdcli.Client, key string, rev int64, match func(ev *etcdcli.Event) bool, opts ...etcdcli.OpOption) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts = append(opts, etcdcli.WithRev(rev))
+	for wr := range cli.Watch(wctx, key, opts...) {
+		if err := wr.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wr.Events {
+			if match(ev) {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("lost watcher waiting for event")
+}
+
+func isPut(ev *etcdcli.Event) bool    { return ev.Type == mvccpb.PUT }
+func isDelete(ev *etcdcli.Event) bool { return ev.Type == mvccpb.DELETE }
+func anyEvent(*etcdcli.Event) bool    { return true }
+
+// Barrier 屏障
+type Barrier struct {
+	manager *LockManager
+	key     string
+}
+
+// NewBarrier 创建屏障, ops配置屏障绑定的session
+func (cli *Client) NewBarrier(key string, ops ...LockOptionFunc) *Barrier {
+	return &Barrier{manager: cli.NewLockManager(ops...), key: key}
+}
+
+// Hold 设置屏障, 已被设置时返回 ErrBarrierHeld
+func (b *Barrier) Hold(ctx context.Context) error {
+	sess, err := b.manager.session()
+	if err != nil {
+		return err
+	}
+	cli := b.manager.cli
+	res, err := cli.Txn(ctx).IfNotExists(b.key).Then(etcdcli.OpPut(b.key, cli.nodeName, etcdcli.WithLease(sess.Lease()))).Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return ErrBarrierHeld
+	}
+	return nil
+}
+
+// Release 释放屏障, 唤醒所有等待者
+func (b *Barrier) Release(ctx context.Context) error {
+	return b.manager.cli.Delete(ctx, b.key)
+}
+
+// Wait 阻塞直到屏障被释放; 屏障未设置时立即返回
+func (b *Barrier) Wait(ctx context.Context) error {
+	resp, err := b.manager.cli.cli.Get(ctx, b.key, etcdcli.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	if resp.Count == 0 {
+		return nil
+	}
+	return waitEvent(ctx, b.manager.cli.cli, b.key, resp.Header.Revision+1, isDelete)
+}
+
+// Close 关闭屏障绑定的session, 持有的屏障被释放
+func (b *Barrier) Close() error {
+	return b.manager.Close()
+}
+
+// DoubleBarrier 双屏障
+type DoubleBarrier struct {
+	manager *LockManager
+	key     string
+	count   int
+	myKey   string
+}
+
+// NewDoubleBarrier 创建count个参与者的双屏障, ops配置参与者绑定的session
+func (cli *Client) NewDoubleBarrier(key string, count int, ops ...LockOptionFunc) *DoubleBarrier {
+	return &DoubleBarrier{manager: cli.NewLockManager(ops...), key: key, count: count}
+}
+
+func (b *DoubleBarrier) waitersPrefix() string { return b.key + "/waiters/" }
+func (b *DoubleBarrier) readyKey() string      { return b.key + "/ready" }
+
+// Enter 加入屏障, 阻塞直到count个参与者都已加入
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	if b.count <= 0 {
+		return fmt.Errorf("%w: double barrier count %d", ErrInvalidParam, b.count)
+	}
+	sess, err := b.manager.session()
+	if err != nil {
+		return err
+	}
+	cli := b.manager.cli
+	b.myKey = fmt.Sprintf("%s%x", b.waitersPrefix(), sess.Lease())
+	if _, err = cli.cli.Put(ctx, b.myKey, cli.nodeName, etcdcli.WithLease(sess.Lease())); err != nil {
+		return err
+	}
+	// 同一个revision下读取参与者数量与ready
+	res, err := cli.Txn(ctx).Then(
+		etcdcli.OpGet(b.waitersPrefix(), etcdcli.WithPrefix(), etcdcli.WithCountOnly()),
+		etcdcli.OpGet(b.readyKey(), etcdcli.WithCountOnly()),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	waiters := res.resp.Responses[0].GetResponseRange().Count
+	ready := res.resp.Responses[1].GetResponseRange().Count
+	if ready > 0 {
+		return nil
+	}
+	if waiters >= int64(b.count) {
+		_, err = cli.cli.Put(ctx, b.readyKey(), "")
+		return err
+	}
+	return waitEvent(ctx, cli.cli, b.readyKey(), res.Revision+1, isPut)
+}
+
+// Leave 离开屏障, 阻塞直到所有参与者都已离开
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	cli := b.manager.cli
+	if b.myKey != "" {
+		if _, err := cli.cli.Delete(ctx, b.myKey); err != nil {
+			return err
+		}
+	}
+	for {
+		resp, err := cli.cli.Get(ctx, b.waitersPrefix(), etcdcli.WithPrefix(), etcdcli.WithCountOnly())
+		if err != nil {
+			return err
+		}
+		if resp.Count == 0 {
+			_, err = cli.cli.Delete(ctx, b.readyKey())
+			return err
+		}
+		if err = waitEvent(ctx, cli.cli, b.waitersPrefix(), resp.Header.Revision+1, isDelete, etcdcli.WithPrefix()); err != nil {
+			return err
+		}
+	}
+}
+
+// Close 关闭参与者绑定的session
+func (b *DoubleBarrier) Close() error {
+	return b.manager.Close()
+}
@@ -0,0 +1,447 @@
+package configcenter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/8xmx8/easier/pkg/storage/etcd"
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+配置中心:
+1. 前缀下的数据绑定到结构体T(按json tag): 前缀本身的value为整个配置文档, 前缀/a/b 的value为字段a.b, 子key覆盖文档中的同名字段;
+   value可以是JSON或YAML, 无法解析时作为字符串; 数字形式的字符串字段需要加引号;
+2. 先使用默认值(WithDefault)再覆盖etcd中的数据, 之后校验: T实现了 Validate() error 时调用, 以及 WithValidator 配置的校验函数;
+3. 通过Watcher热更新, 新配置校验失败时保留上一次正确的配置, 通过 LastError 获取失败原因;
+4. 配置变化时通知订阅者, 并给出变化的字段路径(如 redis.addr);
+5. Save 以CAS的方式写入整个配置文档, Rollback 将文档恢复到之前的revision(未被压缩);
+*/
+
+var (
+	ErrConflict         = errors.New("config has been modified by others")
+	ErrRevisionNotFound = errors.New("config document does not exist at revision")
+)
+
+// Validator T实现此接口时, 加载后自动校验
+type Validator interface {
+	Validate() error
+}
+
+// SubscribeFunc 配置变化时回调, changed为变化的字段路径
+type SubscribeFunc[T any] func(old, new *T, changed []string)
+
+// Center 配置中心, 并发安全
+type Center[T any] struct {
+	client     *etcd.Client
+	prefix     string
+	defaults   *T
+	validators []func(*T) error
+	logger     *zap.Logger
+	watcher    *etcd.Watcher
+
+	mu      sync.RWMutex
+	raw     map[string]string // 前缀下的原始数据
+	current *T
+	rev     int64 // 当前配置对应的revision
+	docRev  int64 // 配置文档的ModRevision, 用于CAS
+	lastErr error
+	subs    map[int]SubscribeFunc[T]
+	nextSub int
+}
+
+type Option[T any] func(*Center[T])
+
+// WithDefault 配置默认值, etcd中没有的字段使用默认值
+func WithDefault[T any](v T) Option[T] {
+	return func(c *Center[T]) {
+		c.defaults = &v
+	}
+}
+
+// WithValidator 增加校验函数
+func WithValidator[T any](f func(*T) error) Option[T] {
+	return func(c *Center[T]) {
+		c.validators = append(c.validators, f)
+	}
+}
+
+func newCenter[T any](prefix string, logger *zap.Logger, ops ...Option[T]) *Center[T] {
+	c := &Center[T]{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		logger: logger,
+		raw:    map[string]string{},
+		subs:   map[int]SubscribeFunc[T]{},
+	}
+	for _, op := range ops {
+		op(c)
+	}
+	return c
+}
+
+// New 加载前缀下的配置并监听变化, 首次加载失败时返回错误
+func New[T any](ctx context.Context, cli *etcd.Client, prefix string, ops ...Option[T]) (*Center[T], error) {
+	c := newCenter(prefix, cli.Logger(), ops...)
+	c.client = cli
+	tctx, cancel := context.WithTimeout(ctx, cli.Timeout())
+	resp, err := cli.RawClient().Get(tctx, c.prefix, etcdcli.WithPrefix())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if c.owns(string(kv.Key)) {
+			raw[string(kv.Key)] = string(kv.Value)
+			if string(kv.Key) == c.prefix {
+				c.docRev = kv.ModRevision
+			}
+		}
+	}
+	if err := c.load(raw, resp.Header.Revision); err != nil {
+		return nil, err
+	}
+	c.watcher, err = cli.NewWatcher(ctx, c.prefix, c.onEvents,
+		etcd.WatcherWithRevision(resp.Header.Revision), etcd.WatcherWithResync(c.onResync))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// owns key是否属于本配置, 排除前缀相同的其他配置(如 /config/a 与 /config/ab)
+func (c *Center[T]) owns(key string) bool {
+	return key == c.prefix || strings.HasPrefix(key, c.prefix+"/")
+}
+
+func (c *Center[T]) onEvents(w *etcd.Watcher, events []*etcd.UpdateEvent, err error) {
+	if err != nil {
+		c.logger.Warn("config center watch", zap.Error(err), zap.String("prefix", c.prefix))
+		return
+	}
+	c.mu.RLock()
+	raw := make(map[string]string, len(c.raw))
+	for k, v := range c.raw {
+		raw[k] = v
+	}
+	docRev := c.docRev
+	c.mu.RUnlock()
+	var rev int64
+	for _, ev := range events {
+		rev = ev.Revision
+		if !c.owns(ev.Key) {
+			continue
+		}
+		switch ev.EventType {
+		case etcd.UpdateEventTypePut:
+			raw[ev.Key] = ev.Value
+			if ev.Key == c.prefix {
+				docRev = ev.Revision
+			}
+		case etcd.UpdateEventTypeDelete:
+			delete(raw, ev.Key)
+			if ev.Key == c.prefix {
+				docRev = 0 // 配置文档已删除, CAS按不存在处理
+			}
+		}
+	}
+	c.mu.Lock()
+	c.docRev = docRev
+	c.mu.Unlock()
+	if err := c.load(raw, rev); err != nil {
+		c.logger.Error("config center reload, keep last good config", zap.Error(err), zap.String("prefix", c.prefix))
+	}
+}
+
+func (c *Center[T]) onResync(w *etcd.Watcher, kvs map[string]string, rev int64) {
+	raw := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		if c.owns(k) {
+			raw[k] = v
+		}
+	}
+	// 全量数据中没有ModRevision, 重新读取配置文档的ModRevision
+	tctx, cancel := context.WithTimeout(context.Background(), c.client.Timeout())
+	resp, err := c.client.RawClient().Get(tctx, c.prefix)
+	cancel()
+	if err != nil {
+		c.logger.Warn("config center get document revision", zap.Error(err), zap.String("prefix", c.prefix))
+	} else {
+		var docRev int64
+		if len(resp.Kvs) > 0 {
+			docRev = resp.Kvs[0].ModRevision
+		}
+		c.mu.Lock()
+		c.docRev = docRev
+		c.mu.Unlock()
+	}
+	if err := c.load(raw, rev); err != nil {
+		c.logger.Error("config center resync, keep last good config", zap.Error(err), zap.String("prefix", c.prefix))
+	}
+}
+
+// load 解析并校验配置, 成功时替换当前配置并通知订阅者; 失败时保留原配置
+func (c *Center[T]) load(raw map[string]string, rev int64) error {
+	cfg, err := c.decode(raw)
+	if err == nil {
+		err = c.validate(cfg)
+	}
+	c.mu.Lock()
+	c.raw = raw
+	if err != nil {
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+	old := c.current
+	c.current, c.rev, c.lastErr = cfg, rev, nil
+	subs := make([]SubscribeFunc[T], 0, len(c.subs))
+	ids := make([]int, 0, len(c.subs))
+	for id := range c.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		subs = append(subs, c.subs[id])
+	}
+	c.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	changed := diffFields(old, cfg)
+	if len(changed) == 0 {
+		return nil
+	}
+	for _, f := range subs {
+		f(old, cfg, changed)
+	}
+	return nil
+}
+
+// decode 默认值 -> 配置文档 -> 子key, 依次覆盖
+func (c *Center[T]) decode(raw map[string]string) (*T, error) {
+	cfg := new(T)
+	if c.defaults != nil {
+		b, err := json.Marshal(c.defaults)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return nil, err
+		}
+	}
+	tree := map[string]interface{}{}
+	if doc, ok := raw[c.prefix]; ok {
+		v := decodeValue(doc)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config document %s is not an object", c.prefix)
+		}
+		tree = m
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		if k != c.prefix {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys) // 父字段先于子字段写入
+	for _, k := range keys {
+		path := strings.Split(strings.Trim(strings.TrimPrefix(k, c.prefix), "/"), "/")
+		setPath(tree, path, decodeValue(raw[k]))
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("bind config %s: %w", c.prefix, err)
+	}
+	return cfg, nil
+}
+
+func (c *Center[T]) validate(cfg *T) error {
+	if v, ok := interface{}(cfg).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.validators {
+		if err := f(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeValue 解析JSON或YAML, 都无法解析时作为字符串
+func decodeValue(s string) interface{} {
+	var v interface{}
+	if json.Valid([]byte(s)) {
+		if err := json.Unmarshal([]byte(s), &v); err == nil {
+			return v
+		}
+	}
+	if err := yaml.Unmarshal([]byte(s), &v); err == nil && v != nil {
+		return v
+	}
+	return s
+}
+
+func setPath(tree map[string]interface{}, path []string, v interface{}) {
+	for _, p := range path[:len(path)-1] {
+		next, ok := tree[p].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			tree[p] = next
+		}
+		tree = next
+	}
+	tree[path[len(path)-1]] = v
+}
+
+// diffFields 比较两个配置, 返回变化的字段路径
+func diffFields(a, b interface{}) []string {
+	fa, fb := map[string]interface{}{}, map[string]interface{}{}
+	flatten("", toTree(a), fa)
+	flatten("", toTree(b), fb)
+	var changed []string
+	for k, v := range fa {
+		if w, ok := fb[k]; !ok || !reflect.DeepEqual(v, w) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range fb {
+		if _, ok := fa[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func toTree(v interface{}) interface{} {
+	b, _ := json.Marshal(v)
+	var t interface{}
+	_ = json.Unmarshal(b, &t)
+	return t
+}
+
+func flatten(prefix string, v interface{}, out map[string]interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		out[prefix] = v
+		return
+	}
+	for k, sub := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flatten(k, sub, out)
+	}
+}
+
+// Get 当前(最后一次校验通过的)配置, 不要修改返回值
+func (c *Center[T]) Get() *T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+// Revision 当前配置对应的etcd revision
+func (c *Center[T]) Revision() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rev
+}
+
+// DocRevision 配置文档的ModRevision, 作为 Save 的expectRev; 文档不存在时为0
+func (c *Center[T]) DocRevision() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.docRev
+}
+
+// LastError 最后一次加载失败的原因, 加载成功后清空
+func (c *Center[T]) LastError() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// Subscribe 订阅配置变化, 返回取消订阅的函数; 回调在监听协程中执行, 需尽快返回
+func (c *Center[T]) Subscribe(f SubscribeFunc[T]) (unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextSub
+	c.nextSub++
+	c.subs[id] = f
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subs, id)
+	}
+}
+
+// Save 校验并以CAS的方式写入整个配置文档(JSON), expectRev为 DocRevision, 被其他人修改过时返回 ErrConflict.
+// 返回新文档的revision; 子key中的字段仍会覆盖文档
+func (c *Center[T]) Save(ctx context.Context, cfg *T, expectRev int64) (int64, error) {
+	if err := c.validate(cfg); err != nil {
+		return 0, err
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return 0, err
+	}
+	return c.put(ctx, string(b), expectRev)
+}
+
+func (c *Center[T]) put(ctx context.Context, doc string, expectRev int64) (int64, error) {
+	res, err := c.client.Txn(ctx).IfModRevision(c.prefix, "=", expectRev).Then(etcdcli.OpPut(c.prefix, doc)).Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !res.Succeeded {
+		return 0, ErrConflict
+	}
+	return res.Revision, nil
+}
+
+// Rollback 将配置文档恢复为revision为rev时的内容, 作为新的版本写入; revision已被压缩时返回错误
+func (c *Center[T]) Rollback(ctx context.Context, rev int64) (int64, error) {
+	tctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
+	resp, err := c.client.RawClient().Get(tctx, c.prefix, etcdcli.WithRev(rev))
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, ErrRevisionNotFound
+	}
+	doc := string(resp.Kvs[0].Value)
+	// 校验历史版本, 避免回滚到无法使用的配置
+	cfg, err := c.decode(map[string]string{c.prefix: doc})
+	if err == nil {
+		err = c.validate(cfg)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("rollback to revision %d: %w", rev, err)
+	}
+	return c.put(ctx, doc, c.DocRevision())
+}
+
+// Close 停止监听, 可以在回调中调用
+func (c *Center[T]) Close() {
+	if c.watcher != nil {
+		c.watcher.Stop()
+	}
+}
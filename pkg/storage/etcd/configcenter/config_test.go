@@ -0,0 +1,117 @@
+package configcenter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/8xmx8/easier/pkg/storage/etcd"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type redisConf struct {
+	Addr string `json:"addr"`
+	DB   int    `json:"db"`
+}
+
+type testConf struct {
+	Name    string    `json:"name"`
+	Workers int       `json:"workers"`
+	Redis   redisConf `json:"redis"`
+	Tags    []string  `json:"tags"`
+}
+
+func (c *testConf) Validate() error {
+	if c.Workers <= 0 {
+		return errors.New("workers must be > 0")
+	}
+	return nil
+}
+
+func newTestCenter(ops ...Option[testConf]) *Center[testConf] {
+	ops = append([]Option[testConf]{WithDefault(testConf{Workers: 4, Redis: redisConf{Addr: "127.0.0.1:6379"}})}, ops...)
+	return newCenter("/config/svc/", zap.NewNop(), ops...)
+}
+
+func TestDecode(t *testing.T) {
+	c := newTestCenter()
+	err := c.load(map[string]string{
+		"/config/svc":          `{"name":"svc","redis":{"db":1}}`,
+		"/config/svc/redis/db": "2",
+		"/config/svc/tags":     "- a\n- b\n",
+	}, 10)
+	assert.NoError(t, err)
+	cfg := c.Get()
+	assert.Equal(t, "svc", cfg.Name)
+	assert.Equal(t, 4, cfg.Workers)                   // 默认值
+	assert.Equal(t, "127.0.0.1:6379", cfg.Redis.Addr) // 默认值
+	assert.Equal(t, 2, cfg.Redis.DB)                  // 子key覆盖文档
+	assert.Equal(t, []string{"a", "b"}, cfg.Tags)     // YAML
+	assert.Equal(t, int64(10), c.Revision())
+
+	// YAML文档
+	err = c.load(map[string]string{"/config/svc": "name: yaml\nworkers: 8\n"}, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "yaml", c.Get().Name)
+	assert.Equal(t, 8, c.Get().Workers)
+}
+
+func TestKeepLastGood(t *testing.T) {
+	c := newTestCenter(WithValidator(func(cfg *testConf) error {
+		if cfg.Name == "" {
+			return errors.New("name is required")
+		}
+		return nil
+	}))
+	assert.Error(t, c.load(map[string]string{"/config/svc": `{}`}, 1))
+	assert.Nil(t, c.Get())
+
+	assert.NoError(t, c.load(map[string]string{"/config/svc": `{"name":"a"}`}, 2))
+	assert.Error(t, c.load(map[string]string{"/config/svc": `{"name":"a","workers":0}`}, 3))
+	assert.Error(t, c.LastError())
+	assert.Equal(t, 4, c.Get().Workers)
+	assert.Equal(t, int64(2), c.Revision())
+
+	assert.Error(t, c.load(map[string]string{"/config/svc": `"not an object"`}, 4))
+	assert.Equal(t, "a", c.Get().Name)
+}
+
+func TestSubscribe(t *testing.T) {
+	c := newTestCenter()
+	assert.NoError(t, c.load(map[string]string{"/config/svc": `{"name":"a"}`}, 1))
+	var changed []string
+	unsubscribe := c.Subscribe(func(old, new *testConf, fields []string) {
+		assert.Equal(t, "a", old.Name)
+		changed = fields
+	})
+	assert.NoError(t, c.load(map[string]string{"/config/svc": `{"name":"b","redis":{"addr":"x"}}`}, 2))
+	assert.Equal(t, []string{"name", "redis.addr"}, changed)
+
+	changed = nil
+	assert.NoError(t, c.load(map[string]string{"/config/svc": `{"name":"b","redis":{"addr":"x"}}`}, 3))
+	assert.Nil(t, changed) // 没有变化时不通知
+
+	unsubscribe()
+	assert.NoError(t, c.load(map[string]string{"/config/svc": `{"name":"c"}`}, 4))
+	assert.Nil(t, changed)
+}
+
+func TestOwns(t *testing.T) {
+	c := newTestCenter()
+	assert.True(t, c.owns("/config/svc"))
+	assert.True(t, c.owns("/config/svc/redis"))
+	assert.False(t, c.owns("/config/svc2"))
+}
+
+func TestDocRevisionOnDelete(t *testing.T) {
+	c := newTestCenter()
+	c.onEvents(nil, []*etcd.UpdateEvent{{EventType: etcd.UpdateEventTypePut, Key: "/config/svc", Value: `{"name":"a"}`, Revision: 5}}, nil)
+	assert.Equal(t, int64(5), c.DocRevision())
+	// 删除子key不影响文档的revision
+	c.onEvents(nil, []*etcd.UpdateEvent{{EventType: etcd.UpdateEventTypeDelete, Key: "/config/svc/workers", Revision: 6}}, nil)
+	assert.Equal(t, int64(5), c.DocRevision())
+	// 文档删除后按不存在处理, Save 需使用0作为expectRev
+	c.onEvents(nil, []*etcd.UpdateEvent{{EventType: etcd.UpdateEventTypeDelete, Key: "/config/svc", Revision: 7}}, nil)
+	assert.Equal(t, int64(0), c.DocRevision())
+	assert.Equal(t, int64(7), c.Revision())
+}
@@ -0,0 +1,132 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinationInvalidParam(t *testing.T) {
+	cc := &Client{}
+	_, err := cc.NewSemaphore("/sem", 0).TryAcquire(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidParam)
+	assert.ErrorIs(t, cc.NewDoubleBarrier("/barrier", 0).Enter(context.Background()), ErrInvalidParam)
+	_, err = cc.NewCountDownLatch("/latch").Init(context.Background(), -1)
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
+
+func TestSemaphorePermits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cc := newFakeClient(ctx)
+	for _, permits := range []int{1, 3} {
+		s := cc.NewSemaphore(fmt.Sprintf("/sem/%d", permits), permits)
+		held := make([]*Permit, 0, permits)
+		for i := 0; i < permits; i++ {
+			p, err := s.TryAcquire(ctx)
+			if !assert.NoError(t, err, "permits=%d", permits) {
+				return
+			}
+			held = append(held, p)
+		}
+		_, err := s.TryAcquire(ctx)
+		assert.Equal(t, ErrNoPermit, err)
+		// 释放一个许可后可以再次获取
+		assert.NoError(t, held[0].Release(ctx))
+		p, err := s.TryAcquire(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, p.Release(ctx))
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestETCDCoordination(t *testing.T) {
+	ctx := context.Background()
+	cc, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cc.DeleteWithPrefix(ctx, prefix+"/coordination")
+
+	t.Run("barrier", func(t *testing.T) {
+		b := cc.NewBarrier(prefix + "/coordination/barrier")
+		defer b.Close()
+		assert.NoError(t, b.Hold(ctx))
+		assert.Equal(t, ErrBarrierHeld, b.Hold(ctx))
+		released := int32(0)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&released, 1)
+			assert.NoError(t, b.Release(ctx))
+		}()
+		assert.NoError(t, b.Wait(ctx))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&released))
+	})
+	t.Run("double_barrier", func(t *testing.T) {
+		var entered int32
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b := cc.NewDoubleBarrier(prefix+"/coordination/double", 3)
+				defer b.Close()
+				assert.NoError(t, b.Enter(ctx))
+				atomic.AddInt32(&entered, 1)
+				assert.NoError(t, b.Leave(ctx))
+				assert.Equal(t, int32(3), atomic.LoadInt32(&entered))
+			}()
+		}
+		wg.Wait()
+	})
+	t.Run("semaphore", func(t *testing.T) {
+		s := cc.NewSemaphore(prefix+"/coordination/sem", 2)
+		defer s.Close()
+		p1, err := s.TryAcquire(ctx)
+		assert.NoError(t, err)
+		_, err = s.TryAcquire(ctx)
+		assert.NoError(t, err)
+		_, err = s.TryAcquire(ctx)
+		assert.Equal(t, ErrNoPermit, err)
+		assert.NoError(t, p1.Release(ctx))
+		p3, err := s.TryAcquire(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// session过期后通知持有者
+		assert.NoError(t, s.Close())
+		select {
+		case <-p3.Lost():
+		case <-time.After(5 * time.Second):
+			t.Fatal("permit not lost")
+		}
+		assert.ErrorIs(t, p3.Release(ctx), ErrPermitLost)
+	})
+	t.Run("counter&latch", func(t *testing.T) {
+		c := cc.NewCounter(prefix + "/coordination/counter")
+		assert.NoError(t, c.Reset(ctx))
+		n, err := c.Add(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), n)
+		n, _ = c.Incr(ctx)
+		assert.Equal(t, int64(6), n)
+
+		l := cc.NewCountDownLatch(prefix + "/coordination/latch")
+		assert.NoError(t, l.Destroy(ctx))
+		ok, err := l.Init(ctx, 2)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		go func() {
+			for i := 0; i < 2; i++ {
+				_, err := l.CountDown(ctx)
+				assert.NoError(t, err)
+			}
+		}()
+		assert.NoError(t, l.Wait(ctx))
+	})
+}
@@ -0,0 +1,149 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+/*
+计数器:
+1. Counter: 原子计数器, 基于 Update 的CAS重试实现;
+2. CountDownLatch: 倒计时门闩, CountDown 减到0之后 Wait 返回; 计数持久化在etcd中, 进程崩溃不影响;
+*/
+
+var ErrLatchNotInit = errors.New("count down latch is not initialized")
+
+// Counter 分布式原子计数器
+type Counter struct {
+	cli *Client
+	key string
+}
+
+// NewCounter 创建计数器, key不存在时计数为0
+func (cli *Client) NewCounter(key string) *Counter {
+	return &Counter{cli: cli, key: key}
+}
+
+// Add 原子地增加delta(可以为负数), 返回增加后的值
+func (c *Counter) Add(ctx context.Context, delta int64) (int64, error) {
+	var n int64
+	_, err := c.cli.Update(ctx, c.key, func(old string, exists bool) (string, error) {
+		var err error
+		n, err = parseCount(old, exists)
+		if err != nil {
+			return "", err
+		}
+		n += delta
+		return strconv.FormatInt(n, 10), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Incr 原子地加1
+func (c *Counter) Incr(ctx context.Context) (int64, error) {
+	return c.Add(ctx, 1)
+}
+
+// Get 当前的值
+func (c *Counter) Get(ctx context.Context) (int64, error) {
+	tctx, cancel := context.WithTimeout(ctx, c.cli.timeout)
+	defer cancel()
+	resp, err := c.cli.cli.Get(tctx, c.key)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return parseCount(string(resp.Kvs[0].Value), true)
+}
+
+// Reset 重置为0
+func (c *Counter) Reset(ctx context.Context) error {
+	return c.cli.Delete(ctx, c.key)
+}
+
+func parseCount(v string, exists bool) (int64, error) {
+	if !exists {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// CountDownLatch 分布式倒计时门闩
+type CountDownLatch struct {
+	cli *Client
+	key string
+}
+
+// NewCountDownLatch 创建倒计时门闩, 需要由一个参与者调用 Init 设置初始计数
+func (cli *Client) NewCountDownLatch(key string) *CountDownLatch {
+	return &CountDownLatch{cli: cli, key: key}
+}
+
+// Init 设置初始计数, 已经初始化时返回false
+func (l *CountDownLatch) Init(ctx context.Context, count int64) (bool, error) {
+	if count < 0 {
+		return false, ErrInvalidParam
+	}
+	return l.cli.PutIfAbsent(ctx, l.key, strconv.FormatInt(count, 10))
+}
+
+// CountDown 计数减1, 已经为0时不变, 返回减1后的计数
+func (l *CountDownLatch) CountDown(ctx context.Context) (int64, error) {
+	var n int64
+	_, err := l.cli.Update(ctx, l.key, func(old string, exists bool) (string, error) {
+		if !exists {
+			return "", ErrLatchNotInit
+		}
+		var err error
+		if n, err = parseCount(old, exists); err != nil {
+			return "", err
+		}
+		if n > 0 {
+			n--
+		}
+		return strconv.FormatInt(n, 10), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Count 当前计数
+func (l *CountDownLatch) Count(ctx context.Context) (int64, error) {
+	return (&Counter{cli: l.cli, key: l.key}).Get(ctx)
+}
+
+// Wait 阻塞直到计数减到0; 未初始化时返回 ErrLatchNotInit
+func (l *CountDownLatch) Wait(ctx context.Context) error {
+	for {
+		resp, err := l.cli.cli.Get(ctx, l.key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return ErrLatchNotInit
+		}
+		n, err := parseCount(string(resp.Kvs[0].Value), true)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return nil
+		}
+		if err = waitEvent(ctx, l.cli.cli, l.key, resp.Header.Revision+1, anyEvent); err != nil {
+			return err
+		}
+	}
+}
+
+// Destroy 删除门闩
+func (l *CountDownLatch) Destroy(ctx context.Context) error {
+	return l.cli.Delete(ctx, l.key)
+}
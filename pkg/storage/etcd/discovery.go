@@ -0,0 +1,244 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+/*
+服务发现:
+1. Provider 以 <服务前缀>/<实例> 注册实例, value为实例地址(如 http://127.0.0.1:8080)或 Endpoint 的JSON(包含权重、版本、机房等元数据);
+2. Discovery 读取服务前缀下的全部实例并监听变化, 维护可用实例列表, 通过 Picker 选择实例;
+3. 在 Discovery 之上提供 net/http 的 RoundTripper(DiscoveryTransport)与 gRPC 的 resolver(NewResolverBuilder);
+*/
+
+var ErrNoEndpoint = errors.New("no available endpoint")
+
+// Endpoint 服务实例
+type Endpoint struct {
+	Key      string            `json:"-"`                  // 在etcd中注册的key
+	Addr     string            `json:"addr"`               // 实例地址, 如 http://127.0.0.1:8080 或 127.0.0.1:9090
+	Weight   int               `json:"weight,omitempty"`   // 权重, 默认为1
+	Version  string            `json:"version,omitempty"`  // 版本
+	Zone     string            `json:"zone,omitempty"`     // 机房/可用区
+	Metadata map[string]string `json:"metadata,omitempty"` // 其他元数据
+}
+
+// ParseEndpoint 解析注册的实例, value可以是 Endpoint 的JSON或实例地址
+func ParseEndpoint(key, value string) (*Endpoint, error) {
+	ep := &Endpoint{}
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		if err := json.Unmarshal([]byte(value), ep); err != nil {
+			return nil, err
+		}
+	} else {
+		ep.Addr = strings.TrimSpace(value)
+	}
+	if ep.Addr == "" {
+		return nil, errors.New("endpoint addr is empty")
+	}
+	ep.Key = key
+	return ep, nil
+}
+
+// String 注册到etcd的value, 可作为 NewProvider 的value
+func (ep *Endpoint) String() string {
+	b, _ := json.Marshal(ep)
+	return string(b)
+}
+
+// weight 权重, 未配置时为1
+func (ep *Endpoint) weight() int {
+	if ep.Weight <= 0 {
+		return 1
+	}
+	return ep.Weight
+}
+
+// HostPort 去掉协议后的地址
+func (ep *Endpoint) HostPort() string {
+	if u, err := url.Parse(ep.Addr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return ep.Addr
+}
+
+// Discovery 服务发现, 并发安全
+type Discovery struct {
+	prefix    string
+	picker    Picker
+	filter    func(ep *Endpoint) bool
+	watcher   *Watcher
+	logger    *zap.Logger
+	notifyMu  sync.Mutex // 保证监听者按顺序收到实例列表, 不持有mu回调监听者
+	mu        sync.RWMutex
+	endpoints map[string]*Endpoint
+	list      []*Endpoint // 按key排序
+	listeners []func(endpoints []*Endpoint)
+}
+
+type DiscoveryOptionFunc func(*Discovery)
+
+// DiscoveryWithPicker 配置选择实例的策略, 默认轮询
+func DiscoveryWithPicker(p Picker) DiscoveryOptionFunc {
+	return func(d *Discovery) {
+		d.picker = p
+	}
+}
+
+// DiscoveryWithFilter 只保留filter返回true的实例, 如指定版本或机房
+func DiscoveryWithFilter(filter func(ep *Endpoint) bool) DiscoveryOptionFunc {
+	return func(d *Discovery) {
+		d.filter = filter
+	}
+}
+
+func newDiscovery(prefix string, logger *zap.Logger, ops ...DiscoveryOptionFunc) *Discovery {
+	d := &Discovery{
+		prefix:    prefix,
+		picker:    NewRoundRobinPicker(),
+		logger:    logger,
+		endpoints: map[string]*Endpoint{},
+	}
+	for _, op := range ops {
+		op(d)
+	}
+	return d
+}
+
+// NewDiscovery 读取服务前缀下的全部实例, 并在后台监听变化直到ctx结束或调用 Close;
+// 服务前缀补全"/", 以免匹配到名称以其开头的其他服务(如 /services/user 与 /services/user-admin)
+func (cli *Client) NewDiscovery(ctx context.Context, prefix string, ops ...DiscoveryOptionFunc) (*Discovery, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	d := newDiscovery(prefix, cli.logger, ops...)
+	kvs, rev, err := cli.getKvsWithRevision(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	d.reset(kvs)
+	d.watcher, err = cli.NewWatcher(ctx, prefix, d.onEvents, WatcherWithRevision(rev), WatcherWithResync(d.onResync))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// getKvsWithRevision 读取前缀下的全部数据与读取时的revision
+func (cli *Client) getKvsWithRevision(ctx context.Context, prefix string) (map[string]string, int64, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, cli.timeout)
+	defer cancel()
+	resp, err := cli.cli.Get(timeoutCtx, prefix, etcdcli.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs, resp.Header.Revision, nil
+}
+
+func (d *Discovery) onEvents(w *Watcher, events []*UpdateEvent, err error) {
+	if err != nil {
+		d.logger.Warn("discovery watch", zap.Error(err), zap.String("prefix", d.prefix))
+		return
+	}
+	d.update(func() {
+		for _, ev := range events {
+			switch ev.EventType {
+			case UpdateEventTypePut:
+				d.put(ev.Key, ev.Value)
+			case UpdateEventTypeDelete:
+				delete(d.endpoints, ev.Key)
+			}
+		}
+	})
+}
+
+func (d *Discovery) onResync(w *Watcher, kvs map[string]string, rev int64) {
+	d.reset(kvs)
+}
+
+func (d *Discovery) reset(kvs map[string]string) {
+	d.update(func() {
+		d.endpoints = make(map[string]*Endpoint, len(kvs))
+		for k, v := range kvs {
+			d.put(k, v)
+		}
+	})
+}
+
+func (d *Discovery) put(key, value string) {
+	ep, err := ParseEndpoint(key, value)
+	if err != nil {
+		d.logger.Warn("invalid endpoint", zap.Error(err), zap.String("key", key))
+		delete(d.endpoints, key)
+		return
+	}
+	if d.filter != nil && !d.filter(ep) {
+		delete(d.endpoints, key)
+		return
+	}
+	d.endpoints[key] = ep
+}
+
+// update 持有写锁执行modify修改实例, 重建实例列表并通知picker, 释放写锁后通知监听者
+func (d *Discovery) update(modify func()) {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	d.mu.Lock()
+	modify()
+	list := make([]*Endpoint, 0, len(d.endpoints))
+	for _, ep := range d.endpoints {
+		list = append(list, ep)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	d.list = list
+	d.picker.Update(list)
+	listeners := append([]func(endpoints []*Endpoint){}, d.listeners...)
+	d.mu.Unlock()
+	for _, f := range listeners {
+		f(list)
+	}
+}
+
+// Endpoints 当前可用的实例, 按key排序, 不要修改返回值
+func (d *Discovery) Endpoints() []*Endpoint {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.list
+}
+
+// Pick 通过picker选择一个实例, key用于一致性哈希, 其他策略忽略
+func (d *Discovery) Pick(key string) (*Endpoint, error) {
+	return d.picker.Pick(key)
+}
+
+// OnChange 注册实例列表变化的回调, 注册时立即回调一次当前列表;
+// 回调中可以调用 Endpoints、Pick, 不能调用 OnChange
+func (d *Discovery) OnChange(f func(endpoints []*Endpoint)) {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	d.mu.Lock()
+	d.listeners = append(d.listeners, f)
+	list := d.list
+	d.mu.Unlock()
+	f(list)
+}
+
+// Close 停止监听, 可以在回调中调用
+func (d *Discovery) Close() {
+	if d.watcher != nil {
+		d.watcher.Stop()
+	}
+}
@@ -0,0 +1,167 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func testEndpoints(n int) []*Endpoint {
+	eps := make([]*Endpoint, 0, n)
+	for i := 0; i < n; i++ {
+		eps = append(eps, &Endpoint{Key: fmt.Sprintf("/svc/%d", i), Addr: fmt.Sprintf("127.0.0.1:%d", 8000+i)})
+	}
+	return eps
+}
+
+func TestParseEndpoint(t *testing.T) {
+	ep, err := ParseEndpoint("/svc/a", "http://127.0.0.1:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", ep.HostPort())
+	assert.Equal(t, 1, ep.weight())
+
+	src := &Endpoint{Addr: "127.0.0.1:9090", Weight: 3, Version: "v2", Zone: "sh"}
+	ep, err = ParseEndpoint("/svc/b", src.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "/svc/b", ep.Key)
+	assert.Equal(t, 3, ep.Weight)
+	assert.Equal(t, "v2", ep.Version)
+	assert.Equal(t, "127.0.0.1:9090", ep.HostPort())
+
+	_, err = ParseEndpoint("/svc/c", "")
+	assert.Error(t, err)
+}
+
+func TestPickers(t *testing.T) {
+	for name, p := range map[string]Picker{
+		"round_robin":     NewRoundRobinPicker(),
+		"weighted_random": NewWeightedRandomPicker(),
+		"consistent_hash": NewConsistentHashPicker(0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := p.Pick("a")
+			assert.Equal(t, ErrNoEndpoint, err)
+			p.Update(testEndpoints(3))
+			ep, err := p.Pick("a")
+			assert.NoError(t, err)
+			assert.NotNil(t, ep)
+		})
+	}
+}
+
+func TestRoundRobinPicker(t *testing.T) {
+	p := NewRoundRobinPicker()
+	eps := testEndpoints(3)
+	p.Update(eps)
+	for i := 0; i < 6; i++ {
+		ep, _ := p.Pick("")
+		assert.Equal(t, eps[i%3], ep)
+	}
+}
+
+func TestWeightedRandomPicker(t *testing.T) {
+	p := NewWeightedRandomPicker()
+	eps := testEndpoints(2)
+	eps[1].Weight = 9
+	p.Update(eps)
+	counts := map[*Endpoint]int{}
+	for i := 0; i < 10000; i++ {
+		ep, _ := p.Pick("")
+		counts[ep]++
+	}
+	assert.InDelta(t, 1000, counts[eps[0]], 300)
+	assert.InDelta(t, 9000, counts[eps[1]], 300)
+}
+
+func TestConsistentHashPicker(t *testing.T) {
+	p := NewConsistentHashPicker(0)
+	eps := testEndpoints(4)
+	p.Update(eps)
+	before := map[string]*Endpoint{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		ep, _ := p.Pick(key)
+		again, _ := p.Pick(key)
+		assert.Equal(t, ep, again)
+		before[key] = ep
+	}
+	// 删除一个实例, 只有原来在该实例上的key迁移
+	p.Update(eps[:3])
+	for key, old := range before {
+		ep, _ := p.Pick(key)
+		if old != eps[3] {
+			assert.Equal(t, old, ep, key)
+		}
+	}
+}
+
+func TestDiscoveryTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	d := newDiscovery("/svc", zap.NewNop())
+	var changed []*Endpoint
+	d.OnChange(func(endpoints []*Endpoint) { changed = endpoints })
+	cli := &http.Client{Transport: &DiscoveryTransport{Discovery: d}}
+	_, err := cli.Get("http://user-service/hello")
+	assert.ErrorIs(t, err, ErrNoEndpoint)
+
+	d.reset(map[string]string{"/svc/a": srv.URL, "/svc/b": "127.0.0.1:1"})
+	assert.Len(t, changed, 2)
+	d.onEvents(nil, []*UpdateEvent{{Key: "/svc/b", EventType: UpdateEventTypeDelete}}, nil)
+	assert.Len(t, d.Endpoints(), 1)
+	assert.Equal(t, changed, d.Endpoints())
+
+	resp, err := cli.Get("http://user-service/hello")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "/hello", string(body))
+}
+
+func TestDiscoveryFilter(t *testing.T) {
+	d := newDiscovery("/svc", zap.NewNop(), DiscoveryWithFilter(func(ep *Endpoint) bool { return ep.Zone == "sh" }))
+	d.reset(map[string]string{
+		"/svc/a": (&Endpoint{Addr: "127.0.0.1:1", Zone: "sh"}).String(),
+		"/svc/b": (&Endpoint{Addr: "127.0.0.1:2", Zone: "bj"}).String(),
+	})
+	eps := d.Endpoints()
+	if assert.Len(t, eps, 1) {
+		assert.Equal(t, "/svc/a", eps[0].Key)
+	}
+}
+
+func TestDiscoverySiblingService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cc := newFakeClient(ctx)
+	_, _ = cc.cli.Put(ctx, "/svc/user/1", "127.0.0.1:1")
+	_, _ = cc.cli.Put(ctx, "/svc/user-admin/1", "127.0.0.1:2")
+	_, _ = cc.cli.Put(ctx, "/svc/users/1", "127.0.0.1:3")
+
+	// 名称以user开头的其他服务不属于user
+	d, err := cc.NewDiscovery(ctx, "/svc/user")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer d.Close()
+	eps := d.Endpoints()
+	if assert.Len(t, eps, 1) {
+		assert.Equal(t, "/svc/user/1", eps[0].Key)
+	}
+	assert.Eventually(t, func() bool {
+		keys := cc.cli.Watcher.(*fakeWatcher).watched()
+		return len(keys) == 1 && keys[0] == "/svc/user/"
+	}, time.Second, 10*time.Millisecond)
+}
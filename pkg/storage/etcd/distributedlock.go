@@ -2,49 +2,351 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdcli "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
 )
 
+/*
+分布式锁:
+1. LockManager 中的所有锁共享同一个session(租约), session过期(如网络分区超过TTL)时所有持有的锁都会丢失,
+   通过 Lock.Lost() 通知持有者, 之后加锁会自动创建新的session;
+2. 每次加锁在 /lock/<key>/ 下写入一个绑定租约的唯一key, 按创建版本号排队:
+   写锁(互斥锁)需等待所有更早的key被删除, 读锁只需等待更早的写锁被删除;
+3. 支持阻塞加锁、TryLock(锁被占用时立即返回 ErrLocked)与超时加锁(超时返回 ErrLockTimeout);
+*/
+
 const (
-	lockPrefix = "/lock/%s" // /lock/<lockID>
+	lockPrefix     = "/lock/%s" // /lock/<lockID>
+	defaultLockTTL = 60         // session的默认TTL, 单位秒
 )
 
-func (cli *Client) Lock(ctx context.Context, key string) error {
-	lock, isExist := cli.locks[key]
-	if !isExist {
-		sess, err := concurrency.NewSession(cli.cli)
+var (
+	ErrLocked      = errors.New("lock is held by another session")
+	ErrLockTimeout = errors.New("lock timeout")
+	ErrLockLost    = errors.New("lock lost because session expired")
+)
+
+type lockMode string
+
+const (
+	lockWrite lockMode = "write"
+	lockRead  lockMode = "read"
+)
+
+// Lock 已持有的锁
+type Lock struct {
+	manager *LockManager
+	key     string // 用户传入的key
+	myKey   string // 在etcd中排队的key
+	sess    *concurrency.Session
+	lost    chan struct{}
+	once    sync.Once
+}
+
+// Key 锁的key
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Lost 锁因session过期而丢失时关闭
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock 释放锁; 锁已丢失时返回 ErrLockLost
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.manager.forget(l)
+	select {
+	case <-l.lost:
+		return ErrLockLost
+	default:
+	}
+	tctx, cancel := context.WithTimeout(ctx, l.manager.cli.timeout)
+	defer cancel()
+	_, err := l.manager.cli.cli.Delete(tctx, l.myKey)
+	return err
+}
+
+func (l *Lock) markLost() {
+	l.once.Do(func() { close(l.lost) })
+}
+
+// LockManager 分布式锁管理器, 并发安全
+type LockManager struct {
+	cli    *Client
+	ttl    int
+	logger *zap.Logger
+	seq    uint64
+
+	mu   sync.Mutex
+	sess *concurrency.Session
+	held map[*Lock]struct{}
+}
+
+type LockOptionFunc func(*LockManager)
+
+// LockWithTTL 配置session的TTL(秒), 持有者崩溃后锁最多保留TTL秒, 默认60秒
+func LockWithTTL(ttl int) LockOptionFunc {
+	return func(m *LockManager) {
+		if ttl > 0 {
+			m.ttl = ttl
+		}
+	}
+}
+
+// NewLockManager 创建分布式锁管理器, 首次加锁时创建session
+func (cli *Client) NewLockManager(ops ...LockOptionFunc) *LockManager {
+	m := &LockManager{
+		cli:    cli,
+		ttl:    defaultLockTTL,
+		logger: cli.logger,
+		held:   make(map[*Lock]struct{}),
+	}
+	for _, op := range ops {
+		op(m)
+	}
+	return m
+}
+
+// session 获取共享的session, 不存在或已过期时重新创建
+func (m *LockManager) session() (*concurrency.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sess != nil {
+		select {
+		case <-m.sess.Done():
+		default:
+			return m.sess, nil
+		}
+	}
+	sess, err := concurrency.NewSession(m.cli.cli, concurrency.WithTTL(m.ttl))
+	if err != nil {
+		return nil, err
+	}
+	m.sess = sess
+	go m.monitor(sess)
+	return sess, nil
+}
+
+// monitor session过期时通知该session持有的所有锁
+func (m *LockManager) monitor(sess *concurrency.Session) {
+	<-sess.Done()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sess == sess {
+		m.sess = nil
+	}
+	for l := range m.held {
+		if l.sess == sess {
+			m.logger.Warn("分布式锁丢失", zap.String("key", l.key))
+			l.markLost()
+			delete(m.held, l)
+		}
+	}
+}
+
+func (m *LockManager) forget(l *Lock) {
+	m.mu.Lock()
+	delete(m.held, l)
+	m.mu.Unlock()
+}
+
+// Close 关闭session, 释放所有持有的锁
+func (m *LockManager) Close() error {
+	m.mu.Lock()
+	sess := m.sess
+	m.sess = nil
+	m.mu.Unlock()
+	if sess == nil {
+		return nil
+	}
+	return sess.Close()
+}
+
+// Lock 阻塞直到获取写锁(互斥锁)或ctx结束
+func (m *LockManager) Lock(ctx context.Context, key string) (*Lock, error) {
+	return m.acquire(ctx, key, lockWrite, true)
+}
+
+// TryLock 尝试获取写锁, 锁被占用时返回 ErrLocked
+func (m *LockManager) TryLock(ctx context.Context, key string) (*Lock, error) {
+	return m.acquire(ctx, key, lockWrite, false)
+}
+
+// LockWithTimeout 在timeout内获取写锁, 超时返回 ErrLockTimeout
+func (m *LockManager) LockWithTimeout(ctx context.Context, key string, timeout time.Duration) (*Lock, error) {
+	return m.acquireWithTimeout(ctx, key, lockWrite, timeout)
+}
+
+// RLock 阻塞直到获取读锁或ctx结束, 多个读锁可以同时持有
+func (m *LockManager) RLock(ctx context.Context, key string) (*Lock, error) {
+	return m.acquire(ctx, key, lockRead, true)
+}
+
+// TryRLock 尝试获取读锁, 写锁被占用或有更早的写锁在排队时返回 ErrLocked
+func (m *LockManager) TryRLock(ctx context.Context, key string) (*Lock, error) {
+	return m.acquire(ctx, key, lockRead, false)
+}
+
+// RLockWithTimeout 在timeout内获取读锁, 超时返回 ErrLockTimeout
+func (m *LockManager) RLockWithTimeout(ctx context.Context, key string, timeout time.Duration) (*Lock, error) {
+	return m.acquireWithTimeout(ctx, key, lockRead, timeout)
+}
+
+func (m *LockManager) acquireWithTimeout(ctx context.Context, key string, mode lockMode, timeout time.Duration) (*Lock, error) {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	l, err := m.acquire(tctx, key, mode, true)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, ErrLockTimeout
+	}
+	return l, err
+}
+
+func (m *LockManager) acquire(ctx context.Context, key string, mode lockMode, block bool) (*Lock, error) {
+	sess, err := m.session()
+	if err != nil {
+		return nil, err
+	}
+	pfx := fmt.Sprintf(lockPrefix, key) + "/"
+	l := &Lock{
+		manager: m,
+		key:     key,
+		myKey:   fmt.Sprintf("%s%s/%x-%d", pfx, mode, sess.Lease(), atomic.AddUint64(&m.seq, 1)),
+		sess:    sess,
+		lost:    make(chan struct{}),
+	}
+	resp, err := m.cli.cli.Put(ctx, l.myKey, m.cli.nodeName, etcdcli.WithLease(sess.Lease()))
+	if err != nil {
+		return nil, err
+	}
+	myRev := resp.Header.Revision
+	// 写锁等待所有更早的key, 读锁只等待更早的写锁
+	waitPfx := pfx
+	if mode == lockRead {
+		waitPfx = pfx + string(lockWrite) + "/"
+	}
+	if err = waitLockDeletes(ctx, m.cli.cli, waitPfx, myRev-1, block); err != nil {
+		// 使用新的ctx删除, ctx可能已经结束
+		dctx, cancel := context.WithTimeout(context.Background(), m.cli.timeout)
+		defer cancel()
+		if _, derr := m.cli.cli.Delete(dctx, l.myKey); derr != nil {
+			m.logger.Warn("删除排队的锁失败", zap.String("key", l.myKey), zap.Error(derr))
+		}
+		return nil, err
+	}
+	if !m.hold(l) {
+		return nil, ErrLockLost
+	}
+	return l, nil
+}
+
+// hold 记录持有的锁, session过期时通过 Lock.Lost() 通知; session已过期时返回false
+func (m *LockManager) hold(l *Lock) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-l.sess.Done():
+		return false
+	default:
+	}
+	m.held[l] = struct{}{}
+	return true
+}
+
+// waitLockDeletes 等待pfx下所有创建版本号不大于maxCreateRev的key被删除; block为false时存在这样的key则返回 ErrLocked
+func waitLockDeletes(ctx context.Context, cli *etcdcli.Client, pfx string, maxCreateRev int64, block bool) error {
+	opts := append(etcdcli.WithLastCreate(), etcdcli.WithPrefix(), etcdcli.WithMaxCreateRev(maxCreateRev))
+	for {
+		resp, err := cli.Get(ctx, pfx, opts...)
 		if err != nil {
 			return err
 		}
-		lock = concurrency.NewMutex(sess, fmt.Sprintf(lockPrefix, key))
-		cli.locks[key] = lock
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+		if !block {
+			return ErrLocked
+		}
+		if err = waitKeyDelete(ctx, cli, string(resp.Kvs[0].Key), resp.Header.Revision+1); err != nil {
+			return err
+		}
 	}
-	if err := lock.Lock(ctx); err != nil {
+}
+
+// waitKeyDelete 从rev开始监听, 直到key被删除
+func waitKeyDelete(ctx context.Context, cli *etcdcli.Client, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wr := range cli.Watch(wctx, key, etcdcli.WithRev(rev)) {
+		if err := wr.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wr.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("lost watcher waiting for delete")
+}
+
+// Lock 使用客户端默认的锁管理器获取写锁, 需使用 UnLock 释放
+func (cli *Client) Lock(ctx context.Context, key string) error {
+	l, err := cli.lockManager.Lock(ctx, key)
+	if err != nil {
 		return err
 	}
-	// cli.logger.Info("分布式锁-lock", zap.String("key", key))
+	cli.locksMu.Lock()
+	cli.locks[key] = l
+	cli.locksMu.Unlock()
+	return nil
+}
+
+// TryLock 使用客户端默认的锁管理器尝试获取写锁, 锁被占用时返回 ErrLocked
+func (cli *Client) TryLock(ctx context.Context, key string) error {
+	l, err := cli.lockManager.TryLock(ctx, key)
+	if err != nil {
+		return err
+	}
+	cli.locksMu.Lock()
+	cli.locks[key] = l
+	cli.locksMu.Unlock()
 	return nil
 }
 
 func (cli *Client) UnLock(ctx context.Context, key string) error {
-	lock, isExist := cli.locks[key]
+	cli.locksMu.Lock()
+	l, isExist := cli.locks[key]
+	delete(cli.locks, key)
+	cli.locksMu.Unlock()
 	if !isExist {
 		return fmt.Errorf("[%s]不存在的锁", key)
 	}
-	if err := lock.Unlock(ctx); err != nil {
-		return err
-	}
-	// cli.logger.Info("分布式锁-Unlock", zap.String("key", key))
-	return nil
+	return l.Unlock(ctx)
 }
 
+// DestroyLock 释放并删除锁, 锁不存在时忽略
 func (cli *Client) DestroyLock(ctx context.Context, key string) {
-	_, isExist := cli.locks[key]
+	cli.locksMu.Lock()
+	l, isExist := cli.locks[key]
+	delete(cli.locks, key)
+	cli.locksMu.Unlock()
 	if !isExist {
 		return
 	}
-	// cli.logger.Info("分布式锁-Destroy", zap.String("key", key))
-	delete(cli.locks, key)
+	if err := l.Unlock(ctx); err != nil {
+		cli.logger.Warn("释放分布式锁失败", zap.String("key", key), zap.Error(err))
+	}
 }
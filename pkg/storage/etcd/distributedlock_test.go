@@ -3,6 +3,7 @@ package etcd
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
@@ -42,3 +43,29 @@ func (s *MainLockSuite) Test_Lock() {
 		})
 	})
 }
+
+func (s *MainLockSuite) Test_LockManager() {
+	m := s.etcd.NewLockManager(LockWithTTL(5))
+	defer m.Close()
+	convey.Convey("Test_LockManager", s.T(), func() {
+		convey.Convey("TryLock", func() {
+			l, err := m.TryLock(s.ctx, "manager")
+			convey.So(err, convey.ShouldBeNil)
+			_, err = m.TryLock(s.ctx, "manager")
+			convey.So(err, convey.ShouldEqual, ErrLocked)
+			_, err = m.LockWithTimeout(s.ctx, "manager", 100*time.Millisecond)
+			convey.So(err, convey.ShouldEqual, ErrLockTimeout)
+			convey.So(l.Unlock(s.ctx), convey.ShouldBeNil)
+		})
+		convey.Convey("RLock", func() {
+			r1, err := m.TryRLock(s.ctx, "manager")
+			convey.So(err, convey.ShouldBeNil)
+			r2, err := m.TryRLock(s.ctx, "manager")
+			convey.So(err, convey.ShouldBeNil)
+			_, err = m.TryLock(s.ctx, "manager")
+			convey.So(err, convey.ShouldEqual, ErrLocked)
+			convey.So(r1.Unlock(s.ctx), convey.ShouldBeNil)
+			convey.So(r2.Unlock(s.ctx), convey.ShouldBeNil)
+		})
+	})
+}
@@ -3,89 +3,342 @@ package etcd
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"go.uber.org/zap"
 )
 
-// ElectionFunc 选举成功之后会回调这个函数，只会回调一次.
+/*
+选举:
+1. 以节点名称(Client.WithNodeName, 需在集群内唯一)作为竞选值, 竞选成功后成为leader直到主动退出、Resign或session过期;
+2. session过期(如网络分区超过Timeout)时立即退位, 并使用新的session重新竞选;
+3. 自身leader状态的变化通过 Leadership() 的channel与 ElectionFunc 回调通知, Observe 可以观察集群的leader变化;
+*/
+
+const (
+	defaultElectionTimeout = 10              // session的默认TTL, 单位秒
+	electionRetryInterval  = time.Second     // 竞选失败后的重试间隔
+	leadershipBufferSize   = 16              // Leadership channel的缓冲大小
+	resignTimeout          = 5 * time.Second // 退位的超时时间
+)
+
+var ErrSessionLost = errors.New("election session expired")
+
+// ElectionFunc 自身leader状态变化时回调; 回调在单独的协程中按顺序执行, 可以在回调中调用 Resign 与 Destroy,
+// 因此 Destroy 返回时最后一次回调可能尚未执行完.
 type ElectionFunc func(ctx context.Context, e *Election, isLeader bool, err error)
 
 type ElectionConfig struct {
-	Timeout int `json:"timeout"`
+	Timeout int `json:"timeout"` // session的TTL(秒), leader掉线后其他节点最多等待Timeout秒重新选举
+}
+
+// LeadershipEvent leader变化事件
+type LeadershipEvent struct {
+	Leader   string // 当前leader的节点名称, 没有leader时为空
+	IsLeader bool   // 当前节点是否为leader
+	Err      error  // 退位的原因, 如 ErrSessionLost
+}
+
+// resignRequest 退位请求, term为发起请求时的任期, 任期已变化时不退位
+type resignRequest struct {
+	term uint64
+	errC chan error
 }
 
-// Election 用来进行选举，如果成功，就会通过回调函数返回选举成功，默认情况下，当前节点不是主节点.
+// Election 用来进行选举，默认情况下，当前节点不是主节点.
 type Election struct { // nolint
 	key      string
 	nodeName string
 	conf     *ElectionConfig
-	cs       *concurrency.Session
-	ele      *concurrency.Election
+	client   *Client
 	callback ElectionFunc
 	logger   *zap.Logger
 	cancel   func()
-}
+	done     chan struct{}
 
-func (e *Election) Start(ctx context.Context) (err error) {
-	// 重置选举状态
-	e.callback(ctx, e, false, errors.New("重新选举"))
-	e.monitor(ctx)
+	term    atomic.Uint64 // 当前的任期, 每次成为leader时加1, 不是leader时为0
+	terms   uint64        // 已经历的任期数, 只在竞选循环中访问
+	resignC chan resignRequest
+	events  chan LeadershipEvent
+	lastCb  chan struct{} // 上一次回调结束时关闭, 只在竞选循环中访问
 
+	mu  sync.Mutex
+	cs  *concurrency.Session
+	ele *concurrency.Election
+}
+
+// Start 在后台开始竞选, 直到ctx结束或调用 Destroy
+func (e *Election) Start(ctx context.Context) error {
+	if _, err := e.session(); err != nil {
+		return err
+	}
+	go e.run(ctx)
 	return nil
 }
 
-// monitor leader监视器
-func (e *Election) monitor(ctx context.Context) {
-	for {
-		// 判断是否有leader
-		isloader, noLeader, err := e.leader(ctx)
+// run 竞选循环
+func (e *Election) run(ctx context.Context) {
+	defer close(e.done)
+	defer close(e.events)
+	for ctx.Err() == nil {
+		// 竞选期间不是leader, 直接响应退位请求; 成为leader前等待响应结束
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			e.rejectResign(stop)
+		}()
+		cs, ele, err := e.follow(ctx)
+		close(stop)
+		<-stopped
 		if err != nil {
-			e.logger.Warn("leader err", zap.Error(err))
-			e.callback(ctx, e, false, err) // 不是leader的回调
 			continue
 		}
-		if noLeader { // 没有leader,发起选举请求
-			e.callback(ctx, e, false, nil)
-			if err := e.elect(ctx); err != nil {
-				e.logger.Warn("elect err", zap.Error(err))
+		e.setLeader(ctx, true, nil)
+		e.lead(ctx, cs, ele)
+	}
+}
+
+// follow 阻塞直到成为leader, 失败时等待重试间隔后返回错误
+func (e *Election) follow(ctx context.Context) (*concurrency.Session, *concurrency.Election, error) {
+	cs, err := e.session()
+	if err != nil {
+		e.logger.Warn("create election session", zap.Error(err), zap.String("key", e.key))
+		e.sleep(ctx, electionRetryInterval)
+		return nil, nil, err
+	}
+	ele := e.election()
+	// Campaign不感知session过期, session过期时取消竞选
+	if err := e.campaign(ctx, cs, ele); err != nil {
+		if ctx.Err() == nil {
+			e.logger.Warn("campaign", zap.Error(err), zap.String("key", e.key))
+			e.sleep(ctx, electionRetryInterval)
+		}
+		return nil, nil, err
+	}
+	return cs, ele, nil
+}
+
+// lead 保持leader直到ctx结束、session过期或退位
+func (e *Election) lead(ctx context.Context, cs *concurrency.Session, ele *concurrency.Election) {
+	term := e.term.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			e.resign(ele)
+			e.setLeader(ctx, false, ctx.Err())
+			return
+		case <-cs.Done():
+			e.logger.Warn("election session lost, step down", zap.String("key", e.key))
+			e.setLeader(ctx, false, ErrSessionLost)
+			return
+		case req := <-e.resignC:
+			if req.term != term { // 发起请求时的任期已结束
+				req.errC <- nil
+				continue
 			}
-			continue
+			err := e.resign(ele)
+			e.setLeader(ctx, false, nil)
+			req.errC <- err
+			return
+		}
+	}
+}
+
+// rejectResign 直接响应退位请求, 直到stop关闭
+func (e *Election) rejectResign(stop <-chan struct{}) {
+	for {
+		select {
+		case req := <-e.resignC:
+			req.errC <- nil
+		case <-stop:
+			return
 		}
-		if isloader {
-			e.callback(ctx, e, true, nil)
-		} else {
-			e.callback(ctx, e, false, nil)
+	}
+}
+
+func (e *Election) campaign(ctx context.Context, cs *concurrency.Session, ele *concurrency.Election) error {
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-cs.Done():
+			cancel()
+		case <-cctx.Done():
+		}
+	}()
+	if err := ele.Campaign(cctx, e.nodeName); err != nil {
+		return err
+	}
+	select {
+	case <-cs.Done():
+		return ErrSessionLost
+	default:
+		return nil
+	}
+}
+
+// session 获取当前session, 不存在或已过期时重新创建
+func (e *Election) session() (*concurrency.Session, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cs != nil {
+		select {
+		case <-e.cs.Done():
+		default:
+			return e.cs, nil
 		}
 	}
+	cs, err := concurrency.NewSession(e.client.cli, concurrency.WithTTL(e.conf.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	e.cs, e.ele = cs, concurrency.NewElection(cs, e.key)
+	return cs, nil
+}
+
+func (e *Election) election() *concurrency.Election {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ele
 }
 
-// elect 选举
-func (e *Election) elect(ctx context.Context) error {
-	// 添加超时时间
-	tctx, cancel := context.WithTimeout(ctx, time.Duration(e.conf.Timeout)*time.Second)
+// resign 退位, ctx可能已经结束, 使用新的ctx
+func (e *Election) resign(ele *concurrency.Election) error {
+	tctx, cancel := context.WithTimeout(context.Background(), resignTimeout)
 	defer cancel()
-	return e.ele.Campaign(tctx, e.key)
+	err := ele.Resign(tctx)
+	if err != nil {
+		e.logger.Warn("resign", zap.Error(err), zap.String("key", e.key))
+	}
+	return err
 }
 
-// 查询leader的信息
-func (e *Election) leader(ctx context.Context) (isLeader, noLeader bool, err error) {
-	res, err := e.ele.Leader(ctx)
+// setLeader 更新leader状态, 并通知回调与Leadership channel
+func (e *Election) setLeader(ctx context.Context, isLeader bool, err error) {
+	if isLeader {
+		e.terms++
+		e.term.Store(e.terms)
+	} else {
+		e.term.Store(0)
+	}
+	ev := LeadershipEvent{IsLeader: isLeader, Err: err}
+	if isLeader {
+		ev.Leader = e.nodeName
+	}
+	// channel已满时丢弃最旧的事件, 保证最新状态可以送达
+	for {
+		select {
+		case e.events <- ev:
+			e.notify(ctx, isLeader, err)
+			return
+		default:
+		}
+		select {
+		case <-e.events:
+		default:
+		}
+	}
+}
+
+// notify 在单独的协程中执行回调, 等待上一次回调结束以保证顺序; 竞选循环不等待回调, 回调中可以退位或停止选举
+func (e *Election) notify(ctx context.Context, isLeader bool, err error) {
+	if e.callback == nil {
+		return
+	}
+	prev, cur := e.lastCb, make(chan struct{})
+	e.lastCb = cur
+	go func() {
+		defer close(cur)
+		if prev != nil {
+			<-prev
+		}
+		e.callback(ctx, e, isLeader, err)
+	}()
+}
+
+func (e *Election) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// IsLeader 当前节点是否为leader
+func (e *Election) IsLeader() bool {
+	return e.term.Load() != 0
+}
+
+// Leadership 自身leader状态变化的事件, 选举停止时关闭; 只适合单个消费者
+func (e *Election) Leadership() <-chan LeadershipEvent {
+	return e.events
+}
+
+// Leader 查询当前leader的节点名称
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	tctx, cancel := context.WithTimeout(ctx, e.client.timeout)
+	defer cancel()
+	res, err := e.election().Leader(tctx)
 	if err != nil {
-		if err == concurrency.ErrElectionNoLeader { // 没有leader
-			return false, true, nil
+		if errors.Is(err, concurrency.ErrElectionNoLeader) {
+			return "", nil
 		}
-		return false, false, err // 单纯报错
+		return "", err
+	}
+	return string(res.Kvs[0].Value), nil
+}
+
+// Observe 观察集群的leader变化, ctx结束时关闭channel
+func (e *Election) Observe(ctx context.Context) <-chan LeadershipEvent {
+	out := make(chan LeadershipEvent)
+	go func() {
+		defer close(out)
+		for res := range e.election().Observe(ctx) {
+			if len(res.Kvs) == 0 {
+				continue
+			}
+			leader := string(res.Kvs[0].Value)
+			select {
+			case out <- LeadershipEvent{Leader: leader, IsLeader: leader == e.nodeName && e.IsLeader()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Resign 当前节点是leader时退位并重新排队竞选, 其他节点优先成为leader;
+// 调用后已不是leader(如session过期)时直接返回, 不会退位之后重新竞选得到的leader
+func (e *Election) Resign(ctx context.Context) error {
+	term := e.term.Load()
+	if term == 0 {
+		return nil
+	}
+	req := resignRequest{term: term, errC: make(chan error, 1)}
+	select {
+	case e.resignC <- req:
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if string(res.Kvs[0].Value) == e.nodeName {
-		return true, false, nil
+	select {
+	case err := <-req.errC:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return false, false, nil
 }
 
-// Stop 停止进行选举.
+// Destroy 停止选举, 是leader时退位.
 func (e *Election) Destroy() {
+	e.cancel()
+	<-e.done
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if e.cs != nil {
 		e.cs.Close()
 	}
@@ -2,10 +2,11 @@ package etcd
 
 import (
 	"context"
-	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 const (
@@ -15,10 +16,87 @@ const (
 func TestETCDElection(t *testing.T) {
 	ctx := context.Background()
 	// new ETCD client
-	cc, err := NewETCD(ctx, []string{host})
+	c1, err := NewETCD(ctx, []string{host})
 	if !assert.NoError(t, err) {
 		return
 	}
-	// TODO: 未完, 后期再补
-	fmt.Println(cc)
+	defer c1.Close()
+	c2, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c2.Close()
+
+	e1, err := c1.WithNodeName("node-1").NewElection(ctx, serviceName, &ElectionConfig{Timeout: 5}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ev := <-e1.Leadership()
+	assert.True(t, ev.IsLeader)
+	assert.True(t, e1.IsLeader())
+
+	e2, err := c2.WithNodeName("node-2").NewElection(ctx, serviceName, &ElectionConfig{Timeout: 5}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	leader, err := e2.Leader(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", leader)
+	assert.False(t, e2.IsLeader())
+
+	// node-1 退位后 node-2 成为leader
+	assert.NoError(t, e1.Resign(ctx))
+	select {
+	case ev = <-e2.Leadership():
+		assert.True(t, ev.IsLeader)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node-2 not elected")
+	}
+	assert.False(t, e1.IsLeader())
+}
+
+func TestElectionResignNotLeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := &Election{resignC: make(chan resignRequest), done: make(chan struct{})}
+	assert.NoError(t, e.Resign(ctx))
+
+	// 发起退位时是leader, 随后session过期重新竞选: 直接返回, 不等待ctx结束
+	e.term.Store(1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.rejectResign(stop)
+	assert.NoError(t, e.Resign(ctx))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestElectionCallbackResign(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resigned := make(chan error, 1)
+	e := &Election{
+		key:      "/election/callback",
+		nodeName: nodeName,
+		conf:     &ElectionConfig{Timeout: defaultElectionTimeout},
+		client:   newFakeClient(ctx),
+		logger:   zap.NewNop(),
+		done:     make(chan struct{}),
+		resignC:  make(chan resignRequest),
+		events:   make(chan LeadershipEvent, leadershipBufferSize),
+		callback: func(ctx context.Context, e *Election, isLeader bool, err error) {
+			if isLeader {
+				resigned <- e.Resign(ctx)
+			}
+		},
+	}
+	cs, err := e.session()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// 成为leader后在回调中退位, lead 响应退位请求后返回
+	e.setLeader(ctx, true, nil)
+	e.lead(ctx, cs, e.election())
+	assert.NoError(t, <-resigned)
+	assert.False(t, e.IsLeader())
+	assert.NoError(t, ctx.Err())
 }
@@ -3,11 +3,11 @@ package etcd
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/8xmx8/easier/pkg/utils"
 	etcdcli "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/client/v3/concurrency"
 	"go.uber.org/zap"
 )
 
@@ -23,13 +23,16 @@ var (
 )
 
 type Client struct { // nolint
-	nodeName  string
-	cli       *etcdcli.Client
-	logger    *zap.Logger
-	timeout   time.Duration                 // 操作超时
-	elections []*Election                   // 选举器
-	watchers  []*Watcher                    // 监听器
-	locks     map[string]*concurrency.Mutex // 分布式锁
+	nodeName    string
+	cli         *etcdcli.Client
+	logger      *zap.Logger
+	timeout     time.Duration    // 操作超时
+	elections   []*Election      // 选举器
+	watchersMu  sync.Mutex       // 保护watchers
+	watchers    []*Watcher       // 运行中的监听器, 停止后移除
+	lockManager *LockManager     // 默认的分布式锁管理器
+	locksMu     sync.Mutex       // 保护locks
+	locks       map[string]*Lock // 通过 Client.Lock 持有的分布式锁
 }
 
 func (cli *Client) WithNodeName(name string) *Client {
@@ -37,6 +40,21 @@ func (cli *Client) WithNodeName(name string) *Client {
 	return cli
 }
 
+// RawClient 原生的etcd客户端, 用于本包未封装的操作
+func (cli *Client) RawClient() *etcdcli.Client {
+	return cli.cli
+}
+
+// Logger 客户端使用的logger
+func (cli *Client) Logger() *zap.Logger {
+	return cli.logger
+}
+
+// Timeout 单次操作的超时时间
+func (cli *Client) Timeout() time.Duration {
+	return cli.timeout
+}
+
 type OptionFunc func(*etcdcli.Config) error
 
 // WithBaseAuth 基础鉴权
@@ -91,13 +109,26 @@ func NewETCD(ctx context.Context, endpoints []string, ops ...OptionFunc) (*Clien
 		logger:    c.Logger,
 		elections: []*Election{},
 		watchers:  []*Watcher{},
-		locks:     map[string]*concurrency.Mutex{},
+		locks:     map[string]*Lock{},
 	}
+	ec.lockManager = ec.NewLockManager()
 	return ec, nil
 }
 
-// Close 关闭etcd客户端
+// Close 关闭etcd客户端, 不等待正在执行的监听回调, 可以在监听回调中调用
 func (cli *Client) Close() error {
+	for _, e := range cli.elections {
+		e.Destroy()
+	}
+	cli.watchersMu.Lock()
+	watchers := append([]*Watcher{}, cli.watchers...)
+	cli.watchersMu.Unlock()
+	for _, w := range watchers {
+		w.Stop()
+	}
+	if err := cli.lockManager.Close(); err != nil {
+		cli.logger.Warn("关闭分布式锁session失败", zap.Error(err))
+	}
 	return cli.cli.Close()
 }
 
@@ -354,56 +385,79 @@ func (cli *Client) DelMember(ctx context.Context, memberID uint64) error {
 
 // //////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// NewElection 通过ETCD Client创建一个Election.
-func (cli *Client) NewElection(ctx context.Context, key string, conf *ElectionConfig, f ElectionFunc) error {
-	// concurrency.WithTTL 设置过期时间，可以在leader掉线的时候，
-	// 其他节点能快速获取到相关情况，进行再次选举
-	cs, err := concurrency.NewSession(cli.cli, concurrency.WithTTL(conf.Timeout))
-	if err != nil {
-		return err
+// NewElection 通过ETCD Client创建一个Election, 在后台竞选直到ctx结束或调用 Election.Destroy.
+// f 可以为空, 也可以通过 Election.Leadership 获取leader状态的变化.
+func (cli *Client) NewElection(ctx context.Context, key string, conf *ElectionConfig, f ElectionFunc) (*Election, error) {
+	if conf == nil {
+		conf = &ElectionConfig{}
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultElectionTimeout
 	}
-	el := concurrency.NewElection(cs, key)
 	ectx, cancel := context.WithCancel(ctx)
 	eleObj := &Election{
 		nodeName: cli.nodeName,
 		key:      key,
 		conf:     conf,
-		cs:       cs,
-		ele:      el,
+		client:   cli,
 		callback: f,
 		logger:   cli.logger,
 		cancel:   cancel,
+		done:     make(chan struct{}),
+		resignC:  make(chan resignRequest),
+		events:   make(chan LeadershipEvent, leadershipBufferSize),
+	}
+	if err := eleObj.Start(ectx); err != nil {
+		cancel()
+		return nil, err
 	}
 	// 交给client持有
 	cli.elections = append(cli.elections, eleObj)
-	return eleObj.Start(ectx)
+	return eleObj, nil
 }
 
 // //////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // NewWatcher 通过ETCD Client创建一个Watcher对象，需要输入监听的前缀.
-// 异步的
-func (cli *Client) NewWatcher(ctx context.Context, prefix string, f WatcherFunc) error {
+// 异步的, 事件按顺序分批回调f
+func (cli *Client) NewWatcher(ctx context.Context, prefix string, f WatcherFunc, ops ...WatcherOptionFunc) (*Watcher, error) {
 	if f == nil {
-		return ErrInvalidParam
+		return nil, ErrInvalidParam
 	}
 	cctx, cancel := context.WithCancel(ctx)
-	pools, err := utils.NewPool(2000)
-	if err != nil {
-		cancel()
-		return err
-	}
 	w := &Watcher{
 		client:   cli,
 		prefix:   prefix,
 		callback: f,
 		cancel:   cancel,
 		ctx:      cctx,
+		done:     make(chan struct{}),
 		Logger:   cli.logger,
-		pools:    pools,
 	}
+	for _, op := range ops {
+		op(w)
+	}
+	cli.watchersMu.Lock()
 	cli.watchers = append(cli.watchers, w)
-	return w.Start(cctx)
+	cli.watchersMu.Unlock()
+	if err := w.Start(cctx); err != nil {
+		cancel()
+		cli.removeWatcher(w)
+		return nil, err
+	}
+	return w, nil
+}
+
+// removeWatcher 移除已停止的监听器
+func (cli *Client) removeWatcher(w *Watcher) {
+	cli.watchersMu.Lock()
+	defer cli.watchersMu.Unlock()
+	for i, item := range cli.watchers {
+		if item == w {
+			cli.watchers = append(cli.watchers[:i], cli.watchers[i+1:]...)
+			return
+		}
+	}
 }
 
 func (cli *Client) Compact(ctx context.Context, threshold int64, isdefrag bool) {
@@ -0,0 +1,147 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// fakeKV 内存中的etcd KV, 只实现 Put/Get/Delete; 与etcd一致, Count 不受创建版本号等过滤条件影响
+type fakeKV struct {
+	etcdcli.KV
+	mu  sync.Mutex
+	rev int64
+	kvs map[string]*mvccpb.KeyValue
+}
+
+func (kv *fakeKV) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: kv.rev}
+}
+
+func (kv *fakeKV) Put(ctx context.Context, key, val string, opts ...etcdcli.OpOption) (*etcdcli.PutResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.rev++
+	item := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(val), CreateRevision: kv.rev, ModRevision: kv.rev, Version: 1}
+	if old, ok := kv.kvs[key]; ok {
+		item.CreateRevision, item.Version = old.CreateRevision, old.Version+1
+	}
+	kv.kvs[key] = item
+	return &etcdcli.PutResponse{Header: kv.header()}, nil
+}
+
+// match 返回op范围内按key排序的数据
+func (kv *fakeKV) match(op etcdcli.Op) []*mvccpb.KeyValue {
+	key, end := op.KeyBytes(), op.RangeBytes()
+	var items []*mvccpb.KeyValue
+	for k, item := range kv.kvs {
+		b := []byte(k)
+		if bytes.Equal(b, key) || (len(end) > 0 && bytes.Compare(b, key) >= 0 && (bytes.Equal(end, []byte{0}) || bytes.Compare(b, end) < 0)) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].Key, items[j].Key) < 0 })
+	return items
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string, opts ...etcdcli.OpOption) (*etcdcli.GetResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	op := etcdcli.OpGet(key, opts...)
+	items := kv.match(op)
+	resp := &etcdcli.GetResponse{Header: kv.header(), Count: int64(len(items))}
+	if op.IsCountOnly() {
+		return resp, nil
+	}
+	for _, item := range items {
+		if maxRev := op.MaxCreateRev(); maxRev > 0 && item.CreateRevision > maxRev {
+			continue
+		}
+		cp := *item
+		if op.IsKeysOnly() {
+			cp.Value = nil
+		}
+		resp.Kvs = append(resp.Kvs, &cp)
+	}
+	return resp, nil
+}
+
+func (kv *fakeKV) Delete(ctx context.Context, key string, opts ...etcdcli.OpOption) (*etcdcli.DeleteResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	items := kv.match(etcdcli.OpDelete(key, opts...))
+	if len(items) > 0 {
+		kv.rev++
+	}
+	for _, item := range items {
+		delete(kv.kvs, string(item.Key))
+	}
+	return &etcdcli.DeleteResponse{Header: kv.header(), Deleted: int64(len(items))}, nil
+}
+
+// fakeLease 永不过期的租约
+type fakeLease struct {
+	etcdcli.Lease
+	mu sync.Mutex
+	id etcdcli.LeaseID
+}
+
+func (l *fakeLease) Grant(ctx context.Context, ttl int64) (*etcdcli.LeaseGrantResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.id++
+	return &etcdcli.LeaseGrantResponse{ID: l.id, TTL: ttl}, nil
+}
+
+func (l *fakeLease) KeepAlive(ctx context.Context, id etcdcli.LeaseID) (<-chan *etcdcli.LeaseKeepAliveResponse, error) {
+	ch := make(chan *etcdcli.LeaseKeepAliveResponse)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (l *fakeLease) Revoke(ctx context.Context, id etcdcli.LeaseID) (*etcdcli.LeaseRevokeResponse, error) {
+	return &etcdcli.LeaseRevokeResponse{}, nil
+}
+
+// fakeWatcher 没有事件的监听, 记录监听的key; 通道在ctx结束时关闭
+type fakeWatcher struct {
+	etcdcli.Watcher
+	mu   sync.Mutex
+	keys []string
+}
+
+func (w *fakeWatcher) Watch(ctx context.Context, key string, opts ...etcdcli.OpOption) etcdcli.WatchChan {
+	w.mu.Lock()
+	w.keys = append(w.keys, key)
+	w.mu.Unlock()
+	ch := make(chan etcdcli.WatchResponse)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func (w *fakeWatcher) watched() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.keys...)
+}
+
+// newFakeClient 使用内存KV、租约与监听的客户端, 用于不依赖etcd的测试
+func newFakeClient(ctx context.Context) *Client {
+	cli := etcdcli.NewCtxClient(ctx)
+	cli.KV = &fakeKV{rev: 1, kvs: map[string]*mvccpb.KeyValue{}} // 与etcd一致, 空集群的revision为1
+	cli.Lease = &fakeLease{}
+	cli.Watcher = &fakeWatcher{}
+	return &Client{nodeName: nodeName, cli: cli, timeout: optTimeout, logger: zap.NewNop(), locks: map[string]*Lock{}}
+}
@@ -0,0 +1,132 @@
+package etcd
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultHashReplicas = 100 // 一致性哈希中每个权重的虚拟节点数
+
+// Picker 从可用实例中选择一个, 需要并发安全
+type Picker interface {
+	// Update 实例列表变化时调用
+	Update(endpoints []*Endpoint)
+	// Pick 选择一个实例, 没有可用实例时返回 ErrNoEndpoint
+	Pick(key string) (*Endpoint, error)
+}
+
+// roundRobinPicker 轮询
+type roundRobinPicker struct {
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	next      uint64
+}
+
+// NewRoundRobinPicker 轮询选择实例, 忽略权重
+func NewRoundRobinPicker() Picker {
+	return &roundRobinPicker{}
+}
+
+func (p *roundRobinPicker) Update(endpoints []*Endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints = endpoints
+}
+
+func (p *roundRobinPicker) Pick(key string) (*Endpoint, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return p.endpoints[n%uint64(len(p.endpoints))], nil
+}
+
+// weightedRandomPicker 加权随机
+type weightedRandomPicker struct {
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	cum       []int // 权重的前缀和
+}
+
+// NewWeightedRandomPicker 按权重随机选择实例
+func NewWeightedRandomPicker() Picker {
+	return &weightedRandomPicker{}
+}
+
+func (p *weightedRandomPicker) Update(endpoints []*Endpoint) {
+	cum := make([]int, len(endpoints))
+	total := 0
+	for i, ep := range endpoints {
+		total += ep.weight()
+		cum[i] = total
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints, p.cum = endpoints, cum
+}
+
+func (p *weightedRandomPicker) Pick(key string) (*Endpoint, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	r := rand.Intn(p.cum[len(p.cum)-1]) // nolint
+	return p.endpoints[sort.SearchInts(p.cum, r+1)], nil
+}
+
+// consistentHashPicker 一致性哈希
+type consistentHashPicker struct {
+	replicas int
+	mu       sync.RWMutex
+	hashes   []uint32 // 已排序的虚拟节点哈希
+	nodes    map[uint32]*Endpoint
+}
+
+// NewConsistentHashPicker 按key的一致性哈希选择实例, 实例变化时只有少量key会迁移;
+// 每个实例的虚拟节点数为 replicas*权重, replicas<=0时为100
+func NewConsistentHashPicker(replicas int) Picker {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+	return &consistentHashPicker{replicas: replicas}
+}
+
+func (p *consistentHashPicker) Update(endpoints []*Endpoint) {
+	hashes := make([]uint32, 0, len(endpoints)*p.replicas)
+	nodes := make(map[uint32]*Endpoint, cap(hashes))
+	for _, ep := range endpoints {
+		for i := 0; i < p.replicas*ep.weight(); i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + ep.Key))
+			if _, ok := nodes[h]; ok { // 哈希冲突时保留先加入的
+				continue
+			}
+			nodes[h] = ep
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashes, p.nodes = hashes, nodes
+}
+
+func (p *consistentHashPicker) Pick(key string) (*Endpoint, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.hashes) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(p.hashes), func(i int) bool { return p.hashes[i] >= h })
+	if i == len(p.hashes) {
+		i = 0
+	}
+	return p.nodes[p.hashes[i]], nil
+}
@@ -91,31 +91,80 @@ func (p *PriorityQueue) Push(ctx context.Context, val string, pr uint16) (err er
 	return
 }
 
-// Pop 如果消息队列为空, 则该方法将阻塞
-// MARK: @zcf ~~如果消息队列为空, 则该方法将阻塞1秒钟, 直到有消息可以被取出; 或为空字符串""~~
-func (p *PriorityQueue) Pop(ctx context.Context) (val string, err error) {
-	// MARK: @zcf Timeout太短会导返回不处数据, 推测是 超时与读取到数据竞争了
-	// subctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	// go func() {
-	// 	val, err = p.queue.Dequeue()
-	// 	cancel()
-	// }()
-	// <-subctx.Done()
-	return p.queue.Dequeue()
+// prefix 队列中元素的前缀, 带"/"以免匹配到名称以key开头的其他队列
+func (p *PriorityQueue) prefix() string {
+	return fmt.Sprintf(priorityQueuePrefix, p.key) + "/"
+}
+
+// Pop 如果消息队列为空, 则该方法将阻塞, 直到有消息可以被取出或ctx结束
+func (p *PriorityQueue) Pop(ctx context.Context) (string, error) {
+	for {
+		val, rev, err := p.tryPop(ctx)
+		if err != ErrQueueEmpty {
+			return val, err
+		}
+		if err = waitEvent(ctx, p.etcd.cli, p.prefix(), rev+1, isPut, etcdcli.WithPrefix()); err != nil {
+			return "", err
+		}
+	}
+}
+
+// TryPop 无阻塞出列, 队列为空时返回 ErrQueueEmpty
+func (p *PriorityQueue) TryPop(ctx context.Context) (string, error) {
+	val, _, err := p.tryPop(ctx)
+	return val, err
+}
+
+// tryPop 取出优先级最高(数值最小)的第一个元素, 队列为空时返回读取时的revision
+func (p *PriorityQueue) tryPop(ctx context.Context) (string, int64, error) {
+	for {
+		resp, err := p.etcd.cli.Get(ctx, p.prefix(), etcdcli.WithFirstKey()...)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(resp.Kvs) == 0 {
+			return "", resp.Header.Revision, ErrQueueEmpty
+		}
+		kv := resp.Kvs[0]
+		// 只有删除成功的消费者取得该元素, 失败时重新读取
+		res, err := p.etcd.Txn(ctx).IfModRevision(string(kv.Key), "=", kv.ModRevision).Then(etcdcli.OpDelete(string(kv.Key))).Commit()
+		if err != nil {
+			return "", 0, err
+		}
+		if res.Succeeded {
+			return string(kv.Value), res.Revision, nil
+		}
+	}
+}
+
+// Peek 查看下一个出列的元素但不取出, 队列为空时返回 ErrQueueEmpty
+func (p *PriorityQueue) Peek(ctx context.Context) (string, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, p.etcd.timeout)
+	defer cancel()
+	resp, err := p.etcd.cli.Get(timeoutCtx, p.prefix(), etcdcli.WithFirstKey()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrQueueEmpty
+	}
+	return string(resp.Kvs[0].Value), nil
 }
 
 // Len 获取优先级队列的剩余元素
 func (p *PriorityQueue) Len(ctx context.Context) (int, error) {
-	resp, err := p.etcd.Get(ctx, fmt.Sprintf(priorityQueuePrefix, ""))
+	timeoutCtx, cancel := context.WithTimeout(ctx, p.etcd.timeout)
+	defer cancel()
+	resp, err := p.etcd.cli.Get(timeoutCtx, p.prefix(), etcdcli.WithPrefix(), etcdcli.WithCountOnly())
 	if err != nil {
 		return 0, err
 	}
-	return len(resp), nil
+	return int(resp.Count), nil
 }
 
 // Delete 删除这个优先级队列的所有记录
 func (p *PriorityQueue) Delete(ctx context.Context) error {
-	if err := p.etcd.DeleteWithPrefix(ctx, fmt.Sprintf(priorityQueuePrefix, p.key)); err != nil {
+	if err := p.etcd.DeleteWithPrefix(ctx, p.prefix()); err != nil {
 		return err
 	}
 	// MARK: @zcf 下列做法比较蠢, 后续考虑其必要性
@@ -0,0 +1,418 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+/*
+可靠队列:
+1. 数据布局(/reliableQueue/<name>/):
+   ready/<优先级>/<id>      待消费的消息, 优先级数值越小越先出列, 同优先级先进先出;
+   delayed/<到期时间>/<id>   延迟消息, 到期后由消费者移入ready;
+   inflight/<id>           已出列未确认的消息;
+   lease/<id>              inflight消息的租约标记, 绑定消费者的session或可见性超时的租约;
+2. 出列时在一个事务中删除ready并写入inflight与lease标记; Ack 删除两者, Nack 将消息放回ready(或延迟);
+   消息ID在重新出列时不变, Ack/Nack 比较lease标记的ModRevision(出列事务的revision), 过期后重新出列的消息不会被原消费者确认;
+3. 消费者崩溃或可见性超时后lease标记随租约删除, 其他消费者出列时将没有标记的inflight消息放回ready;
+*/
+
+const (
+	reliableQueuePrefix   = "/reliableQueue/%s/" // /reliableQueue/<name>/
+	queueReady            = "ready/"
+	queueDelayed          = "delayed/"
+	queueInflight         = "inflight/"
+	queueLease            = "lease/"
+	queueWatchMaxInterval = time.Minute // 阻塞出列时最长的重新检查间隔
+)
+
+var (
+	ErrQueueEmpty     = errors.New("queue is empty")
+	ErrMessageExpired = errors.New("message lease expired and has been requeued")
+)
+
+// queueItem 保存在etcd中的消息
+type queueItem struct {
+	ID       string `json:"id"`
+	Value    string `json:"value"`
+	Priority uint16 `json:"priority"`
+	Attempts int    `json:"attempts"` // 出列次数
+}
+
+// Message 出列的消息, 处理完成后需要 Ack 或 Nack
+type Message struct {
+	ID       string
+	Value    string
+	Priority uint16
+	Attempts int // 包括本次在内的出列次数
+
+	queue *ReliableQueue
+	item  queueItem
+	rev   int64 // 出列事务的revision, 每次出列不同
+}
+
+// ReliableQueue 支持确认的可靠队列, 并发安全
+type ReliableQueue struct {
+	cli        *Client
+	manager    *LockManager
+	name       string
+	prefix     string
+	visibility time.Duration
+}
+
+type ReliableQueueOptionFunc func(*ReliableQueue)
+
+// QueueWithVisibilityTimeout 消息出列后d内未确认则重新入列; 默认消息在消费者的session过期(崩溃)前一直属于该消费者
+func QueueWithVisibilityTimeout(d time.Duration) ReliableQueueOptionFunc {
+	return func(q *ReliableQueue) {
+		q.visibility = d
+	}
+}
+
+// QueueWithSessionTTL 消费者session的TTL(秒), 消费者崩溃后最多TTL秒消息重新入列
+func QueueWithSessionTTL(ttl int) ReliableQueueOptionFunc {
+	return func(q *ReliableQueue) {
+		q.manager = q.cli.NewLockManager(LockWithTTL(ttl))
+	}
+}
+
+// NewReliableQueue 创建可靠队列
+func (cli *Client) NewReliableQueue(name string, ops ...ReliableQueueOptionFunc) *ReliableQueue {
+	q := &ReliableQueue{
+		cli:    cli,
+		name:   name,
+		prefix: fmt.Sprintf(reliableQueuePrefix, name),
+	}
+	q.manager = cli.NewLockManager()
+	for _, op := range ops {
+		op(q)
+	}
+	return q
+}
+
+func newQueueID() string {
+	return fmt.Sprintf("%019d-%08x", time.Now().UnixNano(), rand.Uint32()) // nolint
+}
+
+func (q *ReliableQueue) readyKey(item *queueItem) string {
+	return fmt.Sprintf("%s%s%05d/%s", q.prefix, queueReady, item.Priority, item.ID)
+}
+
+func (q *ReliableQueue) delayedKey(item *queueItem, due time.Time) string {
+	return fmt.Sprintf("%s%s%019d/%s", q.prefix, queueDelayed, due.UnixNano(), item.ID)
+}
+
+func (q *ReliableQueue) inflightKey(id string) string { return q.prefix + queueInflight + id }
+func (q *ReliableQueue) leaseKey(id string) string    { return q.prefix + queueLease + id }
+
+// enqueueOp 入列操作, delay>0时作为延迟消息
+func (q *ReliableQueue) enqueueOp(item *queueItem, delay time.Duration) etcdcli.Op {
+	b, _ := json.Marshal(item)
+	if delay > 0 {
+		return etcdcli.OpPut(q.delayedKey(item, time.Now().Add(delay)), string(b))
+	}
+	return etcdcli.OpPut(q.readyKey(item), string(b))
+}
+
+// Push 入列
+func (q *ReliableQueue) Push(ctx context.Context, value string, priority uint16) error {
+	return q.PushDelayed(ctx, value, priority, 0)
+}
+
+// PushDelayed 入列延迟消息, delay之后才能出列
+func (q *ReliableQueue) PushDelayed(ctx context.Context, value string, priority uint16, delay time.Duration) error {
+	item := &queueItem{ID: newQueueID(), Value: value, Priority: priority}
+	_, err := q.cli.Txn(ctx).Then(q.enqueueOp(item, delay)).Commit()
+	return err
+}
+
+// Pop 阻塞直到取出一条消息或ctx结束
+func (q *ReliableQueue) Pop(ctx context.Context) (*Message, error) {
+	for {
+		msg, rev, next, err := q.tryPop(ctx)
+		if err != ErrQueueEmpty {
+			return msg, err
+		}
+		// 等待队列中的任何变化(新消息、lease标记过期)或下一条延迟消息到期
+		wait := queueWatchMaxInterval
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		wctx, cancel := context.WithTimeout(ctx, wait)
+		err = waitEvent(wctx, q.cli.cli, q.prefix, rev+1, anyEvent, etcdcli.WithPrefix())
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+	}
+}
+
+// TryPop 无阻塞出列, 队列为空时返回 ErrQueueEmpty
+func (q *ReliableQueue) TryPop(ctx context.Context) (*Message, error) {
+	msg, _, _, err := q.tryPop(ctx)
+	return msg, err
+}
+
+// tryPop 移入到期的延迟消息与过期的inflight消息后出列;
+// 队列为空时返回读取时的revision与下一条延迟消息的到期时间
+func (q *ReliableQueue) tryPop(ctx context.Context) (*Message, int64, time.Time, error) {
+	next, err := q.promoteDelayed(ctx)
+	if err != nil {
+		return nil, 0, next, err
+	}
+	if err = q.requeueExpired(ctx); err != nil {
+		return nil, 0, next, err
+	}
+	for {
+		resp, err := q.cli.cli.Get(ctx, q.prefix+queueReady, etcdcli.WithFirstKey()...)
+		if err != nil {
+			return nil, 0, next, err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil, resp.Header.Revision, next, ErrQueueEmpty
+		}
+		msg, err := q.claim(ctx, string(resp.Kvs[0].Key), resp.Kvs[0].Value, resp.Kvs[0].ModRevision)
+		if err != nil || msg != nil {
+			return msg, 0, next, err
+		}
+		// 被其他消费者取走, 重新读取
+	}
+}
+
+// claim 在一个事务中将ready消息移入inflight并写入lease标记, 已被其他消费者取走时返回nil
+func (q *ReliableQueue) claim(ctx context.Context, key string, value []byte, rev int64) (*Message, error) {
+	var item queueItem
+	if err := json.Unmarshal(value, &item); err != nil {
+		// 无法解析的消息直接丢弃, 避免阻塞队列
+		q.cli.logger.Error("invalid queue message, drop it", zap.String("key", key), zap.Error(err))
+		_, err = q.cli.Txn(ctx).IfModRevision(key, "=", rev).Then(etcdcli.OpDelete(key)).Commit()
+		return nil, err
+	}
+	lease, err := q.lease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	item.Attempts++
+	b, _ := json.Marshal(item)
+	res, err := q.cli.Txn(ctx).IfModRevision(key, "=", rev).Then(
+		etcdcli.OpDelete(key),
+		etcdcli.OpPut(q.inflightKey(item.ID), string(b)),
+		etcdcli.OpPut(q.leaseKey(item.ID), q.cli.nodeName, etcdcli.WithLease(lease)),
+	).Commit()
+	if err != nil || !res.Succeeded {
+		return nil, err
+	}
+	return &Message{
+		ID:       item.ID,
+		Value:    item.Value,
+		Priority: item.Priority,
+		Attempts: item.Attempts,
+		queue:    q,
+		item:     item,
+		rev:      res.Revision,
+	}, nil
+}
+
+// lease inflight消息的租约: 配置了可见性超时时每条消息使用单独的租约, 否则使用消费者的session
+func (q *ReliableQueue) lease(ctx context.Context) (etcdcli.LeaseID, error) {
+	if q.visibility > 0 {
+		ttl := int64((q.visibility + time.Second - 1) / time.Second)
+		resp, err := q.cli.cli.Grant(ctx, ttl)
+		if err != nil {
+			return 0, err
+		}
+		return resp.ID, nil
+	}
+	sess, err := q.manager.session()
+	if err != nil {
+		return 0, err
+	}
+	return sess.Lease(), nil
+}
+
+// promoteDelayed 将到期的延迟消息移入ready, 返回下一条延迟消息的到期时间
+func (q *ReliableQueue) promoteDelayed(ctx context.Context) (time.Time, error) {
+	pfx := q.prefix + queueDelayed
+	now := time.Now()
+	end := fmt.Sprintf("%s%019d", pfx, now.UnixNano()+1)
+	resp, err := q.cli.cli.Get(ctx, pfx, etcdcli.WithRange(end))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, kv := range resp.Kvs {
+		var item queueItem
+		if err := json.Unmarshal(kv.Value, &item); err != nil {
+			q.cli.logger.Error("invalid delayed queue message, drop it", zap.String("key", string(kv.Key)), zap.Error(err))
+			_, err = q.cli.Txn(ctx).IfModRevision(string(kv.Key), "=", kv.ModRevision).Then(etcdcli.OpDelete(string(kv.Key))).Commit()
+			if err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+		_, err = q.cli.Txn(ctx).IfModRevision(string(kv.Key), "=", kv.ModRevision).
+			Then(etcdcli.OpDelete(string(kv.Key)), q.enqueueOp(&item, 0)).Commit()
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	// 下一条未到期的延迟消息
+	resp, err = q.cli.cli.Get(ctx, pfx, etcdcli.WithFirstKey()...)
+	if err != nil || len(resp.Kvs) == 0 {
+		return time.Time{}, err
+	}
+	due := strings.SplitN(strings.TrimPrefix(string(resp.Kvs[0].Key), pfx), "/", 2)[0]
+	ns, err := strconv.ParseInt(due, 10, 64)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, ns), nil
+}
+
+// requeueExpired 将lease标记已过期的inflight消息放回ready
+func (q *ReliableQueue) requeueExpired(ctx context.Context) error {
+	inflight, err := q.cli.cli.Get(ctx, q.prefix+queueInflight, etcdcli.WithPrefix())
+	if err != nil || len(inflight.Kvs) == 0 {
+		return err
+	}
+	// 先读取inflight再读取标记, 之后出列的消息不在inflight结果中, 不会被误判为过期
+	leases, err := q.cli.cli.Get(ctx, q.prefix+queueLease, etcdcli.WithPrefix(), etcdcli.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	alive := make(map[string]struct{}, len(leases.Kvs))
+	for _, kv := range leases.Kvs {
+		alive[strings.TrimPrefix(string(kv.Key), q.prefix+queueLease)] = struct{}{}
+	}
+	for _, kv := range inflight.Kvs {
+		id := strings.TrimPrefix(string(kv.Key), q.prefix+queueInflight)
+		if _, ok := alive[id]; ok {
+			continue
+		}
+		var item queueItem
+		if err := json.Unmarshal(kv.Value, &item); err != nil {
+			q.cli.logger.Error("invalid inflight queue message, drop it", zap.String("key", string(kv.Key)), zap.Error(err))
+			_, err = q.cli.Txn(ctx).IfModRevision(string(kv.Key), "=", kv.ModRevision).Then(etcdcli.OpDelete(string(kv.Key))).Commit()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		res, err := q.cli.Txn(ctx).
+			IfModRevision(string(kv.Key), "=", kv.ModRevision).
+			IfNotExists(q.leaseKey(id)).
+			Then(etcdcli.OpDelete(string(kv.Key)), q.enqueueOp(&item, 0)).Commit()
+		if err != nil {
+			return err
+		}
+		if res.Succeeded {
+			q.cli.logger.Info("requeue expired message", zap.String("queue", q.name), zap.String("id", id), zap.Int("attempts", item.Attempts))
+		}
+	}
+	return nil
+}
+
+// Ack 确认消息已处理, 从队列中删除; 消息的租约已过期(已重新入列或被其他消费者取出)时返回 ErrMessageExpired
+func (m *Message) Ack(ctx context.Context) error {
+	q := m.queue
+	res, err := q.cli.Txn(ctx).IfModRevision(q.leaseKey(m.ID), "=", m.rev).Then(
+		etcdcli.OpDelete(q.inflightKey(m.ID)),
+		etcdcli.OpDelete(q.leaseKey(m.ID)),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return ErrMessageExpired
+	}
+	return nil
+}
+
+// Nack 处理失败, 将消息放回队列, delay>0时延迟delay后才能再次出列; 消息的租约已过期时返回 ErrMessageExpired
+func (m *Message) Nack(ctx context.Context, delay time.Duration) error {
+	q := m.queue
+	res, err := q.cli.Txn(ctx).IfModRevision(q.leaseKey(m.ID), "=", m.rev).Then(
+		etcdcli.OpDelete(q.inflightKey(m.ID)),
+		etcdcli.OpDelete(q.leaseKey(m.ID)),
+		q.enqueueOp(&m.item, delay),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return ErrMessageExpired
+	}
+	return nil
+}
+
+// Peek 查看下一条出列的消息但不取出, 队列为空时返回 ErrQueueEmpty; 不包括未到期的延迟消息
+func (q *ReliableQueue) Peek(ctx context.Context) (*Message, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, q.cli.timeout)
+	defer cancel()
+	resp, err := q.cli.cli.Get(timeoutCtx, q.prefix+queueReady, etcdcli.WithFirstKey()...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrQueueEmpty
+	}
+	var item queueItem
+	if err := json.Unmarshal(resp.Kvs[0].Value, &item); err != nil {
+		return nil, err
+	}
+	return &Message{ID: item.ID, Value: item.Value, Priority: item.Priority, Attempts: item.Attempts, item: item}, nil
+}
+
+// QueueStats 队列中各状态的消息数
+type QueueStats struct {
+	Ready    int64
+	Delayed  int64
+	InFlight int64
+}
+
+// Len 待消费的消息数, 不包括延迟与未确认的消息
+func (q *ReliableQueue) Len(ctx context.Context) (int64, error) {
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return stats.Ready, nil
+}
+
+// Stats 队列中各状态的消息数
+func (q *ReliableQueue) Stats(ctx context.Context) (*QueueStats, error) {
+	count := func(sub string) etcdcli.Op {
+		return etcdcli.OpGet(q.prefix+sub, etcdcli.WithPrefix(), etcdcli.WithCountOnly())
+	}
+	res, err := q.cli.Txn(ctx).Then(count(queueReady), count(queueDelayed), count(queueInflight)).Commit()
+	if err != nil {
+		return nil, err
+	}
+	rs := res.resp.Responses
+	return &QueueStats{
+		Ready:    rs[0].GetResponseRange().Count,
+		Delayed:  rs[1].GetResponseRange().Count,
+		InFlight: rs[2].GetResponseRange().Count,
+	}, nil
+}
+
+// Delete 删除队列中的所有消息
+func (q *ReliableQueue) Delete(ctx context.Context) error {
+	return q.cli.DeleteWithPrefix(ctx, q.prefix)
+}
+
+// Close 关闭消费者的session, 未确认的消息将重新入列
+func (q *ReliableQueue) Close() error {
+	return q.manager.Close()
+}
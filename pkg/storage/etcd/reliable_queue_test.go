@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETCDReliableQueue(t *testing.T) {
+	ctx := context.Background()
+	cc, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := cc.NewReliableQueue("test_reliable", QueueWithVisibilityTimeout(time.Second))
+	defer q.Close()
+	assert.NoError(t, q.Delete(ctx))
+	defer q.Delete(ctx)
+
+	t.Run("priority&ack", func(t *testing.T) {
+		assert.NoError(t, q.Push(ctx, "two", 2))
+		assert.NoError(t, q.Push(ctx, "one", 1))
+		n, err := q.Len(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		peek, err := q.Peek(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "one", peek.Value)
+
+		msg, err := q.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "one", msg.Value)
+		assert.NoError(t, msg.Ack(ctx))
+		msg, err = q.TryPop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "two", msg.Value)
+		assert.NoError(t, msg.Ack(ctx))
+		_, err = q.TryPop(ctx)
+		assert.Equal(t, ErrQueueEmpty, err)
+	})
+	t.Run("visibility_timeout", func(t *testing.T) {
+		assert.NoError(t, q.Push(ctx, "retry", 1))
+		msg, err := q.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, msg.Attempts)
+		// 未确认, 租约过期后重新入列
+		tctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		again, err := q.Pop(tctx)
+		assert.NoError(t, err)
+		assert.Equal(t, msg.ID, again.ID)
+		assert.Equal(t, 2, again.Attempts)
+		// 原消费者的确认不影响重新出列的消息
+		assert.Equal(t, ErrMessageExpired, msg.Ack(ctx))
+		assert.Equal(t, ErrMessageExpired, msg.Nack(ctx, 0))
+		stats, err := q.Stats(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &QueueStats{InFlight: 1}, stats)
+		assert.NoError(t, again.Ack(ctx))
+	})
+	t.Run("delayed&nack", func(t *testing.T) {
+		assert.NoError(t, q.PushDelayed(ctx, "later", 1, 500*time.Millisecond))
+		_, err := q.TryPop(ctx)
+		assert.Equal(t, ErrQueueEmpty, err)
+		msg, err := q.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "later", msg.Value)
+		assert.NoError(t, msg.Nack(ctx, 0))
+		stats, err := q.Stats(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &QueueStats{Ready: 1}, stats)
+	})
+	t.Run("pop_ctx", func(t *testing.T) {
+		empty := cc.NewReliableQueue("test_reliable_empty")
+		defer empty.Close()
+		tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := empty.Pop(tctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
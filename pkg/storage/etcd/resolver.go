@@ -0,0 +1,76 @@
+package etcd
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// ResolverScheme gRPC resolver的scheme, 目标地址形如 etcd:///<服务前缀>, 如 etcd:///services/user
+const ResolverScheme = "etcd"
+
+// endpointAttrKey 保存在 resolver.Address.Attributes 中的 *Endpoint 的key
+type endpointAttrKey struct{}
+
+// EndpointFromAddress 获取gRPC地址对应的实例
+func EndpointFromAddress(addr resolver.Address) (*Endpoint, bool) {
+	ep, ok := addr.Attributes.Value(endpointAttrKey{}).(*Endpoint)
+	return ep, ok
+}
+
+type resolverBuilder struct {
+	client *Client
+	ops    []DiscoveryOptionFunc
+}
+
+// NewResolverBuilder 基于 Discovery 的gRPC resolver, 使用 grpc.WithResolvers 注册;
+// 负载均衡由gRPC的balancer完成, 如 grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`)
+func NewResolverBuilder(cli *Client, ops ...DiscoveryOptionFunc) resolver.Builder {
+	return &resolverBuilder{client: cli, ops: ops}
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := b.client.NewDiscovery(ctx, target.URL.Path, b.ops...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return newDiscoveryResolver(d, cc, cancel), nil
+}
+
+// newDiscoveryResolver 实例列表变化时更新gRPC的地址列表
+func newDiscoveryResolver(d *Discovery, cc resolver.ClientConn, cancel func()) *discoveryResolver {
+	d.OnChange(func(endpoints []*Endpoint) {
+		addrs := make([]resolver.Address, 0, len(endpoints))
+		for _, ep := range endpoints {
+			addrs = append(addrs, resolver.Address{
+				Addr:       ep.HostPort(),
+				Attributes: attributes.New(endpointAttrKey{}, ep),
+			})
+		}
+		if err := cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+			d.logger.Debug("grpc resolver update state", zap.Error(err))
+		}
+	})
+	return &discoveryResolver{discovery: d, cancel: cancel}
+}
+
+type discoveryResolver struct {
+	discovery *Discovery
+	cancel    func()
+}
+
+// ResolveNow 实例变化通过监听获得, 无需主动解析
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *discoveryResolver) Close() {
+	r.discovery.Close()
+	r.cancel()
+}
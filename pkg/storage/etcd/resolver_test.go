@@ -0,0 +1,55 @@
+package etcd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+// fakeClientConn 记录resolver更新的地址列表
+type fakeClientConn struct {
+	resolver.ClientConn
+	onUpdate func()
+	states   []resolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(s resolver.State) error {
+	cc.states = append(cc.states, s)
+	if cc.onUpdate != nil {
+		cc.onUpdate()
+	}
+	return nil
+}
+
+func TestResolver(t *testing.T) {
+	assert.Equal(t, ResolverScheme, NewResolverBuilder(&Client{}).Scheme())
+
+	d := newDiscovery("/svc", zap.NewNop())
+	d.reset(map[string]string{"/svc/a": (&Endpoint{Addr: "http://127.0.0.1:1", Zone: "sh"}).String()})
+	cc := &fakeClientConn{}
+	// 更新地址时读取实例列表不会死锁
+	var seen [][]*Endpoint
+	cc.onUpdate = func() { seen = append(seen, d.Endpoints()) }
+	canceled := false
+	r := newDiscoveryResolver(d, cc, func() { canceled = true })
+
+	d.onEvents(nil, []*UpdateEvent{{Key: "/svc/b", Value: "127.0.0.1:2", EventType: UpdateEventTypePut}}, nil)
+	if !assert.Len(t, cc.states, 2) {
+		return
+	}
+	addrs := cc.states[1].Addresses
+	if assert.Len(t, addrs, 2) {
+		assert.Equal(t, "127.0.0.1:1", addrs[0].Addr)
+		ep, ok := EndpointFromAddress(addrs[0])
+		assert.True(t, ok)
+		assert.Equal(t, "sh", ep.Zone)
+	}
+	assert.Len(t, seen[1], 2)
+
+	d.reset(map[string]string{})
+	assert.Empty(t, cc.states[2].Addresses)
+	r.Close()
+	assert.True(t, canceled)
+}
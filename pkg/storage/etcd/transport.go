@@ -0,0 +1,40 @@
+package etcd
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// DiscoveryTransport 通过 Discovery 选择实例的 http.RoundTripper, 请求的host(如 http://user-service/api)会被替换为实例地址.
+// 实例地址不带协议时保留请求的协议.
+type DiscoveryTransport struct {
+	Discovery *Discovery
+	// Base 实际发送请求的 RoundTripper, 为空时使用 http.DefaultTransport
+	Base http.RoundTripper
+	// HashKey 一致性哈希使用的key, 为空时使用请求的path
+	HashKey func(req *http.Request) string
+}
+
+func (t *DiscoveryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.Path
+	if t.HashKey != nil {
+		key = t.HashKey(req)
+	}
+	ep, err := t.Discovery.Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	// RoundTripper 不能修改原请求
+	r := req.Clone(req.Context())
+	if u, err := url.Parse(ep.Addr); err == nil && u.Host != "" {
+		r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+	} else {
+		r.URL.Host = ep.Addr
+	}
+	r.Host = ""
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
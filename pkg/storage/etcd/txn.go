@@ -0,0 +1,202 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+/*
+事务:
+1. Txn 以链式调用构建事务: If* 添加比较条件(全部满足时执行Then, 否则执行Else), Then/Else 添加操作, Commit 提交;
+2. 比较运算符为 "=", "!=", ">", "<";
+3. CompareAndSwap/CompareAndDelete/PutIfAbsent 是基于Txn的常用原子操作;
+4. Update 对单个key乐观地"读取-修改-写入", 冲突时重试; STM 对多个key使用软件事务内存, 冲突时重试;
+*/
+
+const defaultUpdateRetries = 32 // Update 冲突时的最大重试次数
+
+var (
+	ErrTxnCompareOp = errors.New("invalid txn compare op, must be one of = != > <")
+	ErrTxnConflict  = errors.New("txn conflict, retries exhausted")
+)
+
+// Txn 事务构建器, 非并发安全
+type Txn struct {
+	cli     *Client
+	ctx     context.Context
+	cmps    []etcdcli.Cmp
+	thenOps []etcdcli.Op
+	elseOps []etcdcli.Op
+	err     error
+}
+
+// TxnResult 事务结果
+type TxnResult struct {
+	Succeeded bool  // 比较条件是否全部满足
+	Revision  int64 // 事务提交后的revision
+	resp      *etcdcli.TxnResponse
+}
+
+// Txn 创建事务构建器
+func (cli *Client) Txn(ctx context.Context) *Txn {
+	return &Txn{cli: cli, ctx: ctx}
+}
+
+func (t *Txn) compare(cmp etcdcli.Cmp, op string, v interface{}) *Txn {
+	switch op {
+	case "=", "!=", ">", "<":
+		t.cmps = append(t.cmps, etcdcli.Compare(cmp, op, v))
+	default:
+		if t.err == nil {
+			t.err = fmt.Errorf("%w: %q", ErrTxnCompareOp, op)
+		}
+	}
+	return t
+}
+
+// IfValue 比较key的value
+func (t *Txn) IfValue(key, op, value string) *Txn {
+	return t.compare(etcdcli.Value(key), op, value)
+}
+
+// IfVersion 比较key的版本(修改次数), 不存在的key版本为0
+func (t *Txn) IfVersion(key, op string, version int64) *Txn {
+	return t.compare(etcdcli.Version(key), op, version)
+}
+
+// IfModRevision 比较key最后一次修改的revision
+func (t *Txn) IfModRevision(key, op string, rev int64) *Txn {
+	return t.compare(etcdcli.ModRevision(key), op, rev)
+}
+
+// IfCreateRevision 比较key创建时的revision, 不存在的key为0
+func (t *Txn) IfCreateRevision(key, op string, rev int64) *Txn {
+	return t.compare(etcdcli.CreateRevision(key), op, rev)
+}
+
+// IfExists key存在
+func (t *Txn) IfExists(key string) *Txn {
+	return t.IfCreateRevision(key, ">", 0)
+}
+
+// IfNotExists key不存在
+func (t *Txn) IfNotExists(key string) *Txn {
+	return t.IfCreateRevision(key, "=", 0)
+}
+
+// Then 比较条件全部满足时执行的操作, 如 etcdcli.OpPut、etcdcli.OpDelete、etcdcli.OpGet
+func (t *Txn) Then(ops ...etcdcli.Op) *Txn {
+	t.thenOps = append(t.thenOps, ops...)
+	return t
+}
+
+// Else 比较条件不满足时执行的操作
+func (t *Txn) Else(ops ...etcdcli.Op) *Txn {
+	t.elseOps = append(t.elseOps, ops...)
+	return t
+}
+
+// Commit 提交事务
+func (t *Txn) Commit() (*TxnResult, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	tctx, cancel := context.WithTimeout(t.ctx, t.cli.timeout)
+	defer cancel()
+	resp, err := t.cli.cli.Txn(tctx).If(t.cmps...).Then(t.thenOps...).Else(t.elseOps...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &TxnResult{Succeeded: resp.Succeeded, Revision: resp.Header.Revision, resp: resp}, nil
+}
+
+// Kvs 第i个操作(Get)读取的数据, 不是Get操作时返回nil
+func (r *TxnResult) Kvs(i int) map[string]string {
+	if i < 0 || i >= len(r.resp.Responses) {
+		return nil
+	}
+	rr := r.resp.Responses[i].GetResponseRange()
+	if rr == nil {
+		return nil
+	}
+	kvs := make(map[string]string, len(rr.Kvs))
+	for _, kv := range rr.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs
+}
+
+// Raw 原始的事务响应
+func (r *TxnResult) Raw() *etcdcli.TxnResponse {
+	return r.resp
+}
+
+// CompareAndSwap key的value等于oldValue时修改为newValue, 返回是否修改成功
+func (cli *Client) CompareAndSwap(ctx context.Context, key, oldValue, newValue string) (bool, error) {
+	res, err := cli.Txn(ctx).IfValue(key, "=", oldValue).Then(etcdcli.OpPut(key, newValue)).Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// CompareAndDelete key的value等于value时删除, 返回是否删除成功
+func (cli *Client) CompareAndDelete(ctx context.Context, key, value string) (bool, error) {
+	res, err := cli.Txn(ctx).IfValue(key, "=", value).Then(etcdcli.OpDelete(key)).Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// PutIfAbsent key不存在时写入, 返回是否写入成功
+func (cli *Client) PutIfAbsent(ctx context.Context, key, value string) (bool, error) {
+	res, err := cli.Txn(ctx).IfNotExists(key).Then(etcdcli.OpPut(key, value)).Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// UpdateFunc 根据旧值计算新值, exists为key是否存在; 返回错误时放弃修改
+type UpdateFunc func(old string, exists bool) (string, error)
+
+// Update 乐观地"读取-修改-写入"单个key: key在读取后被修改时重新读取并调用f, 重试次数耗尽时返回 ErrTxnConflict.
+// f可能被调用多次, 不能有副作用
+func (cli *Client) Update(ctx context.Context, key string, f UpdateFunc) (string, error) {
+	for i := 0; i < defaultUpdateRetries; i++ {
+		tctx, cancel := context.WithTimeout(ctx, cli.timeout)
+		resp, err := cli.cli.Get(tctx, key)
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		var old string
+		var modRev int64
+		if len(resp.Kvs) > 0 {
+			old, modRev = string(resp.Kvs[0].Value), resp.Kvs[0].ModRevision
+		}
+		value, err := f(old, len(resp.Kvs) > 0)
+		if err != nil {
+			return "", err
+		}
+		res, err := cli.Txn(ctx).IfModRevision(key, "=", modRev).Then(etcdcli.OpPut(key, value)).Commit()
+		if err != nil {
+			return "", err
+		}
+		if res.Succeeded {
+			return value, nil
+		}
+	}
+	return "", ErrTxnConflict
+}
+
+// STM 在软件事务内存中执行apply, 读取的key在提交前被修改时自动重试; apply可能被调用多次, 不能有副作用
+func (cli *Client) STM(ctx context.Context, apply func(stm concurrency.STM) error) error {
+	_, err := concurrency.NewSTM(cli.cli, apply, concurrency.WithAbortContext(ctx))
+	return err
+}
@@ -0,0 +1,84 @@
+package etcd
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	etcdcli "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+func TestTxnCompareOp(t *testing.T) {
+	cc := &Client{}
+	_, err := cc.Txn(context.Background()).IfValue("/a", ">=", "1").Commit()
+	assert.ErrorIs(t, err, ErrTxnCompareOp)
+}
+
+func TestETCDTxn(t *testing.T) {
+	ctx := context.Background()
+	cc, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cc.DeleteWithPrefix(ctx, prefix+"/txn")
+	key := prefix + "/txn/cas"
+	assert.NoError(t, cc.Delete(ctx, key))
+
+	t.Run("put_if_absent&cas", func(t *testing.T) {
+		ok, err := cc.PutIfAbsent(ctx, key, "a")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		ok, _ = cc.PutIfAbsent(ctx, key, "b")
+		assert.False(t, ok)
+		ok, _ = cc.CompareAndSwap(ctx, key, "b", "c")
+		assert.False(t, ok)
+		ok, _ = cc.CompareAndSwap(ctx, key, "a", "c")
+		assert.True(t, ok)
+		ok, _ = cc.CompareAndDelete(ctx, key, "a")
+		assert.False(t, ok)
+		ok, _ = cc.CompareAndDelete(ctx, key, "c")
+		assert.True(t, ok)
+	})
+	t.Run("txn_else", func(t *testing.T) {
+		res, err := cc.Txn(ctx).IfExists(key).Then(etcdcli.OpDelete(key)).Else(etcdcli.OpGet(prefix+"/txn", etcdcli.WithPrefix())).Commit()
+		assert.NoError(t, err)
+		assert.False(t, res.Succeeded)
+		assert.NotNil(t, res.Kvs(0))
+	})
+	t.Run("update", func(t *testing.T) {
+		counter := prefix + "/txn/counter"
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := cc.Update(ctx, counter, func(old string, exists bool) (string, error) {
+					n, _ := strconv.Atoi(old)
+					return strconv.Itoa(n + 1), nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		v, _ := cc.GetOne(ctx, counter)
+		assert.Equal(t, "10", v)
+	})
+	t.Run("stm", func(t *testing.T) {
+		from, to := prefix+"/txn/from", prefix+"/txn/to"
+		assert.NoError(t, cc.Put(ctx, from, "100"))
+		assert.NoError(t, cc.Put(ctx, to, "0"))
+		err := cc.STM(ctx, func(stm concurrency.STM) error {
+			a, _ := strconv.Atoi(stm.Get(from))
+			b, _ := strconv.Atoi(stm.Get(to))
+			stm.Put(from, strconv.Itoa(a-30))
+			stm.Put(to, strconv.Itoa(b+30))
+			return nil
+		})
+		assert.NoError(t, err)
+		v, _ := cc.GetOne(ctx, to)
+		assert.Equal(t, "30", v)
+	})
+}
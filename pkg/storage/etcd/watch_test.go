@@ -78,3 +78,43 @@ func TestNodeRegist(t *testing.T) {
 
 	<-time.After(60 * time.Second)
 }
+
+func TestETCDWatcherOrder(t *testing.T) {
+	ctx := context.Background()
+	cc, err := NewETCD(ctx, []string{host})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cc.Close()
+	var keys []string
+	w, err := cc.NewWatcher(ctx, watch_prefix+"/order", func(w *Watcher, events []*UpdateEvent, err error) {
+		assert.NoError(t, err)
+		for _, ev := range events {
+			keys = append(keys, ev.Key)
+		}
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	want := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%s/order/%d", watch_prefix, i)
+		want = append(want, key)
+		assert.NoError(t, cc.Put(ctx, key, "v"))
+	}
+	<-time.After(time.Second)
+	w.StopAndWait()
+	assert.Equal(t, want, keys)
+	assert.NoError(t, cc.DeleteWithPrefix(ctx, watch_prefix+"/order"))
+}
+
+func TestWatcherRemovedAfterStop(t *testing.T) {
+	cli := &Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Watcher{client: cli, cancel: cancel, done: make(chan struct{}), Logger: zap.NewNop()}
+	cli.watchers = append(cli.watchers, w)
+	w.Stop()
+	w.runWatcher(ctx)
+	<-w.done
+	assert.Empty(t, cli.watchers)
+}
@@ -2,14 +2,29 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/8xmx8/easier/pkg/utils"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	etcdcli "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 )
 
+/*
+监听器:
+1. 记录已处理的revision, 监听通道关闭或出错时从下一个revision恢复监听, 不会丢失事件;
+2. 要监听的revision已被压缩(compaction)时, 无法获得中间的事件, 重新读取前缀下的全部数据并回调 ResyncFunc;
+3. 事件按revision顺序、以每个watch响应为一批同步回调, 回调返回前不会处理下一批;
+4. Stop 只停止监听, 可以在回调中调用; StopAndWait 还会等待正在执行的回调返回, 不能在回调中调用, 否则会死锁;
+*/
+
+const watchRetryInterval = time.Second // 监听出错后的重试间隔
+
+// ErrWatchCompacted 监听的revision已被压缩, 且没有配置 ResyncFunc
+var ErrWatchCompacted = errors.New("watch revision has been compacted")
+
 type EventType int
 
 const (
@@ -25,6 +40,7 @@ type UpdateEvent struct {
 	PreKey    string
 	PreValue  string
 	EventType EventType
+	Revision  int64 // 事件的revision
 }
 
 func (ue UpdateEvent) String() string {
@@ -35,74 +51,158 @@ func (ue UpdateEvent) String() string {
 type Watcher struct { // nolint
 	client   *Client
 	callback WatcherFunc
+	resync   ResyncFunc
 	prefix   string
+	rev      int64 // 已处理的revision
 	cancel   func()
 	ctx      context.Context
+	done     chan struct{}
 	Logger   *zap.Logger
-	pools    *utils.ConcurrentPool
 }
 
 // WatcherFunc 来自于Watcher的回调，通过这个回调来进行通知.
 type WatcherFunc func(w *Watcher, updateEvent []*UpdateEvent, err error)
 
+// ResyncFunc 监听的revision被压缩后回调, kvs为前缀下的全部数据, rev为读取时的revision
+type ResyncFunc func(w *Watcher, kvs map[string]string, rev int64)
+
+type WatcherOptionFunc func(*Watcher)
+
+// WatcherWithResync 配置revision被压缩后的全量同步回调; 未配置时以 ErrWatchCompacted 回调 WatcherFunc
+func WatcherWithResync(f ResyncFunc) WatcherOptionFunc {
+	return func(w *Watcher) {
+		w.resync = f
+	}
+}
+
+// WatcherWithRevision 从rev之后开始监听(不包含rev), 默认从当前revision开始
+func WatcherWithRevision(rev int64) WatcherOptionFunc {
+	return func(w *Watcher) {
+		w.rev = rev
+	}
+}
+
+// Revision 已处理的revision
+func (w *Watcher) Revision() int64 {
+	return atomic.LoadInt64(&w.rev)
+}
+
 func (w *Watcher) Start(ctx context.Context) error {
+	if w.Revision() == 0 {
+		// 记录当前revision, 重新监听时从这里恢复
+		tctx, cancel := context.WithTimeout(ctx, w.client.timeout)
+		resp, err := w.client.cli.Get(tctx, w.prefix, etcdcli.WithPrefix(), etcdcli.WithCountOnly())
+		cancel()
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(&w.rev, resp.Header.Revision)
+	}
 	go w.runWatcher(ctx)
 	return nil
 }
 
 func (w *Watcher) runWatcher(ctx context.Context) {
-	for {
-		subctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
-		w.handlerWatch(subctx, w.prefix, w.callback)
-		<-subctx.Done()
-		cancel() // 尝试再关闭一下
-		w.Logger.Info("watcher has new", zap.String("prefix", w.prefix))
+	defer close(w.done)
+	defer w.client.removeWatcher(w)
+	for ctx.Err() == nil {
+		err := w.handlerWatch(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			w.Logger.Warn("watch revision compacted, resync", zap.String("prefix", w.prefix), zap.Int64("revision", w.Revision()))
+			err = w.resyncAll(ctx)
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			w.Logger.Error("watch error", zap.Error(err), zap.String("prefix", w.prefix))
+			w.callback(w, nil, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchRetryInterval):
+		}
+		w.Logger.Info("watcher has new", zap.String("prefix", w.prefix), zap.Int64("revision", w.Revision()))
 	}
+	w.Logger.Info("watcher has stopped", zap.String("prefix", w.prefix))
 }
-func (w *Watcher) handlerWatch(ctx context.Context, prefix string, callback WatcherFunc) {
-	wc := w.client.cli.Watch(ctx, prefix, etcdcli.WithPrefix(), etcdcli.WithPrevKV())
-	for {
-		select {
-		case resp, ok := <-wc:
-			if !ok {
-				callback(w, nil, nil)
-				time.Sleep(time.Second) // 缓冲一下
-				w.Logger.Error("etcd监听通道关闭, 5秒钟后重试")
-				break
+
+// handlerWatch 从已处理的revision之后开始监听, 直到通道关闭或出错
+func (w *Watcher) handlerWatch(ctx context.Context) error {
+	wctx, cancel := context.WithCancel(etcdcli.WithRequireLeader(ctx))
+	defer cancel()
+	wc := w.client.cli.Watch(wctx, w.prefix, etcdcli.WithPrefix(), etcdcli.WithPrevKV(),
+		etcdcli.WithRev(w.Revision()+1), etcdcli.WithProgressNotify())
+	for resp := range wc {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if len(resp.Events) == 0 { // 进度通知
+			if resp.Header.Revision > w.Revision() {
+				atomic.StoreInt64(&w.rev, resp.Header.Revision)
 			}
-			for _, ev := range resp.Events {
-				var eventType EventType
-				switch ev.Type {
-				case etcdcli.EventTypePut:
-					eventType = UpdateEventTypePut
-				case etcdcli.EventTypeDelete:
-					eventType = UpdateEventTypeDelete
-				default:
-					eventType = UpdateEventTypeUnKnown
-				}
-				data := &UpdateEvent{
-					EventType: eventType,
-					Key:       string(ev.Kv.Key),
-					Value:     string(ev.Kv.Value),
-				}
-				if ev.PrevKv != nil {
-					data.PreKey = string(ev.PrevKv.Key)
-					data.PreValue = string(ev.PrevKv.Value)
-				}
-				if err := w.pools.Submit(func() {
-					callback(w, []*UpdateEvent{data}, nil)
-				}); err != nil {
-					w.Logger.Error("watcher 协程池任务创建错误", zap.Error(err))
-				}
+			continue
+		}
+		events := make([]*UpdateEvent, 0, len(resp.Events))
+		for _, ev := range resp.Events {
+			var eventType EventType
+			switch ev.Type {
+			case etcdcli.EventTypePut:
+				eventType = UpdateEventTypePut
+			case etcdcli.EventTypeDelete:
+				eventType = UpdateEventTypeDelete
+			default:
+				eventType = UpdateEventTypeUnKnown
 			}
-		case <-ctx.Done():
-			w.Logger.Info("watcher has stopped", zap.String("prefix", w.prefix))
-			return
+			data := &UpdateEvent{
+				EventType: eventType,
+				Key:       string(ev.Kv.Key),
+				Value:     string(ev.Kv.Value),
+				Revision:  ev.Kv.ModRevision,
+			}
+			if ev.PrevKv != nil {
+				data.PreKey = string(ev.PrevKv.Key)
+				data.PreValue = string(ev.PrevKv.Value)
+			}
+			events = append(events, data)
 		}
+		w.callback(w, events, nil)
+		atomic.StoreInt64(&w.rev, events[len(events)-1].Revision)
 	}
+	return errors.New("watch channel closed")
 }
 
-// Stop 停止监听.
+// resyncAll 读取前缀下的全部数据, 从读取时的revision之后继续监听
+func (w *Watcher) resyncAll(ctx context.Context) error {
+	tctx, cancel := context.WithTimeout(ctx, w.client.timeout)
+	resp, err := w.client.cli.Get(tctx, w.prefix, etcdcli.WithPrefix())
+	cancel()
+	if err != nil {
+		return err
+	}
+	if w.resync != nil {
+		kvs := make(map[string]string, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			kvs[string(kv.Key)] = string(kv.Value)
+		}
+		w.resync(w, kvs, resp.Header.Revision)
+	} else {
+		w.callback(w, nil, ErrWatchCompacted)
+	}
+	atomic.StoreInt64(&w.rev, resp.Header.Revision)
+	return nil
+}
+
+// Stop 停止监听, 不等待正在执行的回调, 可以在回调中调用
 func (w *Watcher) Stop() {
 	w.cancel()
 }
+
+// StopAndWait 停止监听并等待正在执行的回调返回; 不能在回调中调用, 否则会死锁
+func (w *Watcher) StopAndWait() {
+	w.cancel()
+	<-w.done
+}